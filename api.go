@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// A PackageAPI, is the exported API of a package grouped by kind, see
+// Corpus.API.  Each group is sorted by name.
+type PackageAPI struct {
+	ImportPath string    // Import path of package "net/http"
+	Name       string    // Package name "http"
+	Doc        string    // Documentation synopsis, see Package.Synopsis
+	Consts     []Ident   // Exported constants
+	Vars       []Ident   // Exported variables
+	Types      []TypeAPI // Exported types and their methods
+	Funcs      []Ident   // Exported functions
+}
+
+// A TypeAPI, is an exported type of a PackageAPI and its exported methods,
+// which are named "<Type>.<Method>" and sorted by name.
+type TypeAPI struct {
+	Ident
+	Methods []Ident
+}
+
+// API returns the exported API of the package with import path importPath:
+// its exported constants, variables, types, with their methods, and
+// functions.  Like LookupOrImport, the package is imported and indexed on
+// demand.  If the idents of the package are not indexed, because IndexGoCode
+// is disabled or the package is not indexable, such as commands when
+// IndexCommands is disabled, its Go files are parsed without adding them to
+// the index.
+func (c *Corpus) API(importPath string) (*PackageAPI, error) {
+	p, err := c.LookupOrImport(importPath)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := c.apiExports(p)
+	if err != nil {
+		return nil, err
+	}
+	return newPackageAPI(p, exp), nil
+}
+
+// apiExports, returns the exports of Package p from the index, or parses its
+// Go files if the package is not indexed.
+func (c *Corpus) apiExports(p *Package) (map[string]Ident, error) {
+	if c.idents != nil {
+		if exp := c.idents.lookupExports(p.ImportPath); exp != nil {
+			return exp, nil
+		}
+	}
+	// Use a separate Index so the strings of the parsed idents are not
+	// interned by the Corpus.
+	ax := &astIndexer{
+		x:          &Index{c: c},
+		fset:       token.NewFileSet(),
+		current:    p,
+		exports:    make(map[string]Ident),
+		publicOnly: true,
+	}
+	if indexed, err := ax.index(); !indexed && err != nil {
+		return nil, err
+	}
+	return ax.exports, nil
+}
+
+// newPackageAPI, returns the PackageAPI of Package p built from its exports.
+// Methods of types that are not exported are ignored.
+func newPackageAPI(p *Package, exp map[string]Ident) *PackageAPI {
+	api := &PackageAPI{
+		ImportPath: p.ImportPath,
+		Name:       p.Name,
+		Doc:        p.Synopsis(),
+	}
+	types := make(map[string]*TypeAPI)
+	var methods []Ident
+	for name, id := range exp {
+		if !exportedName(name) {
+			continue
+		}
+		switch id.Info.Kind() {
+		case ConstDecl:
+			api.Consts = append(api.Consts, id)
+		case VarDecl:
+			api.Vars = append(api.Vars, id)
+		case TypeDecl:
+			types[id.Name] = &TypeAPI{Ident: id}
+		case FuncDecl:
+			api.Funcs = append(api.Funcs, id)
+		case MethodDecl, InterfaceDecl:
+			methods = append(methods, id)
+		}
+	}
+	for _, id := range methods {
+		typeName := id.Name[:strings.IndexByte(id.Name, '.')]
+		if t := types[typeName]; t != nil {
+			t.Methods = append(t.Methods, id)
+		}
+	}
+	if len(types) != 0 {
+		api.Types = make([]TypeAPI, 0, len(types))
+		for _, t := range types {
+			sort.Sort(byPathName(t.Methods))
+			api.Types = append(api.Types, *t)
+		}
+		sort.Slice(api.Types, func(i, j int) bool {
+			return api.Types[i].Name < api.Types[j].Name
+		})
+	}
+	sort.Sort(byPathName(api.Consts))
+	sort.Sort(byPathName(api.Vars))
+	sort.Sort(byPathName(api.Funcs))
+	return api
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+// apiNames, returns the names of the idents of each group of PackageAPI api,
+// methods are listed after their type.
+func apiNames(api *PackageAPI) map[string][]string {
+	m := make(map[string][]string)
+	add := func(group string, ids []Ident) {
+		for _, id := range ids {
+			m[group] = append(m[group], id.Name)
+		}
+	}
+	add("consts", api.Consts)
+	add("vars", api.Vars)
+	add("funcs", api.Funcs)
+	for _, t := range api.Types {
+		m["types"] = append(m["types"], t.Name)
+		add("types", t.Methods)
+	}
+	return m
+}
+
+func TestAPI(t *testing.T) {
+	for _, indexGoCode := range []bool{true, false} {
+		f := newFixture(t, indexGoCode)
+		f.write(t, "alpha/doc.go", "// Package alpha is a test package.\npackage alpha\n")
+		f.write(t, "alpha/more.go", "package alpha\n\n"+
+			"const ZConst, BConst = 1, 2\n\n"+
+			"type BType int\n\n"+
+			"func (*BType) Set() {}\n\n"+
+			"func (BType) Get() int { return 0 }\n\n"+
+			"type hidden int\n\n"+
+			"func (hidden) Exported() {}\n")
+		if err := f.initDirTree(); err != nil {
+			t.Fatal(err)
+		}
+
+		api, err := f.API("alpha")
+		if err != nil {
+			t.Fatalf("API (IndexGoCode %t): %v", indexGoCode, err)
+		}
+		if api.ImportPath != "alpha" || api.Name != "alpha" || api.Doc != "Package alpha is a test package." {
+			t.Errorf("API (IndexGoCode %t): %q %q %q", indexGoCode, api.ImportPath, api.Name, api.Doc)
+		}
+		exp := map[string][]string{
+			"consts": {"AlphaConst", "BConst", "ZConst"},
+			"vars":   {"AlphaVar"},
+			"funcs":  {"AlphaFunc"},
+			"types":  {"AlphaIface", "AlphaType", "AlphaType.Method", "BType", "BType.Get", "BType.Set"},
+		}
+		if got := apiNames(api); !reflect.DeepEqual(got, exp) {
+			t.Errorf("API (IndexGoCode %t):\nExp: %q\nGot: %q", indexGoCode, exp, got)
+		}
+		for _, id := range api.Funcs {
+			if id.File != f.path("alpha/alpha.go") || id.Info.Line() == 0 {
+				t.Errorf("API (IndexGoCode %t): %s: missing position: %q %d",
+					indexGoCode, id.Name, id.File, id.Info.Line())
+			}
+		}
+
+		// Packages are imported on demand.
+		f.write(t, "late/late.go", "package late\n\nfunc Late() {}\n")
+		api, err = f.API("late")
+		if err != nil {
+			t.Fatalf("API (IndexGoCode %t): late: %v", indexGoCode, err)
+		}
+		if got := apiNames(api); !reflect.DeepEqual(got, map[string][]string{"funcs": {"Late"}}) {
+			t.Errorf("API (IndexGoCode %t): late: %q", indexGoCode, got)
+		}
+
+		if _, err := f.API("missing"); err == nil {
+			t.Errorf("API (IndexGoCode %t): expected error for missing package", indexGoCode)
+		}
+		f.Close()
+	}
+}
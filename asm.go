@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"bytes"
+	"go/token"
+	"strings"
+
+	"github.com/charlievieth/pkg/fs"
+)
+
+// isAsmFile, returns if the file name is a Go assembly file.
+func isAsmFile(name string) bool {
+	return validName(name) && strings.HasSuffix(name, ".s")
+}
+
+// An asmSymbol, is a TEXT symbol declared by an assembly file.
+type asmSymbol struct {
+	Name   string // symbol name, without the package qualifier
+	Offset int    // byte offset of the name
+	Line   int    // line of the name
+}
+
+// scanAsm, returns the TEXT symbols of assembly source src that are declared
+// for the package with import path importPath.  Symbols are qualified by the
+// package, with "/" written as "∕", followed by "·", an empty qualifier
+// refers to the package of the file.  For example, in package "math":
+//
+//	TEXT ·archSqrt(SB), NOSPLIT, $0
+//	TEXT math·archSqrt(SB), NOSPLIT, $0
+//
+// Symbols of other packages, local symbols ("name<>") and names that are not
+// Go identifiers are ignored.
+func scanAsm(src []byte, importPath string) []asmSymbol {
+	qualifier := strings.Replace(importPath, "/", "∕", -1)
+	var syms []asmSymbol
+	line := 0
+	for off := 0; off < len(src); {
+		line++
+		end := bytes.IndexByte(src[off:], '\n')
+		if end == -1 {
+			end = len(src)
+		} else {
+			end += off
+		}
+		if sym, ok := scanAsmLine(src[off:end], off, qualifier); ok {
+			sym.Line = line
+			syms = append(syms, sym)
+		}
+		off = end + 1
+	}
+	return syms
+}
+
+// scanAsmLine, returns the symbol declared by the TEXT directive of line b,
+// which starts at offset off, if it is declared for the package qualifier.
+func scanAsmLine(b []byte, off int, qualifier string) (asmSymbol, bool) {
+	i := 0
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+		i++
+	}
+	if !bytes.HasPrefix(b[i:], []byte("TEXT")) {
+		return asmSymbol{}, false
+	}
+	i += len("TEXT")
+	start := i
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+		i++
+	}
+	if i == start {
+		return asmSymbol{}, false // "TEXTX"
+	}
+	n := bytes.Index(b[i:], []byte("(SB)"))
+	if n == -1 {
+		return asmSymbol{}, false
+	}
+	sym := string(b[i : i+n])
+	dot := strings.Index(sym, "·")
+	if dot == -1 {
+		return asmSymbol{}, false
+	}
+	if q := sym[:dot]; q != "" && q != qualifier {
+		return asmSymbol{}, false
+	}
+	name := sym[dot+len("·"):]
+	// Strip the ABI selector, such as "<ABIInternal>".
+	if j := strings.IndexByte(name, '<'); j > 0 && strings.HasSuffix(name, ">") &&
+		name[j:] != "<>" {
+		name = name[:j]
+	}
+	if !token.IsIdentifier(name) {
+		return asmSymbol{}, false
+	}
+	return asmSymbol{
+		Name:   name,
+		Offset: off + i + dot + len("·"),
+	}, true
+}
+
+// asmKey, returns the key of the AsmDecl ident named name in the exports of
+// its package.  Assembly symbols usually implement a Go declaration without
+// a body, so they are keyed separately to keep the Go declaration, the key
+// is never an exported name.
+func asmKey(name string) string {
+	return "·" + name
+}
+
+// indexAsm, indexes the TEXT symbols of the assembly files of the current
+// package that match the build context, see Corpus.IndexAsm.  Files that
+// cannot be read are skipped.
+func (x *astIndexer) indexAsm() {
+	c := x.x.c
+	for _, f := range x.current.asmFiles() {
+		if !c.ctxt.MatchFile(x.current.Dir, f.Name) {
+			continue
+		}
+		src, err := fs.ReadFile(f.Path)
+		if err != nil {
+			continue
+		}
+		for _, sym := range scanAsm(src, x.current.ImportPath) {
+			x.addAsm(f.Path, sym)
+		}
+	}
+}
+
+// addAsm, adds the AsmDecl ident of symbol sym declared in file.
+func (x *astIndexer) addAsm(file string, sym asmSymbol) {
+	if x.namesOnly {
+		// Only exported names are indexed, which are never keys of
+		// assembly symbols.
+		return
+	}
+	if x.publicOnly && !token.IsExported(sym.Name) {
+		return
+	}
+	key := asmKey(sym.Name)
+	if _, ok := x.exports[key]; ok {
+		return // declared by another file
+	}
+	name := x.intern(sym.Name)
+	id := Ident{
+		Name:    name,
+		Package: x.intern(x.current.Name),
+		Path:    x.intern(x.current.ImportPath),
+		File:    file,
+		Info:    makeTypInfo(AsmDecl, sym.Offset, sym.Line),
+	}
+	if x.idents != nil {
+		if x.idents[AsmDecl] == nil {
+			x.idents[AsmDecl] = make(map[string][]Ident)
+		}
+		x.idents[AsmDecl][name] = append(x.idents[AsmDecl][name], id)
+	}
+	if x.exports == nil {
+		x.exports = make(map[string]Ident)
+	}
+	x.exports[x.intern(key)] = id
+}
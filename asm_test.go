@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestScanAsm(t *testing.T) {
+	const src = "#include \"textflag.h\"\n" +
+		"\n" +
+		"// func archSqrt(x float64) float64\n" +
+		"TEXT ·archSqrt(SB), NOSPLIT, $0\n" +
+		"\tRET\n" +
+		"TEXT\tcrypto∕internal∕x·Qualified(SB),NOSPLIT,$0\n" +
+		"TEXT ·abi<ABIInternal>(SB), NOSPLIT, $0\n" +
+		"TEXT local<>(SB), NOSPLIT, $0\n" +
+		"TEXT ·local<>(SB), NOSPLIT, $0\n" +
+		"TEXT runtime·other(SB), NOSPLIT, $0\n" +
+		"TEXTX ·bad(SB)\n" +
+		"\tTEXT ·indented(SB), $0"
+
+	got := scanAsm([]byte(src), "crypto/internal/x")
+	exp := []asmSymbol{
+		{"archSqrt", 0, 4},
+		{"Qualified", 0, 6},
+		{"abi", 0, 7},
+		{"indented", 0, 12},
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("scanAsm:\nExp: %+v\nGot: %+v", exp, got)
+	}
+	for i, sym := range got {
+		// The offset must point to the name.
+		if s := src[sym.Offset:]; len(s) < len(sym.Name) || s[:len(sym.Name)] != sym.Name {
+			t.Errorf("scanAsm (%s): bad offset %d: %q", sym.Name, sym.Offset, s)
+		}
+		sym.Offset = 0
+		if sym != exp[i] {
+			t.Errorf("scanAsm: exp: %+v got: %+v", exp[i], sym)
+		}
+	}
+}
+
+// asmIdents, returns the names of the AsmDecl idents of the package with
+// import path path, sorted by name.
+func asmIdents(c *Corpus, path string) []string {
+	var names []string
+	for key, id := range c.idents.lookupExports(path) {
+		if id.Info.Kind() == AsmDecl {
+			if key != asmKey(id.Name) {
+				return []string{"bad key: " + key}
+			}
+			names = append(names, id.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestIndexAsm(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.IndexAsm = true
+	f.write(t, "asm/asm.go", "package asm\n\nfunc Add(x, y int) int\n")
+	f.write(t, "asm/add_amd64.s", "TEXT ·Add(SB), $0\n\tRET\n\nTEXT ·helper(SB), $0\n")
+	f.write(t, "asm/add_arm64.s", "TEXT ·Arm64Only(SB), $0\n")
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	f.SetContext(&ctxt)
+	f.SetRoots([]string{f.root})
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, exp := asmIdents(f.Corpus, "asm"), []string{"Add", "helper"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("IndexAsm: exp: %q got: %q", exp, got)
+	}
+	// The Go declaration is not replaced.
+	if id, ok := f.Definition("asm", "Add"); !ok || id.Info.Kind() != FuncDecl {
+		t.Errorf("IndexAsm: Definition: %+v", id)
+	}
+	ids := f.idents.lookupName("helper", Kinds(AsmDecl))
+	if len(ids) != 1 || ids[0].File != f.path("asm/add_amd64.s") || ids[0].Info.Line() != 4 {
+		t.Errorf("IndexAsm: lookupName: %+v", ids)
+	}
+	if names := f.idents.Exports("asm"); !reflect.DeepEqual(names, []string{"Add"}) {
+		t.Errorf("IndexAsm: Exports: %q", names)
+	}
+
+	// Assembly files are updated along with the package.
+	f.write(t, "asm/sub_amd64.s", "TEXT ·sub(SB), $0\n")
+	f.remove(t, "asm/add_amd64.s")
+	f.Update()
+	if got, exp := asmIdents(f.Corpus, "asm"), []string{"sub"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("IndexAsm: update: exp: %q got: %q", exp, got)
+	}
+	if ids := f.idents.lookupName("helper", AllKinds); len(ids) != 0 {
+		t.Errorf("IndexAsm: update: removed symbol: %+v", ids)
+	}
+}
+
+func TestIndexAsmGoroot(t *testing.T) {
+	goroot := runtime.GOROOT()
+	if goroot == "" || !isGoRoot(goroot) {
+		t.Skip("GOROOT not found")
+	}
+	ctxt := build.Default
+	ctxt.GOROOT = goroot
+	ctxt.GOPATH = ""
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+
+	c := NewCorpus()
+	c.LogEvents = false
+	c.IndexGoCode = true
+	c.IndexAsm = true
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	c.SetContext(&ctxt)
+	if _, err := c.LookupOrImport("math"); err != nil {
+		t.Fatal(err)
+	}
+	names := asmIdents(c, "math")
+	if len(names) == 0 {
+		t.Fatal("IndexAsm: math: no assembly symbols")
+	}
+	for _, name := range []string{"archFloor", "archHypot"} {
+		ids := c.idents.lookupName(name, Kinds(AsmDecl))
+		if len(ids) != 1 || filepath.Ext(ids[0].File) != ".s" {
+			t.Errorf("IndexAsm: math: %s: %+v", name, ids)
+		}
+	}
+	// Symbols of other architectures are not indexed.
+	if ids := c.idents.lookupName("archAcos", Kinds(AsmDecl)); len(ids) != 0 {
+		t.Errorf("IndexAsm: math: s390x symbol: %+v", ids)
+	}
+}
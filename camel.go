@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Character classes of SplitCamel.
+const (
+	camelSep = iota // separates words and is dropped, such as '_'
+	camelLower
+	camelUpper
+	camelDigit
+)
+
+// camelClass, returns the SplitCamel character class of rune r.  Letters
+// without case, such as CJK ideographs, and invalid UTF-8 are treated as
+// lower case letters.
+func camelClass(r rune) int {
+	switch {
+	case unicode.IsUpper(r) || unicode.IsTitle(r):
+		return camelUpper
+	case unicode.IsDigit(r):
+		return camelDigit
+	case unicode.IsLetter(r) || r == utf8.RuneError:
+		return camelLower
+	}
+	return camelSep
+}
+
+// SplitCamel, splits identifier name into its CamelCase words, for example:
+//
+//	"ReadCloser"     => ["Read", "Closer"]
+//	"HTTPServer"     => ["HTTP", "Server"]
+//	"parseJSONValue" => ["parse", "JSON", "Value"]
+//	"Int64Slice"     => ["Int", "64", "Slice"]
+//
+// A word starts at an upper case letter that follows a lower case letter or
+// digit, at the last upper case letter of a run that is followed by a lower
+// case letter, and at the start and end of a run of digits.  Underscores and
+// other characters that are neither letters nor digits separate words and
+// are dropped.  Unicode letters are supported and names are only split
+// between runes, the words are substrings of name.
+func SplitCamel(name string) []string {
+	var words []string
+	start := 0
+	prev, prevOff := camelSep, 0
+	for i, r := range name {
+		if unicode.IsMark(r) && prev != camelSep {
+			continue // combining marks belong to the previous letter
+		}
+		class := camelClass(r)
+		switch {
+		case class == camelSep:
+			if prev != camelSep {
+				words = append(words, name[start:i])
+			}
+		case prev == camelSep:
+			start = i
+		case class == camelUpper && prev == camelLower,
+			class == camelDigit && prev != camelDigit,
+			class != camelDigit && prev == camelDigit:
+			words = append(words, name[start:i])
+			start = i
+		case class == camelLower && prev == camelUpper && prevOff > start:
+			// "HTTPServer": the upper case letter before i starts
+			// the next word.
+			words = append(words, name[start:prevOff])
+			start = prevOff
+		}
+		prev, prevOff = class, i
+	}
+	if prev != camelSep {
+		words = append(words, name[start:])
+	}
+	return words
+}
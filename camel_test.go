@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitCamel(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  []string
+	}{
+		{"", nil},
+		{"_", nil},
+		{"a", []string{"a"}},
+		{"A", []string{"A"}},
+		{"read", []string{"read"}},
+		{"Read", []string{"Read"}},
+		{"ReadCloser", []string{"Read", "Closer"}},
+		{"readCloser", []string{"read", "Closer"}},
+
+		// Acronyms
+		{"HTTP", []string{"HTTP"}},
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"parseJSONValue", []string{"parse", "JSON", "Value"}},
+		{"ServeHTTP", []string{"Serve", "HTTP"}},
+		{"AString", []string{"A", "String"}},
+		{"IDs", []string{"I", "Ds"}},
+
+		// Digits
+		{"Int64", []string{"Int", "64"}},
+		{"Int64Slice", []string{"Int", "64", "Slice"}},
+		{"utf8", []string{"utf", "8"}},
+		{"HTTP2Server", []string{"HTTP", "2", "Server"}},
+		{"v2beta1", []string{"v", "2", "beta", "1"}},
+		{"123", []string{"123"}},
+
+		// Separators
+		{"MAX_VALUE", []string{"MAX", "VALUE"}},
+		{"_private", []string{"private"}},
+		{"snake_case_", []string{"snake", "case"}},
+		{"a__b", []string{"a", "b"}},
+		{"Reader.Read", []string{"Reader", "Read"}},
+
+		// Unicode
+		{"ÜberWagen", []string{"Über", "Wagen"}},
+		{"straßeÖffnen", []string{"straße", "Öffnen"}},
+		{"ΑλφαΒήτα", []string{"Αλφα", "Βήτα"}},
+		{"日本語Text", []string{"日本語", "Text"}},
+		{"Text日本語", []string{"Text日本語"}},
+		{"ǅemal", []string{"ǅemal"}},
+		{"étudeFinale", []string{"étude", "Finale"}},
+		{"ÉTATUnis", []string{"ÉTAT", "Unis"}},
+		{"x١٢Y", []string{"x", "١٢", "Y"}},                 // Arabic-Indic digits
+		{"Cafe\u0301Noir", []string{"Cafe\u0301", "Noir"}}, // combining mark
+	}
+	for _, x := range tests {
+		got := SplitCamel(x.name)
+		if !reflect.DeepEqual(got, x.exp) {
+			t.Errorf("SplitCamel(%q): exp: %q got: %q", x.name, x.exp, got)
+		}
+		for _, w := range got {
+			if !utf8.ValidString(w) || !strings.Contains(x.name, w) {
+				t.Errorf("SplitCamel(%q): invalid word: %q", x.name, w)
+			}
+		}
+	}
+}
+
+func TestSplitCamelInvalidUTF8(t *testing.T) {
+	// Invalid bytes are kept and never split from their neighbors.
+	name := "Bad\xffName"
+	exp := []string{"Bad\xff", "Name"}
+	if got := SplitCamel(name); !reflect.DeepEqual(got, exp) {
+		t.Errorf("SplitCamel(%q): exp: %q got: %q", name, exp, got)
+	}
+}
@@ -0,0 +1,285 @@
+// Command pkgd serves queries against a package Corpus over HTTP.
+//
+// Endpoints:
+//
+//	/search?q=<query>[&limit=N&offset=N]  packages and idents matching query
+//	/package?path=<import path>           package with import path
+//	/definition?path=<import path>&name=<name>
+//	                                      declaration of an exported name
+//	/events                               server-sent events of index changes,
+//	                                      the data of each event is JSON
+//
+// All endpoints, other than /events, respond with JSON.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/charlievieth/pkg"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:6061", "HTTP listen address")
+	interval = flag.Duration("interval", time.Second*3, "index update interval")
+	verbose  = flag.Bool("v", false, "log index events")
+)
+
+// A server serves queries against a Corpus.
+type server struct {
+	c    *pkg.Corpus
+	quit chan struct{} // closed on shutdown to end event streams
+}
+
+// Package is the JSON representation of a pkg.Package.
+type Package struct {
+	Dir        string
+	Name       string
+	ImportPath string
+	Goroot     bool
+	Installed  bool
+	IsCommand  bool
+	GoFiles    []string
+	Error      string `json:",omitempty"`
+}
+
+func newPackage(p *pkg.Package) Package {
+	v := Package{
+		Dir:        p.Dir,
+		Name:       p.Name,
+		ImportPath: p.ImportPath,
+		Goroot:     p.Goroot,
+		Installed:  p.Installed,
+		IsCommand:  p.IsCommand(),
+		GoFiles:    p.GoFiles(),
+	}
+	if err := p.Error(); err != nil {
+		v.Error = err.Error()
+	}
+	return v
+}
+
+// Ident is the JSON representation of a pkg.Ident.
+type Ident struct {
+	Name    string
+	Kind    pkg.TypKind
+	Package string
+	Path    string
+	File    string `json:",omitempty"`
+	Line    int    `json:",omitempty"`
+}
+
+func newIdent(id pkg.Ident) Ident {
+	return Ident{
+		Name:    id.Name,
+		Kind:    id.Info.Kind(),
+		Package: id.Package,
+		Path:    id.Path,
+		File:    id.File,
+		Line:    id.Info.Line(),
+	}
+}
+
+// Event is the JSON representation of a pkg.Eventer.
+type Event struct {
+	Message  string
+	Type     string
+	Source   string        `json:",omitempty"`
+	Path     string        `json:",omitempty"`
+	Detail   string        `json:",omitempty"`
+	Time     time.Time     `json:",omitempty"`
+	Duration time.Duration `json:",omitempty"`
+}
+
+func newEvent(e pkg.Eventer) Event {
+	v := Event{Message: e.String(), Type: e.Event().String()}
+	switch e := e.(type) {
+	case pkg.Event:
+		v.Source, v.Path, v.Detail = e.Source, e.Path, e.Detail
+		v.Time, v.Duration = e.Time, e.Duration
+	case pkg.IndexEvent:
+		v.Source, v.Path, v.Detail = "Index", e.Path, e.Detail
+		v.Time, v.Duration = e.Time, e.Duration
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("pkgd: writing response: %s", err)
+	}
+}
+
+// queryInt, returns the integer query parameter name, or zero if not set.
+func queryInt(r *http.Request, name string) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %q", name, s)
+	}
+	return n, nil
+}
+
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "missing q parameter", http.StatusBadRequest)
+		return
+	}
+	var opts pkg.QueryOptions
+	var err error
+	if opts.Limit, err = queryInt(r, "limit"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Offset, err = queryInt(r, "offset"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := s.c.FindPage(q, opts)
+	v := struct {
+		Packages []Package
+		Idents   []Ident
+		Total    int
+	}{
+		Packages: make([]Package, 0, len(res.Packages)),
+		Idents:   make([]Ident, 0, len(res.Idents)),
+		Total:    res.Total,
+	}
+	for _, p := range res.Packages {
+		v.Packages = append(v.Packages, newPackage(p))
+	}
+	for _, id := range res.Idents {
+		v.Idents = append(v.Idents, newIdent(id))
+	}
+	writeJSON(w, v)
+}
+
+func (s *server) pkg(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "missing path parameter", http.StatusBadRequest)
+		return
+	}
+	p, err := s.c.LookupOrImport(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, newPackage(p))
+}
+
+func (s *server) definition(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	name := r.URL.Query().Get("name")
+	if path == "" || name == "" {
+		http.Error(w, "missing path or name parameter", http.StatusBadRequest)
+		return
+	}
+	id, ok := s.c.Definition(path, name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s.%s: not found", path, name), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, newIdent(id))
+}
+
+// events, streams the events of the Corpus as server-sent events.
+func (s *server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := s.c.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(newEvent(e))
+			if err != nil {
+				log.Printf("pkgd: encoding event: %s", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event(), b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.search)
+	mux.HandleFunc("/package", s.pkg)
+	mux.HandleFunc("/definition", s.definition)
+	mux.HandleFunc("/events", s.events)
+	return mux
+}
+
+func main() {
+	flag.Parse()
+
+	c := pkg.NewCorpus()
+	c.LogEvents = *verbose
+	c.IndexInterval = *interval
+	start := time.Now()
+	if err := c.Init(); err != nil {
+		log.Fatalf("pkgd: initializing corpus: %s", err)
+	}
+	log.Printf("pkgd: indexed corpus in %s", time.Since(start))
+
+	s := &server{c: c, quit: make(chan struct{})}
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: s.handler(),
+	}
+	srv.RegisterOnShutdown(func() { close(s.quit) })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("pkgd: shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("pkgd: shutdown: %s", err)
+		}
+		c.Stop()
+	}()
+
+	log.Printf("pkgd: listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("pkgd: %s", err)
+	}
+	<-done
+}
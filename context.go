@@ -1,11 +1,17 @@
 package pkg
 
 import (
+	"bytes"
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
 	"os"
 	pathpkg "path"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,8 +23,15 @@ import (
 type Context struct {
 	ctxt           *build.Context
 	srcDirs        []string
+	roots          []string // explicit source root directories, if set
+	goroot         string   // explicit GOROOT, if gorootSet
+	gorootSet      bool
+	ctxtSet        bool // ctxt was set explicitly by SetContext
+	tagged         []SrcDir
+	modCache       string
 	lastUpdate     time.Time
 	updateInterval time.Duration // ignored if less than or equal to zero
+	gen            uint64        // incremented when ctxt is replaced
 	mu             sync.RWMutex
 }
 
@@ -39,9 +52,56 @@ func NewContext(ctxt *build.Context, updateInterval time.Duration) *Context {
 
 // Context returns a pointer the the current build.Context.  The returned
 // *build.Context should *not* be modified by the reciever.
+//
+// The build.Context is replaced, not modified, when the Context is updated,
+// so the returned pointer may become stale.  Use Snapshot for a copy.
 func (c *Context) Context() *build.Context {
 	c.Update()
-	return c.ctxt
+	c.mu.RLock()
+	ctxt := c.ctxt
+	c.mu.RUnlock()
+	return ctxt
+}
+
+// Snapshot returns a copy of the current build.Context, which may be modified
+// by the caller.  Unlike Context, the environment is not checked for changes,
+// the Context is only updated if it is not initialized.
+func (c *Context) Snapshot() build.Context {
+	c.mu.RLock()
+	ctxt := c.ctxt
+	c.mu.RUnlock()
+	if ctxt == nil {
+		return *c.Context()
+	}
+	return *ctxt
+}
+
+// clone, returns a copy of the Context, which is updated independently.
+func (c *Context) clone() *Context {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return &Context{
+		ctxt:           c.ctxt,
+		srcDirs:        c.srcDirs,
+		roots:          c.roots,
+		goroot:         c.goroot,
+		gorootSet:      c.gorootSet,
+		ctxtSet:        c.ctxtSet,
+		tagged:         c.tagged,
+		modCache:       c.modCache,
+		lastUpdate:     c.lastUpdate,
+		updateInterval: c.updateInterval,
+		gen:            c.gen,
+	}
+}
+
+// generation, returns the generation of the build.Context, which changes
+// whenever it is replaced.  Used to invalidate results that depend on it.
+func (c *Context) generation() uint64 {
+	c.mu.RLock()
+	gen := c.gen
+	c.mu.RUnlock()
+	return gen
 }
 
 // SrcDirs returns a list of package source root directories.  It draws from
@@ -54,6 +114,37 @@ func (c *Context) SrcDirs() []string {
 	return c.srcDirs
 }
 
+// A SrcDir is a package source root directory.
+type SrcDir struct {
+	Path     string // Directory path "$GOROOT/src"
+	Goroot   bool   // Directory is in the Go root
+	ModCache bool   // Directory is the module cache "$GOPATH/pkg/mod"
+}
+
+// SrcDirsTagged is like SrcDirs, but reports whether each source root
+// directory is in the Go root or Go path.
+func (c *Context) SrcDirsTagged() []SrcDir {
+	c.Update()
+	c.mu.RLock()
+	dirs := c.tagged
+	c.mu.RUnlock()
+	return dirs
+}
+
+// ModCacheDir returns the module cache directory, which is either $GOMODCACHE
+// or "pkg/mod" in the first GOPATH entry.  An empty string is returned if the
+// directory does not exist.
+//
+// Like SrcDirs, the result is cached and only updated when GOROOT or GOPATH
+// change.
+func (c *Context) ModCacheDir() string {
+	c.Update()
+	c.mu.RLock()
+	dir := c.modCache
+	c.mu.RUnlock()
+	return dir
+}
+
 // GOROOT returns the GOROOT of Context.
 func (c *Context) GOROOT() string {
 	return c.Context().GOROOT
@@ -64,11 +155,22 @@ func (c *Context) GOPATH() string {
 	return c.Context().GOPATH
 }
 
-// SetGoRoot sets the Context GOROOT.
+// SetGoRoot sets the Context GOROOT to s, which is used instead of the
+// GOROOT environment variable and the GOROOT the binary was built with.  If s
+// is not a valid Go root (a directory containing "src") the Context has no
+// GOROOT and only the Go path is used.
 func (c *Context) SetGoRoot(s string) {
-	if s := clean(s); fs.IsDir(s) {
-		c.doUpdate(s, c.GOPATH())
+	if s != "" {
+		if s = clean(s); !isGoRoot(s) {
+			s = ""
+		}
 	}
+	path := c.GOPATH()
+	c.mu.Lock()
+	c.goroot = s
+	c.gorootSet = true
+	c.mu.Unlock()
+	c.doUpdate(s, path)
 }
 
 // SetGoPath sets the Context GOPATH.
@@ -78,12 +180,85 @@ func (c *Context) SetGoPath(s string) {
 	}
 }
 
+// SetGOOS sets the target operating system of the Context to s, which is used
+// to match the file names and build constraints of Go files.  If s is empty
+// build.Default.GOOS is used.  Indexed packages are not re-matched, see
+// PackageIndex.InvalidateContext and Corpus.SetPlatform.
+func (c *Context) SetGOOS(s string) {
+	if s == "" {
+		s = build.Default.GOOS
+	}
+	c.setPlatform(s, "")
+}
+
+// SetGOARCH sets the target architecture of the Context to s, see SetGOOS.
+// If s is empty build.Default.GOARCH is used.
+func (c *Context) SetGOARCH(s string) {
+	if s == "" {
+		s = build.Default.GOARCH
+	}
+	c.setPlatform("", s)
+}
+
+// setPlatform, sets the GOOS and GOARCH of the Context, empty values are not
+// changed, and reports if either changed.  Like doUpdate, the build.Context
+// is copied and replaced on change.
+func (c *Context) setPlatform(goos, goarch string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctxt == nil {
+		c.initDefault()
+	}
+	if goos == "" {
+		goos = c.ctxt.GOOS
+	}
+	if goarch == "" {
+		goarch = c.ctxt.GOARCH
+	}
+	if goos == c.ctxt.GOOS && goarch == c.ctxt.GOARCH {
+		return false
+	}
+	ctxt := *c.ctxt
+	ctxt.GOOS = goos
+	ctxt.GOARCH = goarch
+	c.ctxt = &ctxt
+	c.gen++
+	c.setSrcDirs(c.rootDirs(&ctxt))
+	return true
+}
+
+// SetContext replaces the build.Context of the Context with a copy of ctxt,
+// which is used as is: its GOROOT and GOPATH are no longer derived from the
+// environment, which is no longer checked for changes, and any source root
+// directories set with SetSrcDirs are cleared.  If ctxt is nil, the Context
+// reverts to build.Default and the current GOROOT and GOPATH.
+func (c *Context) SetContext(ctxt *build.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roots = nil
+	c.lastUpdate = time.Now()
+	if ctxt == nil {
+		c.ctxtSet = false
+		c.goroot = ""
+		c.gorootSet = false
+		c.initDefault()
+		return
+	}
+	dup := *ctxt
+	c.ctxtSet = true
+	c.goroot = dup.GOROOT
+	c.gorootSet = true
+	c.ctxt = &dup
+	c.gen++
+	c.setSrcDirs(c.rootDirs(&dup))
+}
+
 // PkgTargetRoot, returns the package directory and package .a file for the
 // Go package named by the import path and the current context.
 //
 // See: go/build/build.go Import() for more information.
 func (c *Context) PkgTargetRoot(path string) (pkgRoot string, pkgA string, err error) {
-	ctxt := c.Context()
+	ctxt := c.Snapshot()
 	suffix := ctxt.InstallSuffix
 	if suffix != "" {
 		suffix = "_" + suffix
@@ -111,28 +286,166 @@ func (c *Context) PkgTargetRoot(path string) (pkgRoot string, pkgA string, err e
 //
 // See: go/build/build.go Context.MatchFile for more information.
 func (c *Context) MatchFile(dir, name string) bool {
-	ok, err := c.Context().MatchFile(dir, name)
+	ctxt := c.matchContext()
+	ok, err := ctxt.MatchFile(dir, name)
 	return ok && err == nil
 }
 
+// matchContext, returns a snapshot of the build.Context for matching files,
+// which reads files with the fs package, unless an OpenFile func is set.
+func (c *Context) matchContext() build.Context {
+	ctxt := c.Snapshot()
+	if ctxt.OpenFile == nil {
+		ctxt.OpenFile = fs.OpenFile
+	}
+	return ctxt
+}
+
+// A fileMatch, is the result of matching a Go file against the build
+// context, see Context.matchFile.
+type fileMatch struct {
+	match   bool
+	reason  string // why the file is ignored, if it does not match
+	invalid string // why the file is not Go source, see sniffGoSource
+}
+
+// matchFile, is like MatchFile but also returns why a file that does not
+// match is excluded, see ignoreReason, and if MatchFile could not read it,
+// whether the file is Go source.  Both are derived from what MatchFile read,
+// so the file is read at most once.
+func (c *Context) matchFile(dir, name string) fileMatch {
+	ctxt := c.matchContext()
+	open := ctxt.OpenFile
+	var header bytes.Buffer
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		rc, err := open(path)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(rc, &header), rc}, nil
+	}
+	ok, err := ctxt.MatchFile(dir, name)
+	switch {
+	case err != nil:
+		return fileMatch{
+			reason:  "error: " + err.Error(),
+			invalid: sniffGoSource(header.Bytes(), true),
+		}
+	case ok:
+		return fileMatch{match: true}
+	}
+	return fileMatch{reason: ignoreReason(&ctxt, dir, name, header.Bytes())}
+}
+
+// ignoreReason, returns why the file with the given name in the given
+// directory is not matched by build context ctxt, given the header of the
+// file read by MatchFile, if any.  The reason is either the GOOS/GOARCH
+// suffix of the file name or the build constraint of the file.
+func ignoreReason(ctxt *build.Context, dir, name string, header []byte) string {
+	// Match only the file name by replacing the file with a bare package.
+	named := *ctxt
+	named.OpenFile = func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("package p\n")), nil
+	}
+	if ok, _ := named.MatchFile(dir, name); !ok {
+		return fmt.Sprintf("file name does not match %s/%s", ctxt.GOOS, ctxt.GOARCH)
+	}
+	// MatchFile reads the file through its package clause, which may be
+	// followed by a partial import block.
+	af, _ := parser.ParseFile(token.NewFileSet(), name, header,
+		parser.PackageClauseOnly|parser.ParseComments)
+	if af != nil {
+		if expr := buildConstraint(af); expr != nil {
+			return "build constraint not satisfied: " + expr.String()
+		}
+	}
+	return "excluded by build context"
+}
+
 // Update, updates or initializes a Context that is outdated or has a nil
 // build.Context or SrcDirs.
 func (c *Context) Update() {
-	if c.ctxt == nil || c.srcDirs == nil || c.outdated() {
-		c.doUpdate(runtime.GOROOT(), os.Getenv("GOPATH"))
+	c.mu.RLock()
+	update := c.ctxt == nil || c.srcDirs == nil || c.outdated()
+	c.mu.RUnlock()
+	if update {
+		c.doUpdate(c.defaultGoRoot(), os.Getenv("GOPATH"))
+	}
+}
+
+// defaultGoRoot, returns the explicit GOROOT of the Context, if set,
+// otherwise the GOROOT found by findGoRoot.
+func (c *Context) defaultGoRoot() string {
+	c.mu.RLock()
+	root, ok := c.goroot, c.gorootSet
+	c.mu.RUnlock()
+	if ok {
+		return root
+	}
+	return findGoRoot()
+}
+
+// findGoRoot, returns the GOROOT environment variable or, if not set, the
+// GOROOT the binary was built with.  An empty string is returned if neither
+// is a valid Go root, which is common for stripped binaries and containers.
+func findGoRoot() string {
+	for _, root := range [...]string{os.Getenv("GOROOT"), runtime.GOROOT()} {
+		if root != "" && isGoRoot(root) {
+			return root
+		}
+	}
+	return ""
+}
+
+// isGoRoot, returns if root is a directory containing a "src" directory.
+func isGoRoot(root string) bool {
+	return fs.IsDir(filepath.Join(root, "src"))
+}
+
+// SetSrcDirs sets the package source root directories of the Context to
+// dirs.  The directories are used instead of those derived from GOROOT and
+// GOPATH and the environment is no longer checked for changes.  If dirs is
+// nil, the Context reverts to deriving its source root directories from
+// GOROOT and GOPATH.
+//
+// Useful for testing and sandboxed environments.
+func (c *Context) SetSrcDirs(dirs []string) {
+	c.Update()
+	c.mu.Lock()
+	if dirs == nil {
+		c.roots = nil
+	} else {
+		c.roots = make([]string, len(dirs))
+		for i, dir := range dirs {
+			c.roots[i] = filepath.Clean(dir)
+		}
+	}
+	c.setSrcDirs(c.rootDirs(c.ctxt))
+	c.mu.Unlock()
+}
+
+// rootDirs, returns the explicit source root directories of the Context, if
+// set, otherwise the source root directories of ctxt.  Lock the mutex before
+// calling.
+func (c *Context) rootDirs(ctxt *build.Context) []string {
+	if c.roots != nil {
+		return c.roots
 	}
+	return ctxt.SrcDirs()
 }
 
 // outdated returns if the Context is outdated and should be updated.  If the
-// updateInterval is less than or equal to zero, false is always returned.
+// updateInterval is less than or equal to zero or the source root directories
+// or build.Context were explicitly set, false is always returned.  Lock the
+// mutex before calling.
 func (c *Context) outdated() bool {
 	if c.updateInterval <= 0 {
 		return false
 	}
-	c.mu.RLock()
-	update := time.Since(c.lastUpdate) >= c.updateInterval
-	c.mu.RUnlock()
-	return update
+	return c.roots == nil && !c.ctxtSet && time.Since(c.lastUpdate) >= c.updateInterval
 }
 
 // doUpdate, updates the current GOROOT and GOPATH to root and path.
@@ -148,21 +461,68 @@ func (c *Context) doUpdate(root, path string) {
 		ctxt := *c.ctxt
 		ctxt.GOPATH = path
 		ctxt.GOROOT = root
-		srcDirs := ctxt.SrcDirs()
 		c.ctxt = &ctxt
-		c.srcDirs = srcDirs
+		c.gen++
+		c.setSrcDirs(c.rootDirs(&ctxt))
 	case len(c.srcDirs) == 0:
 		if c.ctxt.GOROOT != "" || c.ctxt.GOPATH != "" {
-			c.srcDirs = c.ctxt.SrcDirs()
+			c.setSrcDirs(c.rootDirs(c.ctxt))
+		}
+	}
+}
+
+// setSrcDirs, sets the source root directories of the Context to dirs and
+// tags them by origin.  Lock the mutex for writing before calling.
+func (c *Context) setSrcDirs(dirs []string) {
+	if dirs == nil {
+		// Non-nil so that Update does not re-initialize the Context when
+		// there are no source directories, such as when GOROOT is missing
+		// and GOPATH is not set.
+		dirs = []string{}
+	}
+	goroot := ""
+	if c.ctxt.GOROOT != "" {
+		goroot = clean(c.ctxt.GOROOT) + "/src"
+	}
+	tagged := make([]SrcDir, len(dirs))
+	for i, dir := range dirs {
+		tagged[i] = SrcDir{
+			Path:   dir,
+			Goroot: goroot != "" && clean(dir) == goroot,
 		}
 	}
+	c.srcDirs = dirs
+	c.tagged = tagged
+	c.modCache = modCacheDir(c.ctxt.GOPATH)
+}
+
+// modCacheDir, returns the module cache directory for Go path gopath, if it
+// exists.
+func modCacheDir(gopath string) string {
+	dir := os.Getenv("GOMODCACHE")
+	if dir == "" {
+		list := filepath.SplitList(gopath)
+		if len(list) == 0 || list[0] == "" {
+			return ""
+		}
+		dir = filepath.Join(list[0], "pkg", "mod")
+	}
+	if !fs.IsDir(dir) {
+		return ""
+	}
+	return dir
 }
 
 // initDefault, initializes the Context to build.Default.
 func (c *Context) initDefault() {
 	ctxt := build.Default
 	ctxt.GOPATH = os.Getenv("GOPATH")
-	ctxt.GOROOT = runtime.GOROOT()
+	if c.gorootSet {
+		ctxt.GOROOT = c.goroot
+	} else {
+		ctxt.GOROOT = findGoRoot()
+	}
 	c.ctxt = &ctxt
-	c.srcDirs = ctxt.SrcDirs()
+	c.gen++
+	c.setSrcDirs(c.rootDirs(&ctxt))
 }
@@ -2,7 +2,11 @@ package pkg
 
 import (
 	"go/build"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"sync"
 	"testing"
@@ -114,6 +118,83 @@ func TestContextUpdate(t *testing.T) {
 	wg.Wait()
 }
 
+func TestContextSnapshot(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "plan9"
+	c := NewContext(&ctxt, 0)
+
+	snap := c.Snapshot()
+	if snap.GOOS != "plan9" {
+		t.Fatalf("Snapshot: GOOS: exp (plan9) got (%s)", snap.GOOS)
+	}
+	snap.GOOS = "windows"
+	if goos := c.Snapshot().GOOS; goos != "plan9" {
+		t.Errorf("Snapshot: modifying the copy changed the Context: GOOS (%s)", goos)
+	}
+
+	// Snapshots must not race with updates.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.doUpdate(randPaths())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if s := c.Snapshot(); !validpaths[s.GOROOT] && s.GOROOT != ctxt.GOROOT {
+					t.Errorf("Snapshot: invalid GOROOT: %s", s.GOROOT)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s := new(Context).Snapshot(); s.Compiler == "" {
+		t.Error("Snapshot: uninitialized Context: expected default build.Context")
+	}
+}
+
+func TestContextSetGOOS(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	c := NewContext(&ctxt, 0)
+	srcDirs := c.SrcDirs()
+
+	gen := c.generation()
+	old := c.Context()
+	c.SetGOOS("windows")
+	c.SetGOARCH("arm64")
+	if s := c.Context(); s.GOOS != "windows" || s.GOARCH != "arm64" {
+		t.Errorf("SetGOOS: exp: windows/arm64 got: %s/%s", s.GOOS, s.GOARCH)
+	}
+	if old.GOOS != "linux" || old.GOARCH != "amd64" {
+		t.Error("SetGOOS: build.Context modified in place")
+	}
+	if c.generation() != gen+2 {
+		t.Errorf("SetGOOS: generation: exp: %d got: %d", gen+2, c.generation())
+	}
+	if dirs := c.SrcDirs(); !reflect.DeepEqual(dirs, srcDirs) {
+		t.Errorf("SetGOOS: SrcDirs: exp: %q got: %q", srcDirs, dirs)
+	}
+
+	// Unchanged values are ignored.
+	gen = c.generation()
+	c.SetGOOS("windows")
+	if c.generation() != gen {
+		t.Error("SetGOOS: unchanged GOOS incremented generation")
+	}
+	c.SetGOOS("")
+	if s := c.Context().GOOS; s != build.Default.GOOS {
+		t.Errorf("SetGOOS: empty: exp: %s got: %s", build.Default.GOOS, s)
+	}
+}
+
 func TestContextPkgTargetRoot(t *testing.T) {
 
 	defaultContext := func() *build.Context {
@@ -195,6 +276,43 @@ func TestContextPkgTargetRoot(t *testing.T) {
 	}
 }
 
+func TestContextSrcDirsTagged(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	goroot := filepath.Join(tmp, "go")
+	gopath := filepath.Join(tmp, "gopath")
+	for _, dir := range []string{goroot, gopath} {
+		if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := NewContext(nil, 0)
+	c.doUpdate(goroot, gopath)
+
+	exp := []SrcDir{
+		{Path: filepath.Join(goroot, "src"), Goroot: true},
+		{Path: filepath.Join(gopath, "src"), Goroot: false},
+	}
+	dirs := c.SrcDirsTagged()
+	if !reflect.DeepEqual(dirs, exp) {
+		t.Fatalf("SrcDirsTagged: Exp (%+v) Got (%+v)", exp, dirs)
+	}
+	srcDirs := c.SrcDirs()
+	if len(srcDirs) != len(dirs) {
+		t.Fatalf("SrcDirsTagged: length Exp (%d) Got (%d)", len(srcDirs), len(dirs))
+	}
+	for i, dir := range dirs {
+		if dir.Path != srcDirs[i] {
+			t.Errorf("SrcDirsTagged (%d): Exp (%s) Got (%s)", i, srcDirs[i], dir.Path)
+		}
+	}
+}
+
 func BenchmarkGOROOT(b *testing.B) {
 	c := NewContext(nil, time.Minute)
 	b.ResetTimer()
@@ -1,11 +1,20 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
+	"go/build"
 	"log"
 	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/charlievieth/pkg/fs"
 )
 
 // TODO:
@@ -13,37 +22,210 @@ import (
 //  - Improve Corpus creation (Context) and defaults.
 //  - Remove unused fields
 
+// A RefreshMode selects how an initialized Corpus keeps its index up to date.
+type RefreshMode int
+
+const (
+	// RefreshDefault, refreshes the index every IndexInterval and when
+	// signaled by Refresh, SetRoots, SetGoRoot or SetContext.  Refreshes within a second
+	// of the last refresh are skipped.
+	RefreshDefault RefreshMode = iota
+
+	// RefreshPolling, only refreshes the index every IndexInterval.
+	RefreshPolling
+
+	// RefreshSignal, only refreshes the index when signaled.  Useful for
+	// servers that drive updates explicitly, for which polling is overhead.
+	RefreshSignal
+
+	// RefreshWatch, refreshes the index on file system events.  Not yet
+	// supported, Init returns an error.
+	RefreshWatch
+)
+
+var refreshModeStr = [...]string{
+	RefreshDefault: "Default",
+	RefreshPolling: "Polling",
+	RefreshSignal:  "Signal",
+	RefreshWatch:   "Watch",
+}
+
+func (m RefreshMode) String() string {
+	if 0 <= m && int(m) < len(refreshModeStr) {
+		return refreshModeStr[m]
+	}
+	return "Invalid"
+}
+
 type Corpus struct {
-	ctxt               *Context
-	MaxDepth           int
-	LogEvents          bool
-	IndexGoCode        bool
+	ctxt *Context
+
+	// MaxDepth, is the maximum depth of the directory trees walked below
+	// each source root.  Directories at MaxDepth are not read, so neither
+	// they nor their sub-directories are indexed, such directories are
+	// reported by MaxDepthReached and with a WarningEvent.  If less than or
+	// equal to zero the depth is not limited.  Defaults to 512.
+	MaxDepth int
+
+	LogEvents     bool
+	IndexGoCode   bool
+	IndexCommands bool // Index the idents of commands (main packages)
+	ModuleMode    bool // Index the module cache
+
+	// IndexAsm, indexes the TEXT symbols of the Go assembly (".s") files
+	// of packages as AsmDecl idents, which requires IndexGoCode.  Assembly
+	// files matching the build context are scanned for the symbols of
+	// their package, such as "TEXT ·archSqrt(SB)".  Assembly files are
+	// listed by Package.OtherFiles.  It must be set before calling Init.
+	IndexAsm bool
+
+	// AllowBinary, allows LookupOrImport, and the methods that import
+	// packages on demand, to find packages that only exist as an installed
+	// archive, such as "$GOPATH/pkg/linux_amd64/foo.a", without source
+	// files.  Like go/build, the archive is only used when no source root
+	// contains the package.  Such packages are BinaryOnly: their name is
+	// derived from the archive path and they have no files or idents.
+	// Binary-only packages are not found by walking the source roots, but
+	// are removed by Update once their archive no longer exists.
+	AllowBinary bool
+
+	// TypeCheck, enables type checking packages with TypeInfo.  Type-checked
+	// packages, and their dependencies, are cached until a package is
+	// updated or removed.  Type checking large packages uses significant
+	// memory and CPU, so it is disabled by default.
+	TypeCheck bool
+
+	// FollowSymlinks, resolves symbolic links in the paths of the source
+	// root directories with fs.EvalSymlinks, so that packages are
+	// indexed under their canonical path and roots that link to the same
+	// directory are only indexed once.  Resolved roots are cached until the
+	// next update.
+	FollowSymlinks bool
+
+	// IgnorePatterns, are gitignore-style patterns of directories that are
+	// not indexed, matched against the slash-separated path of directories
+	// relative to their source root.  Patterns without a slash, other than
+	// a trailing slash, match a directory name at any depth, such as
+	// "generated".  Patterns with a leading or middle slash are relative to
+	// the source root, such as "/vendor" or "foo/bar".  A "*" matches any
+	// sequence of characters within a name and a "**" segment matches any
+	// number of directories, such as "**/gen/**".  Negated patterns are not
+	// supported.  Directories that become ignored are removed on update.
+	IgnorePatterns []string
+
+	// PackageMode, controls how much of each package is indexed, see
+	// ImportMode.  With FindPackageName only the package clause of one Go
+	// file is parsed per package, so package files, imports and idents are
+	// not indexed.  Defaults to FindPackageFiles and must be set before Init.
+	PackageMode ImportMode
+
+	// IndexNamesOnly, only indexes the exported names of packages, without
+	// positions or idents by kind, which greatly reduces memory use.  Only
+	// Exports and Definition (without position information) are supported.
+	IndexNamesOnly bool
+
+	// PublicOnly, only indexes the idents of the public API of packages,
+	// which reduces the size of the index.  The idents of internal packages,
+	// those with an "internal" element in their import path, are not
+	// indexed and neither are unexported idents or the methods of
+	// unexported types.  Definition does not find excluded idents.  It must
+	// be set before calling Init.
+	PublicOnly bool
+
+	// MaxIndexBytes, if greater than zero, is the maximum estimated size in
+	// bytes of the ident index.  When exceeded the idents of the least
+	// recently queried packages are evicted, they are re-indexed on demand by
+	// Definition and LookupOrImport.
+	MaxIndexBytes int64
+
+	// IndexFileInfo, stats the files of packages in unchanged directories
+	// on update to detect in-place modifications.  Statting files is the
+	// dominant cost of an update, when disabled only changes that modify a
+	// directory (adding, removing or renaming files, which includes the
+	// atomic saves of most editors) are detected.  Enabled by default.
+	IndexFileInfo bool
+
+	// VerifyContent, records a hash of the contents of Go files and, when
+	// the modification time of a file changes but not its size, compares
+	// hashes before re-parsing the file.  This avoids re-indexing packages
+	// when tools, such as formatters or version control checkouts, rewrite
+	// files without changing them, at the cost of reading each changed
+	// file an additional time.
+	VerifyContent bool
+
+	// VerifySample, is the number of randomly selected packages checked by
+	// Verify.  If less than or equal to zero every package is checked.
+	VerifySample int
+
+	// ExtraFileFilter, if not nil, matches the names of non-Go files that are
+	// tracked by packages, such as ".proto" or ".tmpl" files.  Matched files
+	// are listed by Package.OtherFiles and updated along with the package,
+	// but are not parsed.  Directories without Go files are not packages,
+	// regardless of the other files they contain.
+	ExtraFileFilter fs.FilterFunc
+
+	// Progress, if not nil, is called as directories are visited during Init
+	// with the number of directories visited and the number of directories
+	// found so far.  The total increases as the directory tree is walked, so
+	// it is an estimate until the walk is complete, at which point done and
+	// total are equal.  Calls are serialized.
+	Progress func(done, total int)
+
+	// RefreshMode, selects how the index is refreshed once the Corpus is
+	// initialized, see RefreshMode.  It must be set before calling Init.
+	RefreshMode RefreshMode
+
+	// IndexInterval, is the interval at which the index is polled for
+	// changes in the RefreshDefault and RefreshPolling modes.  If less than
+	// or equal to zero the index is not polled.  Ignored by RefreshSignal.
+	IndexInterval time.Duration
+
 	IndexThrottle      float64
-	IndexInterval      time.Duration
 	log                *log.Logger
 	idents             *Index
 	packages           *PackageIndex
 	dirs               map[string]*Directory
+	snippets           lineCache
 	lastUpdate         time.Time
 	eventCh            chan Eventer
+	subs               map[chan Eventer]bool // event subscribers
+	nsubs              int32                 // number of subscribers, atomic
+	initializing       int32                 // set during Init, atomic
 	refreshIndexSignal chan bool
 	stop               chan bool
-	mu                 sync.RWMutex
+	types              *typeChecker            // lazily initialized by TypeInfo
+	modules            map[string]*moduleEntry // module root => go.mod
+	symlinks           map[string]string       // source root => resolved path
+	walkErrs           map[string]error        // directory => error reading or importing it
+	ignore             *ignoreMatcher          // compiled IgnorePatterns
+	lastIgnore         *ignoreMatcher          // IgnorePatterns of the last update, protected by updateMu
+	forked             bool                    // read-only snapshot created by Fork
+	mu                 sync.RWMutex            // protects dirs, eventCh, subs, types, modules, symlinks, walkErrs and ignore
+	updateMu           sync.Mutex              // serializes directory tree updates
 	wg                 sync.WaitGroup
 }
 
-// TODO: Do we care about missing GOROOT and GOPATH env vars?
+// NewCorpus, returns a new Corpus for the current GOROOT and GOPATH.  If no
+// valid GOROOT is found only the GOPATH is indexed.  See New, which
+// configures the Corpus with options.
 func NewCorpus() *Corpus {
+	return New()
+}
+
+// newCorpus, returns a new Corpus with the default configuration.
+func newCorpus() *Corpus {
 	logger := log.New(os.Stdout, "", log.LstdFlags)
 	c := &Corpus{
 		ctxt:               NewContext(nil, 0),
 		dirs:               make(map[string]*Directory),
 		MaxDepth:           defaultMaxDepth,
 		IndexGoCode:        true,
+		IndexFileInfo:      true,
 		LogEvents:          false,
 		log:                logger,
 		eventCh:            make(chan Eventer, 100),
 		refreshIndexSignal: make(chan bool, 1), // buffer
+		stop:               make(chan bool),
 		IndexInterval:      time.Second * 3,
 	}
 	return c
@@ -59,8 +241,19 @@ func (c *Corpus) lazyInitEventChan() {
 	}
 }
 
+// logEvents, reports if events should be logged.  Events are not logged
+// while the Corpus is initializing.
+func (c *Corpus) logEvents() bool {
+	return c.LogEvents && atomic.LoadInt32(&c.initializing) == 0
+}
+
+// notifying, reports if events are logged or have subscribers.
+func (c *Corpus) notifying() bool {
+	return c.logEvents() || atomic.LoadInt32(&c.nsubs) != 0
+}
+
 func (c *Corpus) notify(e Eventer) {
-	if !c.LogEvents || e == nil {
+	if e == nil || !c.notifying() {
 		return
 	}
 	c.lazyInitEventChan()
@@ -81,13 +274,14 @@ func (c *Corpus) eventStream() {
 		for {
 			select {
 			case e := <-c.eventCh:
-				if !c.LogEvents {
+				c.publish(e)
+				if !c.logEvents() {
 					break
 				}
-				c.log.Println(e.String())
+				c.log.Println(logMessage(e))
 				if err := e.Callback(c); err != nil {
 					// TODO: Add more info to event
-					c.log.Printf("Error: %s")
+					c.log.Printf("Error: %s", err)
 				}
 			case <-c.stop:
 				return
@@ -96,6 +290,49 @@ func (c *Corpus) eventStream() {
 	}()
 }
 
+// Subscribe returns a channel that receives the events of the Corpus and a
+// function that cancels the subscription and closes the channel.  Events are
+// sent regardless of LogEvents, but are dropped if the channel is full.
+//
+// Events are only delivered while the Corpus is running (see Init).
+func (c *Corpus) Subscribe() (<-chan Eventer, func()) {
+	ch := make(chan Eventer, 100)
+	c.mu.Lock()
+	if c.subs == nil {
+		c.subs = make(map[chan Eventer]bool)
+	}
+	c.subs[ch] = true
+	atomic.AddInt32(&c.nsubs, 1)
+	c.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.subs, ch)
+			atomic.AddInt32(&c.nsubs, -1)
+			close(ch)
+			c.mu.Unlock()
+		})
+	}
+	return ch, cancel
+}
+
+// publish, sends event e to all subscribers without blocking.
+func (c *Corpus) publish(e Eventer) {
+	if atomic.LoadInt32(&c.nsubs) == 0 {
+		return
+	}
+	c.mu.RLock()
+	for ch := range c.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	c.mu.RUnlock()
+}
+
 func (c *Corpus) refreshIndex() {
 	select {
 	case c.refreshIndexSignal <- true:
@@ -103,89 +340,390 @@ func (c *Corpus) refreshIndex() {
 	}
 }
 
+// Refresh, signals the Corpus to refresh its index.  It does not block, and
+// is a no-op if the Corpus is not initialized or the RefreshMode is
+// RefreshPolling.
+func (c *Corpus) Refresh() {
+	c.refreshIndex()
+}
+
+// refreshIndexLoop, starts the refresh mechanisms selected by RefreshMode.
 func (c *Corpus) refreshIndexLoop() {
+	var signal <-chan bool
+	if c.RefreshMode != RefreshPolling {
+		signal = c.refreshIndexSignal
+	}
+	poll := c.RefreshMode != RefreshSignal && c.IndexInterval > 0
+	throttle := c.RefreshMode == RefreshDefault
+
+	// Discard signals sent before the index was initialized.
+	select {
+	case <-c.refreshIndexSignal:
+	default:
+	}
+
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
 		lastUpdate := time.Now()
 		for {
+			var tick <-chan time.Time
+			if poll {
+				tick = time.After(c.IndexInterval)
+			}
 			select {
-			case <-c.refreshIndexSignal:
-				if time.Since(lastUpdate) >= time.Second {
-					start := time.Now()
-					c.updateIndex()
-					e := Event{
-						typ: UpdateEvent,
-						msg: fmt.Sprintf("Index: \033[33mupdated\033[0m in %s", time.Since(start)),
-					}
-					c.notify(&e)
-					lastUpdate = time.Now()
-				}
-			case <-time.After(c.IndexInterval):
-				if time.Since(lastUpdate) >= time.Second {
-					start := time.Now()
-					c.updateIndex()
-					e := Event{
-						typ: UpdateEvent,
-						msg: fmt.Sprintf("Index: \033[33mupdated\033[0m in %s", time.Since(start)),
-					}
-					c.notify(&e)
-					lastUpdate = time.Now()
-				}
+			case <-signal:
+			case <-tick:
 			case <-c.stop:
 				return
 			}
+			if throttle && time.Since(lastUpdate) < time.Second {
+				continue
+			}
+			start := time.Now()
+			c.updateIndex()
+			c.notify(newEvent(UpdateEvent, "Index", "", time.Since(start)))
+			lastUpdate = time.Now()
 		}
 	}()
 }
 
+// srcDirs, returns the source root directories to index.  If ModuleMode is
+// enabled the module cache is included.
+func (c *Corpus) srcDirs() []SrcDir {
+	dirs := c.ctxt.SrcDirsTagged()
+	if c.FollowSymlinks {
+		dirs = c.resolveSrcDirs(dirs)
+	}
+	if !c.ModuleMode {
+		return dirs
+	}
+	if dir := c.ctxt.ModCacheDir(); dir != "" {
+		s := make([]SrcDir, len(dirs), len(dirs)+1)
+		copy(s, dirs)
+		dirs = append(s, SrcDir{Path: dir, ModCache: true})
+	}
+	return dirs
+}
+
+// resolveSrcDirs, returns a copy of dirs with symbolic links in the path of
+// each directory resolved.  Directories that resolve to the path of a
+// previous directory are removed.
+func (c *Corpus) resolveSrcDirs(dirs []SrcDir) []SrcDir {
+	s := make([]SrcDir, 0, len(dirs))
+	seen := make(map[string]bool, len(dirs))
+	for _, d := range dirs {
+		d.Path = c.evalSymlinks(d.Path)
+		if !seen[d.Path] {
+			seen[d.Path] = true
+			s = append(s, d)
+		}
+	}
+	return s
+}
+
+// evalSymlinks, returns path with any symbolic links resolved, or path if it
+// cannot be resolved.  Results are cached until resetSymlinks is called.
+func (c *Corpus) evalSymlinks(path string) string {
+	c.mu.RLock()
+	s, ok := c.symlinks[path]
+	c.mu.RUnlock()
+	if ok {
+		return s
+	}
+	s = path
+	if p, err := fs.EvalSymlinks(path); err == nil {
+		s = clean(p)
+	}
+	c.mu.Lock()
+	if c.symlinks == nil {
+		c.symlinks = make(map[string]string)
+	}
+	c.symlinks[path] = s
+	c.mu.Unlock()
+	return s
+}
+
+// resetSymlinks, clears the cache of resolved source roots.
+func (c *Corpus) resetSymlinks() {
+	c.mu.Lock()
+	c.symlinks = nil
+	c.mu.Unlock()
+}
+
+// Errors, returns the last error encountered indexing each directory, keyed
+// by directory.  This includes directories that could not be read or
+// imported while walking the source roots and packages with errors, such as
+// files that failed to parse or declare conflicting package names.
+// Directories without buildable Go files are not errors.
+//
+// Entries are removed when a directory is re-indexed without error or
+// removed.  The returned map is a copy.
+func (c *Corpus) Errors() map[string]error {
+	errs := c.walkErrors()
+	if c.packages != nil {
+		c.packages.each(func(p *Package) bool {
+			if err := p.indexError(); err != nil && errs[p.Dir] == nil {
+				errs[p.Dir] = err
+			}
+			return true
+		})
+	}
+	return errs
+}
+
+// MaxDepthReached, returns the sorted paths of the directories at MaxDepth
+// that were not read, so neither they nor their sub-directories are indexed.
+// Very deep trees are usually the result of symbolic links that form a chain
+// or loop.
+func (c *Corpus) MaxDepthReached() []string {
+	var paths []string
+	for _, dir := range c.dirTrees() {
+		if dir == nil {
+			continue
+		}
+		for d := range dir.iter(false) {
+			if d.Truncated {
+				paths = append(paths, d.Path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// walkErrors, returns a copy of the errors encountered walking directories.
+func (c *Corpus) walkErrors() map[string]error {
+	c.mu.RLock()
+	errs := make(map[string]error, len(c.walkErrs))
+	for dir, err := range c.walkErrs {
+		errs[dir] = err
+	}
+	c.mu.RUnlock()
+	return errs
+}
+
+// setWalkError, records error err encountered walking directory dir, or
+// clears the error of dir if err is nil.
+func (c *Corpus) setWalkError(dir string, err error) {
+	c.mu.Lock()
+	if err != nil {
+		if c.walkErrs == nil {
+			c.walkErrs = make(map[string]error)
+		}
+		c.walkErrs[dir] = err
+	} else {
+		delete(c.walkErrs, dir)
+	}
+	c.mu.Unlock()
+}
+
+// clearWalkErrors, clears the errors of directory dir and its
+// sub-directories.
+func (c *Corpus) clearWalkErrors(dir string) {
+	c.mu.Lock()
+	for path := range c.walkErrs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			delete(c.walkErrs, path)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// dirTrees, returns the directory trees of the Corpus keyed by source root.
+// The map is replaced, not modified, on update and must not be modified.
+func (c *Corpus) dirTrees() map[string]*Directory {
+	c.mu.RLock()
+	dirs := c.dirs
+	c.mu.RUnlock()
+	return dirs
+}
+
+// setDirTrees, replaces the directory trees of the Corpus with dirs.
+func (c *Corpus) setDirTrees(dirs map[string]*Directory) {
+	c.mu.Lock()
+	c.dirs = dirs
+	c.mu.Unlock()
+}
+
+// updateIndex, updates the directory trees of the Corpus.  The updated trees
+// are built without holding the mutex and then swapped in, so readers always
+// see a complete directory tree.  Updates are serialized.
 func (c *Corpus) updateIndex() {
-	srcDirs := c.ctxt.SrcDirs()
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	// Symlinked roots may have been re-pointed.
+	c.resetSymlinks()
+
+	// Directories that are no longer ignored are only found if their
+	// parent is re-read, so re-read all directories when the patterns
+	// change.
+	ignore := c.ignoreMatcher()
+	rescan := ignore != c.lastIgnore
+	c.lastIgnore = ignore
+
+	prev := c.dirTrees()
+	dirs := make(map[string]*Directory, len(prev))
 	seen := make(map[string]bool)
-	for _, root := range srcDirs {
+	for _, srcDir := range c.srcDirs() {
+		root := srcDir.Path
 		seen[root] = true
 		var d *Directory
-		if dir := c.dirs[root]; dir != nil {
-			d = newTreeBuilder(c, c.MaxDepth).updateDirTree(dir)
+		if dir := prev[root]; dir != nil {
+			t := newTreeBuilder(c, c.MaxDepth)
+			t.rescan = rescan
+			d = t.updateDirTree(dir)
 		} else {
 			d = c.newDirectory(root, c.MaxDepth)
 		}
 		if d != nil {
-			c.dirs[root] = d
-		} else {
-			delete(c.dirs, root)
+			dirs[root] = d
 		}
 	}
 	// Remove missing directories
-	for root := range c.dirs {
+	for root, dir := range prev {
 		if !seen[root] {
-			delete(c.dirs, root)
+			newTreeBuilder(c, c.MaxDepth).removePackage(dir)
 		}
 	}
+	c.setDirTrees(dirs)
+	if c.packages != nil {
+		c.packages.updateBinary()
+	}
+	c.reconcile()
+}
+
+// SetRoots sets the source root directories indexed by the Corpus to roots,
+// instead of the directories derived from GOROOT and GOPATH.  If roots is nil
+// the Corpus reverts to indexing GOROOT and GOPATH.
+//
+// If the Corpus is initialized, the index is updated on the next refresh.
+func (c *Corpus) SetRoots(roots []string) {
+	c.ctxt.SetSrcDirs(roots)
+	c.refreshIndex()
+}
+
+// SetGoRoot sets the GOROOT of the Corpus to root, instead of the GOROOT
+// environment variable or the GOROOT the binary was built with.  If root is
+// empty or not a valid Go root only the GOPATH is indexed.
+//
+// If the Corpus is initialized, the index is updated on the next refresh.
+func (c *Corpus) SetGoRoot(root string) {
+	c.ctxt.SetGoRoot(root)
+	c.refreshIndex()
+}
+
+// SetContext replaces the build.Context of the Corpus with a copy of ctxt,
+// such as one for the GOROOT and GOPATH of a different workspace, see
+// Context.SetContext.  If ctxt is nil the Corpus reverts to build.Default
+// and the current GOROOT and GOPATH.
+//
+// Since packages are matched against the build.Context, the package and
+// ident indexes are cleared, instead of mixing results from both contexts,
+// and the source root directories are re-indexed on the next refresh or
+// Update.  Forks are not modified.
+func (c *Corpus) SetContext(ctxt *build.Context) {
+	if c.forked {
+		return
+	}
+	c.updateMu.Lock()
+	c.ctxt.SetContext(ctxt)
+	c.resetIndex()
+	c.updateMu.Unlock()
+	c.refreshIndex()
+}
+
+// SetPlatform sets the GOOS and GOARCH of the build context of the Corpus,
+// such as to index the packages of another platform, empty values use those
+// of build.Default.  Unlike SetContext the index is kept: the Go files of
+// indexed packages are re-matched, see PackageIndex.InvalidateContext, and
+// the source root directories are refreshed.  Forks are not modified.
+func (c *Corpus) SetPlatform(goos, goarch string) {
+	if c.forked {
+		return
+	}
+	if goos == "" {
+		goos = build.Default.GOOS
+	}
+	if goarch == "" {
+		goarch = build.Default.GOARCH
+	}
+	c.updateMu.Lock()
+	if c.ctxt.setPlatform(goos, goarch) && c.packages != nil {
+		c.packages.InvalidateContext(true)
+		c.invalidateTypes()
+	}
+	c.updateMu.Unlock()
+	c.refreshIndex()
+}
+
+// resetIndex, removes every directory tree and package from the Corpus.
+// Lock updateMu before calling.
+func (c *Corpus) resetIndex() {
+	c.setDirTrees(make(map[string]*Directory))
+	c.mu.Lock()
+	c.walkErrs = nil
+	c.symlinks = nil
+	c.mu.Unlock()
+	if c.packages != nil {
+		c.packages.reset()
+	}
+	if c.idents != nil {
+		c.idents.reset()
+	}
+	c.invalidateTypes()
 }
 
 func (c *Corpus) Init() error {
-	logEvents := c.LogEvents
-	c.LogEvents = false
-	c.eventStream()
-	if c.packages == nil {
-		c.packages = newPackageIndex(c)
+	if c.forked {
+		return errors.New("pkg: cannot initialize a forked Corpus")
 	}
-	if c.IndexGoCode {
-		c.idents = newIndex(c)
+	c.updateMu.Lock()
+	err := c.initIndexes()
+	c.updateMu.Unlock()
+	if err != nil {
+		return err
 	}
+	atomic.StoreInt32(&c.initializing, 1)
+	defer atomic.StoreInt32(&c.initializing, 0)
+	c.eventStream()
 	if err := c.initDirTree(); err != nil {
 		return err
 	}
-	c.LogEvents = logEvents
 	c.refreshIndexLoop()
 	return nil
 }
 
+// initIndexes, validates the options of the Corpus and creates its package
+// index and, if IndexGoCode is enabled, its Index before the first walk of
+// the source roots, see Init and Scan.  Lock updateMu before calling.
+func (c *Corpus) initIndexes() error {
+	switch c.RefreshMode {
+	case RefreshDefault, RefreshPolling, RefreshSignal:
+	default:
+		return fmt.Errorf("pkg: unsupported refresh mode: %s (%d)", c.RefreshMode, int(c.RefreshMode))
+	}
+	if _, err := compileIgnorePatterns(c.IgnorePatterns); err != nil {
+		return err
+	}
+	if c.packages == nil {
+		c.packages = newPackageIndex(c)
+	}
+	// Keep an existing Index, the packages it indexes are kept and are not
+	// re-indexed if unchanged.  An Index loaded by LoadIndex is reconciled by
+	// the walk.
+	if c.IndexGoCode && c.idents == nil {
+		c.idents = newIndex(c)
+	}
+	return nil
+}
+
 func (c *Corpus) Stop() {
 	select {
 	case <-c.stop:
 		c.log.Println("Corpus: index not running!")
+		return
 	default:
 		c.log.Println("Corpus: stopping index.")
 	}
@@ -195,37 +733,85 @@ func (c *Corpus) Stop() {
 	c.log.Printf("Corpus: shutdown complete, elapsed time: %s", time.Since(t))
 }
 
-// WARN
+// Update, updates the directory trees and package index of the Corpus.  It
+// is a no-op if the Corpus is a fork, see Fork.
+//
+// Directories that have not changed since the last update are not re-read,
+// instead the files of any packages they contain are statted to check for
+// changes.  Statting files accounts for the majority of update time, to skip
+// it set IndexFileInfo to false, see IndexFileInfo for more information.
 func (c *Corpus) Update() {
-	for root, dir := range c.dirs {
-		t := newTreeBuilder(c, c.MaxDepth)
-		dir = t.updateDirTree(dir)
-		if dir == nil {
-			panic("NIL DIR " + root)
-		}
+	if c.forked {
+		return
 	}
+	c.updateIndex()
 }
 
 // initDirTree, initializes the Directory tree's at build.Context.SrcDirs().
 // An error is returned if root is not a directory or there was an error
 // statting it.
 func (c *Corpus) initDirTree() error {
-	srcDirs := c.ctxt.SrcDirs()
-	for _, root := range srcDirs {
-		if dir := c.newDirectory(root, c.MaxDepth); dir != nil {
-			c.dirs[root] = dir
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+	c.buildDirTrees(newTreeBuilder(c, c.MaxDepth))
+	c.reconcile()
+	return nil
+}
+
+// buildDirTrees, builds the directory trees of the source roots with tree
+// builder t and replaces the directory trees of the Corpus.  If the walk is
+// canceled, only the trees of the roots that were completely walked are
+// replaced.  Lock updateMu before calling.
+func (c *Corpus) buildDirTrees(t *treeBuilder) {
+	srcDirs := c.srcDirs()
+	t.progress = c.Progress
+	c.lastIgnore = t.ignore
+	t.found(len(srcDirs))
+	dirs := make(map[string]*Directory, len(srcDirs))
+	walked := make([]string, 0, len(srcDirs))
+	for _, srcDir := range srcDirs {
+		dir := t.newRootDir(srcDir.Path)
+		if t.canceled() {
+			break
+		}
+		walked = append(walked, srcDir.Path)
+		if dir != nil {
+			dirs[srcDir.Path] = dir
 		}
 	}
-	return nil
+	if len(walked) != len(srcDirs) {
+		prev := c.dirTrees()
+		trees := make(map[string]*Directory, len(prev))
+		for root, dir := range prev {
+			trees[root] = dir
+		}
+		for _, root := range walked {
+			if dir := dirs[root]; dir != nil {
+				trees[root] = dir
+			} else {
+				delete(trees, root)
+			}
+		}
+		dirs = trees
+	}
+	c.setDirTrees(dirs)
 }
 
 func (c *Corpus) newDirectory(root string, maxDepth int) *Directory {
-	t := newTreeBuilder(c, maxDepth)
-	fi, err := os.Stat(root)
-	if err != nil || !fi.IsDir() {
-		return nil
+	return newTreeBuilder(c, maxDepth).newRootDir(root)
+}
+
+// EachPackage calls fn for each indexed package, in no particular order,
+// until fn returns false.  Unlike Packages, no map or slice of the packages
+// is built and iterating is safe while the index is updated.
+//
+// The package index is read locked while iterating, which blocks updates,
+// so fn must not block for long and must not modify the index, such as by
+// calling LookupOrImport or Update, which would deadlock.
+func (c *Corpus) EachPackage(fn func(*Package) bool) {
+	if c.packages != nil {
+		c.packages.each(fn)
 	}
-	return t.newDirTree(root, fi, 0, false)
 }
 
 // WARN
@@ -233,12 +819,25 @@ func (c *Corpus) Packages() map[string]map[string]*Package {
 	return c.packages.packages
 }
 
-// WARN
+// Dirs returns a copy of the map of source root directories to their
+// directory trees.  The directory trees must not be modified.
 func (c *Corpus) Dirs() map[string]*Directory {
-	return c.dirs
+	dirs := c.dirTrees()
+	m := make(map[string]*Directory, len(dirs))
+	for root, dir := range dirs {
+		m[root] = dir
+	}
+	return m
 }
 
-// WARN
+// Index returns the ident index of the Corpus, or nil if IndexGoCode is
+// disabled or the Corpus is not initialized.
+func (c *Corpus) Index() *Index {
+	return c.idents
+}
+
+// Idents returns a snapshot of all the indexed Idents, see Index.Idents for
+// the ordering guarantee.
 func (c *Corpus) Idents() []Ident {
 	if c.idents == nil {
 		return nil
@@ -248,8 +847,711 @@ func (c *Corpus) Idents() []Ident {
 
 func (c *Corpus) DirList() map[string]*DirList {
 	m := make(map[string]*DirList)
-	for root, dir := range c.dirs {
+	for root, dir := range c.dirTrees() {
 		m[root] = dir.listing(true, nil)
 	}
 	return m
 }
+
+// Subtree returns the DirList of the directory tree rooted at path, which is
+// either an absolute directory path or an import path.  The directory at path
+// is included in the list with a Depth of zero and the Path of each entry is
+// relative to it.  The second result reports if the directory was found.
+func (c *Corpus) Subtree(path string) (*DirList, bool) {
+	return c.SubtreeFunc(path, nil)
+}
+
+// SubtreeFunc is like Subtree, but only lists the directories with a path
+// matched by filter.  Depths and heights are relative to the listed
+// directories.
+func (c *Corpus) SubtreeFunc(path string, filter func(string) bool) (*DirList, bool) {
+	dir := c.lookupDir(path)
+	if dir == nil {
+		return nil, false
+	}
+	list := dir.listing(false, filter)
+	return list, list != nil
+}
+
+// lookupDir, returns the Directory at path, which is either an absolute
+// directory path or an import path that is resolved against the source roots.
+func (c *Corpus) lookupDir(path string) *Directory {
+	if path == "" {
+		return nil
+	}
+	if filepath.IsAbs(path) {
+		for _, dir := range c.dirTrees() {
+			if d := dir.lookup(path); d != nil {
+				return d
+			}
+		}
+		return nil
+	}
+	dirs := c.dirTrees()
+	for _, srcDir := range c.srcDirs() {
+		if dir := dirs[srcDir.Path]; dir != nil {
+			if d := dir.lookup(pathpkg.Join(clean(srcDir.Path), path)); d != nil {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// Search returns the indexed idents of every kind named name, sorted by Path
+// then Name.  Methods are matched by their method name, without the type
+// name, see Find for selector expressions.  If IndexGoCode is disabled nil is
+// returned.
+func (c *Corpus) Search(name string) []Ident {
+	if c.idents == nil {
+		return nil
+	}
+	return c.idents.lookupName(name, AllKinds)
+}
+
+// SearchFold is like Search, but matches names under Unicode case folding,
+// so "readfile" finds "ReadFile".  If name is a selector expression, such as
+// "client.do", only the methods named "<type>.<method>" are matched.  Names
+// are folded at query time, so unlike Search every indexed name is compared.
+func (c *Corpus) SearchFold(name string) []Ident {
+	if c.idents == nil || name == "" {
+		return nil
+	}
+	return c.idents.lookupFold(name)
+}
+
+// SearchPrefix returns the indexed idents whose name starts with prefix, see
+// Index.SearchPrefix.  If IndexGoCode is disabled nil is returned.
+func (c *Corpus) SearchPrefix(prefix string, limit int) []Ident {
+	if c.idents == nil {
+		return nil
+	}
+	return c.idents.SearchPrefix(prefix, limit)
+}
+
+// SearchPrefixExported returns the exported idents whose name starts with
+// prefix, see Index.SearchPrefixExported.  If IndexGoCode is disabled nil is
+// returned.
+func (c *Corpus) SearchPrefixExported(prefix string, limit int) []Ident {
+	if c.idents == nil {
+		return nil
+	}
+	return c.idents.SearchPrefixExported(prefix, limit)
+}
+
+// IdentsAllBuilds returns all idents named name, including those declared in
+// Go files excluded by the current build context (i.e. other GOOS/GOARCH).
+// Idents from excluded files have their Constraint field set to the build
+// constraint that gates them.
+//
+// Excluded files are parsed on demand and the results cached, so repeated
+// queries are cheap.
+func (c *Corpus) IdentsAllBuilds(name string) []Ident {
+	if c.idents == nil {
+		return nil
+	}
+	return c.idents.identsAllBuilds(name)
+}
+
+// Importers returns the sorted import paths of the indexed packages that
+// directly import the package with import path importPath, see
+// PackageIndex.Importers and AffectedBy for transitive importers.
+func (c *Corpus) Importers(importPath string) []string {
+	if c.packages == nil {
+		return nil
+	}
+	return c.packages.Importers(importPath)
+}
+
+// AffectedBy returns the import paths of the packages that directly or
+// transitively import the package containing the file at path, in sorted
+// order.  The package containing path is not included.  Import cycles are
+// handled.
+//
+// Import paths are matched literally, vendored imports are not resolved.
+func (c *Corpus) AffectedBy(path string) []string {
+	if c.packages == nil {
+		return nil
+	}
+	path = clean(path)
+	p, ok := c.packages.lookupPath(path)
+	if !ok {
+		if p, ok = c.packages.lookupPath(pathpkg.Dir(path)); !ok {
+			return nil
+		}
+	}
+	seen := map[string]bool{p.ImportPath: true}
+	queue := []string{p.ImportPath}
+	var list []string
+	for len(queue) != 0 {
+		path := queue[0]
+		queue = queue[1:]
+		for _, s := range c.packages.Importers(path) {
+			if !seen[s] {
+				seen[s] = true
+				queue = append(queue, s)
+				list = append(list, s)
+			}
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Deps returns the sorted import paths of the packages that the package with
+// import path importPath directly or transitively imports, excluding the
+// package itself.  Only the imports of buildable Go files are followed.  Each
+// import is resolved from the directory of the importing package, like
+// WhyNotFound, so vendored packages are listed by their full import path,
+// such as "a/vendor/b".  Packages that are not indexed are imported on demand.
+// Import cycles are handled.
+//
+// If the package cannot be found an error is returned.  Imports that cannot be
+// resolved are not followed, they are reported by an UnresolvedImportsError
+// that is returned along with the dependencies that were resolved.  The
+// pseudo-package "C" is ignored.
+func (c *Corpus) Deps(importPath string) ([]string, error) {
+	root, err := c.importPackage(importPath)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{root.ImportPath: true}
+	missing := make(map[string]bool)
+	queue := []*Package{root}
+	var deps []string
+	for len(queue) != 0 {
+		p := queue[0]
+		queue = queue[1:]
+		c.packages.mu.RLock()
+		imports := p.importPaths()
+		c.packages.mu.RUnlock()
+		for _, path := range imports {
+			if path == "C" {
+				continue
+			}
+			q, err := c.resolveImport(path, p.Dir)
+			if err != nil {
+				missing[path] = true
+				continue
+			}
+			if !seen[q.ImportPath] {
+				seen[q.ImportPath] = true
+				deps = append(deps, q.ImportPath)
+				queue = append(queue, q)
+			}
+		}
+	}
+	sort.Strings(deps)
+	if len(missing) != 0 {
+		e := &UnresolvedImportsError{ImportPath: root.ImportPath}
+		for path := range missing {
+			e.Imports = append(e.Imports, path)
+		}
+		sort.Strings(e.Imports)
+		return deps, e
+	}
+	return deps, nil
+}
+
+// UnresolvedImportsError describes the imports that could not be resolved
+// when listing the dependencies of a package, see Corpus.Deps.
+type UnresolvedImportsError struct {
+	ImportPath string   // import path of the package
+	Imports    []string // sorted import paths that could not be resolved
+}
+
+func (e *UnresolvedImportsError) Error() string {
+	return fmt.Sprintf("pkg: dependencies of %q: cannot find packages: %s",
+		e.ImportPath, strings.Join(e.Imports, ", "))
+}
+
+// Definition returns the declaration of the identifier name exported by the
+// package with import path importPath.  Methods are named "<Type>.<Method>".
+// If the package is not indexed it is imported and indexed on demand.
+func (c *Corpus) Definition(importPath, name string) (Ident, bool) {
+	if c.idents == nil {
+		return Ident{}, false
+	}
+	if !c.idents.hasPackage(importPath) {
+		if _, err := c.LookupOrImport(importPath); err != nil {
+			return Ident{}, false
+		}
+	}
+	id, ok := c.idents.lookupExports(importPath)[name]
+	return id, ok
+}
+
+// IdentAt returns the Ident declared at the position in file, see
+// Index.IdentAt.  If the package of the file is not indexed, it is imported
+// and indexed on demand.
+func (c *Corpus) IdentAt(file string, line, col int) (Ident, bool) {
+	if c.idents == nil || c.packages == nil {
+		return Ident{}, false
+	}
+	root, dir := c.packages.matchSrcRoot(pathpkg.Dir(file))
+	if root == "" {
+		return c.idents.IdentAt(file, line, col)
+	}
+	// Idents are recorded under the resolved path of their file.
+	file = pathpkg.Join(dir, pathpkg.Base(file))
+	if p, ok := c.packages.lookup(root, trimPathPrefix(dir, root)); ok {
+		if !c.idents.hasPackage(p.ImportPath) {
+			c.idents.indexPackage(p)
+		}
+	} else if _, err := c.LookupOrImport(trimPathPrefix(dir, root)); err != nil {
+		return Ident{}, false
+	}
+	return c.idents.IdentAt(file, line, col)
+}
+
+// MethodSet returns the methods of the type typeName declared by the package
+// with import path importPath, sorted by method name.  Methods are named
+// "<Type>.<Method>" after the type that declares them.
+//
+// If TypeCheck is enabled the package is type-checked and the method set
+// includes the methods promoted from embedded fields, as well as the methods
+// of interface types.  Otherwise, or if the type cannot be resolved, only the
+// methods declared with typeName as their receiver are returned, which
+// requires IndexGoCode.
+func (c *Corpus) MethodSet(importPath, typeName string) []Ident {
+	var ids []Ident
+	ok := false
+	if c.TypeCheck {
+		ids, ok = c.typeMethodSet(importPath, typeName)
+	}
+	if !ok {
+		if c.idents == nil {
+			return nil
+		}
+		if !c.idents.hasPackage(importPath) {
+			if _, err := c.LookupOrImport(importPath); err != nil {
+				return nil
+			}
+		}
+		ids = c.idents.methods(importPath, typeName)
+	}
+	sort.Sort(byMethodName(ids))
+	return ids
+}
+
+// Exports returns the sorted exported names declared by the package with
+// import path importPath, see Index.Exports for more information.
+func (c *Corpus) Exports(importPath string) []string {
+	if c.idents == nil {
+		return nil
+	}
+	return c.idents.Exports(importPath)
+}
+
+// LookupOrImport returns the package with import path importPath.  If the
+// package is not indexed, such as packages outside of the source roots or
+// below MaxDepth, its directory is resolved against the source roots of the
+// Corpus and the package is imported and added to the index.  If IndexGoCode
+// is enabled the idents of the package are also indexed.
+func (c *Corpus) LookupOrImport(importPath string) (*Package, error) {
+	p, err := c.importPackage(importPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.idents != nil && !c.idents.hasPackage(p.ImportPath) {
+		c.idents.indexPackage(p)
+	}
+	return p, nil
+}
+
+// importPackage, returns the package with import path importPath, importing
+// it from the first source root that contains it, if it is not indexed.
+func (c *Corpus) importPackage(importPath string) (*Package, error) {
+	if c.packages == nil {
+		return nil, errors.New("pkg: corpus not initialized")
+	}
+	if !validImportPath(importPath) {
+		return nil, fmt.Errorf("pkg: invalid import path %q", importPath)
+	}
+	srcDirs := c.srcDirs()
+	for _, srcDir := range srcDirs {
+		if p, ok := c.packages.lookup(srcDir.Path, importPath); ok {
+			return p, nil
+		}
+	}
+	for _, srcDir := range srcDirs {
+		if srcDir.ModCache {
+			continue
+		}
+		dir := pathpkg.Join(clean(srcDir.Path), importPath)
+		if fs.IsDir(dir) {
+			return c.packages.ImportDir(dir)
+		}
+	}
+	if c.AllowBinary {
+		for _, srcDir := range srcDirs {
+			if srcDir.ModCache {
+				continue
+			}
+			if p, ok := c.packages.importBinary(srcDir, importPath); ok {
+				return p, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("pkg: cannot find package %q", importPath)
+}
+
+// validImportPath, reports if importPath may be imported from a source root,
+// which excludes absolute and relative import paths and paths that are not
+// clean or contain "." or ".." elements, which could escape the root.
+func validImportPath(importPath string) bool {
+	if importPath == "" || pathpkg.IsAbs(importPath) || strings.HasPrefix(importPath, ".") ||
+		pathpkg.Clean(importPath) != importPath {
+		return false
+	}
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveImport, returns the package with import path importPath imported by
+// the package in directory srcDir, searching the vendor directories of srcDir
+// and its parents before the source root directories, see importPackage.  If
+// srcDir is empty, vendor directories are not searched.
+func (c *Corpus) resolveImport(importPath, srcDir string) (*Package, error) {
+	if c.packages == nil {
+		return nil, errors.New("pkg: corpus not initialized")
+	}
+	for _, vdir := range c.vendorDirs(importPath, srcDir) {
+		if p, ok := c.packages.lookupPath(vdir); ok {
+			return p, nil
+		}
+		if fs.IsDir(vdir) {
+			return c.packages.ImportDir(vdir)
+		}
+	}
+	return c.importPackage(importPath)
+}
+
+// vendorDirs, returns the vendor directories searched for import path
+// importPath by the package in directory srcDir, from the innermost, see
+// resolveImport.
+func (c *Corpus) vendorDirs(importPath, srcDir string) []string {
+	if srcDir == "" || !validImportPath(importPath) {
+		return nil
+	}
+	root, srcDir := c.packages.matchSrcRoot(srcDir)
+	if root == "" {
+		return nil
+	}
+	var dirs []string
+	for d := srcDir; hasRoot(d, root); d = pathpkg.Dir(d) {
+		dirs = append(dirs, pathpkg.Join(d, "vendor", importPath))
+		if d == root {
+			break
+		}
+	}
+	return dirs
+}
+
+// WhyNotFound, explains why the package with import path importPath, imported
+// by the package in directory srcDir, cannot be found.  Like the go command,
+// it lists every directory that was searched, for example:
+//
+//	cannot find package "example.com/x" in any of:
+//		/home/user/go/src/a/vendor/example.com/x (vendor tree)
+//		/usr/local/go/src/example.com/x (from $GOROOT)
+//		/home/user/go/src/example.com/x (from $GOPATH)
+//
+// The vendor directories of srcDir and its parents are searched first, unless
+// srcDir is empty, then the source root directories.  Packages in the module
+// cache are only found if they are indexed.  If a directory was found but its
+// package cannot be imported, the error importing it is returned instead.  An
+// empty string is returned if the package is found, in which case it is
+// imported, as by LookupOrImport.
+func (c *Corpus) WhyNotFound(importPath, srcDir string) string {
+	if srcDir != "" {
+		srcDir = clean(srcDir)
+	}
+	_, err := c.resolveImport(importPath, srcDir)
+	if err == nil {
+		return ""
+	}
+	if c.packages == nil || !validImportPath(importPath) {
+		return err.Error()
+	}
+	type tried struct {
+		dir, from string
+	}
+	var list []tried
+	for _, dir := range c.vendorDirs(importPath, srcDir) {
+		list = append(list, tried{dir, "vendor tree"})
+	}
+	for _, sd := range c.srcDirs() {
+		switch {
+		case sd.ModCache:
+		case sd.Goroot:
+			list = append(list, tried{pathpkg.Join(clean(sd.Path), importPath), "from $GOROOT"})
+		default:
+			list = append(list, tried{pathpkg.Join(clean(sd.Path), importPath), "from $GOPATH"})
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "cannot find package %q in any of:", importPath)
+	for _, t := range list {
+		if fs.IsDir(t.dir) {
+			// Found, but not importable.
+			return err.Error()
+		}
+		fmt.Fprintf(&b, "\n\t%s (%s)", t.dir, t.from)
+	}
+	return b.String()
+}
+
+// A PathConflict is an import path provided by packages in more than one
+// source root.  Like the go tool, the package in the first source root wins
+// and shadows the others.
+type PathConflict struct {
+	ImportPath string   // Import path "net/http"
+	Dir        string   // Directory of the package that is imported
+	Shadowed   []string // Directories of the shadowed packages, in source root order
+}
+
+// Conflicts, returns the import paths provided by packages in more than one
+// source root, sorted by import path.  The winning directory is resolved in
+// source root order, GOROOT before GOPATH, which is the order used by
+// importPackage and the go tool.  The module cache is not included, as its
+// packages are resolved by version.
+//
+// Conflicts help debug "wrong version of package" problems, where a copy of
+// a package in GOROOT or an earlier GOPATH entry shadows the intended one.
+func (c *Corpus) Conflicts() []PathConflict {
+	if c.packages == nil {
+		return nil
+	}
+	var roots []string
+	for _, srcDir := range c.srcDirs() {
+		if !srcDir.ModCache {
+			roots = append(roots, srcDir.Path)
+		}
+	}
+	if len(roots) < 2 {
+		return nil
+	}
+	x := c.packages
+	var conflicts []PathConflict
+	x.mu.RLock()
+	for i, root := range roots {
+		for path, p := range x.packages[root] {
+			// Only report the conflict from the winning root.
+			if shadowed(x.packages, roots[:i], path) {
+				continue
+			}
+			var dirs []string
+			for _, other := range roots[i+1:] {
+				if q, ok := x.packages[other][path]; ok {
+					dirs = append(dirs, q.Dir)
+				}
+			}
+			if len(dirs) != 0 {
+				conflicts = append(conflicts, PathConflict{
+					ImportPath: path,
+					Dir:        p.Dir,
+					Shadowed:   dirs,
+				})
+			}
+		}
+	}
+	x.mu.RUnlock()
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].ImportPath < conflicts[j].ImportPath
+	})
+	return conflicts
+}
+
+// shadowed, reports if any of the source roots contain a package at path.
+func shadowed(pkgs map[string]map[string]*Package, roots []string, path string) bool {
+	for _, root := range roots {
+		if _, ok := pkgs[root][path]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryOptions control the pagination of query results.  Results are
+// always sorted, so paging through a query is stable as long as the index
+// does not change.
+type QueryOptions struct {
+	Limit  int     // Maximum number of results, unlimited if less than or equal to zero
+	Offset int     // Number of results to skip
+	Kinds  KindSet // Kinds of idents to match, all kinds if zero
+}
+
+// page, returns the start and end indexes of the page of a result of length n.
+func (o QueryOptions) page(n int) (start, end int) {
+	start = o.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > n {
+		start = n
+	}
+	end = n
+	if o.Limit > 0 && start+o.Limit < n {
+		end = start + o.Limit
+	}
+	return start, end
+}
+
+// pageStrings, returns the page of s specified by opts.
+func pageStrings(s []string, opts QueryOptions) []string {
+	i, j := opts.page(len(s))
+	return s[i:j]
+}
+
+// A SearchResult is the result of a Corpus Find query.
+type SearchResult struct {
+	Packages []*Package // Packages matching the query by import path or name
+	Idents   []Ident    // Idents matching the query
+	Total    int        // Total number of matching packages and idents
+}
+
+// Find interprets query as a package import path, package name or identifier
+// and returns the matching packages and idents.
+//
+// Packages with an import path equal to query are listed first, followed by
+// packages named query.  Idents are matched by name, if query is a selector
+// expression (i.e. "http.Client" or "Client.Do") the idents exported by the
+// named package and methods of the named type are also matched.
+func (c *Corpus) Find(query string) SearchResult {
+	return c.find(query, AllKinds)
+}
+
+// find, implements Find and FindPage, only idents of the given kinds are
+// matched.
+func (c *Corpus) find(query string, kinds KindSet) SearchResult {
+	var res SearchResult
+	if query == "" {
+		return res
+	}
+	if c.packages != nil {
+		res.Packages = c.packages.findPackages(query)
+	}
+	if c.idents != nil {
+		if i := strings.LastIndexByte(query, '.'); i > 0 && i < len(query)-1 {
+			res.Idents = c.idents.lookupSelector(query[:i], query[i+1:], kinds)
+		} else {
+			res.Idents = c.idents.lookupName(query, kinds)
+		}
+	}
+	res.Total = len(res.Packages) + len(res.Idents)
+	return res
+}
+
+// FindPage is like Find, but only returns the page of results specified by
+// opts.  Packages are ordered before idents.  The Total of the SearchResult
+// is the number of results before pagination.  If opts.Kinds is set only
+// idents of those kinds are matched, packages are matched regardless.
+func (c *Corpus) FindPage(query string, opts QueryOptions) SearchResult {
+	res := c.find(query, opts.Kinds)
+	i, j := opts.page(res.Total)
+	n := len(res.Packages)
+	switch {
+	case j <= n:
+		res.Packages = res.Packages[i:j]
+		res.Idents = nil
+	case i >= n:
+		res.Packages = nil
+		res.Idents = res.Idents[i-n : j-n]
+	default:
+		res.Packages = res.Packages[i:]
+		res.Idents = res.Idents[:j-n]
+	}
+	return res
+}
+
+// ImportPaths returns the sorted, de-duplicated import paths of the indexed
+// packages, paginated by opts, and the total number of import paths.
+func (c *Corpus) ImportPaths(opts QueryOptions) ([]string, int) {
+	if c.packages == nil {
+		return nil, 0
+	}
+	s := c.packages.importPathList()
+	return pageStrings(s, opts), len(s)
+}
+
+// Commands returns the indexed commands (packages named "main"), sorted by
+// import path.
+func (c *Corpus) Commands() []*Package {
+	if c.packages == nil {
+		return nil
+	}
+	return c.packages.packageList(func(p *Package) bool {
+		return p.IsCommand()
+	})
+}
+
+// NumPackages returns the number of indexed packages, including commands.
+// Unlike Packages, nothing is copied or allocated.
+func (c *Corpus) NumPackages() int {
+	if c.packages == nil {
+		return 0
+	}
+	return c.packages.numPackages(nil)
+}
+
+// NumCommands returns the number of indexed commands, see Commands.
+func (c *Corpus) NumCommands() int {
+	if c.packages == nil {
+		return 0
+	}
+	return c.packages.numPackages(func(p *Package) bool {
+		return p.IsCommand()
+	})
+}
+
+// NumIdents returns the number of indexed Idents, of every kind, without
+// copying them like Idents.  Zero is returned if IndexGoCode is disabled.
+func (c *Corpus) NumIdents() int {
+	if c.idents == nil {
+		return 0
+	}
+	return c.idents.numIdents()
+}
+
+// PackagesNamed returns the indexed packages named name, across all source
+// roots, sorted by import path then directory.  For example "util" returns
+// every package declaring "package util", not only the first one found.  If
+// name is "main" the indexed commands are returned, see Commands.
+func (c *Corpus) PackagesNamed(name string) []*Package {
+	if c.packages == nil {
+		return nil
+	}
+	if name == "main" {
+		return c.Commands()
+	}
+	pkgs := c.packages.lookupPackages(name)
+	sort.Sort(byImportPath(pkgs))
+	return pkgs
+}
+
+// Libraries returns the indexed packages that are not commands, sorted by
+// import path.
+func (c *Corpus) Libraries() []*Package {
+	if c.packages == nil {
+		return nil
+	}
+	return c.packages.packageList(func(p *Package) bool {
+		return !p.IsCommand()
+	})
+}
+
+// PackagesDeclaring returns the sorted import paths of the packages that
+// declare the identifier name, paginated by opts, and the total number of
+// packages.  Methods are named "<Type>.<Method>".
+func (c *Corpus) PackagesDeclaring(name string, opts QueryOptions) ([]string, int) {
+	if c.idents == nil {
+		return nil, 0
+	}
+	s := c.idents.packagesDeclaring(name)
+	return pageStrings(s, opts), len(s)
+}
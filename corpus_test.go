@@ -1,8 +1,21 @@
 package pkg
 
 import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/charlievieth/pkg/fs"
 )
 
 func BenchmarkCorpusInit(b *testing.B) {
@@ -17,6 +30,29 @@ func BenchmarkCorpusInit(b *testing.B) {
 	}
 }
 
+func BenchmarkCorpusUpdateFileInfo(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("IndexFileInfo=%v", enabled), func(b *testing.B) {
+			c := NewCorpus()
+			if c.ctxt.GOROOT() == "" {
+				b.Skip("GOROOT must be set to run benchmark")
+			}
+			c.IndexGoCode = false
+			c.LogEvents = false
+			c.IndexFileInfo = enabled
+			c.packages = newPackageIndex(c)
+			if err := c.initDirTree(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Update()
+			}
+		})
+	}
+}
+
 func BenchmarkCorpusUpdate(b *testing.B) {
 	c := NewCorpus()
 	c.IndexGoCode = false
@@ -30,3 +66,1457 @@ func BenchmarkCorpusUpdate(b *testing.B) {
 		c.updateIndex()
 	}
 }
+
+func TestAffectedBy(t *testing.T) {
+	c := &Corpus{
+		ctxt: NewContext(&build.Default, 0),
+	}
+	c.packages = newPackageIndex(c)
+	srcDirs := c.ctxt.SrcDirs()
+	if len(srcDirs) == 0 {
+		t.Skip("no source directories")
+	}
+	root := srcDirs[0]
+
+	// "d" is imported by "c" and "b", "c" is imported by "b", "b" and "a"
+	// import each other and "e" does not import anything.
+	imports := map[string][]string{
+		"a": {"b"},
+		"b": {"a", "c", "d"},
+		"c": {"d"},
+		"d": {"fmt"},
+		"e": nil,
+	}
+	for path, imps := range imports {
+		p := &Package{
+			Dir:        root + "/" + path,
+			Name:       path,
+			ImportPath: path,
+			SrcRoot:    root,
+		}
+		p.addFile(GoFile, File{
+			Name:    path + ".go",
+			Path:    p.Dir + "/" + path + ".go",
+			imports: imps,
+		})
+		c.packages.addPackage(p)
+	}
+
+	var tests = []struct {
+		path string
+		exp  []string
+	}{
+		{root + "/d/d.go", []string{"a", "b", "c"}},
+		{root + "/c/c.go", []string{"a", "b"}},
+		{root + "/a/a.go", []string{"b"}},
+		{root + "/e/e.go", nil},
+		{root + "/e", nil},
+		{root + "/missing/missing.go", nil},
+	}
+	for _, x := range tests {
+		if s := c.AffectedBy(x.path); !reflect.DeepEqual(s, x.exp) {
+			t.Errorf("AffectedBy (%s): exp (%v) got (%v)", x.path, x.exp, s)
+		}
+	}
+}
+
+// writeTestFiles, writes files (path => content) to directory root.
+func writeTestFiles(t testing.TB, root string, files map[string]string) {
+	for name, src := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestModuleMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	goroot := filepath.Join(tmp, "go")
+	gopath := filepath.Join(tmp, "gopath")
+	writeTestFiles(t, tmp, map[string]string{
+		"go/src/.keep":     "",
+		"gopath/src/.keep": "",
+		"gopath/pkg/mod/example.com/foo@v1.2.3/foo.go":            "package foo\n",
+		"gopath/pkg/mod/example.com/foo@v1.2.3/bar/bar.go":        "package bar\n",
+		"gopath/pkg/mod/github.com/!burnt!sushi/toml@v0.1.0/t.go": "package toml\n",
+		"gopath/pkg/mod/cache/download/example.com/x/x.go":        "package x\n",
+	})
+
+	defer os.Setenv("GOMODCACHE", os.Getenv("GOMODCACHE"))
+	os.Setenv("GOMODCACHE", "")
+
+	c := NewCorpus()
+	c.IndexGoCode = false
+	c.ModuleMode = true
+	c.ctxt.doUpdate(goroot, gopath)
+	c.packages = newPackageIndex(c)
+	if err := c.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	modRoot := c.ctxt.ModCacheDir()
+	if modRoot != filepath.Join(gopath, "pkg", "mod") {
+		t.Fatalf("ModCacheDir: %q", modRoot)
+	}
+	exp := map[string]string{
+		"example.com/foo@v1.2.3":              "example.com/foo",
+		"example.com/foo@v1.2.3/bar":          "example.com/foo/bar",
+		"github.com/!burnt!sushi/toml@v0.1.0": "github.com/BurntSushi/toml",
+	}
+	pkgs := c.Packages()[modRoot]
+	if len(pkgs) != len(exp) {
+		t.Errorf("ModuleMode: exp (%d) packages got (%d): %v", len(exp), len(pkgs), pkgs)
+	}
+	for rel, importPath := range exp {
+		p, ok := pkgs[rel]
+		if !ok {
+			t.Errorf("ModuleMode: missing package: %s", rel)
+			continue
+		}
+		if p.ImportPath != importPath {
+			t.Errorf("ModuleMode: import path: exp (%s) got (%s)", importPath, p.ImportPath)
+		}
+		if !p.ModCache || !p.Installed {
+			t.Errorf("ModuleMode: package (%s) not flagged as module cache", rel)
+		}
+		if _, ok := c.packages.lookupPath(p.Dir); !ok {
+			t.Errorf("ModuleMode: lookupPath (%s)", p.Dir)
+		}
+	}
+
+	// Module cache is not indexed without ModuleMode.
+	c = NewCorpus()
+	c.IndexGoCode = false
+	c.ctxt.doUpdate(goroot, gopath)
+	c.packages = newPackageIndex(c)
+	if err := c.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Packages()); n != 0 {
+		t.Errorf("ModuleMode: disabled: indexed (%d) source roots", n)
+	}
+}
+
+func TestIndexFileInfo(t *testing.T) {
+	const src = "package alpha\n\nfunc AlphaModified() {}\n"
+	for _, enabled := range []bool{true, false} {
+		f := newFixture(t, true)
+		defer f.Close()
+		f.IndexFileInfo = enabled
+		if err := f.initDirTree(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Modify the file in-place, the directory is unchanged.
+		path := f.path("alpha/alpha.go")
+		fi, err := os.Stat(filepath.Dir(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Dir(path), fi.ModTime(), fi.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+		f.Update()
+
+		updated := len(f.idents.lookupName("AlphaModified", AllKinds)) == 1
+		if updated != enabled {
+			t.Errorf("IndexFileInfo (%v): updated modified file: %v", enabled, updated)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "broken/broken.go", "package broken\n\nfunc Broken( {}\n")
+	f.write(t, "broken/ok.go", "package broken\n\nfunc OK() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := f.Errors()
+	if err := errs[f.path("multi")]; !IsMultiplePackage(err) {
+		t.Errorf("Errors: multi: expected MultiplePackageError got: %v", err)
+	}
+	if err := errs[f.path("broken")]; err == nil || !strings.Contains(err.Error(), "broken.go") {
+		t.Errorf("Errors: broken: expected parse error got: %v", err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("Errors: exp: %d errors got: %v", 2, errs)
+	}
+
+	// Fix the file in-place, the directory is unchanged.
+	path := f.path("broken/broken.go")
+	fi, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, "broken/broken.go", "package broken\n\nfunc Broken() {}\n")
+	if err := os.Chtimes(filepath.Dir(path), fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	f.remove(t, "multi")
+	f.Update()
+	if errs := f.Errors(); len(errs) != 0 {
+		t.Errorf("Errors: expected errors to be cleared got: %v", errs)
+	}
+	if _, ok := f.Definition("broken", "Broken"); !ok {
+		t.Error("Errors: fixed file not indexed")
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("cannot test unreadable directories as root")
+	}
+	f.write(t, "perm/perm.go", "package perm\n")
+	dir := f.path("perm")
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+	f.Update()
+	if err := f.Errors()[dir]; !os.IsPermission(err) {
+		t.Errorf("Errors: expected permission error got: %v", err)
+	}
+
+	// Unreadable directories are retried when their parent changes.
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, "touch.txt", "")
+	f.Update()
+	if errs := f.Errors(); len(errs) != 0 {
+		t.Errorf("Errors: expected errors to be cleared got: %v", errs)
+	}
+}
+
+func TestSetRoots(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	rootA := filepath.Join(tmp, "a")
+	rootB := filepath.Join(tmp, "b")
+	writeTestFiles(t, tmp, map[string]string{
+		"a/foo/foo.go": "package foo\n",
+		"b/bar/bar.go": "package bar\n",
+	})
+
+	c := NewCorpus()
+	c.IndexGoCode = false
+	c.packages = newPackageIndex(c)
+	c.SetRoots([]string{rootA, rootB + "/"})
+
+	exp := []string{rootA, rootB}
+	if dirs := c.ctxt.SrcDirs(); !reflect.DeepEqual(dirs, exp) {
+		t.Fatalf("SetRoots: SrcDirs: Exp (%q) Got (%q)", exp, dirs)
+	}
+	if c.ctxt.outdated() {
+		t.Error("SetRoots: explicit roots should never be outdated")
+	}
+	if err := c.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"a/foo", "b/bar"} {
+		if _, ok := c.packages.lookupPath(filepath.Join(tmp, dir)); !ok {
+			t.Errorf("SetRoots: missing package: %s", dir)
+		}
+	}
+
+	c.SetRoots([]string{rootB})
+	c.updateIndex()
+	if _, ok := c.packages.lookupPath(filepath.Join(rootA, "foo")); ok {
+		t.Error("SetRoots: package in removed root was not removed")
+	}
+	if _, ok := c.dirs[rootA]; ok {
+		t.Error("SetRoots: directory tree of removed root was not removed")
+	}
+	if _, ok := c.packages.lookupPath(filepath.Join(rootB, "bar")); !ok {
+		t.Error("SetRoots: missing package: b/bar")
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	root, err := filepath.EvalSymlinks(f.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(f.dir, "link")
+	if err := os.Symlink(f.root, link); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+
+	// Without FollowSymlinks both roots are indexed.
+	f.SetRoots([]string{link, f.root})
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.dirTrees()); n != 2 {
+		t.Errorf("FollowSymlinks: disabled: expected 2 directory trees got: %d", n)
+	}
+
+	f.FollowSymlinks = true
+	f.packages = newPackageIndex(f.Corpus)
+	f.SetRoots([]string{link, f.root})
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	dirs := f.dirTrees()
+	if len(dirs) != 1 || dirs[root] == nil {
+		t.Fatalf("FollowSymlinks: expected directory tree at %s got: %v", root, dirs)
+	}
+	p, err := f.importPackage("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SrcRoot != root || p.Dir != root+"/alpha" || p.ImportPath != "alpha" {
+		t.Errorf("FollowSymlinks: alpha: SrcRoot (%s) Dir (%s) ImportPath (%s)",
+			p.SrcRoot, p.Dir, p.ImportPath)
+	}
+	if r, _ := f.packages.matchSrcRoot(p.Dir); r != root {
+		t.Errorf("FollowSymlinks: matchSrcRoot: exp (%s) got (%s)", root, r)
+	}
+
+	// Paths given through the symbolic link resolve to the canonical root.
+	r, dir := f.packages.matchSrcRoot(filepath.Join(link, "alpha"))
+	if r != root || dir != p.Dir {
+		t.Errorf("FollowSymlinks: matchSrcRoot: link: exp (%s, %s) got (%s, %s)",
+			root, p.Dir, r, dir)
+	}
+	if lp, ok := f.packages.lookupPath(filepath.Join(link, "alpha")); !ok || lp != p {
+		t.Errorf("FollowSymlinks: lookupPath: link: missing package: %s", p.Dir)
+	}
+	lp, err := f.packages.ImportDir(filepath.Join(link, "alpha"))
+	if err != nil || lp == nil || lp.Dir != p.Dir {
+		t.Errorf("FollowSymlinks: ImportDir: link: exp (%s, <nil>) got (%+v, %v)",
+			p.Dir, lp, err)
+	}
+	if n := len(f.packages.packages); n != 1 {
+		t.Errorf("FollowSymlinks: expected packages in 1 root got: %d", n)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	other := filepath.Join(f.dir, "other", "src")
+	for _, rel := range []string{"alpha", "unique"} {
+		dir := filepath.Join(other, rel)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		src := "package " + rel + "\n"
+		if err := os.WriteFile(filepath.Join(dir, rel+".go"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.SetRoots([]string{f.root, other})
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []PathConflict{{
+		ImportPath: "alpha",
+		Dir:        f.path("alpha"),
+		Shadowed:   []string{filepath.Join(other, "alpha")},
+	}}
+	if got := f.Conflicts(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Conflicts:\nExp: %+v\nGot: %+v", exp, got)
+	}
+
+	// The first root wins.
+	f.SetRoots([]string{other, f.root})
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	got := f.Conflicts()
+	if len(got) != 1 || got[0].Dir != filepath.Join(other, "alpha") {
+		t.Errorf("Conflicts: reversed roots: %+v", got)
+	}
+}
+
+func TestEmptyGoRoot(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+
+	gopath := filepath.Dir(f.root)
+	f.SetRoots(nil)
+	f.ctxt.SetGoPath(gopath)
+	for _, root := range []string{"", filepath.Join(f.dir, "missing")} {
+		f.SetGoRoot(root)
+		if s := f.ctxt.GOROOT(); s != "" {
+			t.Errorf("SetGoRoot (%q): GOROOT: Exp (%q) Got (%q)", root, "", s)
+		}
+		exp := []string{f.root}
+		if dirs := f.ctxt.SrcDirs(); !reflect.DeepEqual(dirs, exp) {
+			t.Fatalf("SetGoRoot (%q): SrcDirs: Exp (%q) Got (%q)", root, exp, dirs)
+		}
+	}
+
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := f.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("SetGoRoot: missing GOPATH package: alpha")
+	}
+	if p.Goroot {
+		t.Error("SetGoRoot: GOPATH package reported as in GOROOT")
+	}
+
+	// Updates from the environment must keep the explicit GOROOT.
+	f.ctxt.updateInterval = time.Nanosecond
+	f.ctxt.Update()
+	if s := f.ctxt.GOROOT(); s != "" {
+		t.Errorf("Update: GOROOT: Exp (%q) Got (%q)", "", s)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "cmd/zeta/main.go", "package main\n\nfunc main() {}\n")
+	f.write(t, "cmd/eta/main.go", "package main\n\nfunc main() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	importPaths := func(pkgs []*Package) []string {
+		var s []string
+		for _, p := range pkgs {
+			s = append(s, p.ImportPath)
+		}
+		return s
+	}
+	exp := []string{"cmd/eta", "cmd/zeta"}
+	if got := importPaths(f.Commands()); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Commands: Exp (%q) Got (%q)", exp, got)
+	}
+	libs := importPaths(f.Libraries())
+	if !sort.StringsAreSorted(libs) {
+		t.Errorf("Libraries: not sorted: %q", libs)
+	}
+	for _, path := range []string{"alpha", "beta", "nested/inner"} {
+		if i := sort.SearchStrings(libs, path); i == len(libs) || libs[i] != path {
+			t.Errorf("Libraries: missing package: %s", path)
+		}
+	}
+	for _, path := range exp {
+		if i := sort.SearchStrings(libs, path); i < len(libs) && libs[i] == path {
+			t.Errorf("Libraries: includes command: %s", path)
+		}
+	}
+}
+
+func TestEachPackage(t *testing.T) {
+	NewCorpus().EachPackage(func(*Package) bool {
+		t.Error("EachPackage: called for uninitialized Corpus")
+		return false
+	})
+
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	f.EachPackage(func(p *Package) bool {
+		got = append(got, p.Dir)
+		return true
+	})
+	sort.Strings(got)
+	var exp []string
+	for _, p := range f.packages.packageList(func(*Package) bool { return true }) {
+		exp = append(exp, p.Dir)
+	}
+	sort.Strings(exp)
+	if len(exp) == 0 || !reflect.DeepEqual(got, exp) {
+		t.Errorf("EachPackage:\nExp: %q\nGot: %q", exp, got)
+	}
+
+	n := 0
+	f.EachPackage(func(p *Package) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("EachPackage: expected iteration to stop after 2 packages got: %d", n)
+	}
+}
+
+func TestNumPackages(t *testing.T) {
+	c := NewCorpus()
+	if c.NumPackages() != 0 || c.NumCommands() != 0 || c.NumIdents() != 0 {
+		t.Error("Num: uninitialized Corpus: expected zero counts")
+	}
+
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "cmd/zeta/main.go", "package main\n\nfunc main() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	all := f.packages.packageList(func(*Package) bool { return true })
+	if n := f.NumPackages(); n == 0 || n != len(all) {
+		t.Errorf("NumPackages: exp: %d got: %d", len(all), n)
+	}
+	if n := f.NumCommands(); n != len(f.Commands()) || n != 1 {
+		t.Errorf("NumCommands: exp: %d got: %d", len(f.Commands()), n)
+	}
+	if n := f.NumIdents(); n == 0 || n != len(f.Idents()) {
+		t.Errorf("NumIdents: exp: %d got: %d", len(f.Idents()), n)
+	}
+
+	// Counts are updated with the index.
+	f.write(t, "alpha/more.go", "package alpha\n\nfunc More() {}\n\nvar Less int\n")
+	f.remove(t, "cmd/zeta")
+	f.Update()
+	if n := f.NumCommands(); n != 0 {
+		t.Errorf("NumCommands: update: exp: 0 got: %d", n)
+	}
+	if n := f.NumPackages(); n != len(all)-1 {
+		t.Errorf("NumPackages: update: exp: %d got: %d", len(all)-1, n)
+	}
+	if n := f.NumIdents(); n != len(f.Idents()) {
+		t.Errorf("NumIdents: update: exp: %d got: %d", len(f.Idents()), n)
+	}
+}
+
+func TestDeps(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "gamma/gamma.go", "package gamma\n\nimport (\n\t\"C\"\n\t\"beta\"\n"+
+		"\t\"gamma/sub\"\n\t\"missing/x\"\n)\n")
+	f.write(t, "gamma/sub/sub.go", "package sub\n\nimport _ \"gamma\"\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	deps, err := f.Deps("gamma")
+	exp := []string{"alpha", "beta", "beta/vendor/vendored", "gamma/sub"}
+	if !reflect.DeepEqual(deps, exp) {
+		t.Errorf("Deps:\nExp: %q\nGot: %q", exp, deps)
+	}
+	var e *UnresolvedImportsError
+	if !errors.As(err, &e) || e.ImportPath != "gamma" || !reflect.DeepEqual(e.Imports, []string{"missing/x"}) {
+		t.Errorf("Deps: unresolved imports: %v", err)
+	}
+
+	if deps, err := f.Deps("alpha"); len(deps) != 0 || err != nil {
+		t.Errorf("Deps: alpha: %q: %v", deps, err)
+	}
+	if deps, err := f.Deps("missing"); err == nil {
+		t.Errorf("Deps: missing package: %q", deps)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	goroot := filepath.Join(tmp, "go")
+	gopath := filepath.Join(tmp, "gopath")
+	writeTestFiles(t, tmp, map[string]string{
+		"go/src/.keep":                  "",
+		"gopath/src/example.com/a/a.go": "package a\n\ntype T int\n\nfunc (T) M() {}\n\nfunc F() {}\n",
+	})
+
+	c := NewCorpus()
+	c.ctxt.doUpdate(goroot, gopath)
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+
+	var tests = []struct {
+		name string
+		kind TypKind
+		line int
+	}{
+		{"T", TypeDecl, 3},
+		{"T.M", MethodDecl, 5},
+		{"F", FuncDecl, 7},
+	}
+	for _, x := range tests {
+		id, ok := c.Definition("example.com/a", x.name)
+		if !ok {
+			t.Errorf("Definition: missing ident: %s", x.name)
+			continue
+		}
+		if k := id.Info.Kind(); k != x.kind {
+			t.Errorf("Definition (%s): kind: exp (%s) got (%s)", x.name, x.kind, k)
+		}
+		pos := id.Position()
+		if pos.Line != x.line || filepath.Base(pos.Filename) != "a.go" {
+			t.Errorf("Definition (%s): position: %s", x.name, pos)
+		}
+	}
+	if _, ok := c.Definition("example.com/a", "M"); ok {
+		t.Error("Definition: found method without type name")
+	}
+	if _, ok := c.Definition("example.com/missing", "F"); ok {
+		t.Error("Definition: found ident in missing package")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	if ids := NewCorpus().Search("AlphaFunc"); ids != nil {
+		t.Errorf("Search: uninitialized Corpus: %+v", ids)
+	}
+
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "multi2/method.go", "package multi2\n\ntype T int\n\nfunc (T) Method() {}\n\nconst AlphaFunc = 1\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, id := range f.Search("AlphaFunc") {
+		got = append(got, id.Path+"."+id.Name+":"+id.Info.Kind().String())
+	}
+	exp := []string{"alpha.AlphaFunc:FuncDecl", "multi2.AlphaFunc:ConstDecl"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Search:\nExp: %q\nGot: %q", exp, got)
+	}
+
+	// Methods are matched by method name.
+	got = got[:0]
+	for _, id := range f.Search("Method") {
+		got = append(got, id.Path+"."+id.Name)
+	}
+	if exp := []string{"alpha.AlphaType.Method", "multi2.T.Method"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Search: methods:\nExp: %q\nGot: %q", exp, got)
+	}
+	for _, name := range []string{"", "alpha", "Alphafunc", "AlphaType.Method"} {
+		if ids := f.Search(name); len(ids) != 0 {
+			t.Errorf("Search(%q): %+v", name, ids)
+		}
+	}
+}
+
+func TestSearchFold(t *testing.T) {
+	if ids := NewCorpus().SearchFold("alphafunc"); ids != nil {
+		t.Errorf("SearchFold: uninitialized Corpus: %+v", ids)
+	}
+
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "client/client.go", "package client\n\n"+
+		"type Client struct{}\n\n"+
+		"func (*Client) Do() {}\n\n"+
+		"type server int\n\n"+
+		"func (server) DO() {}\n\n"+
+		"func ReadFile() {}\n\n"+
+		"var readFILE int\n\n"+
+		"func Straße() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	names := func(ids []Ident) []string {
+		var s []string
+		for _, id := range ids {
+			s = append(s, id.Path+"."+id.Name)
+		}
+		return s
+	}
+	tests := []struct {
+		name string
+		exp  []string
+	}{
+		{"readfile", []string{"client.ReadFile", "client.readFILE"}},
+		{"ReadFile", []string{"client.ReadFile", "client.readFILE"}},
+		{"alphafunc", []string{"alpha.AlphaFunc", "alpha.alphaFunc"}},
+		{"ALPHATYPE", []string{"alpha.AlphaType"}},
+		{"STRASSE", nil}, // folding is not a full case mapping
+		{"straSSe", nil},
+		{"STRAßE", []string{"client.Straße"}},
+
+		// Methods are matched by method name or selector.
+		{"do", []string{"client.Client.Do", "client.server.DO"}},
+		{"client.do", []string{"client.Client.Do"}},
+		{"SERVER.do", []string{"client.server.DO"}},
+		{"alphatype.method", []string{"alpha.AlphaType.Method", "alpha.AlphaType.method"}},
+		{"client.readfile", nil},
+		{"missing", nil},
+		{"", nil},
+	}
+	for _, x := range tests {
+		if got := names(f.SearchFold(x.name)); !reflect.DeepEqual(got, x.exp) {
+			t.Errorf("SearchFold(%q):\nExp: %q\nGot: %q", x.name, x.exp, got)
+		}
+	}
+}
+
+func TestLookupOrImport(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.MaxDepth = 1
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.packages.lookupPath(f.path("nested/inner")); ok {
+		t.Fatal("LookupOrImport: package below MaxDepth was indexed")
+	}
+
+	p, err := f.LookupOrImport("nested/inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "inner" || p.Dir != f.path("nested/inner") {
+		t.Errorf("LookupOrImport: package: %+v", p)
+	}
+	if q, ok := f.packages.lookupPath(p.Dir); !ok || q != p {
+		t.Error("LookupOrImport: package not added to the index")
+	}
+	if ids := f.idents.lookupName("InnerFunc", AllKinds); len(ids) != 1 {
+		t.Errorf("LookupOrImport: idents not indexed: %+v", ids)
+	}
+
+	// Indexed packages are returned as is.
+	q, err := f.LookupOrImport("nested/inner")
+	if err != nil || q != p {
+		t.Errorf("LookupOrImport: indexed package: %+v: %v", q, err)
+	}
+
+	// Import paths that are not clean could escape the source root.
+	paths := []string{
+		"", "missing", "../alpha", "/alpha", "empty",
+		"nested/../alpha", "nested/../../src/alpha", "alpha/.", "alpha/",
+	}
+	for _, path := range paths {
+		if p, err := f.LookupOrImport(path); err == nil {
+			t.Errorf("LookupOrImport (%q): expected error got: %+v", path, p)
+		}
+	}
+}
+
+func TestLookupOrImportBinary(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	const importPath = "example.com/binonly"
+	_, pkga, err := f.ctxt.PkgTargetRoot(importPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, filepath.Join(f.dir, "gopath"), map[string]string{
+		pkga: "!<arch>\n",
+	})
+
+	if p, err := f.LookupOrImport(importPath); err == nil {
+		t.Fatalf("LookupOrImport: AllowBinary disabled: expected error got: %+v", p)
+	}
+
+	f.AllowBinary = true
+	p, err := f.LookupOrImport(importPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "binonly" || !p.BinaryOnly || !p.Installed || !p.IsValid() ||
+		p.Dir != f.path(importPath) || p.Info == nil || len(p.GoFiles()) != 0 {
+		t.Errorf("LookupOrImport: binary-only package: %+v", p)
+	}
+	if q, ok := f.packages.lookupPath(p.Dir); !ok || q != p {
+		t.Error("LookupOrImport: binary-only package not added to the index")
+	}
+	if f.idents.hasPackage(importPath) {
+		t.Error("LookupOrImport: binary-only package idents indexed")
+	}
+	if d := f.Verify(); len(d) != 0 {
+		t.Errorf("Verify: binary-only package: %v", d)
+	}
+	archives := []struct {
+		compiler, path, name string
+	}{
+		{"gc", "pkg/linux_amd64/example.com/binonly.a", "binonly"},
+		{"gc", "pkg/linux_amd64/example.com/libyaml.a", "libyaml"},
+		{"gccgo", "pkg/gccgo_linux_amd64/example.com/libbinonly.a", "binonly"},
+	}
+	for _, x := range archives {
+		if name := archivePackageName(x.compiler, x.path); name != x.name {
+			t.Errorf("archivePackageName(%q, %q): exp: %q got: %q", x.compiler, x.path, x.name, name)
+		}
+	}
+
+	// Update re-stats the archive.
+	f.Update()
+	if q, ok := f.packages.lookupPath(p.Dir); !ok || q != p {
+		t.Errorf("Update: unchanged binary-only package replaced: %+v", q)
+	}
+	archive := filepath.Join(f.dir, "gopath", filepath.FromSlash(pkga))
+	if err := ioutil.WriteFile(archive, []byte("!<arch>\nchanged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.Update()
+	if q, ok := f.packages.lookupPath(p.Dir); !ok || !q.BinaryOnly || q.Info.Size() == p.Info.Size() {
+		t.Errorf("Update: changed binary-only package: %+v", q)
+	}
+	if err := os.Remove(archive); err != nil {
+		t.Fatal(err)
+	}
+	f.Update()
+	if q, ok := f.packages.lookupPath(p.Dir); ok {
+		t.Errorf("Update: binary-only package not removed: %+v", q)
+	}
+
+	// Sources take precedence over the archive.
+	f.write(t, importPath+"/b.go", "package binonly\n\nfunc B() {}\n")
+	f.Update()
+	p, ok := f.packages.lookupPath(f.path(importPath))
+	if !ok || p.BinaryOnly || !reflect.DeepEqual(p.GoFiles(), []string{"b.go"}) {
+		t.Errorf("Update: package with sources: %+v", p)
+	}
+}
+
+func TestWhyNotFound(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `cannot find package "missing/pkg" in any of:` +
+		"\n\t" + f.path("beta/vendor/missing/pkg") + " (vendor tree)" +
+		"\n\t" + f.path("vendor/missing/pkg") + " (vendor tree)" +
+		"\n\t" + f.path("missing/pkg") + " (from $GOPATH)"
+	if s := f.WhyNotFound("missing/pkg", f.path("beta")); s != exp {
+		t.Errorf("WhyNotFound:\nExp: %s\nGot: %s", exp, s)
+	}
+	exp = `cannot find package "missing/pkg" in any of:` +
+		"\n\t" + f.path("missing/pkg") + " (from $GOPATH)"
+	if s := f.WhyNotFound("missing/pkg", ""); s != exp {
+		t.Errorf("WhyNotFound: no srcDir:\nExp: %s\nGot: %s", exp, s)
+	}
+
+	// Found packages, including vendored packages.
+	for _, x := range [][2]string{{"alpha", ""}, {"vendored", f.path("beta")}} {
+		if s := f.WhyNotFound(x[0], x[1]); s != "" {
+			t.Errorf("WhyNotFound (%q, %q): found package: %s", x[0], x[1], s)
+		}
+	}
+
+	// The error of a directory that cannot be imported is returned.
+	if s := f.WhyNotFound("empty", ""); s != (&NoGoError{f.path("empty")}).Error() {
+		t.Errorf("WhyNotFound: empty: %s", s)
+	}
+	if s := f.WhyNotFound("../alpha", ""); !strings.Contains(s, "invalid import path") {
+		t.Errorf("WhyNotFound: invalid import path: %s", s)
+	}
+}
+
+// Run with -race.
+func TestDirsConcurrentUpdate(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, list := range f.DirList() {
+					if list == nil {
+						t.Error("DirList: nil list")
+						return
+					}
+				}
+				for range f.Dirs() {
+				}
+				f.Subtree("alpha")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			f.write(t, "gamma/gamma.go", "package gamma\n")
+		} else {
+			f.remove(t, "gamma")
+		}
+		f.updateIndex()
+	}
+	close(done)
+	wg.Wait()
+
+	if _, ok := f.Subtree("alpha"); !ok {
+		t.Error("Subtree: missing directory: alpha")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.log = log.New(ioutil.Discard, "", 0)
+
+	events, cancel := f.Subscribe()
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	created := false
+	timeout := time.After(time.Second * 5)
+	for !created {
+		select {
+		case e := <-events:
+			created = e.Event() == CreateEvent
+		case <-timeout:
+			t.Fatal("Subscribe: timed out waiting for event")
+		}
+	}
+	cancel()
+	cancel() // no-op
+	for range events {
+		// Drain buffered events, the loop exits when the channel is closed.
+	}
+
+	done := make(chan struct{})
+	go func() {
+		f.Stop()
+		f.Stop() // no-op
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Stop: timed out")
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.log = log.New(ioutil.Discard, "", 0)
+	f.RefreshMode = RefreshSignal
+
+	events, cancel := f.Subscribe()
+	defer cancel()
+	start := time.Now()
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	f.Refresh()
+
+	// Wait for the update event of the refresh.
+	var pkgEvent, indexEvent, refreshEvent bool
+	timeout := time.After(time.Second * 5)
+	for !refreshEvent {
+		var e Eventer
+		select {
+		case e = <-events:
+		case <-timeout:
+			t.Fatal("EventTime: timed out waiting for refresh event")
+		}
+		var tm time.Time
+		var d time.Duration
+		switch e := e.(type) {
+		case Event:
+			tm, d = e.Time, e.Duration
+			if e.Event() == CreateEvent && e.Source == "Package" {
+				pkgEvent = d > 0
+			}
+			refreshEvent = e.Event() == UpdateEvent && e.Source == "Index"
+		case IndexEvent:
+			tm, d = e.Time, e.Duration
+			if e.Event() == CreateEvent {
+				indexEvent = d > 0
+			}
+		default:
+			t.Fatalf("EventTime: unexpected event type: %T", e)
+		}
+		if tm.Before(start) || tm.After(time.Now()) {
+			t.Errorf("EventTime: %s: invalid Time: %s", e, tm)
+		}
+		if refreshEvent && d <= 0 {
+			t.Errorf("EventTime: %s: expected Duration", e)
+		}
+		if d > 0 && !strings.HasSuffix(e.String(), " in "+d.String()) {
+			t.Errorf("EventTime: %s: Duration not in message", e)
+		}
+	}
+	if !pkgEvent {
+		t.Error("EventTime: missing Duration of package create events")
+	}
+	if !indexEvent {
+		t.Error("EventTime: missing Duration of index create events")
+	}
+}
+
+func TestRefreshMode(t *testing.T) {
+	// waitPackage, reports if the package at rel is indexed within d.
+	waitPackage := func(f *fixture, rel string, d time.Duration) bool {
+		deadline := time.Now().Add(d)
+		for {
+			if _, ok := f.packages.lookupPath(f.path(rel)); ok {
+				return true
+			}
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	const src = "package delta\n"
+
+	t.Run("Signal", func(t *testing.T) {
+		f := newFixture(t, false)
+		defer f.Close()
+		f.log = log.New(ioutil.Discard, "", 0)
+		f.RefreshMode = RefreshSignal
+		f.IndexInterval = time.Millisecond
+		if err := f.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer f.Stop()
+
+		f.write(t, "delta/delta.go", src)
+		if waitPackage(f, "delta", time.Millisecond*100) {
+			t.Fatal("RefreshSignal: index was polled")
+		}
+		f.Refresh()
+		if !waitPackage(f, "delta", time.Second*5) {
+			t.Fatal("RefreshSignal: index not refreshed when signaled")
+		}
+	})
+
+	t.Run("Polling", func(t *testing.T) {
+		f := newFixture(t, false)
+		defer f.Close()
+		f.log = log.New(ioutil.Discard, "", 0)
+		f.RefreshMode = RefreshPolling
+		f.IndexInterval = time.Millisecond * 10
+		if err := f.Init(); err != nil {
+			t.Fatal(err)
+		}
+		defer f.Stop()
+
+		f.write(t, "delta/delta.go", src)
+		if !waitPackage(f, "delta", time.Second*5) {
+			t.Fatal("RefreshPolling: index not polled")
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		f := newFixture(t, false)
+		defer f.Close()
+		f.RefreshMode = RefreshWatch
+		if err := f.Init(); err == nil {
+			t.Fatal("RefreshWatch: expected error from Init")
+		}
+	})
+}
+
+func TestSubtree(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]DirEntry{
+		"":                {Depth: 0, Height: 3, Path: "", Name: "beta", PkgName: "beta", HasPkg: true},
+		"vendor":          {Depth: 1, Height: 2, Path: "vendor", Name: "vendor"},
+		"vendor/vendored": {Depth: 2, Height: 1, Path: "vendor/vendored", Name: "vendored", PkgName: "vendored", HasPkg: true},
+	}
+	for _, path := range []string{"beta", f.path("beta")} {
+		list, ok := f.Subtree(path)
+		if !ok {
+			t.Errorf("Subtree (%s): not found", path)
+			continue
+		}
+		if list.Root != f.path("beta") || list.MaxHeight != 3 {
+			t.Errorf("Subtree (%s): Root (%s) MaxHeight (%d)", path, list.Root, list.MaxHeight)
+		}
+		if len(list.List) != len(exp) {
+			t.Errorf("Subtree (%s): exp (%d) entries got (%d): %+v", path, len(exp), len(list.List), list.List)
+		}
+		for _, e := range list.List {
+			if e != exp[e.Path] {
+				t.Errorf("Subtree (%s): exp (%+v) got (%+v)", path, exp[e.Path], e)
+			}
+		}
+	}
+
+	// Filtered to packages only.
+	list, ok := f.SubtreeFunc("beta/vendor", func(path string) bool {
+		_, ok := f.packages.lookupPath(path)
+		return ok
+	})
+	if !ok || len(list.List) != 1 {
+		t.Fatalf("SubtreeFunc: %+v", list)
+	}
+	e := list.List[0]
+	if e.Path != "vendored" || e.Depth != 0 || e.Height != 1 || list.MaxHeight != 1 {
+		t.Errorf("SubtreeFunc: entry: %+v", e)
+	}
+
+	for _, path := range []string{"", "missing", "testdata", f.path("missing"), "/"} {
+		if list, ok := f.Subtree(path); ok {
+			t.Errorf("Subtree (%q): found: %+v", path, list)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	goroot := filepath.Join(tmp, "go")
+	gopath := filepath.Join(tmp, "gopath")
+	writeTestFiles(t, tmp, map[string]string{
+		"go/src/.keep":                     "",
+		"gopath/src/http/h.go":             "package http\n\nfunc Get() {}\n",
+		"gopath/src/web/http/h.go":         "package http\n\ntype Client struct{}\n\nfunc (c *Client) Do() {}\n",
+		"gopath/src/example.com/get/g.go":  "package get\n\nfunc Get() {}\n\ntype T int\n\nfunc (T) Do() {}\n",
+		"gopath/src/example.com/http/h.go": "package http\n\nvar Client int\n",
+	})
+
+	c := NewCorpus()
+	c.ctxt.doUpdate(goroot, gopath)
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	for _, path := range []string{"http", "web/http", "example.com/get", "example.com/http"} {
+		if _, err := c.importPackage(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	importPaths := func(pkgs []*Package) []string {
+		var s []string
+		for _, p := range pkgs {
+			s = append(s, p.ImportPath)
+		}
+		return s
+	}
+	identNames := func(ids []Ident) []string {
+		var s []string
+		for _, id := range ids {
+			s = append(s, id.Path+":"+id.Name)
+		}
+		return s
+	}
+
+	var tests = []struct {
+		query    string
+		packages []string
+		idents   []string
+	}{
+		{"http", []string{"http", "example.com/http", "web/http"}, nil},
+		{"get", []string{"example.com/get"}, nil},
+		{"example.com/get", []string{"example.com/get"}, nil},
+		{"Get", nil, []string{"example.com/get:Get", "http:Get"}},
+		{"Do", nil, []string{"example.com/get:T.Do", "web/http:Client.Do"}},
+		{"http.Client", nil, []string{"example.com/http:Client", "web/http:Client"}},
+		{"Client.Do", nil, []string{"web/http:Client.Do"}},
+		{"web/http.Client", nil, []string{"web/http:Client"}},
+		{"Missing", nil, nil},
+		{"", nil, nil},
+	}
+	for _, x := range tests {
+		res := c.Find(x.query)
+		if s := importPaths(res.Packages); !reflect.DeepEqual(s, x.packages) {
+			t.Errorf("Find (%q): packages: exp (%v) got (%v)", x.query, x.packages, s)
+		}
+		if s := identNames(res.Idents); !reflect.DeepEqual(s, x.idents) {
+			t.Errorf("Find (%q): idents: exp (%v) got (%v)", x.query, x.idents, s)
+		}
+	}
+}
+
+func TestQueryOptionsPage(t *testing.T) {
+	var tests = []struct {
+		opts       QueryOptions
+		n          int
+		start, end int
+	}{
+		{QueryOptions{}, 10, 0, 10},
+		{QueryOptions{Limit: 3}, 10, 0, 3},
+		{QueryOptions{Limit: 3, Offset: 3}, 10, 3, 6},
+		{QueryOptions{Limit: 3, Offset: 9}, 10, 9, 10},
+		{QueryOptions{Limit: 3, Offset: 10}, 10, 10, 10},
+		{QueryOptions{Limit: 3, Offset: 20}, 10, 10, 10},
+		{QueryOptions{Limit: -1, Offset: -1}, 10, 0, 10},
+		{QueryOptions{Limit: 3}, 0, 0, 0},
+	}
+	for _, x := range tests {
+		start, end := x.opts.page(x.n)
+		if start != x.start || end != x.end {
+			t.Errorf("QueryOptions (%+v): page (%d): exp (%d, %d) got (%d, %d)",
+				x.opts, x.n, x.start, x.end, start, end)
+		}
+	}
+}
+
+func TestFindKinds(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		kinds KindSet
+		exp   []string
+	}{
+		{"AlphaType", AllKinds, []string{"AlphaType"}},
+		{"AlphaType", TypeKinds, []string{"AlphaType"}},
+		{"AlphaType", CallableKinds, nil},
+		{"Method", CallableKinds, []string{"AlphaType.Method"}},
+		{"Method", ValueKinds, nil},
+		{"AlphaType.Method", CallableKinds, []string{"AlphaType.Method"}},
+		{"AlphaType.Method", TypeKinds, nil},
+		{"alpha.AlphaConst", ValueKinds, []string{"AlphaConst"}},
+		{"alpha.AlphaConst", Kinds(TypeDecl, FuncDecl), nil},
+	}
+	for _, x := range tests {
+		res := f.FindPage(x.query, QueryOptions{Kinds: x.kinds})
+		var names []string
+		for _, id := range res.Idents {
+			names = append(names, id.Name)
+		}
+		if !reflect.DeepEqual(names, x.exp) {
+			t.Errorf("FindPage(%q, %b): exp: %q got: %q", x.query, x.kinds, x.exp, names)
+		}
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	goroot := filepath.Join(tmp, "go")
+	gopath := filepath.Join(tmp, "gopath")
+	files := map[string]string{"go/src/.keep": ""}
+	var paths []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		path := "p/" + name
+		files["gopath/src/"+path+"/p.go"] = "package " + name + "\n\nfunc New() {}\n"
+		paths = append(paths, path)
+	}
+	files["gopath/src/q/New/p.go"] = "package New\n"
+	paths = append(paths, "q/New")
+	writeTestFiles(t, tmp, files)
+
+	c := NewCorpus()
+	c.ctxt.doUpdate(goroot, gopath)
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	for _, path := range paths {
+		if _, err := c.importPackage(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, total := c.ImportPaths(QueryOptions{Limit: 2, Offset: 1})
+	if exp := []string{"p/b", "p/c"}; !reflect.DeepEqual(s, exp) || total != len(paths) {
+		t.Errorf("ImportPaths: exp (%v, %d) got (%v, %d)", exp, len(paths), s, total)
+	}
+	s, total = c.PackagesDeclaring("New", QueryOptions{Limit: 3, Offset: 2})
+	if exp := []string{"p/c", "p/d"}; !reflect.DeepEqual(s, exp) || total != 4 {
+		t.Errorf("PackagesDeclaring: exp (%v, %d) got (%v, %d)", exp, 4, s, total)
+	}
+
+	// Pages span the package and ident results.
+	var pkgs, ids []string
+	for i := 0; i < 3; i++ {
+		res := c.FindPage("New", QueryOptions{Limit: 2, Offset: i * 2})
+		if res.Total != 5 {
+			t.Fatalf("FindPage: total: exp (%d) got (%d)", 5, res.Total)
+		}
+		for _, p := range res.Packages {
+			pkgs = append(pkgs, p.ImportPath)
+		}
+		for _, id := range res.Idents {
+			ids = append(ids, id.Path)
+		}
+	}
+	if exp := []string{"q/New"}; !reflect.DeepEqual(pkgs, exp) {
+		t.Errorf("FindPage: packages: exp (%v) got (%v)", exp, pkgs)
+	}
+	if exp := paths[:4]; !reflect.DeepEqual(ids, exp) {
+		t.Errorf("FindPage: idents: exp (%v) got (%v)", exp, ids)
+	}
+}
+
+func TestIndexMemFS(t *testing.T) {
+	m := fs.NewMemFS()
+	for name, src := range fixtureFiles {
+		if err := m.WriteFile("/gopath/src/"+name, []byte(src)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prev := fs.SetDefault(m)
+	defer fs.SetDefault(prev)
+
+	c := NewCorpus()
+	c.LogEvents = false
+	c.SetRoots([]string{"/gopath/src"})
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	if err := c.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Definition("alpha", "AlphaFunc"); !ok {
+		t.Error("MemFS: missing ident AlphaFunc")
+	}
+	if _, ok := c.Definition("alpha", "AlphaTagged"); ok {
+		t.Error("MemFS: unexpected ident AlphaTagged")
+	}
+
+	m.WriteFile("/gopath/src/gamma/gamma.go", []byte("package gamma\n\nfunc GammaFunc() {}\n"))
+	m.RemoveAll("/gopath/src/beta")
+	c.Update()
+	if _, ok := c.Definition("gamma", "GammaFunc"); !ok {
+		t.Error("MemFS: Update: missing ident GammaFunc")
+	}
+	if _, ok := c.packages.lookupPath("/gopath/src/beta"); ok {
+		t.Error("MemFS: Update: package beta not removed")
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The package "shared" exists in both GOPATHs with different idents.
+	writeTestFiles(t, tmp, map[string]string{
+		"ws1/src/one/one.go":       "package one\n\nfunc One() {}\n",
+		"ws1/src/shared/shared.go": "package shared\n\nfunc FromOne() {}\n",
+		"ws2/src/two/two.go":       "package two\n\nfunc Two() {}\n",
+		"ws2/src/shared/shared.go": "package shared\n\nfunc FromTwo() {}\n",
+	})
+	ctxt := func(gopath string) *build.Context {
+		ctxt := build.Default
+		ctxt.GOROOT = ""
+		ctxt.GOPATH = filepath.Join(tmp, gopath)
+		return &ctxt
+	}
+
+	c := NewCorpus()
+	c.LogEvents = false
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	c.SetContext(ctxt("ws1"))
+	if err := c.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	test := func(gopath string, exp, notExp []string) {
+		t.Helper()
+		if s := c.ctxt.GOPATH(); s != filepath.Join(tmp, gopath) {
+			t.Errorf("%s: GOPATH: exp: %q got: %q", gopath, filepath.Join(tmp, gopath), s)
+		}
+		if dirs := c.ctxt.SrcDirs(); len(dirs) != 1 || dirs[0] != filepath.Join(tmp, gopath, "src") {
+			t.Errorf("%s: SrcDirs: %q", gopath, dirs)
+		}
+		for _, name := range exp {
+			if len(c.idents.lookupName(name, AllKinds)) != 1 {
+				t.Errorf("%s: missing ident: %s", gopath, name)
+			}
+		}
+		for _, name := range notExp {
+			if ids := c.idents.lookupName(name, AllKinds); len(ids) != 0 {
+				t.Errorf("%s: unexpected ident: %+v", gopath, ids)
+			}
+		}
+		c.EachPackage(func(p *Package) bool {
+			if !strings.HasPrefix(p.Dir, filepath.Join(tmp, gopath)+string(filepath.Separator)) {
+				t.Errorf("%s: unexpected package: %s", gopath, p.Dir)
+			}
+			return true
+		})
+	}
+	test("ws1", []string{"One", "FromOne"}, []string{"Two", "FromTwo"})
+
+	f := c.Fork()
+	c.SetContext(ctxt("ws2"))
+	test("ws2", nil, []string{"One", "FromOne"})
+	if s := f.ctxt.GOPATH(); s != filepath.Join(tmp, "ws1") {
+		t.Errorf("Fork: GOPATH changed to: %q", s)
+	}
+	if _, ok := f.Definition("shared", "FromOne"); !ok {
+		t.Error("Fork: missing ident FromOne")
+	}
+	c.Update()
+	test("ws2", []string{"Two", "FromTwo"}, []string{"One", "FromOne"})
+
+	// Changes to the environment are ignored.
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	os.Setenv("GOPATH", filepath.Join(tmp, "ws1"))
+	c.ctxt.updateInterval = time.Nanosecond
+	c.Update()
+	test("ws2", []string{"Two", "FromTwo"}, []string{"One", "FromOne"})
+}
+
+func TestPackagesNamed(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "zeta/util/util.go", "package util\n")
+	f.write(t, "beta/util/util.go", "package util\n")
+	other := filepath.Join(f.dir, "other", "src")
+	writeTestFiles(t, other, map[string]string{
+		"alpha/util/util.go": "package util\n",
+		"cmd/tool/main.go":   "package main\n",
+	})
+	f.SetRoots([]string{f.root, other})
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, p := range f.PackagesNamed("util") {
+		if p.Name != "util" {
+			t.Errorf("PackagesNamed: %s: unexpected name: %s", p.ImportPath, p.Name)
+		}
+		paths = append(paths, p.ImportPath)
+	}
+	exp := []string{"alpha/util", "beta/util", "zeta/util"}
+	if !reflect.DeepEqual(paths, exp) {
+		t.Errorf("PackagesNamed: exp: %q got: %q", exp, paths)
+	}
+	if pkgs := f.PackagesNamed("missing"); len(pkgs) != 0 {
+		t.Errorf("PackagesNamed: missing: %v", pkgs)
+	}
+	if exp, got := f.Commands(), f.PackagesNamed("main"); len(got) == 0 || !reflect.DeepEqual(got, exp) {
+		t.Errorf("PackagesNamed: main: exp: %v got: %v", exp, got)
+	}
+
+	// The single result lookup still resolves one of the packages.
+	if p, ok := f.packages.lookupPackage("util"); !ok || p.Name != "util" {
+		t.Errorf("lookupPackage: %v, %t", p, ok)
+	}
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import "sort"
+
+// A CorpusDiff, is the difference between the packages and exported API of
+// two Corpora, see Diff.  All lists are sorted by import path.
+type CorpusDiff struct {
+	Added    []string      // Import paths of packages only in the new Corpus
+	Removed  []string      // Import paths of packages only in the old Corpus
+	Changed  []string      // Import paths of packages in both that differ
+	Packages []PackageDiff // Exported idents added or removed by package
+}
+
+// A PackageDiff, is the change to the exported API of a package.  Names are
+// sorted and methods are named "<Type>.<Method>", see Index.Exports.
+type PackageDiff struct {
+	ImportPath string
+	Added      []string // Exported names only in the new package
+	Removed    []string // Exported names only in the old package
+}
+
+// Empty, reports if there are no differences.
+func (d *CorpusDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		len(d.Packages) == 0
+}
+
+// Diff, returns the differences between the packages of Corpus old and new,
+// such as a Fork taken before an update and the updated Corpus, or Corpora
+// of two checkouts of a project.  Packages are matched by import path, if
+// several source roots contain the same import path the package of the first
+// root is used, like the go command.
+//
+// A package changed if its name, the names of its files or its exported API
+// changed, the directory and modification times of packages are ignored so
+// that checkouts in different locations can be compared.  Exported idents
+// are only compared if IndexGoCode is enabled for both Corpora, the idents
+// of added and removed packages are reported as added and removed.  Packages
+// that are not indexed, such as evicted packages, see MaxIndexBytes, are
+// treated as having no exported API.
+func Diff(old, new *Corpus) *CorpusDiff {
+	oldPkgs := old.diffPackages()
+	newPkgs := new.diffPackages()
+	idents := old.idents != nil && new.idents != nil
+	exports := func(c *Corpus, p *Package) []string {
+		if !idents || p == nil {
+			return nil
+		}
+		return c.idents.Exports(p.ImportPath)
+	}
+
+	paths := make([]string, 0, len(newPkgs))
+	for path := range oldPkgs {
+		paths = append(paths, path)
+	}
+	for path := range newPkgs {
+		if _, ok := oldPkgs[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	d := &CorpusDiff{}
+	for _, path := range paths {
+		p, q := oldPkgs[path], newPkgs[path]
+		added, removed := diffNames(exports(old, p), exports(new, q))
+		switch {
+		case p == nil:
+			d.Added = append(d.Added, path)
+		case q == nil:
+			d.Removed = append(d.Removed, path)
+		case len(added) != 0 || len(removed) != 0 || !sameFileNames(p, q):
+			d.Changed = append(d.Changed, path)
+		}
+		if len(added) != 0 || len(removed) != 0 {
+			d.Packages = append(d.Packages, PackageDiff{
+				ImportPath: path,
+				Added:      added,
+				Removed:    removed,
+			})
+		}
+	}
+	return d
+}
+
+// diffPackages, returns copies of the packages of the Corpus by import path,
+// see Diff.
+func (c *Corpus) diffPackages() map[string]*Package {
+	m := make(map[string]*Package)
+	if c.packages == nil {
+		return m
+	}
+	srcDirs := c.srcDirs()
+	rank := func(p *Package) int {
+		for i, srcDir := range srcDirs {
+			if srcDir.Path == p.SrcRoot {
+				return i
+			}
+		}
+		return len(srcDirs)
+	}
+	// Packages are updated in place, so copy them.
+	c.packages.mu.RLock()
+	for _, pkgs := range c.packages.packages {
+		for _, p := range pkgs {
+			if !p.IsValid() {
+				continue
+			}
+			q, ok := m[p.ImportPath]
+			if !ok || rank(p) < rank(q) || rank(p) == rank(q) && p.Dir < q.Dir {
+				m[p.ImportPath] = p.clone()
+			}
+		}
+	}
+	c.packages.mu.RUnlock()
+	return m
+}
+
+// sameFileNames, reports if Packages p and q have the same name and files,
+// which are compared by type and name.
+func sameFileNames(p, q *Package) bool {
+	if p.Name != q.Name || p.BinaryOnly != q.BinaryOnly {
+		return false
+	}
+	for _, typ := range [...]GoFileType{IgnoredGoFile, TestGoFile, GoFile} {
+		if !equalStrings(p.files[typ].FileNames(), q.files[typ].FileNames()) {
+			return false
+		}
+	}
+	return equalStrings(p.other.FileNames(), q.other.FileNames())
+}
+
+// diffNames, returns the names only in sorted list b, added, and only in
+// sorted list a, removed.
+func diffNames(a, b []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case a[i] < b[j]:
+			removed = append(removed, a[i])
+			i++
+		default:
+			added = append(added, b[j])
+			j++
+		}
+	}
+	removed = append(removed, a[i:]...)
+	added = append(added, b[j:]...)
+	return added, removed
+}
+
+// equalStrings, reports if string slices a and b are equal.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
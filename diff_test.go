@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	old := f.Fork()
+	if d := Diff(old, f.Corpus); !d.Empty() {
+		t.Errorf("Diff: unchanged: %+v", d)
+	}
+
+	f.write(t, "alpha/more.go", "package alpha\n\nfunc More() {}\n\nfunc (AlphaType) Next() {}\n\nfunc unexported() {}\n")
+	f.write(t, "beta/beta_test.go", "package beta\n")
+	f.write(t, "gamma/gamma.go", "package gamma\n\nfunc GammaFunc() {}\n")
+	f.remove(t, "nested")
+	f.Update()
+
+	exp := &CorpusDiff{
+		Added:   []string{"gamma"},
+		Removed: []string{"nested/inner"},
+		Changed: []string{"alpha", "beta"},
+		Packages: []PackageDiff{
+			{ImportPath: "alpha", Added: []string{"AlphaType.Next", "More"}},
+			{ImportPath: "gamma", Added: []string{"GammaFunc"}},
+			{ImportPath: "nested/inner", Removed: []string{"InnerFunc"}},
+		},
+	}
+	d := Diff(old, f.Corpus)
+	if !reflect.DeepEqual(d, exp) {
+		t.Errorf("Diff:\nExp: %+v\nGot: %+v", exp, d)
+	}
+
+	// The reverse diff swaps additions and removals.
+	exp = &CorpusDiff{
+		Added:   []string{"nested/inner"},
+		Removed: []string{"gamma"},
+		Changed: []string{"alpha", "beta"},
+		Packages: []PackageDiff{
+			{ImportPath: "alpha", Removed: []string{"AlphaType.Next", "More"}},
+			{ImportPath: "gamma", Removed: []string{"GammaFunc"}},
+			{ImportPath: "nested/inner", Added: []string{"InnerFunc"}},
+		},
+	}
+	if d := Diff(f.Corpus, old); !reflect.DeepEqual(d, exp) {
+		t.Errorf("Diff: reverse:\nExp: %+v\nGot: %+v", exp, d)
+	}
+}
+
+func TestDiffNames(t *testing.T) {
+	tests := []struct {
+		a, b           []string
+		added, removed []string
+	}{
+		{nil, nil, nil, nil},
+		{[]string{"A"}, []string{"A"}, nil, nil},
+		{nil, []string{"A", "B"}, []string{"A", "B"}, nil},
+		{[]string{"A", "B"}, nil, nil, []string{"A", "B"}},
+		{[]string{"A", "C", "E"}, []string{"B", "C", "D"}, []string{"B", "D"}, []string{"A", "E"}},
+	}
+	for _, x := range tests {
+		added, removed := diffNames(x.a, x.b)
+		if !reflect.DeepEqual(added, x.added) || !reflect.DeepEqual(removed, x.removed) {
+			t.Errorf("diffNames(%q, %q): exp: %q, %q got: %q, %q",
+				x.a, x.b, x.added, x.removed, added, removed)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package pkg
 
 import (
-	"fmt"
+	"context"
 	"os"
 	pathpkg "path"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -16,31 +17,167 @@ type treeBuilder struct {
 	c        *Corpus
 	maxDepth int
 	names    map[string]bool // dirs names - to prevent loops
+	skip     map[string]bool // dirs paths to ignore
+	ignore   *ignoreMatcher  // Corpus.IgnorePatterns, may be nil
+	roots    []string        // source roots, only set if ignore is set
+	rescan   bool            // re-read directories, even if unchanged
 	mu       sync.Mutex      // mutext for names map
+
+	ctx  context.Context // stops the walk when done, may be nil
+	pkgs chan<- *Package // receives a copy of each package, may be nil
+
+	progress func(done, total int) // progress callback, may be nil
+	done     int                   // number of directories visited
+	total    int                   // number of directories found
+	pmu      sync.Mutex            // mutex for progress
 }
 
 func newTreeBuilder(c *Corpus, maxDepth int) *treeBuilder {
 	if maxDepth <= 0 {
 		maxDepth = 1e6
 	}
-	return &treeBuilder{
+	t := &treeBuilder{
 		c:        c,
 		maxDepth: maxDepth,
 		names:    make(map[string]bool),
 	}
+	if c != nil {
+		if t.ignore = c.ignoreMatcher(); t.ignore != nil {
+			for _, srcDir := range c.srcDirs() {
+				t.roots = append(t.roots, srcDir.Path)
+			}
+		}
+	}
+	if c != nil && c.ModuleMode {
+		// Ignore the module download cache.
+		for _, srcDir := range c.srcDirs() {
+			if srcDir.ModCache {
+				if t.skip == nil {
+					t.skip = make(map[string]bool)
+				}
+				t.skip[pathpkg.Join(clean(srcDir.Path), "cache")] = true
+			}
+		}
+	}
+	return t
+}
+
+// ignored, reports if the directory at path with name name should be ignored.
+func (t *treeBuilder) ignored(path, name string) bool {
+	return isIgnored(name) || t.skip[path] || t.ignoredPattern(path)
+}
+
+// ignoredPattern, reports if the directory at path is matched by the
+// IgnorePatterns of the Corpus.
+func (t *treeBuilder) ignoredPattern(path string) bool {
+	if t.ignore == nil {
+		return false
+	}
+	for _, root := range t.roots {
+		if len(path) > len(root) && path[len(root)] == '/' && hasRoot(path, root) {
+			return t.ignore.match(path[len(root)+1:])
+		}
+	}
+	return false
 }
 
 func (t *treeBuilder) notify(typ EventType, path string) {
-	if t.c == nil || !t.c.LogEvents {
+	if t.c == nil || !t.c.notifying() {
+		return
+	}
+	t.c.notify(newEvent(typ, "DirTree", path, 0))
+}
+
+// truncate, returns the placeholder Directory of the directory at path, which
+// is at MaxDepth so its sub-directories are not walked.  The placeholder is
+// kept by the parent directory, so that the truncation is reported by
+// Corpus.MaxDepthReached.  A WarningEvent is sent if the tree was not
+// already truncated at path.
+func (t *treeBuilder) truncate(path, name string, depth int, internal, truncated bool) *Directory {
+	if !truncated && t.c != nil && t.c.notifying() {
+		e := newEvent(WarningEvent, "DirTree", path, 0)
+		e.Detail = "directory tree truncated at MaxDepth " + strconv.Itoa(t.maxDepth)
+		t.c.notify(e)
+	}
+	return &Directory{
+		Depth:     depth,
+		Path:      path,
+		Name:      name,
+		Internal:  internal,
+		Truncated: true,
+	}
+}
+
+// errorEvent, records error err encountered reading the directory at path,
+// see Corpus.Errors, and sends an ErrorEvent.
+func (t *treeBuilder) errorEvent(err error, path string) {
+	if t.c == nil {
 		return
 	}
-	e := Event{
-		typ: typ,
-		msg: fmt.Sprintf("DirTree: %s %q", typ.color(), path),
+	t.c.setWalkError(path, err)
+	if !t.c.notifying() {
+		return
 	}
+	e := newEvent(ErrorEvent, "DirTree", path, 0)
+	e.Err = err
+	e.Detail = err.Error()
 	t.c.notify(e)
 }
 
+// canceled, reports if the context of the walk is done.
+func (t *treeBuilder) canceled() bool {
+	return t.ctx != nil && t.ctx.Err() != nil
+}
+
+// sendPackage, sends a copy of indexed package p to the packages channel, if
+// any.  It blocks until the package is received or the walk is canceled.
+func (t *treeBuilder) sendPackage(p *Package) {
+	if t.pkgs == nil || p == nil || t.canceled() {
+		return
+	}
+	t.c.packages.mu.RLock()
+	q := p.clone()
+	t.c.packages.mu.RUnlock()
+	select {
+	case t.pkgs <- q:
+	case <-t.ctx.Done():
+	}
+}
+
+// found, records that n directories were found and will be visited.
+func (t *treeBuilder) found(n int) {
+	if t.progress == nil || n == 0 {
+		return
+	}
+	t.pmu.Lock()
+	t.total += n
+	t.pmu.Unlock()
+}
+
+// visited, records that a directory was visited and invokes the progress
+// callback.  The callback is invoked with the mutex held, so that calls are
+// serialized and done never decreases.
+func (t *treeBuilder) visited() {
+	if t.progress == nil {
+		return
+	}
+	t.pmu.Lock()
+	t.done++
+	t.progress(t.done, t.total)
+	t.pmu.Unlock()
+}
+
+// newRootDir, returns the directory tree rooted at root, or nil if root is
+// not a directory.
+func (t *treeBuilder) newRootDir(root string) *Directory {
+	fi, err := fs.Stat(root)
+	if err != nil || !fi.IsDir() {
+		t.visited()
+		return nil
+	}
+	return t.newDirTree(root, fi, 0, false)
+}
+
 // seen, reports if the path has been seen.
 func (t *treeBuilder) seen(path string) (ok bool) {
 	t.mu.Lock()
@@ -66,7 +203,7 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 	}
 
 	// TODO: Handle circular references (filepath.EvalSymLink ???).
-	if t.seen(dir.Path) || isIgnored(dir.Name) {
+	if t.seen(dir.Path) || t.ignored(dir.Path, dir.Name) {
 		return exitErr(dir)
 	}
 
@@ -79,13 +216,10 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 		if dir.Dirs != nil {
 			t.removeSubPackages(dir)
 		}
-		// Make sure this is the same as newDirTree.
-		return &Directory{
-			Depth:    dir.Depth,
-			Path:     dir.Path,
-			Name:     dir.Name,
-			Internal: dir.Internal,
+		if dir.HasPkg && t.c.packages != nil {
+			t.c.packages.removePath(dir.Path)
 		}
+		return t.truncate(dir.Path, dir.Name, dir.Depth, dir.Internal, dir.Truncated)
 	}
 
 	fi, err := fs.Stat(dir.Path)
@@ -93,8 +227,19 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 		return exitErr(dir)
 	}
 	// noChange, means the directory structure should be the same.
-	noChange := fs.SameFile(dir.Info, fi)
-	dir.Info = fi
+	noChange := !t.rescan && fs.SameFile(dir.Info, fi)
+
+	// The updated copy of dir.  Directory trees may be read concurrently
+	// so dir must not be modified.
+	nd := &Directory{
+		Path:     dir.Path,
+		Name:     dir.Name,
+		PkgName:  dir.PkgName,
+		HasPkg:   dir.HasPkg,
+		Internal: dir.Internal,
+		Info:     fi,
+		Depth:    dir.Depth,
+	}
 
 	// If there is no change to the directory, simply update any
 	// existing sub-directories.
@@ -103,12 +248,17 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 	// sub-directories.
 	var dirchs []chan *Directory
 	if noChange {
+		// The go.mod file may have been modified in place, which does
+		// not change the directory, it is re-read if its FileInfo
+		// changed.
+		var modInfo os.FileInfo
+		if dir.ModuleRoot {
+			modInfo = statGoMod(dir.Path)
+		}
+		nd.setModule(modInfo, dir)
 		if dir.HasPkg {
-			pkg, _ := t.updatePackage(dir.Path, dir.Info)
-			if pkg != nil {
-				dir.PkgName = pkg.Name
-				dir.HasPkg = pkg.isPkgDir()
-			}
+			pkg, err := t.updatePackage(dir.Path, fi)
+			nd.setPackage(pkg, err)
 		}
 		for _, d := range dir.Dirs {
 			ch := make(chan *Directory, 1)
@@ -120,14 +270,14 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 	} else {
 		list, err := fs.Readdir(dir.Path)
 		if err != nil {
+			t.errorEvent(err, dir.Path)
 			return exitErr(dir)
 		}
+		t.c.clearWalkErrors(dir.Path)
 		// Re-Index directory
-		pkg, err := t.indexPackage(dir.Path, dir.Info, list)
-		if err == nil {
-			dir.PkgName = pkg.Name
-			dir.HasPkg = pkg.isPkgDir()
-		}
+		pkg, err := t.indexPackage(dir.Path, fi, list)
+		nd.setPackage(pkg, err)
+		nd.setModule(goModInfo(list), dir)
 		for _, fi := range list {
 			if isPkgDir(fi) {
 				ch := make(chan *Directory, 1)
@@ -158,7 +308,7 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 	}
 
 	// No package or sub-dirs, remove.
-	if !dir.HasPkg && len(dirs) == 0 {
+	if !nd.HasPkg && len(dirs) == 0 {
 		return exitErr(dir)
 	}
 
@@ -177,40 +327,30 @@ func (t *treeBuilder) updateDirTree(dir *Directory) *Directory {
 		t.notify(UpdateEvent, dir.Path)
 	}
 
-	// Return a copy of the Directory.
-	return &Directory{
-		Path:     dir.Path,
-		Name:     dir.Name,
-		PkgName:  dir.PkgName,
-		HasPkg:   dir.HasPkg,
-		Internal: dir.Internal,
-		Info:     dir.Info,
-		Dirs:     dirs, // updated sub-directories
-		Depth:    dir.Depth,
-	}
+	// Return the updated copy of the Directory.
+	nd.Dirs = dirs // updated sub-directories
+	return nd
 }
 
 func (t *treeBuilder) newDirTree(path string, info os.FileInfo, depth int,
 	internal bool) *Directory {
 
+	t.visited()
 	name := info.Name()
-	if t.seen(path) || isIgnored(name) {
+	if t.canceled() || t.seen(path) || t.ignored(path, name) {
 		return nil
 	}
 	if t.maxDepth > 0 && depth >= t.maxDepth {
-		// Return a dummy directory so that the
-		// parent directory does not discard it.
-		return &Directory{
-			Depth:    depth,
-			Path:     path,
-			Name:     name,
-			Internal: internal,
-		}
+		return t.truncate(path, name, depth, internal, false)
 	}
 	list, err := fs.Readdir(path)
 	if err != nil {
+		// Skip only this directory, the parent directory and its
+		// siblings are still indexed.
+		t.errorEvent(err, path)
 		return nil
 	}
+	t.c.clearWalkErrors(path)
 
 	// If the current name is "internal" set internal to true
 	// so that all sub-directories will also be marked "internal".
@@ -227,9 +367,21 @@ func (t *treeBuilder) newDirTree(path string, info os.FileInfo, depth int,
 	if pkg, err := t.indexPackage(path, info, list); err == nil {
 		pkgName = pkg.Name
 		hasPkg = pkg.isPkgDir()
+		if hasPkg {
+			t.sendPackage(pkg)
+		}
 	}
 
 	// Start goroutings to visit sub-directories
+	if t.progress != nil {
+		n := 0
+		for _, fi := range list {
+			if isPkgDir(fi) {
+				n++
+			}
+		}
+		t.found(n)
+	}
 	var dirchs []chan *Directory
 	for _, fi := range list {
 		if isPkgDir(fi) {
@@ -257,7 +409,7 @@ func (t *treeBuilder) newDirTree(path string, info os.FileInfo, depth int,
 	}
 
 	t.notify(CreateEvent, path)
-	return &Directory{
+	d := &Directory{
 		Path:     path,
 		Name:     name,
 		PkgName:  pkgName,
@@ -267,6 +419,8 @@ func (t *treeBuilder) newDirTree(path string, info os.FileInfo, depth int,
 		Depth:    depth,
 		Dirs:     dirs,
 	}
+	d.setModule(goModInfo(list), nil)
+	return d
 }
 
 // indexPackage, indexes the package.
@@ -291,6 +445,7 @@ func (t *treeBuilder) removePackage(dir *Directory) {
 		return
 	}
 	t.notify(DeleteEvent, dir.Path)
+	t.c.clearWalkErrors(dir.Path)
 	if dir.HasPkg && t.c.packages != nil {
 		t.c.packages.removePath(dir.Path)
 	}
@@ -324,6 +479,41 @@ type Directory struct {
 	Info     os.FileInfo           // FileInfo
 	Dirs     map[string]*Directory // Sub-directories
 	Depth    int                   // Distance from root
+
+	ModuleRoot bool   // Directory contains a go.mod file
+	ModulePath string // Module path declared by go.mod, if ModuleRoot
+	Truncated  bool   // At MaxDepth, sub-directories were not walked
+
+	modInfo os.FileInfo // FileInfo of the go.mod file, if ModuleRoot
+}
+
+// setModule, marks dir as a module root if fi, the FileInfo of the go.mod
+// file in the directory, is not nil and sets its module path.  The go.mod
+// file is only read if it changed since prev, the previous version of dir,
+// which may be nil.
+func (dir *Directory) setModule(fi os.FileInfo, prev *Directory) {
+	dir.ModuleRoot = fi != nil
+	dir.ModulePath = ""
+	dir.modInfo = fi
+	switch {
+	case fi == nil:
+	case prev != nil && prev.ModuleRoot && fs.SameFile(prev.modInfo, fi):
+		dir.ModulePath = prev.ModulePath
+	default:
+		dir.ModulePath = readModulePath(dir.Path)
+	}
+}
+
+// setPackage, sets the package name of dir to that of pkg.  If err is not nil
+// or pkg has no Go files, dir is marked as not containing a package.
+func (dir *Directory) setPackage(pkg *Package, err error) {
+	if err != nil || pkg == nil || !pkg.isPkgDir() {
+		dir.PkgName = ""
+		dir.HasPkg = false
+		return
+	}
+	dir.PkgName = pkg.Name
+	dir.HasPkg = true
 }
 
 func (dir *Directory) walk(c chan<- *Directory, skipRoot bool) {
@@ -381,31 +571,47 @@ func (dir *Directory) lookup(path string) *Directory {
 // TODO: Include Golang license, this comes almost directly from godoc.
 
 type DirEntry struct {
-	Depth    int    // >= 0
-	Height   int    // = DirList.MaxHeight - Depth, > 0
-	Path     string // directory path; includes Name, relative to DirList root
-	Name     string // directory name
-	PkgName  string // package name, or "" if none
-	HasPkg   bool   // true if the directory contains at least one package
-	Internal bool   // true if the package is an "internal" package
+	Depth      int    // >= 0
+	Height     int    // = DirList.MaxHeight - Depth, > 0
+	Path       string // directory path; includes Name, relative to DirList root
+	Name       string // directory name
+	PkgName    string // package name, or "" if none
+	HasPkg     bool   // true if the directory contains at least one package
+	Internal   bool   // true if the package is an "internal" package
+	ModuleRoot bool   // true if the directory contains a go.mod file
+	ModulePath string // module path declared by go.mod, or "" if none
 }
 
 type DirList struct {
-	MaxHeight int // directory tree height, > 0
+	Root      string // absolute path of the directory the list is rooted at
+	MaxHeight int    // directory tree height, > 0
 	List      []DirEntry
 }
 
+// listing, returns the DirList of the directory tree rooted at root.  If
+// filter is not nil, only directories with a path matched by filter are
+// listed.  The depth and height of entries are relative to the listed
+// directories.
 func (root *Directory) listing(skipRoot bool, filter func(string) bool) *DirList {
 	if root == nil {
 		return nil
 	}
 
-	// determine number of entries n and maximum height
-	n := 0
+	// collect the listed directories
+	var dirs []*Directory
+	for d := range root.iter(skipRoot) {
+		if filter == nil || filter(d.Path) {
+			dirs = append(dirs, d)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	// determine maximum height
 	minDepth := 1 << 30 // infinity
 	maxDepth := 0
-	for d := range root.iter(skipRoot) {
-		n++
+	for _, d := range dirs {
 		if minDepth > d.Depth {
 			minDepth = d.Depth
 		}
@@ -415,16 +621,9 @@ func (root *Directory) listing(skipRoot bool, filter func(string) bool) *DirList
 	}
 	maxHeight := maxDepth - minDepth + 1
 
-	if n == 0 {
-		return nil
-	}
-
 	// create list
-	list := make([]DirEntry, 0, n)
-	for d := range root.iter(skipRoot) {
-		if filter != nil && !filter(d.Path) {
-			continue
-		}
+	list := make([]DirEntry, 0, len(dirs))
+	for _, d := range dirs {
 		depth := d.Depth - minDepth
 		e := DirEntry{
 			Depth:    depth,
@@ -434,9 +633,12 @@ func (root *Directory) listing(skipRoot bool, filter func(string) bool) *DirList
 			PkgName:  d.PkgName,
 			HasPkg:   d.HasPkg,
 			Internal: d.Internal,
+
+			ModuleRoot: d.ModuleRoot,
+			ModulePath: d.ModulePath,
 		}
 		list = append(list, e)
 	}
 
-	return &DirList{maxHeight, list}
+	return &DirList{Root: root.Path, MaxHeight: maxHeight, List: list}
 }
@@ -1,13 +1,302 @@
 package pkg
 
 import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/charlievieth/pkg/fs"
 )
 
-func newDirtreeCorpus() {
+func TestNewDirTree(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	root := f.dirs[f.root]
+	if root == nil {
+		t.Fatalf("DirTree: missing root directory: %s", f.root)
+	}
+
+	exp := map[string]string{
+		"alpha":                "alpha",
+		"beta":                 "beta",
+		"beta/vendor/vendored": "vendored",
+		"multi":                "a",
+		"nested/inner":         "inner",
+	}
+	for rel, name := range exp {
+		d := root.lookup(f.path(rel))
+		if d == nil {
+			t.Errorf("DirTree: missing directory: %s", rel)
+			continue
+		}
+		if !d.HasPkg || d.PkgName != name {
+			t.Errorf("DirTree (%s): HasPkg (%v) PkgName (%s)", rel, d.HasPkg, d.PkgName)
+		}
+		if _, ok := f.packages.lookupPath(f.path(rel)); !ok {
+			t.Errorf("DirTree: package not indexed: %s", rel)
+		}
+	}
+	if d := root.lookup(f.path("nested")); d == nil || d.HasPkg {
+		t.Errorf("DirTree: nested: %+v", d)
+	}
+	for _, rel := range []string{"empty", "testdata", "testdata/td"} {
+		if d := root.lookup(f.path(rel)); d != nil {
+			t.Errorf("DirTree: indexed directory: %s", rel)
+		}
+	}
+
+	p, _ := f.packages.lookupPath(f.path("multi"))
+	if p == nil || !IsMultiplePackage(p.Error()) {
+		t.Errorf("DirTree: multi: expected MultiplePackageError got: %+v", p)
+	}
+	p, _ = f.packages.lookupPath(f.path("alpha"))
+	if p == nil {
+		t.Fatal("DirTree: missing package: alpha")
+	}
+	for typ, name := range map[GoFileType]string{
+		GoFile:        "alpha.go",
+		IgnoredGoFile: "alpha_tagged.go",
+		TestGoFile:    "alpha_test.go",
+	} {
+		if _, ok := p.files[typ][name]; !ok || len(p.files[typ]) != 1 {
+			t.Errorf("DirTree: alpha (%s): exp file (%s) got: %v", typ, name, p.files[typ])
+		}
+	}
+}
+
+func TestDirTreeProgress(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+
+	var calls, done, total int
+	f.Progress = func(d, n int) {
+		calls++
+		if d != done+1 || d > n || n < total {
+			t.Errorf("Progress: invalid update: (%d, %d) => (%d, %d)", done, total, d, n)
+		}
+		done, total = d, n
+	}
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root, each directory in the fixture and "testdata", which
+	// is visited but ignored.
+	const exp = 10
+	if calls != exp || done != exp || total != exp {
+		t.Errorf("Progress: exp (%d) got: calls (%d) done (%d) total (%d)",
+			exp, calls, done, total)
+	}
+}
+
+func TestDirListFilter(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	root := f.dirs[f.root]
+
+	all := root.listing(true, nil)
+	if all.Root != f.root || all.MaxHeight != 3 {
+		t.Errorf("DirList: Root (%s) MaxHeight (%d)", all.Root, all.MaxHeight)
+	}
+
+	nested := f.path("nested")
+	list := root.listing(true, func(path string) bool {
+		return hasRoot(path, nested)
+	})
+	if list == nil {
+		t.Fatal("DirList: filter: nil list")
+	}
+	if list.Root != f.root {
+		t.Errorf("DirList: filter: Root: exp (%s) got (%s)", f.root, list.Root)
+	}
+	if list.MaxHeight != 2 {
+		t.Errorf("DirList: filter: MaxHeight: exp (%d) got (%d)", 2, list.MaxHeight)
+	}
+	exp := map[string]DirEntry{
+		"nested":       {Depth: 0, Height: 2, Path: "nested", Name: "nested"},
+		"nested/inner": {Depth: 1, Height: 1, Path: "nested/inner", Name: "inner", PkgName: "inner", HasPkg: true},
+	}
+	if len(list.List) != len(exp) {
+		t.Fatalf("DirList: filter: exp (%d) entries got (%d): %+v", len(exp), len(list.List), list.List)
+	}
+	for _, e := range list.List {
+		if e != exp[e.Path] {
+			t.Errorf("DirList: filter: exp (%+v) got (%+v)", exp[e.Path], e)
+		}
+		if filepath.Join(list.Root, e.Path) != f.path(e.Path) {
+			t.Errorf("DirList: filter: cannot reconstruct path: %s", e.Path)
+		}
+	}
+
+	if list := root.listing(true, func(string) bool { return false }); list != nil {
+		t.Errorf("DirList: filter: exp nil list got: %+v", list)
+	}
+}
+
+func TestUpdateDirTree(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.remove(t, "beta")
+	f.remove(t, "nested/inner/inner.go")
+	f.write(t, "gamma/gamma.go", "package gamma\n")
+	f.write(t, "alpha/alpha2.go", "package alpha\n\nfunc Alpha2() {}\n")
+	f.updateIndex()
+
+	root := f.dirs[f.root]
+	if root == nil {
+		t.Fatalf("UpdateDirTree: missing root directory: %s", f.root)
+	}
+	for _, rel := range []string{"beta", "beta/vendor/vendored", "nested", "nested/inner"} {
+		if d := root.lookup(f.path(rel)); d != nil {
+			t.Errorf("UpdateDirTree: directory not removed: %s", rel)
+		}
+		if _, ok := f.packages.lookupPath(f.path(rel)); ok {
+			t.Errorf("UpdateDirTree: package not removed: %s", rel)
+		}
+	}
+	if d := root.lookup(f.path("gamma")); d == nil || d.PkgName != "gamma" {
+		t.Errorf("UpdateDirTree: gamma: %+v", d)
+	}
+	if _, ok := f.packages.lookupPath(f.path("gamma")); !ok {
+		t.Error("UpdateDirTree: package not added: gamma")
+	}
+	p, _ := f.packages.lookupPath(f.path("alpha"))
+	if p == nil {
+		t.Fatal("UpdateDirTree: missing package: alpha")
+	}
+	if _, ok := p.files[GoFile]["alpha2.go"]; !ok {
+		t.Errorf("UpdateDirTree: alpha: file not added: %v", p.files[GoFile])
+	}
+}
+
+func TestMaxDepthReached(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.log = log.New(ioutil.Discard, "", 0)
+	f.write(t, "deep/a/b/c/d/d.go", "package d\n")
+	f.write(t, "deep/a/b/b.go", "package b\n")
+	f.MaxDepth = 3
+	f.RefreshMode = RefreshSignal // updated explicitly
 
+	events, cancel := f.Subscribe()
+	defer cancel()
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	exp := []string{f.path("beta/vendor/vendored"), f.path("deep/a/b")}
+	if got := f.MaxDepthReached(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("MaxDepthReached:\nExp: %q\nGot: %q", exp, got)
+	}
+	for _, rel := range []string{"deep/a/b", "deep/a/b/c/d"} {
+		if _, ok := f.packages.lookupPath(f.path(rel)); ok {
+			t.Errorf("MaxDepthReached: indexed package: %s", rel)
+		}
+	}
+	warned := make(map[string]bool)
+	timeout := time.After(time.Second * 5)
+	for len(warned) < len(exp) {
+		select {
+		case e := <-events:
+			if e.Event() == WarningEvent {
+				warned[e.(Event).Path] = true
+			}
+		case <-timeout:
+			t.Fatalf("MaxDepthReached: timed out waiting for warnings: %v", warned)
+		}
+	}
+
+	// Removing the limit indexes the truncated trees.
+	f.MaxDepth = 0
+	f.Update()
+	if got := f.MaxDepthReached(); len(got) != 0 {
+		t.Errorf("MaxDepthReached: no limit: %q", got)
+	}
+	if _, ok := f.packages.lookupPath(f.path("deep/a/b/c/d")); !ok {
+		t.Error("MaxDepthReached: no limit: missing package: deep/a/b/c/d")
+	}
+
+	// Restoring it removes the packages of the truncated trees.
+	f.MaxDepth = 3
+	f.Update()
+	if got := f.MaxDepthReached(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("MaxDepthReached: restored:\nExp: %q\nGot: %q", exp, got)
+	}
+	for _, rel := range []string{"deep/a/b", "deep/a/b/c/d"} {
+		if _, ok := f.packages.lookupPath(f.path(rel)); ok {
+			t.Errorf("MaxDepthReached: restored: indexed package: %s", rel)
+		}
+	}
+}
+
+func TestUnreadableDir(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.log = log.New(ioutil.Discard, "", 0)
+	f.RefreshMode = RefreshSignal
+	f.write(t, "locked/locked.go", "package locked\n")
+	f.write(t, "locked/sub/sub.go", "package sub\n")
+
+	locked := f.path("locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+	if _, err := ioutil.ReadDir(locked); err == nil {
+		t.Skip("UnreadableDir: chmod 000 directory is readable (running as root?)")
+	}
+
+	events, cancel := f.Subscribe()
+	defer cancel()
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	root := f.dirTrees()[f.root]
+	if root == nil {
+		t.Fatalf("UnreadableDir: missing root directory: %s", f.root)
+	}
+	for _, rel := range []string{"alpha", "beta", "nested/inner"} {
+		if d := root.lookup(f.path(rel)); d == nil || !d.HasPkg {
+			t.Errorf("UnreadableDir: missing directory: %s", rel)
+		}
+	}
+	for _, rel := range []string{"locked", "locked/sub"} {
+		if d := root.lookup(f.path(rel)); d != nil {
+			t.Errorf("UnreadableDir: unreadable directory indexed: %s", rel)
+		}
+	}
+
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			if ev, ok := e.(Event); ok && ev.Event() == ErrorEvent {
+				if !os.IsPermission(ev.Err) {
+					t.Errorf("UnreadableDir: Err: %v", ev.Err)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("UnreadableDir: timed out waiting for ErrorEvent")
+		}
+	}
 }
 
 func BenchmarkNewDirTree(b *testing.B) {
@@ -56,3 +345,72 @@ func BenchmarkUpdateDirTree(b *testing.B) {
 		t.updateDirTree(dir)
 	}
 }
+
+func TestModuleRoot(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "mod/go.mod", "// comment\nmodule example.com/mod // trailing\n\ngo 1.16\n")
+	f.write(t, "mod/mod.go", "package mod\n")
+	f.write(t, "mod/pkg/pkg.go", "package pkg\n")
+	f.write(t, "mod/sub/go.mod", "module \"example.com/mod/sub\"\n")
+	f.write(t, "mod/sub/sub.go", "package sub\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	test := func(exp map[string]string) {
+		t.Helper()
+		root := f.dirTrees()[f.root]
+		for rel, path := range exp {
+			d := root.lookup(f.path(rel))
+			if d == nil {
+				t.Errorf("ModuleRoot: missing directory: %s", rel)
+				continue
+			}
+			if d.ModuleRoot != (path != "") || d.ModulePath != path {
+				t.Errorf("ModuleRoot: %s: exp (%t, %q) got (%t, %q)", rel,
+					path != "", path, d.ModuleRoot, d.ModulePath)
+			}
+		}
+		list, ok := f.Subtree(f.path("mod"))
+		if !ok {
+			t.Fatal("ModuleRoot: missing subtree: mod")
+		}
+		for _, e := range list.List {
+			rel := filepath.ToSlash(filepath.Join("mod", e.Path))
+			if path, ok := exp[rel]; ok && e.ModulePath != path {
+				t.Errorf("ModuleRoot: DirEntry: %s: exp %q got %q", rel, path, e.ModulePath)
+			}
+		}
+	}
+	test(map[string]string{
+		"mod":     "example.com/mod",
+		"mod/pkg": "",
+		"mod/sub": "example.com/mod/sub",
+		"alpha":   "",
+	})
+
+	// Module paths are updated, even if the directory did not change.
+	f.write(t, "mod/sub/go.mod", "module example.com/renamed\n")
+	f.remove(t, "mod/go.mod")
+	f.updateIndex()
+	test(map[string]string{
+		"mod":     "",
+		"mod/sub": "example.com/renamed",
+	})
+
+	// The go.mod file is not re-read if its FileInfo did not change.
+	name := f.path("mod/sub/go.mod")
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, "mod/sub/go.mod", "module example.com/renamex\n")
+	if err := os.Chtimes(name, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	f.updateIndex()
+	test(map[string]string{
+		"mod/sub": "example.com/renamed",
+	})
+}
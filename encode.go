@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary Ident encoding is:
+//
+//   magic    [4]byte  "PKGI"
+//   version  byte     identVersion
+//   strings  uvarint  number of strings in the string table
+//            [uvarint length, bytes]...
+//   idents   uvarint  number of idents
+//            [uvarint Name, Package, Path, File, Constraint, Info]...
+//
+// The string fields of each ident are indexes into the string table, the
+// empty string is always index zero and is not written.  Since the Package,
+// Path and File of idents are highly repetitive, this is significantly
+// smaller than JSON or gob.
+
+const (
+	identMagic   = "PKGI"
+	identVersion = 1
+
+	// maxIdentString is the maximum length of an encoded string, used to
+	// guard against allocating huge strings when decoding invalid input.
+	maxIdentString = 1 << 20
+)
+
+// ErrInvalidIdents is returned by DecodeIdents when the input is not a valid
+// Ident encoding.
+var ErrInvalidIdents = errors.New("pkg: invalid ident encoding")
+
+// identStrings, maps strings to their index in the string table.
+type identStrings struct {
+	index map[string]uint64
+	list  []string
+}
+
+func (s *identStrings) add(str string) {
+	if _, ok := s.index[str]; !ok {
+		s.index[str] = uint64(len(s.list))
+		s.list = append(s.list, str)
+	}
+}
+
+// EncodeIdents writes ids to w using a compact binary encoding that uses a
+// string table for the string fields of the idents.  Use DecodeIdents to
+// decode the idents.
+func EncodeIdents(w io.Writer, ids []Ident) error {
+	strs := identStrings{
+		index: map[string]uint64{"": 0},
+		list:  []string{""},
+	}
+	for _, id := range ids {
+		strs.add(id.Name)
+		strs.add(id.Package)
+		strs.add(id.Path)
+		strs.add(id.File)
+		strs.add(id.Constraint)
+	}
+
+	bw := bufio.NewWriter(w)
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		bw.Write(buf[:n])
+	}
+
+	bw.WriteString(identMagic)
+	bw.WriteByte(identVersion)
+	putUvarint(uint64(len(strs.list) - 1))
+	for _, s := range strs.list[1:] {
+		putUvarint(uint64(len(s)))
+		bw.WriteString(s)
+	}
+	putUvarint(uint64(len(ids)))
+	for _, id := range ids {
+		putUvarint(strs.index[id.Name])
+		putUvarint(strs.index[id.Package])
+		putUvarint(strs.index[id.Path])
+		putUvarint(strs.index[id.File])
+		putUvarint(strs.index[id.Constraint])
+		putUvarint(uint64(id.Info))
+	}
+	return bw.Flush()
+}
+
+// DecodeIdents reads idents encoded by EncodeIdents from r.  Identical string
+// fields of the decoded idents share memory.
+func DecodeIdents(r io.Reader) ([]Ident, error) {
+	br := bufio.NewReader(r)
+	var hdr [len(identMagic) + 1]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, decodeErr(err)
+	}
+	if string(hdr[:len(identMagic)]) != identMagic {
+		return nil, ErrInvalidIdents
+	}
+	if v := hdr[len(identMagic)]; v != identVersion {
+		return nil, fmt.Errorf("pkg: unsupported ident encoding version: %d", v)
+	}
+
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, decodeErr(err)
+	}
+	// Do not trust n for the initial allocation.
+	list := make([]string, 1, minUint64(n, 1024)+1)
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, decodeErr(err)
+		}
+		if size > maxIdentString {
+			return nil, ErrInvalidIdents
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, decodeErr(err)
+		}
+		list = append(list, string(b))
+	}
+
+	n, err = binary.ReadUvarint(br)
+	if err != nil {
+		return nil, decodeErr(err)
+	}
+	var fields [6]uint64
+	ids := make([]Ident, 0, minUint64(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		for j := range fields {
+			if fields[j], err = binary.ReadUvarint(br); err != nil {
+				return nil, decodeErr(err)
+			}
+		}
+		for _, f := range fields[:5] {
+			if f >= uint64(len(list)) {
+				return nil, ErrInvalidIdents
+			}
+		}
+		ids = append(ids, Ident{
+			Name:       list[fields[0]],
+			Package:    list[fields[1]],
+			Path:       list[fields[2]],
+			File:       list[fields[3]],
+			Constraint: list[fields[4]],
+			Info:       TypInfo(fields[5]),
+		})
+	}
+	return ids, nil
+}
+
+// decodeErr, converts unexpected EOF errors to ErrInvalidIdents.
+func decodeErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidIdents
+	}
+	return err
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeIdents(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	ids := f.Idents()
+	if len(ids) == 0 {
+		t.Fatal("EncodeIdents: no idents")
+	}
+	ids = append(ids, Ident{Name: "Open", Constraint: "windows && amd64"})
+
+	for _, exp := range [][]Ident{ids, {}} {
+		var buf bytes.Buffer
+		if err := EncodeIdents(&buf, exp); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeIdents(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(exp) || (len(exp) != 0 && !reflect.DeepEqual(got, exp)) {
+			t.Errorf("EncodeIdents: exp (%+v) got (%+v)", exp, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeIdents(&buf, ids); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	for _, src := range [][]byte{nil, []byte("JSON\x01"), b[:len(b)/2], b[:len(b)-1]} {
+		if _, err := DecodeIdents(bytes.NewReader(src)); err != ErrInvalidIdents {
+			t.Errorf("DecodeIdents (%q): exp (%v) got (%v)", src, ErrInvalidIdents, err)
+		}
+	}
+	if _, err := DecodeIdents(bytes.NewReader([]byte("PKGI\x02"))); err == nil {
+		t.Error("DecodeIdents: expected error for unsupported version")
+	}
+}
+
+// gorootIdents, returns the idents of a set of standard library packages.
+func gorootIdents(b *testing.B) []Ident {
+	c := NewCorpus()
+	root := c.ctxt.GOROOT()
+	if root == "" {
+		b.Skip("GOROOT must be set to run benchmark")
+	}
+	c.SetRoots([]string{filepath.Join(root, "src")})
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	for _, path := range []string{"fmt", "go/ast", "net/http", "os", "reflect", "strings"} {
+		if _, err := c.LookupOrImport(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return c.Idents()
+}
+
+func BenchmarkEncodeIdents(b *testing.B) {
+	ids := gorootIdents(b)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := EncodeIdents(&buf, ids); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	js, err := json.Marshal(ids)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(buf.Len()), "bytes")
+	b.ReportMetric(float64(len(js)), "json-bytes")
+}
+
+func BenchmarkDecodeIdents(b *testing.B) {
+	var buf bytes.Buffer
+	if err := EncodeIdents(&buf, gorootIdents(b)); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeIdents(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,17 +1,27 @@
 package pkg
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 type EventType int
 
 const (
 	CreateEvent EventType = iota
 	UpdateEvent
 	DeleteEvent
+	ErrorEvent   // an error that did not stop indexing, such as an unreadable directory
+	WarningEvent // a degraded index, such as a directory tree truncated at MaxDepth
 )
 
 var eventTypeStr = [...]string{
 	"CreateEvent",
 	"UpdateEvent",
 	"DeleteEvent",
+	"ErrorEvent",
+	"WarningEvent",
 }
 
 func (e EventType) String() string {
@@ -25,6 +35,8 @@ var eventTypeVerbs = [...]string{
 	"created",
 	"updated",
 	"deleted",
+	"error",
+	"warning",
 }
 
 func (e EventType) verb() string {
@@ -35,14 +47,17 @@ func (e EventType) verb() string {
 }
 
 var eventTypeColor = [...]string{
-	"\033[32m" + "created" + "\033[0m", // green
-	"\033[33m" + "updated" + "\033[0m", // yellow
-	"\033[31m" + "deleted" + "\033[0m", // red
+	"\033[32m", // green
+	"\033[33m", // yellow
+	"\033[31m", // red
+	"\033[31m", // red
+	"\033[33m", // yellow
 }
 
+// color, returns the verb of the event type with ANSI color codes.
 func (e EventType) color() string {
 	if int(e) < len(eventTypeColor) {
-		return eventTypeColor[e]
+		return eventTypeColor[e] + e.verb() + "\033[0m"
 	}
 	return "invalid"
 }
@@ -53,14 +68,82 @@ type Eventer interface {
 	Callback(c *Corpus) error
 }
 
+// An eventRenderer, is an Eventer that renders its message with or without
+// ANSI color codes, such as Event and IndexEvent.
+type eventRenderer interface {
+	Render(color bool) string
+}
+
+// logMessage, returns the message of event e logged by the Corpus, which is
+// colored if e supports it.
+func logMessage(e Eventer) string {
+	if r, ok := e.(eventRenderer); ok {
+		return r.Render(true)
+	}
+	return e.String()
+}
+
+// An Event describes a change to the Corpus.  Subscribers receive either an
+// Event or an IndexEvent value.
+//
+// The message of an Event is rendered from its fields when requested, see
+// Render, so that the fields may be used for structured logging.
 type Event struct {
+	Time     time.Time     // Time the event occurred
+	Duration time.Duration // Duration of the create or update, if measured
+	Err      error         // Error of an ErrorEvent
+	Source   string        // Part of the Corpus that changed: "Package", "DirTree" or "Index"
+	Path     string        // Directory of the change, if any
+	Detail   string        // Optional detail, such as the error message
 	typ      EventType
-	msg      string
 	callback func(c *Corpus) error
 }
 
+// newEvent, returns an Event from source for path that occurred now and took
+// duration d.
+func newEvent(typ EventType, source, path string, d time.Duration) Event {
+	return Event{Time: time.Now(), Duration: d, Source: source, Path: path, typ: typ}
+}
+
 func (e Event) Event() EventType { return e.typ }
-func (e Event) String() string   { return e.msg }
+func (e Event) String() string   { return e.Render(false) }
+
+// Render, returns the human readable message of the event, for example:
+//
+//	Package: created "/go/src/net/http" in 1.2ms
+//
+// If color is true the event type is wrapped in ANSI color codes.
+func (e Event) Render(color bool) string {
+	return renderEvent(e.typ, e.Source, e.Path, e.Detail, e.Duration, color)
+}
+
+// renderEvent, returns the human readable message of an event, see
+// Event.Render.
+func renderEvent(typ EventType, source, path, detail string, d time.Duration, color bool) string {
+	var b strings.Builder
+	if source != "" {
+		b.WriteString(source)
+		b.WriteString(": ")
+	}
+	if color {
+		b.WriteString(typ.color())
+	} else {
+		b.WriteString(typ.verb())
+	}
+	if path != "" {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(path))
+	}
+	if detail != "" {
+		b.WriteString(": ")
+		b.WriteString(detail)
+	}
+	if d > 0 {
+		b.WriteString(" in ")
+		b.WriteString(d.String())
+	}
+	return b.String()
+}
 
 func (e Event) Callback(c *Corpus) error {
 	if e.callback == nil {
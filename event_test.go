@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventRender(t *testing.T) {
+	err := errors.New("permission denied")
+	tests := []struct {
+		e     Eventer
+		exp   string
+		color string
+	}{
+		{
+			e:     newEvent(CreateEvent, "Package", "/go/src/fmt", time.Millisecond),
+			exp:   `Package: created "/go/src/fmt" in 1ms`,
+			color: "Package: \033[32mcreated\033[0m \"/go/src/fmt\" in 1ms",
+		},
+		{
+			e:     Event{Source: "DirTree", Path: "/go/src/x", Detail: err.Error(), Err: err, typ: ErrorEvent},
+			exp:   `DirTree: error "/go/src/x": permission denied`,
+			color: "DirTree: \033[31merror\033[0m \"/go/src/x\": permission denied",
+		},
+		{
+			e:     newEvent(UpdateEvent, "Index", "", 0),
+			exp:   "Index: updated",
+			color: "Index: \033[33mupdated\033[0m",
+		},
+		{
+			e:     Event{Source: "DirTree", Path: "/go/src/x", Detail: "truncated", typ: WarningEvent},
+			exp:   `DirTree: warning "/go/src/x": truncated`,
+			color: "DirTree: \033[33mwarning\033[0m \"/go/src/x\": truncated",
+		},
+		{
+			e:     IndexEvent{Path: "net/http", typ: DeleteEvent},
+			exp:   `Index: deleted "net/http"`,
+			color: "Index: \033[31mdeleted\033[0m \"net/http\"",
+		},
+	}
+	for _, x := range tests {
+		if s := x.e.String(); s != x.exp {
+			t.Errorf("String: exp: %q got: %q", x.exp, s)
+		}
+		if s := logMessage(x.e); s != x.color {
+			t.Errorf("Render: exp: %q got: %q", x.color, s)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fixtureFiles is the synthetic GOPATH written by newFixture, relative to
+// GOPATH/src.
+var fixtureFiles = map[string]string{
+	// Package with known idents of each kind.
+	"alpha/alpha.go": `package alpha
+
+const AlphaConst = 1
+
+var AlphaVar int
+
+type AlphaType struct{}
+
+func (AlphaType) Method() {}
+
+func (AlphaType) method() {}
+
+type AlphaIface interface {
+	IfaceMethod()
+}
+
+func AlphaFunc() {}
+
+func alphaFunc() {}
+`,
+	// Build-tagged file, never matched.
+	"alpha/alpha_tagged.go": `//go:build ignore
+
+package alpha
+
+func AlphaTagged() {}
+`,
+	"alpha/alpha_test.go": `package alpha
+
+func TestAlpha() {}
+`,
+
+	// Importer of alpha with a vendored dependency.
+	"beta/beta.go": `package beta
+
+import (
+	"alpha"
+	"vendored"
+)
+
+func BetaFunc() { alpha.AlphaFunc(); vendored.VendoredFunc() }
+`,
+	"beta/vendor/vendored/vendored.go": `package vendored
+
+func VendoredFunc() {}
+`,
+
+	// Directory containing multiple packages.
+	"multi/a.go": "package a\n\nfunc A() {}\n",
+	"multi/b.go": "package b\n\nfunc B() {}\n",
+
+	// Directory with only sub-directories.
+	"nested/inner/inner.go": "package inner\n\nfunc InnerFunc() {}\n",
+
+	// Directories without packages, which are not indexed.
+	"empty/README":      "no Go files\n",
+	"testdata/td/td.go": "package td\n",
+}
+
+// A fixture is a Corpus rooted at a synthetic GOPATH.
+type fixture struct {
+	*Corpus
+	dir  string // temp directory, removed by Close
+	root string // GOPATH/src
+}
+
+// newFixture, writes fixtureFiles to a temp directory and returns a Corpus
+// with its source root set to the synthetic GOPATH.  The directory tree is
+// not initialized, call initDirTree for that.
+func newFixture(t testing.TB, indexGoCode bool) *fixture {
+	dir, err := ioutil.TempDir("", "pkg-fixture-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "gopath", "src")
+	writeTestFiles(t, root, fixtureFiles)
+
+	c := NewCorpus()
+	c.IndexGoCode = indexGoCode
+	c.LogEvents = false
+	c.SetRoots([]string{root})
+	c.packages = newPackageIndex(c)
+	if indexGoCode {
+		c.idents = newIndex(c)
+	}
+	return &fixture{Corpus: c, dir: dir, root: root}
+}
+
+// path, returns the absolute path of the slash-separated path rel, which is
+// relative to the fixture source root.
+func (f *fixture) path(rel string) string {
+	return filepath.Join(f.root, filepath.FromSlash(rel))
+}
+
+// write, writes src to the fixture file rel.
+func (f *fixture) write(t testing.TB, rel, src string) {
+	writeTestFiles(t, f.root, map[string]string{rel: src})
+}
+
+// remove, removes the fixture file or directory rel.
+func (f *fixture) remove(t testing.TB, rel string) {
+	if err := os.RemoveAll(f.path(rel)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Close, removes the fixture directory.
+func (f *fixture) Close() error {
+	return os.RemoveAll(f.dir)
+}
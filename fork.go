@@ -0,0 +1,173 @@
+package pkg
+
+// Fork, returns a read-only snapshot of the Corpus, which may be queried
+// while the Corpus continues to be updated.  A server can run queries
+// against a fork and periodically replace it with a fresh one, so that each
+// query sees a consistent index.
+//
+// The fork has its own copies of the package and ident indexes, their
+// strings and the directory trees, which are never modified in place, are
+// shared with the Corpus.  The fork also has its own copy of the Context, so
+// it is not affected by SetRoots or SetContext.  Copying the indexes is
+// proportional to their size and a fork uses about as much memory as the
+// indexes of the Corpus.  Fork waits for any update in progress to complete.
+//
+// Forks are read-only: Init and UpdatePaths return an error, Update and
+// Refresh are no-ops and no events are sent.  Packages that are not indexed
+// may still be imported on demand by LookupOrImport, they are only added to
+// the fork.  Idents are not evicted from a fork, see MaxIndexBytes.
+func (c *Corpus) Fork() *Corpus {
+	f := &Corpus{
+		ctxt:            c.ctxt.clone(),
+		MaxDepth:        c.MaxDepth,
+		LogEvents:       c.LogEvents,
+		IndexGoCode:     c.IndexGoCode,
+		IndexCommands:   c.IndexCommands,
+		ModuleMode:      c.ModuleMode,
+		IndexAsm:        c.IndexAsm,
+		AllowBinary:     c.AllowBinary,
+		TypeCheck:       c.TypeCheck,
+		FollowSymlinks:  c.FollowSymlinks,
+		IgnorePatterns:  c.IgnorePatterns,
+		PackageMode:     c.PackageMode,
+		IndexNamesOnly:  c.IndexNamesOnly,
+		PublicOnly:      c.PublicOnly,
+		MaxIndexBytes:   c.MaxIndexBytes,
+		IndexFileInfo:   c.IndexFileInfo,
+		VerifyContent:   c.VerifyContent,
+		VerifySample:    c.VerifySample,
+		ExtraFileFilter: c.ExtraFileFilter,
+		Progress:        c.Progress,
+		RefreshMode:     c.RefreshMode,
+		IndexInterval:   c.IndexInterval,
+		IndexThrottle:   c.IndexThrottle,
+		log:             c.log,
+		stop:            make(chan bool),
+		forked:          true,
+	}
+	// Forks are never refreshed, a closed stop channel drops any events.
+	close(f.stop)
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+	f.dirs = c.dirTrees()
+	f.walkErrs = c.walkErrors()
+	if c.packages != nil {
+		f.packages = c.packages.fork(f)
+	}
+	if c.idents != nil {
+		f.idents = c.idents.fork(f)
+	}
+	return f
+}
+
+// fork, returns a copy of the PackageIndex for Corpus c, see Corpus.Fork.
+// Packages are copied since they are updated in place.
+func (x *PackageIndex) fork(c *Corpus) *PackageIndex {
+	y := newPackageIndex(c)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for root, m := range x.packages {
+		pkgs := make(map[string]*Package, len(m))
+		for path, p := range m {
+			pkgs[path] = p.clone()
+		}
+		y.packages[root] = pkgs
+	}
+	// The slices of packagePath are replaced, not modified.
+	y.packagePath = make(map[string][]string, len(x.packagePath))
+	for name, dirs := range x.packagePath {
+		y.packagePath[name] = dirs
+	}
+	// The maps of importedBy are modified in place, the slices of imports
+	// are replaced.
+	if x.importedBy != nil {
+		y.importedBy = make(map[string]map[string]string, len(x.importedBy))
+		for path, m := range x.importedBy {
+			dirs := make(map[string]string, len(m))
+			for dir, importPath := range m {
+				dirs[dir] = importPath
+			}
+			y.importedBy[path] = dirs
+		}
+		y.imports = make(map[string][]string, len(x.imports))
+		for dir, paths := range x.imports {
+			y.imports[dir] = paths
+		}
+	}
+	return y
+}
+
+// clone, returns a copy of Package p, with its own file maps.
+func (p *Package) clone() *Package {
+	q := *p
+	if p.files != nil {
+		q.files = make(map[GoFileType]FileMap, len(p.files))
+		for typ, m := range p.files {
+			q.files[typ] = m.clone()
+		}
+	}
+	if p.other != nil {
+		q.other = p.other.clone()
+	}
+	if p.invalid != nil {
+		q.invalid = p.invalid.clone()
+	}
+	return &q
+}
+
+// clone, returns a copy of FileMap m.
+func (m FileMap) clone() FileMap {
+	c := make(FileMap, len(m))
+	for name, f := range m {
+		c[name] = f
+	}
+	return c
+}
+
+// fork, returns a copy of the Index for Corpus c, see Corpus.Fork.  The
+// ident slices are copied since removing a package filters them in place.
+func (x *Index) fork(c *Corpus) *Index {
+	y := newIndex(c)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	y.fset = x.fset
+	for name, paths := range x.packagePath {
+		m := make(map[string]bool, len(paths))
+		for path := range paths {
+			m[path] = true
+		}
+		y.packagePath[name] = m
+	}
+	for path, exports := range x.exports {
+		m := make(map[string]Ident, len(exports))
+		for name, id := range exports {
+			m[name] = id
+		}
+		y.exports[path] = m
+	}
+	for kind, names := range x.idents {
+		m := make(map[string][]Ident, len(names))
+		for name, ids := range names {
+			m[name] = append([]Ident(nil), ids...)
+		}
+		y.idents[kind] = m
+	}
+	if x.names != nil {
+		y.names = x.names.clone()
+	}
+	if x.ignored != nil {
+		y.ignored = make(map[string][]Ident, len(x.ignored))
+		for path, ids := range x.ignored {
+			y.ignored[path] = append([]Ident(nil), ids...)
+		}
+	}
+	if x.sizes != nil {
+		y.sizes = make(map[string]int64, len(x.sizes))
+		for path, n := range x.sizes {
+			y.sizes[path] = n
+		}
+	}
+	y.size = x.size
+	return y
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFork(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	fork := f.Fork()
+
+	f.write(t, "alpha/alpha2.go", "package alpha\n\nfunc Alpha2() {}\n")
+	f.remove(t, "beta")
+	f.Update()
+
+	if _, ok := f.Definition("alpha", "Alpha2"); !ok {
+		t.Error("Fork: Corpus: missing ident Alpha2")
+	}
+	if _, ok := f.packages.lookupPath(f.path("beta")); ok {
+		t.Error("Fork: Corpus: package beta not removed")
+	}
+
+	// The fork is unchanged.
+	if _, ok := fork.Definition("alpha", "Alpha2"); ok {
+		t.Error("Fork: unexpected ident Alpha2")
+	}
+	if _, ok := fork.Definition("beta", "BetaFunc"); !ok {
+		t.Error("Fork: missing ident BetaFunc")
+	}
+	p, ok := fork.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("Fork: missing package alpha")
+	}
+	if _, ok := p.LookupFile("alpha2.go"); ok {
+		t.Error("Fork: alpha: unexpected file alpha2.go")
+	}
+	if fork.lookupDir(f.path("beta")) == nil {
+		t.Error("Fork: missing directory beta")
+	}
+
+	// Forks are read-only.
+	fork.Update()
+	if _, ok := fork.Definition("alpha", "Alpha2"); ok {
+		t.Error("Fork: Update modified the fork")
+	}
+	if err := fork.UpdatePaths([]string{f.path("alpha")}); err == nil {
+		t.Error("Fork: UpdatePaths: expected error")
+	}
+	if err := fork.Init(); err == nil {
+		t.Error("Fork: Init: expected error")
+	}
+}
+
+func TestForkOptions(t *testing.T) {
+	c := NewCorpus()
+	c.LogEvents = false
+
+	// Set every exported field, so that fields added to Corpus but not
+	// copied by Fork are caught.
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Bool:
+			fv.SetBool(!fv.Bool())
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			fv.SetInt(fv.Int() + 7)
+		case reflect.Float32, reflect.Float64:
+			fv.SetFloat(fv.Float() + 0.5)
+		case reflect.Slice:
+			fv.Set(reflect.Append(fv, reflect.New(sf.Type.Elem()).Elem()))
+		case reflect.Func:
+			fv.Set(reflect.MakeFunc(sf.Type, func(args []reflect.Value) []reflect.Value {
+				out := make([]reflect.Value, sf.Type.NumOut())
+				for i := range out {
+					out[i] = reflect.Zero(sf.Type.Out(i))
+				}
+				return out
+			}))
+		default:
+			t.Fatalf("ForkOptions: unsupported field type: %s %s", sf.Name, sf.Type)
+		}
+	}
+
+	fv := reflect.ValueOf(c.Fork()).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		exp, got := v.Field(i), fv.Field(i)
+		var equal bool
+		if sf.Type.Kind() == reflect.Func {
+			equal = exp.Pointer() == got.Pointer()
+		} else {
+			equal = reflect.DeepEqual(exp.Interface(), got.Interface())
+		}
+		if !equal {
+			t.Errorf("ForkOptions: field not copied: %s", sf.Name)
+		}
+	}
+}
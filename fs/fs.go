@@ -8,7 +8,10 @@ import (
 	"io/ioutil"
 	"os"
 	pathpkg "path"
+	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 // Limit the number of simultaneously open files and directories.
@@ -24,6 +27,8 @@ type FS struct {
 	maxOpenDirs  int // max number of open directories
 	fsOpenGate   chan struct{}
 	fsDirGate    chan struct{}
+	once         sync.Once
+	retry        retrier // retries transient errors, see SetRetry
 }
 
 // New, returns a new FS with maxOpenFiles and maxOpenDirs.
@@ -34,16 +39,22 @@ type FS struct {
 // If maxOpenFiles or maxOpenDirs are equal to zero, the default
 // max open files and directories are used.
 func New(maxOpenFiles, maxOpenDirs int) *FS {
-	return &FS{
+	fs := &FS{
 		maxOpenFiles: maxOpenFiles,
 		maxOpenDirs:  maxOpenDirs,
-		fsOpenGate:   make(chan struct{}, maxOpenFiles),
-		fsDirGate:    make(chan struct{}, maxOpenDirs),
 	}
+	fs.lazyInit()
+	return fs
 }
 
-// lazyInit, lazy initialization of FS.
+// lazyInit, lazy initialization of FS.  Safe for concurrent use, the gates
+// are created once.
 func (fs *FS) lazyInit() {
+	fs.once.Do(fs.init)
+}
+
+// init, creates the gates of FS.  Called once by lazyInit.
+func (fs *FS) init() {
 	if fs.fsOpenGate == nil && fs.maxOpenFiles > -1 {
 		if fs.maxOpenFiles == 0 {
 			fs.maxOpenFiles = DefaultMaxOpenFiles
@@ -89,7 +100,11 @@ func (fs *FS) closeDirGate() {
 // describes the symbolic link.  Lstat makes no attempt to follow the link.
 // If there is an error, it will be of type *os.PathError.
 func (fs *FS) Lstat(name string) (os.FileInfo, error) {
-	fi, err := os.Lstat(name)
+	var fi os.FileInfo
+	err := fs.retry.do(func() (err error) {
+		fi, err = os.Lstat(name)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +114,11 @@ func (fs *FS) Lstat(name string) (os.FileInfo, error) {
 // Stat returns a os.FileInfo describing the named file.
 // If there is an error, it will be of type *os.PathError.
 func (fs *FS) Stat(name string) (os.FileInfo, error) {
-	fi, err := os.Stat(name)
+	var fi os.FileInfo
+	err := fs.retry.do(func() (err error) {
+		fi, err = os.Stat(name)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +129,24 @@ func (fs *FS) Stat(name string) (os.FileInfo, error) {
 func (fs *FS) ReadFile(path string) ([]byte, error) {
 	fs.openFileGate()
 	defer fs.closeFileGate()
-	return ioutil.ReadFile(path)
+	var b []byte
+	err := fs.retry.do(func() (err error) {
+		b, err = ioutil.ReadFile(path)
+		return err
+	})
+	return b, err
+}
+
+// EvalSymlinks, returns path name after the evaluation of any symbolic links.
+// Symbolic links are resolved with Lstat and Readlink, which do not hold a
+// file descriptor, so no gate is required.
+func (fs *FS) EvalSymlinks(name string) (string, error) {
+	var s string
+	err := fs.retry.do(func() (err error) {
+		s, err = filepath.EvalSymlinks(name)
+		return err
+	})
+	return s, err
 }
 
 // A fileCloser provides a ReadCloser interface to a File.
@@ -133,8 +169,13 @@ func (f *fileCloser) Close() error {
 // OpenFile, returns the file named by path for reading.
 func (fs *FS) OpenFile(path string) (io.ReadCloser, error) {
 	fs.openFileGate()
-	f, err := os.Open(path)
+	var f *os.File
+	err := fs.retry.do(func() (err error) {
+		f, err = os.Open(path)
+		return err
+	})
 	if err != nil {
+		fs.closeFileGate()
 		return nil, err
 	}
 	return &fileCloser{f: f, fs: fs}, nil
@@ -146,12 +187,16 @@ func (fs *FS) Readdirnames(path string) ([]string, error) {
 	fs.openDirGate()
 	defer fs.closeDirGate()
 
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	names, err := f.Readdirnames(-1)
-	f.Close()
+	var names []string
+	err := fs.retry.do(func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		names, err = f.Readdirnames(-1)
+		f.Close()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -172,12 +217,16 @@ func (fs *FS) Readdir(path string) ([]os.FileInfo, error) {
 	fs.openDirGate()
 	defer fs.closeDirGate()
 
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	names, err := f.Readdir(-1)
-	f.Close()
+	var names []os.FileInfo
+	err := fs.retry.do(func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		names, err = f.Readdir(-1)
+		f.Close()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -214,11 +263,16 @@ func FilterList(list []string, fn FilterFunc) []string {
 //
 // Note: Behavior is undefined if path is not absolute.
 func (fs *FS) ReaddirFunc(path string, fn FilterFunc) ([]os.FileInfo, error) {
-	names, err := fs.Readdirnames(path)
+	return readdirFunc(fs, path, fn)
+}
+
+// readdirFunc, implements ReaddirFunc for FileSystem fsys.
+func readdirFunc(fsys FileSystem, path string, fn FilterFunc) ([]os.FileInfo, error) {
+	names, err := fsys.Readdirnames(path)
 	names = FilterList(names, fn)
 	list := make([]os.FileInfo, 0, len(names))
 	for _, n := range names {
-		fi, lerr := fs.Stat(pathpkg.Join(path, n))
+		fi, lerr := fsys.Stat(pathpkg.Join(path, n))
 		if os.IsNotExist(lerr) {
 			continue
 		}
@@ -242,54 +296,119 @@ func (fs *FS) IsFile(name string) bool {
 	return err == nil && !fi.IsDir()
 }
 
-// default FS.
-var std = New(DefaultMaxOpenFiles, DefaultMaxOpenDirs)
+// A FileSystem provides read access to a file system.  It is implemented by
+// FS, which accesses the operating system's file system, and MemFS.  Errors
+// must be of type *os.PathError, so that os.IsNotExist can be used.
+type FileSystem interface {
+	Lstat(name string) (os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	OpenFile(path string) (io.ReadCloser, error)
+	Readdirnames(path string) ([]string, error)
+	Readdir(path string) ([]os.FileInfo, error)
+}
+
+// A symlinkEvaler, is a FileSystem that supports symbolic links.
+type symlinkEvaler interface {
+	EvalSymlinks(name string) (string, error)
+}
+
+// A fileSystem, holds a FileSystem so that the default may be stored in an
+// atomic.Value, which requires a consistent concrete type.
+type fileSystem struct {
+	FileSystem
+}
+
+// default FileSystem.
+var std atomic.Value
+
+func init() {
+	std.Store(fileSystem{New(DefaultMaxOpenFiles, DefaultMaxOpenDirs)})
+}
+
+// Default, returns the default FileSystem used by the functions of this
+// package.  Unless replaced with SetDefault, it is an FS with the default
+// limits.
+func Default() FileSystem {
+	return std.Load().(fileSystem).FileSystem
+}
+
+// SetDefault, replaces the default FileSystem with fsys and returns the
+// previous default.  It is intended for tests, which can index an in-memory
+// tree with a MemFS, and should be called before any files are accessed.
+func SetDefault(fsys FileSystem) FileSystem {
+	prev := Default()
+	std.Store(fileSystem{fsys})
+	return prev
+}
 
-// Lstat calls Lstat of the default FS.
+// Lstat calls Lstat of the default FileSystem.
 func Lstat(name string) (os.FileInfo, error) {
-	return std.Lstat(name)
+	return Default().Lstat(name)
 }
 
-// Stat calls Stat of the default FS.
+// Stat calls Stat of the default FileSystem.
 func Stat(name string) (os.FileInfo, error) {
-	return std.Stat(name)
+	return Default().Stat(name)
 }
 
-// ReadFile reads the file named by filename using the standard FS and
+// ReadFile reads the file named by filename using the default FileSystem and
 // returns the contents.
 func ReadFile(path string) ([]byte, error) {
-	return std.ReadFile(path)
+	return Default().ReadFile(path)
 }
 
-// OpenFile, returns the file named by path for reading using the standard FS.
+// OpenFile, returns the file named by path for reading using the default
+// FileSystem.
 func OpenFile(path string) (io.ReadCloser, error) {
-	return std.OpenFile(path)
+	return Default().OpenFile(path)
 }
 
-// Readdirnames, uses the default FS to read and return a slice of names from
-// the directory f, in sorted order.
+// Readdirnames, uses the default FileSystem to read and return a slice of
+// names from the directory f, in sorted order.
 func Readdirnames(path string) ([]string, error) {
-	return std.Readdirnames(path)
+	return Default().Readdirnames(path)
 }
 
-// Readdir uses the default FS to read the contents of the directory name.
+// Readdir uses the default FileSystem to read the contents of the directory
+// name.
 func Readdir(path string) ([]os.FileInfo, error) {
-	return std.Readdir(path)
+	return Default().Readdir(path)
 }
 
-// ReaddirFunc calls ReaddirFunc of the default FS.
+// ReaddirFunc calls ReaddirFunc of the default FileSystem.
 func ReaddirFunc(path string, fn FilterFunc) ([]os.FileInfo, error) {
-	return std.ReaddirFunc(path, fn)
+	return readdirFunc(Default(), path, fn)
 }
 
-// IsDir, returns if path name is a directory, using the default FS.
+// EvalSymlinks, returns path name after the evaluation of any symbolic links
+// using the default FileSystem.  If the FileSystem does not support symbolic
+// links, the cleaned name is returned if it exists.
+func EvalSymlinks(name string) (string, error) {
+	return evalSymlinks(Default(), name)
+}
+
+// evalSymlinks, implements EvalSymlinks for FileSystem fsys.
+func evalSymlinks(fsys FileSystem, name string) (string, error) {
+	if e, ok := fsys.(symlinkEvaler); ok {
+		return e.EvalSymlinks(name)
+	}
+	if _, err := fsys.Lstat(name); err != nil {
+		return "", err
+	}
+	return filepath.Clean(name), nil
+}
+
+// IsDir, returns if path name is a directory, using the default FileSystem.
 func IsDir(name string) bool {
-	return std.IsDir(name)
+	fi, err := Stat(name)
+	return err == nil && fi.IsDir()
 }
 
-// IsDir, returns if path name is a file, using the default FS.
+// IsDir, returns if path name is a file, using the default FileSystem.
 func IsFile(name string) bool {
-	return std.IsFile(name)
+	fi, err := Stat(name)
+	return err == nil && !fi.IsDir()
 }
 
 // IsPathErr, returns if error err is a *os.PathError.
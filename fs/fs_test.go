@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"io"
 	"os"
 	"reflect"
 	"testing"
@@ -240,3 +241,89 @@ func TestFileCloser(t *testing.T) {
 		t.Errorf("FileCloser Close error: Exp (%v) Got (%v)", n1, n2)
 	}
 }
+
+func TestNew(t *testing.T) {
+	for _, max := range []int{-1, 0, 1} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fs := New(max, max)
+			if _, err := fs.Readdirnames("."); err != nil {
+				t.Errorf("New(%d): Readdirnames: %v", max, err)
+			}
+			rc, err := fs.OpenFile("fs_test.go")
+			if err != nil {
+				t.Errorf("New(%d): OpenFile: %v", max, err)
+				return
+			}
+			rc.Close()
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("New(%d): timed out opening files", max)
+		}
+	}
+}
+
+func TestOpenFileGate(t *testing.T) {
+	const max = 2
+	fs := New(max, max)
+
+	// Failed opens must not hold the gate.
+	for i := 0; i < max+1; i++ {
+		if _, err := fs.OpenFile("missing.go"); !os.IsNotExist(err) {
+			t.Fatalf("OpenFile: expected not exist error got: %v", err)
+		}
+	}
+
+	var open []io.ReadCloser
+	for i := 0; i < max; i++ {
+		rc, err := fs.OpenFile("fs_test.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		open = append(open, rc)
+	}
+
+	opened := make(chan io.ReadCloser)
+	go func() {
+		rc, err := fs.OpenFile("fs_test.go")
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- rc
+	}()
+	select {
+	case rc := <-opened:
+		rc.Close()
+		t.Fatalf("OpenFile: more than %d files open", max)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	open[0].Close()
+	select {
+	case rc := <-opened:
+		rc.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("OpenFile: gate not released by Close")
+	}
+	open[1].Close()
+	if n := len(fs.fsOpenGate); n != 0 {
+		t.Errorf("OpenFile: %d gate slots held after closing all files", n)
+	}
+}
+
+func TestEvalSymlinks(t *testing.T) {
+	m := NewMemFS()
+	m.WriteFile("/a/b.go", nil)
+	prev := SetDefault(m)
+	defer SetDefault(prev)
+
+	if s, err := EvalSymlinks("/a//b.go"); err != nil || s != "/a/b.go" {
+		t.Errorf("EvalSymlinks: (%q, %v)", s, err)
+	}
+	if _, err := EvalSymlinks("/a/c.go"); !os.IsNotExist(err) {
+		t.Errorf("EvalSymlinks: expected not exist error got: %v", err)
+	}
+}
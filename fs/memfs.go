@@ -0,0 +1,246 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// A MemFS is an in-memory FileSystem, intended for tests that index a
+// synthetic tree without touching the disk.  Paths are slash-separated and
+// absolute, parent directories are created as files are written.
+//
+// Like most file systems, writing or removing a file updates the
+// modification time of its directory if the file is created or removed.
+// Modification times increase monotonically, so every change is observable.
+type MemFS struct {
+	files map[string]*memFile // cleaned path => file or directory
+	clock time.Time           // time of the last modification
+	mu    sync.RWMutex
+}
+
+// A memFile is a file or directory of a MemFS.
+type memFile struct {
+	data    []byte
+	modTime time.Time
+	dir     bool
+}
+
+// NewMemFS, returns a MemFS containing only the root directory.
+func NewMemFS() *MemFS {
+	m := &MemFS{
+		files: make(map[string]*memFile),
+		clock: time.Unix(0, 0).UTC(),
+	}
+	m.files["/"] = &memFile{dir: true, modTime: m.clock}
+	return m
+}
+
+// now, returns the next modification time.  Lock the mutex before calling.
+func (m *MemFS) now() time.Time {
+	m.clock = m.clock.Add(time.Second)
+	return m.clock
+}
+
+// WriteFile, creates or replaces the file at path with data, creating any
+// missing parent directories.
+func (m *MemFS) WriteFile(path string, data []byte) error {
+	path = pathpkg.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f := m.files[path]; f != nil && f.dir {
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+	}
+	if err := m.mkdirAll(pathpkg.Dir(path)); err != nil {
+		return err
+	}
+	mtime := m.now()
+	if m.files[path] == nil {
+		m.files[pathpkg.Dir(path)].modTime = mtime
+	}
+	m.files[path] = &memFile{
+		data:    append([]byte(nil), data...),
+		modTime: mtime,
+	}
+	return nil
+}
+
+// MkdirAll, creates the directory at path and any missing parents.
+func (m *MemFS) MkdirAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(pathpkg.Clean(path))
+}
+
+// mkdirAll, implements MkdirAll.  Lock the mutex before calling.
+func (m *MemFS) mkdirAll(path string) error {
+	if f := m.files[path]; f != nil {
+		if !f.dir {
+			return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+		}
+		return nil
+	}
+	if err := m.mkdirAll(pathpkg.Dir(path)); err != nil {
+		return err
+	}
+	mtime := m.now()
+	m.files[pathpkg.Dir(path)].modTime = mtime
+	m.files[path] = &memFile{dir: true, modTime: mtime}
+	return nil
+}
+
+// RemoveAll, removes path and, if it is a directory, its contents.  No error
+// is returned if path does not exist.
+func (m *MemFS) RemoveAll(path string) error {
+	path = pathpkg.Clean(path)
+	if path == "/" {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files[path] == nil {
+		return nil
+	}
+	prefix := path + "/"
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.files, name)
+		}
+	}
+	m.files[pathpkg.Dir(path)].modTime = m.now()
+	return nil
+}
+
+// Chtimes, sets the modification time of the file or directory at path.
+func (m *MemFS) Chtimes(path string, mtime time.Time) error {
+	path = pathpkg.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.files[path]
+	if f == nil {
+		return &os.PathError{Op: "chtimes", Path: path, Err: os.ErrNotExist}
+	}
+	f.modTime = mtime
+	return nil
+}
+
+// lookup, returns the file at path or an *os.PathError for operation op.
+func (m *MemFS) lookup(op, path string) (*memFile, error) {
+	m.mu.RLock()
+	f := m.files[pathpkg.Clean(path)]
+	m.mu.RUnlock()
+	if f == nil {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// stat, returns the FileInfo of File f named name.
+func (m *MemFS) stat(name string, f *memFile) os.FileInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fi := &fileStat{
+		name:    pathpkg.Base(name),
+		size:    int64(len(f.data)),
+		mode:    0644,
+		modTime: f.modTime,
+	}
+	if f.dir {
+		fi.mode = os.ModeDir | 0755
+		fi.size = 0
+	}
+	return fi
+}
+
+// Lstat, returns the FileInfo of the file at name.  A MemFS does not support
+// symbolic links, so Lstat is the same as Stat.
+func (m *MemFS) Lstat(name string) (os.FileInfo, error) {
+	f, err := m.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.stat(name, f), nil
+}
+
+// Stat, returns the FileInfo of the file at name.
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.stat(name, f), nil
+}
+
+// ReadFile, returns the contents of the file at path.
+func (m *MemFS) ReadFile(path string) ([]byte, error) {
+	f, err := m.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if f.dir {
+		return nil, &os.PathError{Op: "read", Path: path, Err: errIsDir}
+	}
+	m.mu.RLock()
+	b := append([]byte(nil), f.data...)
+	m.mu.RUnlock()
+	return b, nil
+}
+
+// OpenFile, returns the file at path for reading.
+func (m *MemFS) OpenFile(path string) (io.ReadCloser, error) {
+	b, err := m.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Readdirnames, returns the names of the entries of the directory at path,
+// in sorted order.
+func (m *MemFS) Readdirnames(path string) ([]string, error) {
+	path = pathpkg.Clean(path)
+	f, err := m.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if !f.dir {
+		return nil, &os.PathError{Op: "readdirent", Path: path, Err: errNotDir}
+	}
+	var names []string
+	m.mu.RLock()
+	for name := range m.files {
+		if name != "/" && pathpkg.Dir(name) == path {
+			names = append(names, pathpkg.Base(name))
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(names)
+	return names, nil
+}
+
+// Readdir, returns the FileInfo of the entries of the directory at path, in
+// sorted order.
+func (m *MemFS) Readdir(path string) ([]os.FileInfo, error) {
+	names, err := m.Readdirnames(path)
+	if err != nil {
+		return nil, err
+	}
+	fis := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		if fi, err := m.Stat(pathpkg.Join(path, name)); err == nil {
+			fis = append(fis, fi)
+		}
+	}
+	return fis, nil
+}
@@ -0,0 +1,110 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	var _ FileSystem = m
+
+	if err := m.WriteFile("/a/b/c.go", []byte("package b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("/a/b/d.go", []byte("package b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MkdirAll("/a/b/e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("/a/b", nil); err == nil {
+		t.Error("WriteFile: expected error writing directory")
+	}
+
+	names, err := m.Readdirnames("/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"c.go", "d.go", "e"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("Readdirnames: exp: %q got: %q", exp, names)
+	}
+	fis, err := m.Readdir("/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 3 || fis[0].Name() != "c.go" || fis[0].Size() != 10 || !fis[2].IsDir() {
+		t.Errorf("Readdir: %v", fis)
+	}
+	if _, err := m.Readdirnames("/a/b/c.go"); err == nil {
+		t.Error("Readdirnames: expected error reading file")
+	}
+
+	rc, err := m.OpenFile("/a/b/c.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != "package b\n" {
+		t.Errorf("OpenFile: (%q, %v)", b, err)
+	}
+	if _, err := m.ReadFile("/missing"); !os.IsNotExist(err) || !IsPathErr(err) {
+		t.Errorf("ReadFile: expected not exist error got: %v", err)
+	}
+
+	// Modifications update the modification time of the directory.
+	dir, _ := m.Stat("/a/b")
+	file, _ := m.Stat("/a/b/c.go")
+	if err := m.WriteFile("/a/b/c.go", []byte("package c\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := m.Stat("/a/b/c.go"); !fi.ModTime().After(file.ModTime()) || SameFile(fi, file) {
+		t.Error("WriteFile: modification time not updated")
+	}
+	if fi, _ := m.Stat("/a/b"); !SameFile(fi, dir) {
+		t.Error("WriteFile: replacing a file changed its directory")
+	}
+	if err := m.RemoveAll("/a/b/e"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := m.Stat("/a/b"); SameFile(fi, dir) {
+		t.Error("RemoveAll: directory not updated")
+	}
+	if err := m.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/a/b/c.go"); !os.IsNotExist(err) {
+		t.Errorf("RemoveAll: expected not exist error got: %v", err)
+	}
+
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.WriteFile("/f", nil)
+	if err := m.Chtimes("/f", mtime); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := m.Lstat("/f"); !fi.ModTime().Equal(mtime) {
+		t.Errorf("Chtimes: exp: %s got: %s", mtime, fi.ModTime())
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	m := NewMemFS()
+	m.WriteFile("/src/a.go", []byte("package a\n"))
+	prev := SetDefault(m)
+	defer SetDefault(prev)
+
+	if !IsFile("/src/a.go") || !IsDir("/src") {
+		t.Error("SetDefault: MemFS not used")
+	}
+	fis, err := ReaddirFunc("/src", FilterGo)
+	if err != nil || len(fis) != 1 || fis[0].Name() != "a.go" {
+		t.Errorf("ReaddirFunc: (%v, %v)", fis, err)
+	}
+	if Default() != FileSystem(m) {
+		t.Error("Default: MemFS not returned")
+	}
+}
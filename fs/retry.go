@@ -0,0 +1,136 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultMaxBackoff is the maximum delay between retries.
+const DefaultMaxBackoff = time.Second
+
+// IsTransient, returns if error err is likely to succeed if the operation is
+// retried: an interrupted system call (EINTR), a resource that is temporarily
+// unavailable (EAGAIN), too many open files (EMFILE, ENFILE) or a timeout, as
+// returned by network file systems.  Errors such as ENOENT or EACCES are not
+// transient.
+func IsTransient(err error) bool {
+	var tmp interface{ Temporary() bool }
+	if errors.As(err, &tmp) && tmp.Temporary() {
+		return true
+	}
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
+
+// A retrier, retries operations that fail with a transient error.  The delay
+// before the first retry is backoff and doubles after each retry, up to
+// DefaultMaxBackoff.  The zero value does not retry.
+type retrier struct {
+	attempts int           // max number of attempts, including the first
+	backoff  time.Duration // delay before the first retry
+}
+
+// do, calls op until it succeeds, returns an error that is not transient or
+// the attempts are exhausted, and returns the last error.
+func (r retrier) do(op func() error) error {
+	delay := r.backoff
+	for i := 1; ; i++ {
+		err := op()
+		if err == nil || i >= r.attempts || !IsTransient(err) {
+			return err
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > DefaultMaxBackoff {
+			delay = DefaultMaxBackoff
+		}
+	}
+}
+
+// SetRetry, sets the number of attempts, including the first, made by the
+// operations of FS that fail with a transient error, see IsTransient, and the
+// delay before the first retry, which doubles after each retry up to
+// DefaultMaxBackoff.  If attempts is less than two, operations are not
+// retried, which is the default.  Retries are intended for network file
+// systems, such as NFS or SMB.
+//
+// SetRetry must be called before the FS is used.  The open file and
+// directory gates are held while waiting to retry.
+func (fs *FS) SetRetry(attempts int, backoff time.Duration) {
+	fs.retry = retrier{attempts: attempts, backoff: backoff}
+}
+
+// Retry, returns a FileSystem that retries the operations of fsys that fail
+// with a transient error, like FS.SetRetry.  If attempts is less than two,
+// fsys is returned.
+func Retry(fsys FileSystem, attempts int, backoff time.Duration) FileSystem {
+	if attempts < 2 {
+		return fsys
+	}
+	return &retryFS{fsys: fsys, retry: retrier{attempts: attempts, backoff: backoff}}
+}
+
+// A retryFS, is a FileSystem that retries transient errors, see Retry.
+type retryFS struct {
+	fsys  FileSystem
+	retry retrier
+}
+
+func (r *retryFS) Lstat(name string) (fi os.FileInfo, err error) {
+	err = r.retry.do(func() error {
+		fi, err = r.fsys.Lstat(name)
+		return err
+	})
+	return fi, err
+}
+
+func (r *retryFS) Stat(name string) (fi os.FileInfo, err error) {
+	err = r.retry.do(func() error {
+		fi, err = r.fsys.Stat(name)
+		return err
+	})
+	return fi, err
+}
+
+func (r *retryFS) ReadFile(path string) (b []byte, err error) {
+	err = r.retry.do(func() error {
+		b, err = r.fsys.ReadFile(path)
+		return err
+	})
+	return b, err
+}
+
+func (r *retryFS) OpenFile(path string) (rc io.ReadCloser, err error) {
+	err = r.retry.do(func() error {
+		rc, err = r.fsys.OpenFile(path)
+		return err
+	})
+	return rc, err
+}
+
+func (r *retryFS) Readdirnames(path string) (names []string, err error) {
+	err = r.retry.do(func() error {
+		names, err = r.fsys.Readdirnames(path)
+		return err
+	})
+	return names, err
+}
+
+func (r *retryFS) Readdir(path string) (list []os.FileInfo, err error) {
+	err = r.retry.do(func() error {
+		list, err = r.fsys.Readdir(path)
+		return err
+	})
+	return list, err
+}
+
+// EvalSymlinks, resolves symbolic links with the underlying FileSystem, see
+// the package function EvalSymlinks.
+func (r *retryFS) EvalSymlinks(name string) (s string, err error) {
+	err = r.retry.do(func() error {
+		s, err = evalSymlinks(r.fsys, name)
+		return err
+	})
+	return s, err
+}
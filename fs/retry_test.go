@@ -0,0 +1,137 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A flakyFS, is a FileSystem that fails with err until it has been called
+// fails times.
+type flakyFS struct {
+	FileSystem
+	err   error
+	fails int
+	calls int
+}
+
+func (f *flakyFS) Stat(name string) (os.FileInfo, error) {
+	f.calls++
+	if f.calls <= f.fails {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: f.err}
+	}
+	return f.FileSystem.Stat(name)
+}
+
+// timeoutError, is a network file system timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	var tests = []struct {
+		err error
+		ok  bool
+	}{
+		{nil, false},
+		{syscall.EINTR, true},
+		{&os.PathError{Op: "open", Path: "/p", Err: syscall.EAGAIN}, true},
+		{&os.PathError{Op: "open", Path: "/p", Err: timeoutError{}}, true},
+		{&os.PathError{Op: "open", Path: "/p", Err: syscall.ENOENT}, false},
+		{&os.PathError{Op: "open", Path: "/p", Err: syscall.EACCES}, false},
+		{errors.New("error"), false},
+	}
+	for _, x := range tests {
+		if ok := IsTransient(x.err); ok != x.ok {
+			t.Errorf("IsTransient(%v): exp: %v got: %v", x.err, x.ok, ok)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	m := NewMemFS()
+	if err := m.WriteFile("/p/a.go", []byte("package p")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Succeeds once the transient errors stop.
+	f := &flakyFS{FileSystem: m, err: syscall.EINTR, fails: 2}
+	fi, err := Retry(f, 3, time.Millisecond).Stat("/p/a.go")
+	if err != nil || fi.Name() != "a.go" {
+		t.Fatalf("Retry: Stat: %v", err)
+	}
+	if f.calls != 3 {
+		t.Errorf("Retry: exp: %d calls got: %d", 3, f.calls)
+	}
+
+	// The last error is returned when the attempts are exhausted.
+	f = &flakyFS{FileSystem: m, err: syscall.EAGAIN, fails: 5}
+	if _, err := Retry(f, 3, time.Millisecond).Stat("/p/a.go"); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("Retry: exhausted: %v", err)
+	}
+	if f.calls != 3 {
+		t.Errorf("Retry: exhausted: exp: %d calls got: %d", 3, f.calls)
+	}
+
+	// Errors that are not transient are not retried.
+	for _, e := range []error{syscall.ENOENT, syscall.EACCES} {
+		f = &flakyFS{FileSystem: m, err: e, fails: 1}
+		if _, err := Retry(f, 3, time.Millisecond).Stat("/p/a.go"); !errors.Is(err, e) {
+			t.Errorf("Retry (%v): %v", e, err)
+		}
+		if f.calls != 1 {
+			t.Errorf("Retry (%v): exp: %d calls got: %d", e, 1, f.calls)
+		}
+	}
+
+	// Retries are disabled with less than two attempts.
+	if fsys := Retry(m, 1, time.Millisecond); fsys != FileSystem(m) {
+		t.Errorf("Retry: attempts 1: %T", fsys)
+	}
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	calls := 0
+	r := retrier{attempts: 4, backoff: time.Millisecond * 5}
+	start := time.Now()
+	err := r.do(func() error {
+		calls++
+		return syscall.EINTR
+	})
+	if err != syscall.EINTR || calls != 4 {
+		t.Errorf("retrier: err: %v calls: %d", err, calls)
+	}
+	// 5ms + 10ms + 20ms
+	if d := time.Since(start); d < time.Millisecond*35 {
+		t.Errorf("retrier: backoff: %s", d)
+	}
+
+	// The zero value does not retry.
+	calls = 0
+	(retrier{}).do(func() error {
+		calls++
+		return syscall.EINTR
+	})
+	if calls != 1 {
+		t.Errorf("retrier: zero value: calls: %d", calls)
+	}
+}
+
+func TestFSSetRetry(t *testing.T) {
+	fs := New(-1, -1)
+	fs.SetRetry(3, time.Second)
+	start := time.Now()
+	if _, err := fs.Stat("/does/not/exist"); !os.IsNotExist(err) {
+		t.Fatalf("SetRetry: Stat: %v", err)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("SetRetry: ENOENT was retried: %s", d)
+	}
+	if _, err := fs.Readdir("."); err != nil {
+		t.Errorf("SetRetry: Readdir: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// indexGobVersion, is the version of the gob encoding of an Index.  It is
+// incremented when the encoding changes and older encodings are rejected.
+const indexGobVersion = 1
+
+// An indexGob, is the gob encoding of an Index, see Index.Gob.
+type indexGob struct {
+	Version     int
+	Exports     map[string]map[string]Ident // "net/http" => "Client.Do" => ident
+	Idents      []Ident                     // idents of every kind and name
+	PackagePath map[string]map[string]bool  // "http" => "net/http" => true
+}
+
+// Gob writes the idents of the Index to w using encoding/gob, they are read
+// with LoadIndex.  Caches, such as the idents of ignored files and positions,
+// are not written.
+func (x *Index) Gob(w io.Writer) error {
+	x.mu.RLock()
+	v := indexGob{
+		Version:     indexGobVersion,
+		Exports:     x.exports,
+		PackagePath: x.packagePath,
+	}
+	v.Idents = make([]Ident, 0, x.countIdents())
+	for _, m := range x.idents {
+		for _, ids := range m {
+			v.Idents = append(v.Idents, ids...)
+		}
+	}
+	// Encode while holding the lock, the maps are modified in place.
+	err := gob.NewEncoder(w).Encode(&v)
+	x.mu.RUnlock()
+	return err
+}
+
+// LoadIndex reads an Index written by Index.Gob from r.  If c is not nil the
+// Index replaces the ident index of Corpus c, which is kept by Init, so that
+// its idents may be queried before the source roots are walked.  The next
+// Init or Update reconciles the loaded Index with the file system: packages
+// are re-indexed as they are found and the idents of packages that no longer
+// exist, or are no longer indexable, are removed.
+//
+// Only idents are written by Index.Gob, not the package index, so LoadIndex
+// does not make Init faster: the walk still imports and parses every package.
+// It is intended for serving queries before Init, or while it runs.
+//
+// The strings of the loaded idents are interned, as if they were indexed.
+func LoadIndex(r io.Reader, c *Corpus) (*Index, error) {
+	var v indexGob
+	if err := gob.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	if v.Version != indexGobVersion {
+		return nil, fmt.Errorf("pkg: unsupported index version: %d", v.Version)
+	}
+	x := newIndex(c)
+
+	// The file names of idents are not interned, see astIndexer.visitIdent,
+	// but the idents of a file share its name.
+	files := make(map[string]string)
+	intern := func(id Ident) Ident {
+		id.Name = x.intern(id.Name)
+		id.Package = x.intern(id.Package)
+		id.Path = x.intern(id.Path)
+		if s, ok := files[id.File]; ok {
+			id.File = s
+		} else {
+			files[id.File] = id.File
+		}
+		if id.Constraint != "" {
+			id.Constraint = x.intern(id.Constraint)
+		}
+		return id
+	}
+	for path, exp := range v.Exports {
+		m := make(map[string]Ident, len(exp))
+		for key, id := range exp {
+			m[x.intern(key)] = intern(id)
+		}
+		path = x.intern(path)
+		x.exports[path] = m
+		x.setSize(path, len(m))
+	}
+	for _, id := range v.Idents {
+		id = intern(id)
+		x.addIdents(id.Info.Kind(), id.name(), id)
+	}
+	for name, paths := range v.PackagePath {
+		m := make(map[string]bool, len(paths))
+		for path, ok := range paths {
+			m[x.intern(path)] = ok
+		}
+		x.packagePath[x.intern(name)] = m
+	}
+	x.loaded = true
+
+	if c != nil {
+		c.updateMu.Lock()
+		c.idents = x
+		c.updateMu.Unlock()
+	}
+	return x, nil
+}
+
+// reconcile, removes the idents of the packages that are not in the package
+// index, or are not indexable, if the Index was loaded by LoadIndex and
+// this is the first walk of the source roots since.  Lock updateMu before
+// calling.
+func (c *Corpus) reconcile() {
+	x := c.idents
+	if x == nil || c.packages == nil {
+		return
+	}
+	x.mu.Lock()
+	loaded := x.loaded
+	x.loaded = false
+	x.mu.Unlock()
+	if !loaded {
+		return
+	}
+	keep := make(map[string]bool)
+	c.packages.each(func(p *Package) bool {
+		if x.indexable(p) {
+			keep[p.ImportPath] = true
+		}
+		return true
+	})
+	for _, path := range x.ExportedPackages() {
+		if !keep[path] {
+			x.removeImportPath(path, "")
+		}
+	}
+}
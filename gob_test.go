@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestIndexGob(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "alpha/more.go", "package alpha\n\nfunc (AlphaType) Next() {}\n\nvar Shared = 1\n")
+	f.write(t, "gamma/gamma.go", "package gamma\n\nvar Shared = 2\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.Index().Gob(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCorpus()
+	c.IndexGoCode = true
+	c.LogEvents = false
+	c.log = log.New(ioutil.Discard, "", 0)
+	c.SetRoots([]string{f.root})
+	x, err := LoadIndex(&buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Index() != x {
+		t.Fatal("LoadIndex: Index not set on the Corpus")
+	}
+	if x.strings.Len() == 0 {
+		t.Error("LoadIndex: strings not interned")
+	}
+
+	names := []string{"AlphaFunc", "AlphaType", "Method", "Next", "Shared", "InnerFunc", "alphaFunc"}
+	for _, name := range names {
+		exp := f.Search(name)
+		if got := c.Search(name); len(exp) == 0 || !reflect.DeepEqual(got, exp) {
+			t.Errorf("Search(%q):\nExp: %+v\nGot: %+v", name, exp, got)
+		}
+	}
+	if exp, got := f.Idents(), c.Idents(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Idents:\nExp: %+v\nGot: %+v", exp, got)
+	}
+	if exp, got := f.Exports("alpha"), c.Exports("alpha"); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Exports:\nExp: %q\nGot: %q", exp, got)
+	}
+	if exp, got := f.NumIdents(), c.NumIdents(); got != exp {
+		t.Errorf("NumIdents: exp: %d got: %d", exp, got)
+	}
+	if exp, got := f.idents.size, x.size; got != exp {
+		t.Errorf("size: exp: %d got: %d", exp, got)
+	}
+
+	// Init keeps the loaded Index and reconciles it with the file system.
+	f.remove(t, "nested")
+	f.write(t, "delta/delta.go", "package delta\n\nfunc DeltaFunc() {}\n")
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if c.Index() != x {
+		t.Fatal("Init: loaded Index replaced")
+	}
+	if ids := c.Search("InnerFunc"); len(ids) != 0 {
+		t.Errorf("Init: stale idents: %+v", ids)
+	}
+	if ids := c.Search("DeltaFunc"); len(ids) != 1 {
+		t.Errorf("Init: new idents: %+v", ids)
+	}
+	for _, name := range []string{"AlphaFunc", "Next", "Shared"} {
+		if exp, got := f.Search(name), c.Search(name); !reflect.DeepEqual(got, exp) {
+			t.Errorf("Init: Search(%q):\nExp: %+v\nGot: %+v", name, exp, got)
+		}
+	}
+	if x.loaded {
+		t.Error("Init: Index not reconciled")
+	}
+}
+
+func TestLoadIndexVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&indexGob{Version: indexGobVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(&buf, nil); err == nil {
+		t.Error("LoadIndex: expected error for unsupported version")
+	}
+	if _, err := LoadIndex(bytes.NewReader([]byte("invalid")), nil); err == nil {
+		t.Error("LoadIndex: expected error for invalid input")
+	}
+}
@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	pathpkg "path"
+	"sort"
+)
+
+// A filePositions, indexes the declarations of the Idents of a Go file by
+// position, see Index.IdentAt.
+type filePositions struct {
+	file  *token.File // line offsets of the file
+	decls []declPos   // sorted by start, then Ident offset
+}
+
+// A declPos, is the extent of the declaration of an Ident.  Idents declared
+// by the same spec, such as "var a, b int", share its extent.
+type declPos struct {
+	id         Ident
+	start, end int // byte offsets, end is exclusive
+}
+
+// IdentAt returns the Ident declared at the position in file, where line and
+// col are 1-based and col is a byte offset into the line, like
+// token.Position.  The position may be anywhere in the declaration of the
+// Ident, such as the body of a function or the type of a var, and if several
+// names are declared by the same spec, the name at the position, or else the
+// first name, is returned.  False is returned if the position is between
+// declarations or the file is not indexed.
+//
+// Only the declarations of the Idents indexed for the package are found, the
+// Index does not record fields, interface methods or the declarations in
+// ignored files.  The file is parsed on the first lookup, the results are
+// cached until its package changes.
+func (x *Index) IdentAt(file string, line, col int) (Ident, bool) {
+	fp := x.filePositions(file)
+	if fp == nil || line < 1 || line > fp.file.LineCount() || col < 1 {
+		return Ident{}, false
+	}
+	off := fp.file.Offset(fp.file.LineStart(line)) + col - 1
+	if line < fp.file.LineCount() && off >= fp.file.Offset(fp.file.LineStart(line+1)) {
+		return Ident{}, false
+	}
+	decls := fp.decls
+	i := sort.Search(len(decls), func(i int) bool {
+		return decls[i].start > off
+	}) - 1
+	if i < 0 || off >= decls[i].end {
+		return Ident{}, false
+	}
+	// Prefer the name at the position over the other names of the spec.
+	first := i
+	for first > 0 && decls[first-1].start == decls[i].start {
+		first--
+	}
+	for _, d := range decls[first : i+1] {
+		if n := d.id.Info.Offset(); n <= off && off < n+len(d.id.name()) {
+			x.touch(d.id.Path)
+			return d.id, true
+		}
+	}
+	x.touch(decls[first].id.Path)
+	return decls[first].id, true
+}
+
+// filePositions, returns the position index of file, building it if it is
+// not cached, or nil if the file does not declare any indexed Idents.
+func (x *Index) filePositions(file string) *filePositions {
+	if x.c == nil || x.c.packages == nil {
+		return nil
+	}
+	// The package of the file may have been removed from the package index
+	// without removing its idents.
+	p, ok := x.c.packages.lookupPath(pathpkg.Dir(file))
+	if !ok {
+		return nil
+	}
+	x.mu.RLock()
+	fp := x.positions[file]
+	gen := x.posGen
+	var ids []Ident
+	if fp == nil {
+		for _, id := range x.exports[p.ImportPath] {
+			if id.File == file {
+				ids = append(ids, id)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	if fp != nil {
+		return fp
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	fp = newFilePositions(file, ids)
+	if fp == nil {
+		return nil
+	}
+	// Do not cache positions if the package changed while they were built.
+	x.mu.Lock()
+	if x.posGen == gen {
+		if x.positions == nil {
+			x.positions = make(map[string]*filePositions)
+		}
+		x.positions[file] = fp
+	}
+	x.mu.Unlock()
+	return fp
+}
+
+// forgetPositions, removes the cached position indexes of the files of the
+// package with import path path.  Lock the mutex for writing before calling.
+func (x *Index) forgetPositions(path string) {
+	x.posGen++
+	if len(x.positions) == 0 {
+		return
+	}
+	for _, id := range x.exports[path] {
+		delete(x.positions, id.File)
+	}
+}
+
+// newFilePositions, parses file and returns the extents of the declarations
+// of ids, which were declared in it.  Idents that are not found, because the
+// file changed since it was indexed, are omitted.  Nil is returned if the
+// file cannot be parsed.
+func newFilePositions(file string, ids []Ident) *filePositions {
+	fset := token.NewFileSet()
+	af, err := parseFile(fset, file, parser.SkipObjectResolution)
+	if af == nil || err != nil {
+		return nil
+	}
+	type extent struct{ start, end int }
+	extents := make(map[int]extent) // name offset => declaration extent
+	add := func(name *ast.Ident, node ast.Node) {
+		if name != nil {
+			extents[fset.Position(name.Pos()).Offset] = extent{
+				start: fset.Position(node.Pos()).Offset,
+				end:   fset.Position(node.End()).Offset,
+			}
+		}
+	}
+	for _, d := range af.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			add(d.Name, d)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				// Ungrouped specs extend to the keyword.
+				var node ast.Node = spec
+				if !d.Lparen.IsValid() {
+					node = d
+				}
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add(s.Name, node)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						add(n, node)
+					}
+				}
+			}
+		}
+	}
+	fp := &filePositions{
+		file:  fset.File(af.Pos()),
+		decls: make([]declPos, 0, len(ids)),
+	}
+	for _, id := range ids {
+		if e, ok := extents[id.Info.Offset()]; ok {
+			fp.decls = append(fp.decls, declPos{id: id, start: e.start, end: e.end})
+		}
+	}
+	sort.Slice(fp.decls, func(i, j int) bool {
+		if fp.decls[i].start != fp.decls[j].start {
+			return fp.decls[i].start < fp.decls[j].start
+		}
+		return fp.decls[i].id.Info.Offset() < fp.decls[j].id.Info.Offset()
+	})
+	return fp
+}
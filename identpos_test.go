@@ -0,0 +1,165 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const identPosSrc = `package pos
+
+// F is a func.
+func F(a int) int {
+	return a
+}
+
+var A, B = 1, 2
+
+const (
+	C = iota
+	D
+)
+
+type T struct {
+	X int
+}
+
+func (t *T) M() {}
+`
+
+// srcPos, returns the 1-based line and column of the n'th occurrence, from
+// 0, of substr in src plus delta bytes.
+func srcPos(t *testing.T, src, substr string, n, delta int) (line, col int) {
+	t.Helper()
+	off := -1
+	for i := 0; i <= n; i++ {
+		j := strings.Index(src[off+1:], substr)
+		if j == -1 {
+			t.Fatalf("srcPos: %q: occurrence %d not found", substr, n)
+		}
+		off += j + 1
+	}
+	off += delta
+	line = strings.Count(src[:off], "\n") + 1
+	col = off - strings.LastIndex(src[:off], "\n")
+	return line, col
+}
+
+func TestIdentAt(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "pos/pos.go", identPosSrc)
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	file := f.path("pos/pos.go")
+
+	tests := []struct {
+		substr string
+		n      int // occurrence of substr
+		delta  int
+		exp    string // expected ident name, empty for none
+	}{
+		{"func F", 0, 0, "F"},           // keyword
+		{"func F", 0, 5, "F"},           // name
+		{"return a", 0, 0, "F"},         // body
+		{"}", 0, 0, "F"},                // closing brace
+		{"// F is", 0, 0, ""},           // doc comment
+		{"package", 0, 0, ""},           // package clause
+		{"var A", 0, 0, "A"},            // shared spec, first name
+		{"B =", 0, 0, "B"},              // shared spec, name
+		{"1, 2", 0, 0, "A"},             // shared spec, value
+		{"const (", 0, 0, ""},           // grouped decl, between specs
+		{"C = iota", 0, 4, "C"},         // grouped spec, value
+		{"\tD", 0, 1, "D"},              // grouped spec, name
+		{"X int", 0, 0, "T"},            // struct field
+		{"func (t *T) M", 0, 0, "T.M"},  // method receiver
+		{"func (t *T) M", 0, 12, "T.M"}, // method name
+		{"\n\nvar", 0, 1, ""},           // blank line
+	}
+	for _, x := range tests {
+		line, col := srcPos(t, identPosSrc, x.substr, x.n, x.delta)
+		id, ok := f.idents.IdentAt(file, line, col)
+		if x.exp == "" {
+			if ok {
+				t.Errorf("IdentAt (%q %d:%d): unexpected ident: %s", x.substr, line, col, id.Name)
+			}
+			continue
+		}
+		if !ok || id.Name != x.exp {
+			t.Errorf("IdentAt (%q %d:%d): exp: %s got: %s (%t)", x.substr, line, col, x.exp, id.Name, ok)
+		}
+		if ok && id.File != file {
+			t.Errorf("IdentAt (%q): file: exp: %s got: %s", x.substr, file, id.File)
+		}
+	}
+
+	// Invalid positions.
+	for _, pos := range [][2]int{{0, 1}, {1, 0}, {1, 100}, {1000, 1}} {
+		if id, ok := f.idents.IdentAt(file, pos[0], pos[1]); ok {
+			t.Errorf("IdentAt (%d:%d): unexpected ident: %s", pos[0], pos[1], id.Name)
+		}
+	}
+	if _, ok := f.idents.IdentAt(f.path("pos/missing.go"), 1, 1); ok {
+		t.Error("IdentAt: found ident in missing file")
+	}
+
+	// The cached positions are replaced when the package changes.
+	src := strings.Replace(identPosSrc, "func F(a int) int {", "// G is a func.\nfunc G(a int) int {", 1)
+	f.write(t, "pos/pos.go", src)
+	f.write(t, "pos/other.go", "package pos\n")
+	f.Update()
+	line, col := srcPos(t, src, "return a", 0, 0)
+	if id, ok := f.idents.IdentAt(file, line, col); !ok || id.Name != "G" {
+		t.Errorf("IdentAt: update: exp: %s got: %s (%t)", "G", id.Name, ok)
+	}
+
+	// Removed packages are not found.
+	f.remove(t, "pos")
+	f.Update()
+	if id, ok := f.idents.IdentAt(file, line, col); ok {
+		t.Errorf("IdentAt: removed: unexpected ident: %s", id.Name)
+	}
+}
+
+func TestCorpusIdentAt(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.MaxDepth = 1
+	f.write(t, "deep/pkg/deep.go", "package pkg\n\nfunc Deep() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	file := f.path("deep/pkg/deep.go")
+	if _, ok := f.idents.IdentAt(file, 3, 6); ok {
+		t.Fatal("Index.IdentAt: package below MaxDepth should not be indexed")
+	}
+	if id, ok := f.IdentAt(file, 3, 6); !ok || id.Name != "Deep" {
+		t.Errorf("Corpus.IdentAt: exp: %s got: %s (%t)", "Deep", id.Name, ok)
+	}
+}
+
+func TestCorpusIdentAtSymlink(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.FollowSymlinks = true
+	f.write(t, "sym/sym.go", "package sym\n\nfunc Sym() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(f.dir, "link")
+	if err := os.Symlink(f.root, link); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+	root, err := filepath.EvalSymlinks(f.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "sym", "sym.go")
+	id, ok := f.IdentAt(filepath.Join(link, "sym", "sym.go"), 3, 6)
+	if !ok || id.Name != "Sym" || id.File != file {
+		t.Errorf("Corpus.IdentAt: link: exp: (%s, %s) got: (%s, %s) (%t)",
+			"Sym", file, id.Name, id.File, ok)
+	}
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"fmt"
+	pathpkg "path"
+	"strings"
+)
+
+// An ignoreMatcher matches directory paths, relative to a source root,
+// against a list of gitignore-style patterns, see Corpus.IgnorePatterns.
+type ignoreMatcher struct {
+	patterns []string   // source patterns, used to detect changes
+	compiled [][]string // pattern segments
+}
+
+// compileIgnorePatterns, compiles the gitignore-style patterns pats.  Empty
+// patterns and comments, which start with '#', are skipped.  An error is
+// returned if any pattern is malformed or negated, which is not supported.
+func compileIgnorePatterns(pats []string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{patterns: append([]string(nil), pats...)}
+	for _, pat := range pats {
+		s := strings.TrimSpace(pat)
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		if s[0] == '!' {
+			return nil, fmt.Errorf("pkg: negated ignore patterns are not supported: %q", pat)
+		}
+		// Only directories are matched, so a trailing slash is
+		// meaningless.
+		s = strings.TrimRight(s, "/")
+		if s == "" {
+			return nil, fmt.Errorf("pkg: invalid ignore pattern: %q", pat)
+		}
+		// Patterns with a leading or middle slash are relative to
+		// the source root, others match at any depth.
+		anchored := strings.Contains(s, "/")
+		s = strings.TrimLeft(s, "/")
+		segs := strings.Split(s, "/")
+		if !anchored && segs[0] != "**" {
+			segs = append([]string{"**"}, segs...)
+		}
+		for _, seg := range segs {
+			if _, err := pathpkg.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("pkg: invalid ignore pattern: %q: %s", pat, err)
+			}
+		}
+		m.compiled = append(m.compiled, segs)
+	}
+	return m, nil
+}
+
+// equal, reports if m was compiled from patterns pats.
+func (m *ignoreMatcher) equal(pats []string) bool {
+	if len(m.patterns) != len(pats) {
+		return false
+	}
+	for i, s := range pats {
+		if m.patterns[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+// match, reports if the slash-separated directory path rel, which is relative
+// to a source root, is matched by any of the patterns.
+func (m *ignoreMatcher) match(rel string) bool {
+	if m == nil || rel == "" || len(m.compiled) == 0 {
+		return false
+	}
+	name := strings.Split(rel, "/")
+	for _, segs := range m.compiled {
+		if matchSegments(segs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments, reports if the path segments name are matched by the
+// pattern segments pat.  A "**" segment matches zero or more segments, except
+// when trailing, then it matches one or more, so that "dir/**" matches the
+// contents of dir, but not dir itself.
+func matchSegments(pat, name []string) bool {
+	for len(pat) != 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				return len(name) != 0
+			}
+			for i := 0; i < len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := pathpkg.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignoreMatcher, returns the compiled IgnorePatterns of the Corpus, or nil
+// if there are none.  Patterns are recompiled when IgnorePatterns changes, if
+// they are invalid nothing is matched.
+func (c *Corpus) ignoreMatcher() *ignoreMatcher {
+	if len(c.IgnorePatterns) == 0 {
+		return nil
+	}
+	c.mu.RLock()
+	m := c.ignore
+	c.mu.RUnlock()
+	if m != nil && m.equal(c.IgnorePatterns) {
+		return m
+	}
+	m, err := compileIgnorePatterns(c.IgnorePatterns)
+	if err != nil {
+		// Init reports invalid patterns, log changes made after.
+		c.log.Printf("Corpus: %s", err)
+		m = &ignoreMatcher{patterns: append([]string(nil), c.IgnorePatterns...)}
+	}
+	c.mu.Lock()
+	c.ignore = m
+	c.mu.Unlock()
+	return m
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import "testing"
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{
+			pattern: "generated",
+			match:   []string{"generated", "a/generated", "a/b/generated"},
+			noMatch: []string{"generated2", "a/generated/b", "a"},
+		},
+		{
+			pattern: "vendor/",
+			match:   []string{"vendor", "a/vendor"},
+			noMatch: []string{"vendor/a", "vendors"},
+		},
+		{
+			pattern: "/vendor",
+			match:   []string{"vendor"},
+			noMatch: []string{"a/vendor", "vendor/a"},
+		},
+		{
+			pattern: "a/b",
+			match:   []string{"a/b"},
+			noMatch: []string{"x/a/b", "a/b/c", "a"},
+		},
+		{
+			pattern: "**/gen/**",
+			match:   []string{"gen/x", "a/gen/x", "a/b/gen/x/y"},
+			noMatch: []string{"gen", "a/gen", "x"},
+		},
+		{
+			pattern: "a/**/b",
+			match:   []string{"a/b", "a/x/b", "a/x/y/b"},
+			noMatch: []string{"a", "b", "x/a/b", "a/b/c"},
+		},
+		{
+			pattern: "*_gen",
+			match:   []string{"x_gen", "a/y_gen"},
+			noMatch: []string{"x_gen/a", "gen"},
+		},
+		{
+			pattern: "/foo/*",
+			match:   []string{"foo/a", "foo/b"},
+			noMatch: []string{"foo", "foo/a/b", "x/foo/a"},
+		},
+	}
+	for _, x := range tests {
+		m, err := compileIgnorePatterns([]string{x.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range x.match {
+			if !m.match(s) {
+				t.Errorf("%q: expected match: %q", x.pattern, s)
+			}
+		}
+		for _, s := range x.noMatch {
+			if m.match(s) {
+				t.Errorf("%q: unexpected match: %q", x.pattern, s)
+			}
+		}
+	}
+
+	m, err := compileIgnorePatterns([]string{"", "# comment", "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.match("#comment") || m.match("a") {
+		t.Error("empty patterns and comments should not match")
+	}
+	for _, pat := range []string{"!a", "/", "a/[b"} {
+		if _, err := compileIgnorePatterns([]string{pat}); err == nil {
+			t.Errorf("%q: expected error", pat)
+		}
+	}
+}
+
+func TestIgnorePatternsIndex(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "gen/x/x.go", "package x\n")
+	f.write(t, "alpha/generated/gen.go", "package generated\n")
+	f.IgnorePatterns = []string{"**/generated/"}
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed := func(rel string) bool {
+		_, ok := f.packages.lookupPath(f.path(rel))
+		return ok || f.lookupDir(f.path(rel)) != nil
+	}
+	if indexed("alpha/generated") {
+		t.Error("IgnorePatterns: ignored directory indexed: alpha/generated")
+	}
+	for _, rel := range []string{"alpha", "gen/x", "beta/vendor/vendored"} {
+		if !indexed(rel) {
+			t.Errorf("IgnorePatterns: missing package: %s", rel)
+		}
+	}
+
+	// Previously indexed directories that are now ignored are removed.
+	f.IgnorePatterns = []string{"/gen", "vendor/"}
+	f.updateIndex()
+	for _, rel := range []string{"gen", "gen/x", "beta/vendor", "beta/vendor/vendored"} {
+		if indexed(rel) {
+			t.Errorf("IgnorePatterns: ignored directory not removed: %s", rel)
+		}
+	}
+	for _, rel := range []string{"alpha", "alpha/generated", "beta"} {
+		if !indexed(rel) {
+			t.Errorf("IgnorePatterns: missing package: %s", rel)
+		}
+	}
+
+	f.IgnorePatterns = []string{"!negated"}
+	if err := f.Init(); err == nil {
+		f.Stop()
+		t.Error("Init: expected error for invalid IgnorePatterns")
+	}
+}
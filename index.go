@@ -1,11 +1,14 @@
 package pkg
 
 import (
-	"fmt"
 	"go/ast"
+	"go/parser"
 	"go/token"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unsafe"
 
 	"github.com/charlievieth/pkg/fs"
 	"github.com/charlievieth/pkg/util"
@@ -16,11 +19,12 @@ import (
 //  - Add struct and method fields
 
 type Ident struct {
-	Name    string  // Type, func or type.method name
-	Package string  // Package name "http"
-	Path    string  // Package path "net/http"
-	File    string  // File where declared "$GOROOT/src/net/http/server.go"
-	Info    TypInfo // Type and position info
+	Name       string  // Type, func or type.method name
+	Package    string  // Package name "http"
+	Path       string  // Package path "net/http"
+	File       string  // File where declared "$GOROOT/src/net/http/server.go"
+	Info       TypInfo // Type and position info
+	Constraint string  // Build constraint of ignored files "windows && amd64"
 }
 
 // name, returns the name of the ident.  If the ident is a method the typename
@@ -38,14 +42,82 @@ func (i *Ident) name() string {
 // IsExported reports whether the Ident is an exported Go symbol.
 func (i *Ident) IsExported() bool { return ast.IsExported(i.Name) }
 
+// Position, returns the source position of the Ident's declaration.  The
+// column is not recorded and is always zero.
+func (i *Ident) Position() token.Position {
+	return token.Position{
+		Filename: i.File,
+		Offset:   i.Info.Offset(),
+		Line:     i.Info.Line(),
+	}
+}
+
+// byPathName, sorts Idents by Path, Name then File and Offset.
+type byPathName []Ident
+
+func (b byPathName) Len() int      { return len(b) }
+func (b byPathName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPathName) Less(i, j int) bool {
+	switch {
+	case b[i].Path != b[j].Path:
+		return b[i].Path < b[j].Path
+	case b[i].Name != b[j].Name:
+		return b[i].Name < b[j].Name
+	case b[i].File != b[j].File:
+		return b[i].File < b[j].File
+	}
+	return b[i].Info.Offset() < b[j].Info.Offset()
+}
+
+// byMethodName, sorts method Idents by method name, then by Path and Name.
+type byMethodName []Ident
+
+func (b byMethodName) Len() int      { return len(b) }
+func (b byMethodName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byMethodName) Less(i, j int) bool {
+	if ni, nj := b[i].name(), b[j].name(); ni != nj {
+		return ni < nj
+	}
+	if b[i].Path != b[j].Path {
+		return b[i].Path < b[j].Path
+	}
+	return b[i].Name < b[j].Name
+}
+
+// byNameLength, sorts Idents by the length of their name, without the type
+// name of methods, then by name, Path, Name, File and Offset.
+type byNameLength []Ident
+
+func (b byNameLength) Len() int      { return len(b) }
+func (b byNameLength) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byNameLength) Less(i, j int) bool {
+	ni, nj := b[i].name(), b[j].name()
+	switch {
+	case len(ni) != len(nj):
+		return len(ni) < len(nj)
+	case ni != nj:
+		return ni < nj
+	}
+	return byPathName(b).Less(i, j)
+}
+
+// An IndexEvent describes a change to the ident index, see Event.
 type IndexEvent struct {
-	typ EventType
-	msg string
+	Time     time.Time     // Time the event occurred
+	Duration time.Duration // Duration of the create or update, if measured
+	Path     string        // Import path of the package, if any
+	Detail   string        // Optional detail, such as the error message
+	typ      EventType
 }
 
 func (e IndexEvent) Event() EventType         { return e.typ }
 func (e IndexEvent) Callback(c *Corpus) error { return nil }
-func (e IndexEvent) String() string           { return e.msg }
+func (e IndexEvent) String() string           { return e.Render(false) }
+
+// Render, returns the human readable message of the event, see Event.Render.
+func (e IndexEvent) Render(color bool) string {
+	return renderEvent(e.typ, "Index", e.Path, e.Detail, e.Duration, color)
+}
 
 type Index struct {
 	c           *Corpus
@@ -54,9 +126,25 @@ type Index struct {
 	packagePath map[string]map[string]bool     // "http" => "net/http" => true
 	exports     map[string]map[string]Ident    // "net/http" => "Client.Do" => ident
 	idents      map[TypKind]map[string][]Ident // Method => "Do" => []ident
+	names       *nameTrie                      // names of idents, for prefix search (lazy)
+	ignored     map[string][]Ident             // "net/http" => []ident (lazy)
+	sizes       map[string]int64               // "net/http" => estimated size in bytes
+	size        int64                          // estimated size of all packages
+	positions   map[string]*filePositions      // file => declarations (lazy)
+	posGen      uint64                         // incremented when positions are forgotten
+	loaded      bool                           // loaded by LoadIndex and not yet reconciled
 	mu          sync.RWMutex
+
+	access map[string]uint64 // "net/http" => last access, only if MaxIndexBytes is set
+	clock  uint64            // access clock
+	amu    sync.Mutex        // protects access and clock
 }
 
+// identSize is the estimated size in bytes of an indexed Ident, which is
+// stored in both the exports and idents maps.  The strings of Idents are
+// interned, or shared by the Idents of a file, and are not included.
+const identSize = 2*int64(unsafe.Sizeof(Ident{})) + 16
+
 // WARN WARN
 func (x *Index) ExportedPackages() []string {
 	x.mu.Lock()
@@ -68,6 +156,35 @@ func (x *Index) ExportedPackages() []string {
 	return list
 }
 
+// FileSet returns the token.FileSet of the Index.  The FileSet is appended to
+// while packages are indexed, its methods are safe for concurrent use, but the
+// set of files it contains may change between calls.
+//
+// Only files parsed by the Index are added to the FileSet, files parsed while
+// indexing packages are not.  Idents record the file, line and offset of
+// their declaration, use Ident.Position when the file is not present.
+func (x *Index) FileSet() *token.FileSet {
+	return x.fset
+}
+
+// Pos returns the token.Pos of the declaration of id in the Index's FileSet,
+// and reports if the file was found.  If the file was parsed more than once,
+// the most recent version is used.
+func (x *Index) Pos(id Ident) (token.Pos, bool) {
+	var file *token.File
+	x.fset.Iterate(func(f *token.File) bool {
+		if f.Name() == id.File {
+			file = f
+		}
+		return true
+	})
+	off := id.Info.Offset()
+	if file == nil || off > file.Size() {
+		return token.NoPos, false
+	}
+	return file.Pos(off), true
+}
+
 func newIndex(c *Corpus) *Index {
 	return &Index{
 		c:           c,
@@ -78,13 +195,17 @@ func newIndex(c *Corpus) *Index {
 	}
 }
 
-func (x *Index) notify(typ EventType, path string) {
-	if x.c == nil || !x.c.LogEvents {
+// notify, sends an event for the package at path, d is the duration of the
+// create or update, if measured.
+func (x *Index) notify(typ EventType, path string, d time.Duration) {
+	if x.c == nil || !x.c.notifying() {
 		return
 	}
 	e := IndexEvent{
-		typ: typ,
-		msg: fmt.Sprintf("Index: %s %q", typ.color(), path),
+		Time:     time.Now(),
+		Duration: d,
+		Path:     path,
+		typ:      typ,
 	}
 	x.c.notify(e)
 }
@@ -94,8 +215,10 @@ func (x *Index) errorEvent(err error, path string) {
 		return
 	}
 	e := IndexEvent{
-		typ: DeleteEvent,
-		msg: fmt.Sprintf(`Index: error updating package "%s": %s`, path, err),
+		Time:   time.Now(),
+		Path:   path,
+		Detail: err.Error(),
+		typ:    ErrorEvent,
 	}
 	x.c.notify(e)
 }
@@ -111,9 +234,52 @@ func (x *Index) lookupExports(importPath string) map[string]Ident {
 	x.mu.RLock()
 	exp := x.exports[importPath]
 	x.mu.RUnlock()
+	if exp != nil {
+		x.touch(importPath)
+	}
 	return exp
 }
 
+// Exports returns the sorted exported names declared by the package with
+// import path importPath.  Methods are named "<Type>.<Method>" and are only
+// included if both the type and method are exported.
+func (x *Index) Exports(importPath string) []string {
+	x.mu.RLock()
+	exp := x.exports[importPath]
+	names := make([]string, 0, len(exp))
+	for name := range exp {
+		if exportedName(name) {
+			names = append(names, name)
+		}
+	}
+	x.mu.RUnlock()
+	if exp != nil {
+		x.touch(importPath)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportedName, reports if name, which may be of the form "<Type>.<Method>",
+// is exported.
+func exportedName(name string) bool {
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		return ast.IsExported(name[:i]) && ast.IsExported(name[i+1:])
+	}
+	return ast.IsExported(name)
+}
+
+// namesOnly, reports if only the exported names of packages are indexed.
+func (x *Index) namesOnly() bool {
+	return x.c != nil && x.c.IndexNamesOnly
+}
+
+// publicOnly, reports if only the exported idents of public packages are
+// indexed, see Corpus.PublicOnly.
+func (x *Index) publicOnly() bool {
+	return x.c != nil && x.c.PublicOnly
+}
+
 func (x *Index) hasPackage(importPath string) bool {
 	x.mu.RLock()
 	_, ok := x.exports[importPath]
@@ -121,22 +287,377 @@ func (x *Index) hasPackage(importPath string) bool {
 	return ok
 }
 
-// WARN
+// Idents returns a snapshot of all the Idents in the Index, sorted by Path,
+// Name, File then Offset.  The returned slice is a copy and is safe to use
+// while the Index is updated.
 func (x *Index) Idents() []Ident {
-	if x.idents == nil {
+	x.mu.RLock()
+	n := x.countIdents()
+	if n == 0 {
+		x.mu.RUnlock()
 		return nil
 	}
-	x.mu.RLock()
-	ids := make([]Ident, 0, 512)
+	ids := make([]Ident, 0, n)
 	for _, m := range x.idents {
 		for _, id := range m {
 			ids = append(ids, id...)
 		}
 	}
 	x.mu.RUnlock()
+	sort.Sort(byPathName(ids))
+	return ids
+}
+
+// countIdents, returns the number of indexed Idents.  Lock the mutex for
+// reading before calling.
+func (x *Index) countIdents() int {
+	n := 0
+	for _, m := range x.idents {
+		for _, ids := range m {
+			n += len(ids)
+		}
+	}
+	return n
+}
+
+// SearchPrefix returns the Idents whose name starts with prefix, sorted by
+// the length of their name then by name, so that the shortest, closest,
+// matches come first.  Methods are matched by their method name.  If limit is
+// greater than zero at most limit Idents are returned.  An empty prefix
+// matches every Ident.
+func (x *Index) SearchPrefix(prefix string, limit int) []Ident {
+	return x.searchPrefix(prefix, limit, false)
+}
+
+// SearchPrefixExported is like SearchPrefix, but only returns exported
+// Idents, methods are only returned if both the type and method are
+// exported.
+func (x *Index) SearchPrefixExported(prefix string, limit int) []Ident {
+	return x.searchPrefix(prefix, limit, true)
+}
+
+// searchPrefix, implements SearchPrefix and SearchPrefixExported.  The trie
+// of ident names is walked by prefix, but since the results are ordered by
+// length every match is collected before the limit is applied.
+func (x *Index) searchPrefix(prefix string, limit int, exported bool) []Ident {
+	x.initNames()
+	var ids []Ident
+	x.mu.RLock()
+	if x.names != nil {
+		x.names.walk(prefix, func(name string) bool {
+			for _, m := range x.idents {
+				for _, id := range m[name] {
+					if !exported || exportedName(id.Name) {
+						ids = append(ids, id)
+					}
+				}
+			}
+			return true
+		})
+	}
+	x.mu.RUnlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Sort(byNameLength(ids))
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	x.touchIdents(ids)
+	return ids
+}
+
+// numIdents, returns the number of indexed Idents.
+func (x *Index) numIdents() int {
+	x.mu.RLock()
+	n := x.countIdents()
+	x.mu.RUnlock()
+	return n
+}
+
+// initNames, builds the trie of ident names, if it is not already built.
+// The trie is only needed by prefix searches, so to not pay for its memory
+// and upkeep otherwise, it is built by the first search and then kept up to
+// date as idents are added and removed.
+func (x *Index) initNames() {
+	x.mu.RLock()
+	ok := x.names != nil
+	x.mu.RUnlock()
+	if ok {
+		return
+	}
+	x.mu.Lock()
+	if x.names == nil {
+		t := new(nameTrie)
+		for _, m := range x.idents {
+			for name := range m {
+				t.add(name)
+			}
+		}
+		x.names = t
+	}
+	x.mu.Unlock()
+}
+
+// addIdents, appends ids, which are of kind tk, to the idents named name.
+// Lock the mutex for writing before calling.
+func (x *Index) addIdents(tk TypKind, name string, ids ...Ident) {
+	if len(ids) == 0 {
+		return
+	}
+	m := x.idents[tk]
+	if m == nil {
+		m = make(map[string][]Ident)
+		x.idents[tk] = m
+	}
+	if _, ok := m[name]; !ok && x.names != nil {
+		x.names.add(name)
+	}
+	m[name] = append(m[name], ids...)
+}
+
+// setIdents, replaces the idents of kind tk named name with ids.  If ids is
+// empty the name is removed.  Lock the mutex for writing before calling.
+func (x *Index) setIdents(tk TypKind, name string, ids []Ident) {
+	if len(ids) != 0 {
+		if _, ok := x.idents[tk][name]; ok {
+			x.idents[tk][name] = ids
+		} else {
+			x.addIdents(tk, name, ids...)
+		}
+		return
+	}
+	if _, ok := x.idents[tk][name]; !ok {
+		return
+	}
+	delete(x.idents[tk], name)
+	if len(x.idents[tk]) == 0 {
+		delete(x.idents, tk)
+	}
+	if x.names != nil {
+		x.names.remove(name)
+	}
+}
+
+// lookupName, returns the Idents with name name, of the given kinds, sorted
+// by Path then Name.  The name of methods is "<methodname>".
+func (x *Index) lookupName(name string, kinds KindSet) []Ident {
+	var ids []Ident
+	x.mu.RLock()
+	for tk, m := range x.idents {
+		if kinds.Has(tk) {
+			ids = append(ids, m[name]...)
+		}
+	}
+	x.mu.RUnlock()
+	x.touchIdents(ids)
+	sort.Sort(byPathName(ids))
+	return ids
+}
+
+// lookupFold, returns the Idents whose name matches name under Unicode case
+// folding, sorted by Path then Name.  If name is a selector expression, such
+// as "client.do", only methods whose "<type>.<method>" name matches it are
+// returned.
+//
+// Names are folded at query time by scanning the names of every kind,
+// instead of maintaining a second map keyed by folded names.  A folded index
+// would make queries proportional to the number of matches, but it would
+// double the memory used by names and the cost of every update, which are
+// far more frequent than fold queries.  The scan is proportional to the
+// number of names, not Idents.
+func (x *Index) lookupFold(name string) []Ident {
+	typeName, method := "", name
+	if i := strings.LastIndexByte(name, '.'); i > 0 && i < len(name)-1 {
+		typeName, method = name[:i], name[i+1:]
+	}
+	var ids []Ident
+	x.mu.RLock()
+	for _, m := range x.idents {
+		for s, list := range m {
+			if !strings.EqualFold(s, method) {
+				continue
+			}
+			for _, id := range list {
+				if typeName == "" || strings.EqualFold(id.Name, name) {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	x.mu.RUnlock()
+	x.touchIdents(ids)
+	sort.Sort(byPathName(ids))
 	return ids
 }
 
+// lookupSelector, returns the Idents of the given kinds matching selector
+// expression "<package>.<name>" or "<type>.<method>", sorted by Path then
+// Name.  The package may be a package name or import path.
+func (x *Index) lookupSelector(pkg, sel string, kinds KindSet) []Ident {
+	var ids []Ident
+	x.mu.RLock()
+	for path := range x.packagePath[pkg] {
+		if id, ok := x.exports[path][sel]; ok && kinds.Has(id.Info.Kind()) {
+			ids = append(ids, id)
+		}
+	}
+	// Match import paths, unless already matched by package name.
+	if !x.packagePath[pkg][pkg] {
+		if id, ok := x.exports[pkg][sel]; ok && kinds.Has(id.Info.Kind()) {
+			ids = append(ids, id)
+		}
+	}
+	name := pkg + "." + sel
+	for _, tk := range [...]TypKind{MethodDecl, InterfaceDecl} {
+		if !kinds.Has(tk) {
+			continue
+		}
+		for _, id := range x.idents[tk][sel] {
+			if id.Name == name {
+				ids = append(ids, id)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	x.touchIdents(ids)
+	sort.Sort(byPathName(ids))
+	return ids
+}
+
+// methods, returns the methods declared with receiver type typeName by the
+// package with import path importPath.
+func (x *Index) methods(importPath, typeName string) []Ident {
+	prefix := typeName + "."
+	var ids []Ident
+	x.mu.RLock()
+	exp, ok := x.exports[importPath]
+	for name, id := range exp {
+		if id.Info.Kind() == MethodDecl && strings.HasPrefix(name, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	x.mu.RUnlock()
+	if ok {
+		x.touch(importPath)
+	}
+	return ids
+}
+
+// packagesDeclaring, returns the sorted import paths of the packages that
+// declare name.
+func (x *Index) packagesDeclaring(name string) []string {
+	var s []string
+	x.mu.RLock()
+	for path, exp := range x.exports {
+		if _, ok := exp[name]; ok {
+			s = append(s, path)
+		}
+	}
+	x.mu.RUnlock()
+	sort.Strings(s)
+	return s
+}
+
+// maxBytes, returns the maximum estimated size of the Index, or zero if the
+// size is not limited.  The size of the Index of a fork is never limited.
+func (x *Index) maxBytes() int64 {
+	if x.c == nil || x.c.forked {
+		return 0
+	}
+	return x.c.MaxIndexBytes
+}
+
+// touch, records an access of the packages with import paths paths.  Only
+// recorded if the size of the Index is limited.
+func (x *Index) touch(paths ...string) {
+	if x.maxBytes() <= 0 {
+		return
+	}
+	x.amu.Lock()
+	if x.access == nil {
+		x.access = make(map[string]uint64)
+	}
+	for _, path := range paths {
+		x.clock++
+		x.access[path] = x.clock
+	}
+	x.amu.Unlock()
+}
+
+// touchIdents, records an access of the packages that declare ids.
+func (x *Index) touchIdents(ids []Ident) {
+	if x.maxBytes() <= 0 || len(ids) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if len(paths) == 0 || paths[len(paths)-1] != id.Path {
+			paths = append(paths, id.Path)
+		}
+	}
+	x.touch(paths...)
+}
+
+// setSize, sets the estimated size of the package with import path path,
+// which has n Idents.  Lock the mutex for writing before calling.
+func (x *Index) setSize(path string, n int) {
+	if x.sizes == nil {
+		x.sizes = make(map[string]int64)
+	}
+	size := int64(n) * identSize
+	x.size += size - x.sizes[path]
+	x.sizes[path] = size
+}
+
+// evict, removes the idents of the least recently accessed packages, other
+// than the package with import path keep, until the estimated size of the
+// Index is less than or equal to MaxIndexBytes.  Evicted packages are
+// re-indexed when looked up with Corpus.Definition or LookupOrImport.
+func (x *Index) evict(keep string) {
+	max := x.maxBytes()
+	if max <= 0 {
+		return
+	}
+	type candidate struct {
+		path   string
+		size   int64
+		access uint64
+	}
+	x.mu.RLock()
+	size := x.size
+	if size <= max {
+		x.mu.RUnlock()
+		return
+	}
+	cands := make([]candidate, 0, len(x.sizes))
+	for path, n := range x.sizes {
+		if path != keep {
+			cands = append(cands, candidate{path: path, size: n})
+		}
+	}
+	x.mu.RUnlock()
+
+	x.amu.Lock()
+	for i := range cands {
+		cands[i].access = x.access[cands[i].path]
+	}
+	x.amu.Unlock()
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].access != cands[j].access {
+			return cands[i].access < cands[j].access
+		}
+		return cands[i].path < cands[j].path
+	})
+	for _, c := range cands {
+		if size <= max {
+			break
+		}
+		x.removeImportPath(c.path, "")
+		size -= c.size
+	}
+}
+
 // initMaps, inits the Index's maps.  Lock the mutex for writing before calling.
 func (x *Index) initMaps() {
 	if x.exports == nil {
@@ -150,18 +671,30 @@ func (x *Index) initMaps() {
 	}
 }
 
-// removePackageIdents, removes the idents of the Package with name name and
-// import path path.  The Package's exports must still be indexed.
-//
-// Lock the Index's mutex for writing before calling.
+// removePackage, removes the idents of Package p.  The Package's exports must
+// still be indexed.
 func (x *Index) removePackage(p *Package) {
-	if !x.hasPackage(p.ImportPath) {
+	x.removeImportPath(p.ImportPath, p.Name)
+}
+
+// removeImportPath, removes the idents of the package with import path path
+// and name name.  If name is empty it is found using the packagePath map.
+func (x *Index) removeImportPath(path, name string) {
+	if !x.hasPackage(path) {
 		return
 	}
 	x.mu.Lock()
 	// Send event after releasing mutex.
-	defer x.notify(DeleteEvent, p.ImportPath)
+	defer x.notify(DeleteEvent, path, 0)
 	defer x.mu.Unlock()
+	if name == "" {
+		for n, paths := range x.packagePath {
+			if paths[path] {
+				name = n
+				break
+			}
+		}
+	}
 
 	// Returns ids with any Ident found in m removed.
 	filter := func(m map[Ident]bool, ids []Ident) []Ident {
@@ -178,7 +711,7 @@ func (x *Index) removePackage(p *Package) {
 	// Use exports to map the idents we need to remove.
 	// TODO: Improve - see the merge method for reference.
 	idents := make(map[TypKind]map[string]map[Ident]bool)
-	for _, id := range x.exports[p.ImportPath] {
+	for _, id := range x.exports[path] {
 		tk := id.Info.Kind()
 		if idents[tk] == nil {
 			idents[tk] = make(map[string]map[Ident]bool)
@@ -191,22 +724,52 @@ func (x *Index) removePackage(p *Package) {
 	}
 
 	// Remove idents.
-	for kind, names := range idents {
+	for tk, names := range idents {
 		for name, ids := range names {
-			xids := filter(ids, x.idents[kind][name])
-			if len(xids) > 0 {
-				x.idents[kind][name] = xids
-			} else {
-				delete(x.idents[kind], name)
-				if len(x.idents[kind]) == 0 {
-					delete(x.idents, kind)
-				}
-			}
+			x.setIdents(tk, name, filter(ids, x.idents[tk][name]))
 		}
 	}
 
-	delete(x.packagePath[p.Name], p.ImportPath)
-	delete(x.exports, p.ImportPath)
+	x.forgetPositions(path)
+	delete(x.packagePath[name], path)
+	delete(x.exports, path)
+	delete(x.ignored, path)
+	x.size -= x.sizes[path]
+	delete(x.sizes, path)
+
+	x.amu.Lock()
+	delete(x.access, path)
+	x.amu.Unlock()
+}
+
+// reset, removes the idents of every package from the Index.
+func (x *Index) reset() {
+	x.mu.Lock()
+	paths := make([]string, 0, len(x.exports))
+	for path := range x.exports {
+		paths = append(paths, path)
+	}
+	x.packagePath = make(map[string]map[string]bool)
+	x.exports = make(map[string]map[string]Ident)
+	x.idents = make(map[TypKind]map[string][]Ident)
+	if x.names != nil {
+		x.names = new(nameTrie)
+	}
+	x.ignored = nil
+	x.sizes = nil
+	x.size = 0
+	x.positions = nil
+	x.posGen++
+	x.mu.Unlock()
+
+	x.amu.Lock()
+	x.access = nil
+	x.amu.Unlock()
+
+	// Send events after releasing the mutex.
+	for _, path := range paths {
+		x.notify(DeleteEvent, path, 0)
+	}
 }
 
 // mergeIdents, removes the Idents from oldExp not present in newExp, and adds
@@ -245,23 +808,10 @@ func (x *Index) mergeIdents(oldExp, newExp map[string]Ident) {
 		}
 		tk := id.Info.Kind()
 		name := id.name()
-		xids := filter(id, x.idents[tk][name])
-		if len(xids) > 0 {
-			x.idents[tk][name] = xids
-		} else {
-			delete(x.idents[tk], name)
-			if len(x.idents[tk]) == 0 {
-				delete(x.idents, tk)
-			}
-		}
+		x.setIdents(tk, name, filter(id, x.idents[tk][name]))
 	}
 	for id := range add {
-		tk := id.Info.Kind()
-		if x.idents[tk] == nil {
-			x.idents[tk] = make(map[string][]Ident)
-		}
-		name := id.name()
-		x.idents[tk][name] = append(x.idents[tk][name], id)
+		x.addIdents(id.Info.Kind(), id.name(), id)
 	}
 }
 
@@ -271,8 +821,13 @@ func (x *Index) mergeAST(ax *astIndexer) {
 	x.mu.Lock()
 	defer x.mu.Unlock()
 	x.initMaps()
-	x.mergeIdents(x.exports[ax.current.Name], ax.exports)
-	x.exports[ax.current.Name] = ax.exports
+	if !x.namesOnly() {
+		x.mergeIdents(x.exports[ax.current.ImportPath], ax.exports)
+	}
+	x.forgetPositions(ax.current.ImportPath)
+	x.exports[ax.current.ImportPath] = ax.exports
+	x.setSize(ax.current.ImportPath, len(ax.exports))
+	delete(x.ignored, ax.current.ImportPath)
 }
 
 // addAST, adds the Idents from ax to the index.
@@ -288,103 +843,209 @@ func (x *Index) addAST(ax *astIndexer) {
 
 	x.initMaps()
 
+	x.forgetPositions(ax.current.ImportPath)
 	x.exports[ax.current.ImportPath] = ax.exports
+	x.setSize(ax.current.ImportPath, len(ax.exports))
+	delete(x.ignored, ax.current.ImportPath)
 	if x.packagePath[ax.current.Name] == nil {
 		x.packagePath[ax.current.Name] = make(map[string]bool)
 	}
 	x.packagePath[ax.current.Name][ax.current.ImportPath] = true
 	for tk, m := range ax.idents {
-		if x.idents[tk] == nil {
-			x.idents[tk] = make(map[string][]Ident)
-		}
-		idents := x.idents[tk]
 		for n, ids := range m {
-			idents[n] = append(idents[n], ids...)
+			x.addIdents(tk, n, ids...)
 		}
 	}
 }
 
+// indexable, reports if the idents of Package p should be indexed.  Commands
+// are only indexed if IndexCommands is enabled and internal packages are not
+// indexed if PublicOnly is enabled.  Binary-only packages have no idents.
+func (x *Index) indexable(p *Package) bool {
+	return x.c.IndexGoCode && p.IsValid() && !p.BinaryOnly &&
+		(!p.IsCommand() || x.c.IndexCommands) &&
+		(!x.c.PublicOnly || !isInternalPath(p.ImportPath))
+}
+
 // indexPackage, indexes Package p.  If the Package is already indexed, any
 // changes will be merged in.
 func (x *Index) indexPackage(p *Package) {
-	if !x.c.IndexGoCode || p.IsCommand() || !p.IsValid() {
+	if !x.indexable(p) {
 		return
 	}
+	start := time.Now()
 	ax := &astIndexer{
-		x:       x,
-		fset:    x.fset,
-		current: p,
-		exports: make(map[string]Ident),
+		x:          x,
+		fset:       x.fset,
+		current:    p,
+		exports:    make(map[string]Ident),
+		namesOnly:  x.namesOnly(),
+		publicOnly: x.publicOnly(),
 	}
 	// Only init the idents map if we are adding a new
 	// package, it is not used for merging updates.
 	update := x.hasPackage(p.ImportPath)
-	if !update {
+	if !update && !ax.namesOnly {
 		ax.idents = make(map[TypKind]map[string][]Ident)
 	}
-	// The error is either a os.PathError or parser error.
-	// For now, ignore AST errors, but delete on PathError.
-	if err := ax.index(); err != nil {
+	// Files that fail to parse are skipped and the remaining files
+	// are indexed.  If no files were parsed and the first error is a
+	// os.PathError, the package files are missing and it is deleted.
+	indexed, err := ax.index()
+	if err != nil {
 		x.errorEvent(err, p.ImportPath)
-		if update && fs.IsPathErr(err) {
-			x.removePackage(p)
+		if !indexed {
+			if errs, _ := err.(fileErrors); update && len(errs) != 0 && fs.IsPathErr(errs[0]) {
+				x.removePackage(p)
+			}
+			return
 		}
-		return
 	}
 	if update {
 		x.mergeAST(ax)
-		x.notify(UpdateEvent, p.ImportPath)
+		x.notify(UpdateEvent, p.ImportPath, time.Since(start))
 	} else {
 		x.addAST(ax)
-		x.notify(CreateEvent, p.ImportPath)
+		x.notify(CreateEvent, p.ImportPath, time.Since(start))
 	}
+	x.evict(p.ImportPath)
 }
 
 // WARN: NEW
 func (x *Index) indexPackageFiles(p *Package, fset *token.FileSet, files map[string]*ast.File) {
-	if !x.c.IndexGoCode || p.IsCommand() || !p.IsValid() {
+	if !x.indexable(p) {
 		return
 	}
+	start := time.Now()
 	if len(files) == 0 {
 		x.indexPackage(p)
 		return
 	}
 	ax := &astIndexer{
-		x:       x,
-		fset:    fset,
-		current: p,
-		exports: make(map[string]Ident),
+		x:          x,
+		fset:       fset,
+		current:    p,
+		exports:    make(map[string]Ident),
+		namesOnly:  x.namesOnly(),
+		publicOnly: x.publicOnly(),
 	}
 	// Only init the idents map if we are adding a new
 	// package, it is not used for merging updates.
 	update := x.hasPackage(p.ImportPath)
-	if !update {
+	if !update && !ax.namesOnly {
 		ax.idents = make(map[TypKind]map[string][]Ident)
 	}
 	ax.indexFiles(files)
 	if update {
 		x.mergeAST(ax)
-		x.notify(UpdateEvent, p.ImportPath)
+		x.notify(UpdateEvent, p.ImportPath, time.Since(start))
 	} else {
 		x.addAST(ax)
-		x.notify(CreateEvent, p.ImportPath)
+		x.notify(CreateEvent, p.ImportPath, time.Since(start))
 	}
+	x.evict(p.ImportPath)
+}
+
+// identsAllBuilds, returns the idents with name name declared in the Go files
+// of any indexed package, including Go files ignored by the current build
+// context.  Idents declared in ignored files have their Constraint set.
+//
+// The idents of ignored files are parsed on demand and cached until the
+// package is re-indexed.
+func (x *Index) identsAllBuilds(name string) []Ident {
+	var ids []Ident
+	x.mu.RLock()
+	for _, m := range x.idents {
+		ids = append(ids, m[name]...)
+	}
+	x.mu.RUnlock()
+	if x.c == nil || x.c.packages == nil {
+		return ids
+	}
+	for _, p := range x.c.packages.ignoredPackages() {
+		if !x.indexable(p) {
+			continue
+		}
+		for _, id := range x.lookupIgnored(p) {
+			if id.name() == name {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// lookupIgnored, returns the idents declared in the ignored Go files of
+// Package p, parsing them if they are not cached.
+func (x *Index) lookupIgnored(p *Package) []Ident {
+	x.mu.RLock()
+	ids, ok := x.ignored[p.ImportPath]
+	x.mu.RUnlock()
+	if ok {
+		return ids
+	}
+	ids = x.parseIgnored(p)
+	x.mu.Lock()
+	if x.ignored == nil {
+		x.ignored = make(map[string][]Ident)
+	}
+	x.ignored[p.ImportPath] = ids
+	x.mu.Unlock()
+	return ids
+}
+
+// parseIgnored, parses the ignored Go files of Package p and returns their
+// idents tagged with the file's build constraint.  Files that cannot be
+// parsed are skipped.
+func (x *Index) parseIgnored(p *Package) []Ident {
+	var ids []Ident
+	fset := token.NewFileSet()
+	for _, f := range p.files[IgnoredGoFile] {
+		af, err := parseFile(fset, f.Path, parser.ParseComments)
+		if err != nil || af.Name == nil || af.Name.Name != p.Name {
+			continue
+		}
+		ax := &astIndexer{
+			x:          x,
+			fset:       fset,
+			current:    p,
+			exports:    make(map[string]Ident),
+			publicOnly: x.publicOnly(),
+		}
+		ax.Visit(af)
+		constraint := x.intern(fileConstraint(af, f.Name))
+		for _, id := range ax.exports {
+			id.Constraint = constraint
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// invalidateIgnored, clears the cache of ignored Go file idents.
+func (x *Index) invalidateIgnored() {
+	x.mu.Lock()
+	x.ignored = nil
+	x.mu.Unlock()
 }
 
 type astIndexer struct {
-	x       *Index
-	fset    *token.FileSet
-	current *Package
-	exports map[string]Ident
-	idents  map[TypKind]map[string][]Ident // Only updated if not nill.
+	x          *Index
+	fset       *token.FileSet
+	current    *Package
+	exports    map[string]Ident
+	idents     map[TypKind]map[string][]Ident // Only updated if not nill.
+	namesOnly  bool                           // Only index exported names, without positions
+	publicOnly bool                           // Only index exported idents, see Corpus.PublicOnly
 }
 
-func (x *astIndexer) index() error {
+// index, parses and indexes the Go files of the current package and reports
+// if any files were indexed.  Files that fail to parse are skipped, their
+// errors are returned as a fileErrors error.
+func (x *astIndexer) index() (bool, error) {
 	files, err := parseFiles(x.fset, x.current.Dir, x.current.GoFiles())
-	if err != nil {
-		return err
-	}
-	return x.indexFiles(files)
+	x.indexFiles(files)
+	return len(files) != 0, err
 }
 
 func (x *astIndexer) indexFiles(files map[string]*ast.File) error {
@@ -392,6 +1053,9 @@ func (x *astIndexer) indexFiles(files map[string]*ast.File) error {
 	for _, af := range files {
 		x.Visit(af)
 	}
+	if x.x.c != nil && x.x.c.IndexAsm {
+		x.indexAsm()
+	}
 	return nil
 }
 
@@ -419,14 +1083,24 @@ func (x *astIndexer) visitIdent(tk TypKind, ident, recv *ast.Ident) {
 	if !validIdent(ident) {
 		return
 	}
+	if x.publicOnly && (!ident.IsExported() || recv != nil && !recv.IsExported()) {
+		return
+	}
 
+	if x.namesOnly {
+		x.visitName(tk, ident, recv)
+		return
+	}
+
+	// The file name is shared by the positions of the file, so it is not
+	// interned.
 	pos := x.position(ident.Pos())
 	name := x.intern(ident.Name)
 	id := Ident{
 		Name:    name,
 		Package: x.intern(x.current.Name),
 		Path:    x.intern(x.current.ImportPath),
-		File:    x.intern(pos.Filename),
+		File:    pos.Filename,
 		Info:    makeTypInfo(tk, pos.Offset, pos.Line),
 	}
 
@@ -452,6 +1126,28 @@ func (x *astIndexer) visitIdent(tk TypKind, ident, recv *ast.Ident) {
 	x.exports[id.Name] = id
 }
 
+// visitName, adds the exported name of ident to the exports without any
+// position information.  Used when only names are indexed.
+func (x *astIndexer) visitName(tk TypKind, ident, recv *ast.Ident) {
+	name := ident.Name
+	if tk == MethodDecl && recv != nil {
+		name = recv.Name + "." + name
+	}
+	if !exportedName(name) {
+		return
+	}
+	if x.exports == nil {
+		x.exports = make(map[string]Ident)
+	}
+	name = x.intern(name)
+	x.exports[name] = Ident{
+		Name:    name,
+		Package: x.intern(x.current.Name),
+		Path:    x.intern(x.current.ImportPath),
+		Info:    makeTypInfo(tk, 0, 0),
+	}
+}
+
 func (x *astIndexer) visitRecv(fn *ast.FuncDecl, fields *ast.FieldList) {
 	if len(fields.List) != 0 {
 		switch n := fields.List[0].Type.(type) {
@@ -471,27 +1167,27 @@ func (x *astIndexer) visitGenDecl(decl *ast.GenDecl) {
 		case *ast.TypeSpec:
 			x.visitIdent(TypeDecl, n.Name, nil)
 		case *ast.ValueSpec:
-			x.visitValueSpec(n)
+			x.visitValueSpec(decl.Tok, n)
 		}
 	}
 }
 
-func (x *astIndexer) visitValueSpec(spec *ast.ValueSpec) {
+// visitValueSpec, indexes the names declared by spec.  The kind of the names
+// is derived from the token of the enclosing GenDecl (const or var), as the
+// parser does not reliably populate ast.Ident.Obj.
+func (x *astIndexer) visitValueSpec(tok token.Token, spec *ast.ValueSpec) {
 	// TODO (CEV): Add interface methods.
+	var tk TypKind
+	switch tok {
+	case token.CONST:
+		tk = ConstDecl
+	case token.VAR:
+		tk = VarDecl
+	default:
+		return
+	}
 	for _, n := range spec.Names {
-		if n.Obj == nil {
-			continue
-		}
-		switch n.Obj.Kind {
-		case ast.Con:
-			x.visitIdent(ConstDecl, n, nil)
-		case ast.Typ:
-			x.visitIdent(TypeDecl, n, nil)
-		case ast.Var:
-			x.visitIdent(VarDecl, n, nil)
-		case ast.Fun:
-			x.visitIdent(FuncDecl, n, nil)
-		}
+		x.visitIdent(tk, n, nil)
 	}
 }
 
@@ -1,11 +1,18 @@
 package pkg
 
 import (
+	"fmt"
+	"go/ast"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -130,6 +137,288 @@ func TestMergeIdents(t *testing.T) {
 	}
 }
 
+func TestMergeIdentsUpdate(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"AlphaConst", "AlphaVar", "AlphaType", "Method",
+		"AlphaIface", "AlphaFunc", "BetaFunc", "VendoredFunc"} {
+		if ids := f.idents.lookupName(name, AllKinds); len(ids) != 1 {
+			t.Errorf("MergeIdents: init: ident (%s): exp (1) got (%d): %+v", name, len(ids), ids)
+		}
+	}
+	if ids := f.idents.lookupName("AlphaTagged", AllKinds); len(ids) != 0 {
+		t.Errorf("MergeIdents: indexed ignored Go file: %+v", ids)
+	}
+
+	// Replace AlphaFunc with AlphaFunc2 and remove the method.
+	f.write(t, "alpha/alpha.go", `package alpha
+
+const AlphaConst = 1
+
+var AlphaVar int
+
+type AlphaType struct{}
+
+type AlphaIface interface {
+	IfaceMethod()
+}
+
+func AlphaFunc2() {}
+`)
+	f.updateIndex()
+
+	for name, n := range map[string]int{
+		"AlphaConst": 1,
+		"AlphaType":  1,
+		"AlphaFunc2": 1,
+		"AlphaFunc":  0,
+		"Method":     0,
+		"BetaFunc":   1,
+	} {
+		if ids := f.idents.lookupName(name, AllKinds); len(ids) != n {
+			t.Errorf("MergeIdents: update: ident (%s): exp (%d) got (%d): %+v", name, n, len(ids), ids)
+		}
+	}
+	exp := f.idents.lookupExports("alpha")
+	for _, name := range []string{"AlphaFunc", "AlphaType.Method"} {
+		if _, ok := exp[name]; ok {
+			t.Errorf("MergeIdents: update: export not removed: %s", name)
+		}
+	}
+	if _, ok := exp["AlphaFunc2"]; !ok {
+		t.Error("MergeIdents: update: export not added: AlphaFunc2")
+	}
+}
+
+func TestIndexNamesOnly(t *testing.T) {
+	exp := []string{"AlphaConst", "AlphaFunc", "AlphaIface", "AlphaType",
+		"AlphaType.Method", "AlphaVar"}
+	for _, namesOnly := range []bool{false, true} {
+		f := newFixture(t, true)
+		defer f.Close()
+		f.IndexNamesOnly = namesOnly
+		if err := f.initDirTree(); err != nil {
+			t.Fatal(err)
+		}
+		if names := f.Exports("alpha"); !reflect.DeepEqual(names, exp) {
+			t.Errorf("IndexNamesOnly (%v): Exports: exp (%q) got (%q)", namesOnly, exp, names)
+		}
+		if ids := f.idents.lookupName("AlphaFunc", AllKinds); (len(ids) == 0) != namesOnly {
+			t.Errorf("IndexNamesOnly (%v): idents: %+v", namesOnly, ids)
+		}
+		id, ok := f.Definition("alpha", "AlphaFunc")
+		if !ok || id.Info.Kind() != FuncDecl {
+			t.Errorf("IndexNamesOnly (%v): Definition: %+v", namesOnly, id)
+		}
+		if namesOnly && (id.File != "" || id.Info.Line() != 0) {
+			t.Errorf("IndexNamesOnly (%v): indexed position: %+v", namesOnly, id)
+		}
+		if !namesOnly {
+			continue
+		}
+
+		f.write(t, "alpha/alpha.go", "package alpha\n\nfunc AlphaFunc2() {}\n")
+		f.updateIndex()
+		if names := f.Exports("alpha"); !reflect.DeepEqual(names, []string{"AlphaFunc2"}) {
+			t.Errorf("IndexNamesOnly (%v): update: Exports: %q", namesOnly, names)
+		}
+		if n := len(f.idents.Idents()); n != 0 {
+			t.Errorf("IndexNamesOnly (%v): update: indexed (%d) idents", namesOnly, n)
+		}
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	for _, publicOnly := range []bool{false, true} {
+		f := newFixture(t, true)
+		defer f.Close()
+		f.PublicOnly = publicOnly
+		f.write(t, "alpha/internal/priv/priv.go", "package priv\n\nfunc Exported() {}\n")
+		f.write(t, "alpha/impl.go", "package alpha\n\ntype impl struct{}\n\nfunc (impl) Read() {}\n")
+		if err := f.initDirTree(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.packages.lookupPath(f.path("alpha/internal/priv")); !ok {
+			t.Fatalf("PublicOnly (%v): internal package not in the package index", publicOnly)
+		}
+
+		// Exported idents of public packages are always indexed.
+		if id, ok := f.Definition("alpha", "AlphaType.Method"); !ok || id.Info.Kind() != MethodDecl {
+			t.Errorf("PublicOnly (%v): Definition: %+v", publicOnly, id)
+		}
+		for _, name := range []string{"alphaFunc", "AlphaType.method", "impl", "impl.Read"} {
+			if _, ok := f.Definition("alpha", name); ok == publicOnly {
+				t.Errorf("PublicOnly (%v): Definition (%s): exp (%v) got (%v)",
+					publicOnly, name, !publicOnly, ok)
+			}
+		}
+		if ids := f.idents.lookupName("Exported", AllKinds); (len(ids) == 0) != publicOnly {
+			t.Errorf("PublicOnly (%v): internal package idents: %+v", publicOnly, ids)
+		}
+		if _, ok := f.Definition("alpha/internal/priv", "Exported"); ok == publicOnly {
+			t.Errorf("PublicOnly (%v): Definition: internal package", publicOnly)
+		}
+	}
+}
+
+// Run with -race.
+func TestIdentsConcurrentUpdate(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				ids := f.Idents()
+				if !sort.IsSorted(byPathName(ids)) {
+					t.Error("Idents: not sorted")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		src := fmt.Sprintf("package gamma\n\nfunc Gamma%d() {}\n", i)
+		f.write(t, "gamma/gamma.go", src)
+		f.updateIndex()
+	}
+	close(done)
+	wg.Wait()
+
+	ids := f.Idents()
+	var names []string
+	for _, id := range ids {
+		if id.Path == "gamma" {
+			names = append(names, id.Name)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"Gamma19"}) {
+		t.Errorf("Idents: gamma: exp (%q) got (%q)", []string{"Gamma19"}, names)
+	}
+}
+
+func TestMaxIndexBytes(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	alpha := int64(len(f.idents.lookupExports("alpha"))) * identSize
+	if alpha == 0 || f.idents.sizes["alpha"] != alpha {
+		t.Fatalf("MaxIndexBytes: alpha size: exp (%d) got (%d)", alpha, f.idents.sizes["alpha"])
+	}
+
+	// Only alpha fits and it was the most recently accessed.
+	f.MaxIndexBytes = alpha
+	f.Exports("beta")
+	f.Exports("alpha")
+	f.idents.evict("")
+	if exp := []string{"alpha"}; !reflect.DeepEqual(f.idents.ExportedPackages(), exp) {
+		t.Fatalf("MaxIndexBytes: exp (%q) got (%q)", exp, f.idents.ExportedPackages())
+	}
+	if f.idents.size != alpha {
+		t.Errorf("MaxIndexBytes: size: exp (%d) got (%d)", alpha, f.idents.size)
+	}
+	if ids := f.idents.lookupName("BetaFunc", AllKinds); len(ids) != 0 {
+		t.Errorf("MaxIndexBytes: evicted idents: %+v", ids)
+	}
+
+	// Evicted packages are re-indexed on demand, evicting alpha.
+	if _, ok := f.Definition("beta", "BetaFunc"); !ok {
+		t.Fatal("MaxIndexBytes: Definition: evicted package not re-indexed")
+	}
+	if exp := []string{"beta"}; !reflect.DeepEqual(f.idents.ExportedPackages(), exp) {
+		t.Errorf("MaxIndexBytes: exp (%q) got (%q)", exp, f.idents.ExportedPackages())
+	}
+	if _, ok := f.Definition("alpha", "AlphaFunc"); !ok {
+		t.Error("MaxIndexBytes: Definition: evicted package not re-indexed")
+	}
+}
+
+func TestIndexParseErrors(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "broken/a.go", "package broken\n\nfunc A() {}\n")
+	f.write(t, "broken/b.go", "package broken\n\nfunc B() {}\n")
+	f.write(t, "broken/c.go", "package broken\n\nfunc C() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	// parseFiles returns the valid files and the errors of the others.
+	f.write(t, "broken/c.go", "package broken\n\nfunc C( {\n")
+	dir := f.path("broken")
+	files, err := parseFiles(token.NewFileSet(), dir, []string{"a.go", "b.go", "c.go", "missing.go"})
+	if len(files) != 2 || files["a.go"] == nil || files["b.go"] == nil {
+		t.Errorf("parseFiles: exp files (a.go, b.go) got: %v", files)
+	}
+	if errs, ok := err.(fileErrors); !ok || len(errs) != 2 {
+		t.Errorf("parseFiles: exp 2 errors got: %#v", err)
+	}
+
+	// Update the package with a broken file, the valid files must
+	// still be indexed.
+	f.write(t, "broken/a.go", "package broken\n\nfunc A() {}\n\nfunc A2() {}\n")
+	f.updateIndex()
+	for _, name := range []string{"A", "A2", "B"} {
+		if _, ok := f.Definition("broken", name); !ok {
+			t.Errorf("ParseErrors: missing ident: %s", name)
+		}
+	}
+}
+
+func TestIndexMultipleFiles(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	// The package name differs from the import path.
+	f.write(t, "delta/d1.go", "package dname\n\nfunc D1() {}\n")
+	f.write(t, "delta/d2.go", "package dname\n\nfunc D2() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(when string, names ...string) {
+		exp := make(map[string]bool)
+		for _, name := range names {
+			exp[name] = true
+			if ids := f.idents.lookupName(name, AllKinds); len(ids) != 1 {
+				t.Errorf("%s: ident (%s): exp (1) got (%d): %+v", when, name, len(ids), ids)
+			}
+		}
+		got := make(map[string]bool)
+		for name := range f.idents.lookupExports("delta") {
+			got[name] = true
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: exports: exp (%v) got (%v)", when, exp, got)
+		}
+		if f.idents.hasPackage("dname") {
+			t.Errorf("%s: exports indexed by package name", when)
+		}
+	}
+	check("init", "D1", "D2")
+
+	// Only d1.go changes.
+	f.write(t, "delta/d1.go", "package dname\n\nfunc D1() {}\n\nfunc D3() {}\n")
+	f.updateIndex()
+	check("update", "D1", "D2", "D3")
+}
+
 func TestRemovePackage(t *testing.T) {
 	// TODO: organize and add more test cases
 
@@ -211,6 +500,369 @@ func TestRemovePackage(t *testing.T) {
 	}
 }
 
+const valueSpecSrc = `package p
+
+const C1 = 1
+
+const (
+	C2 = iota
+	C3
+)
+
+var V1 int
+
+var (
+	V2, V3 = 2, 3
+)
+
+type T1 int
+
+type (
+	T2 struct{}
+)
+
+func F1() {}
+`
+
+func TestVisitValueSpecNoObjects(t *testing.T) {
+	fset := token.NewFileSet()
+	af, err := parser.ParseFile(fset, "p.go", valueSpecSrc, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ax := &astIndexer{
+		x:       newIndex(nil),
+		fset:    fset,
+		current: &Package{Name: "p", ImportPath: "p"},
+		exports: make(map[string]Ident),
+		idents:  make(map[TypKind]map[string][]Ident),
+	}
+	ax.Visit(af)
+
+	var tests = map[string]TypKind{
+		"C1": ConstDecl,
+		"C2": ConstDecl,
+		"C3": ConstDecl,
+		"V1": VarDecl,
+		"V2": VarDecl,
+		"V3": VarDecl,
+		"T1": TypeDecl,
+		"T2": TypeDecl,
+		"F1": FuncDecl,
+	}
+	for name, kind := range tests {
+		id, ok := ax.exports[name]
+		if !ok {
+			t.Errorf("astIndexer: missing ident: %s", name)
+			continue
+		}
+		if k := id.Info.Kind(); k != kind {
+			t.Errorf("astIndexer: ident (%s) kind: exp (%s) got (%s)", name, kind, k)
+		}
+		if len(ax.idents[kind][name]) != 1 {
+			t.Errorf("astIndexer: ident (%s) not indexed by kind (%s)", name, kind)
+		}
+	}
+	if len(ax.exports) != len(tests) {
+		t.Errorf("astIndexer: exports: exp (%d) got (%d)", len(tests), len(ax.exports))
+	}
+}
+
+func TestSearchPrefix(t *testing.T) {
+	x := newIndex(nil)
+	for _, id := range []Ident{
+		{Name: "Reader", Path: "io", Info: makeTypInfo(TypeDecl, 10, 1)},
+		{Name: "ReadAll", Path: "io", Info: makeTypInfo(FuncDecl, 20, 2)},
+		{Name: "Reader.Read", Path: "bufio", Info: makeTypInfo(MethodDecl, 30, 3)},
+		{Name: "Read", Path: "io", Info: makeTypInfo(FuncDecl, 40, 4)},
+		{Name: "readBuf", Path: "io", Info: makeTypInfo(VarDecl, 50, 5)},
+		{Name: "reader.Read", Path: "io", Info: makeTypInfo(MethodDecl, 60, 6)},
+		{Name: "ReadSize", Path: "bufio", Info: makeTypInfo(ConstDecl, 70, 7)},
+		{Name: "Red", Path: "color", Info: makeTypInfo(ConstDecl, 80, 8)},
+		{Name: "Write", Path: "io", Info: makeTypInfo(FuncDecl, 90, 9)},
+	} {
+		x.addIdents(id.Info.Kind(), id.name(), id)
+	}
+	names := func(ids []Ident) []string {
+		var s []string
+		for _, id := range ids {
+			s = append(s, id.Path+"."+id.Name)
+		}
+		return s
+	}
+	// Same names are sorted by Path.
+	all := []string{
+		"bufio.Reader.Read",
+		"io.Read",
+		"io.reader.Read",
+		"io.Reader",
+		"io.ReadAll",
+		"bufio.ReadSize",
+	}
+	tests := []struct {
+		prefix   string
+		limit    int
+		exported bool
+		exp      []string
+	}{
+		{"Read", 0, false, all},
+		{"Read", -1, false, all},
+		{"Read", len(all), false, all},
+		{"Read", len(all) + 1, false, all},
+		{"Read", 1, false, all[:1]},
+		{"Read", 3, false, all[:3]},
+		{"Read", 0, true, []string{"bufio.Reader.Read", "io.Read", "io.Reader", "io.ReadAll", "bufio.ReadSize"}},
+		{"Re", 2, false, []string{"color.Red", "bufio.Reader.Read"}},
+		{"re", 0, false, []string{"io.readBuf"}},
+		{"re", 0, true, nil},
+		{"Reader.", 0, false, nil}, // methods are matched by method name
+		{"Z", 0, false, nil},
+	}
+	for _, test := range tests {
+		var got []Ident
+		if test.exported {
+			got = x.SearchPrefixExported(test.prefix, test.limit)
+		} else {
+			got = x.SearchPrefix(test.prefix, test.limit)
+		}
+		if s := names(got); !reflect.DeepEqual(s, test.exp) {
+			t.Errorf("SearchPrefix(%q, %d, exported=%t):\nExp: %q\nGot: %q",
+				test.prefix, test.limit, test.exported, test.exp, s)
+		}
+	}
+	if n := len(x.SearchPrefix("", 0)); n != 9 {
+		t.Errorf("SearchPrefix: empty prefix: exp: 9 got: %d", n)
+	}
+
+	// Once built, the trie of names is kept up to date.
+	x.mu.Lock()
+	x.addIdents(FuncDecl, "Rest", Ident{Name: "Rest", Path: "io", Info: makeTypInfo(FuncDecl, 100, 10)})
+	x.setIdents(ConstDecl, "Red", nil)
+	x.mu.Unlock()
+	if s := names(x.SearchPrefix("Res", 0)); !reflect.DeepEqual(s, []string{"io.Rest"}) {
+		t.Errorf("SearchPrefix: update: %q", s)
+	}
+	if s := names(x.SearchPrefix("Red", 0)); len(s) != 0 {
+		t.Errorf("SearchPrefix: update: removed: %q", s)
+	}
+	if x.names.contains("Red") || !x.names.contains("Rest") {
+		t.Errorf("SearchPrefix: update: names not updated: %q", trieNames(x.names, ""))
+	}
+	if ids := NewCorpus().SearchPrefix("Read", 0); ids != nil {
+		t.Errorf("SearchPrefix: uninitialized Corpus: %+v", ids)
+	}
+}
+
+func TestIdentsAllBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	goos := "windows"
+	if runtime.GOOS == goos {
+		goos = "linux"
+	}
+	files := map[string]string{
+		"a.go":                  "package a\n\nfunc Open() {}\n",
+		"a_" + goos + ".go":     "package a\n\nfunc Open() {}\n",
+		"tagged.go":             "//go:build ignore\n\npackage a\n\nfunc Open() {}\n",
+		"other_" + goos + ".go": "package a\n\nfunc Close() {}\n",
+	}
+	pkg := &Package{
+		Dir:        dir,
+		Name:       "a",
+		ImportPath: "a",
+		SrcRoot:    filepath.Dir(dir),
+		files:      make(map[GoFileType]FileMap),
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		typ := IgnoredGoFile
+		if name == "a.go" {
+			typ = GoFile
+		}
+		pkg.addFile(typ, File{Name: name, Path: path})
+	}
+
+	c := &Corpus{IndexGoCode: true}
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	c.packages.addPackage(pkg)
+
+	af, err := parser.ParseFile(c.idents.fset, filepath.Join(dir, "a.go"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.idents.indexPackageFiles(pkg, c.idents.fset, map[string]*ast.File{"a.go": af})
+
+	exp := map[string]bool{
+		"":       true,
+		goos:     true,
+		"ignore": true,
+	}
+	for i := 0; i < 2; i++ {
+		ids := c.IdentsAllBuilds("Open")
+		if len(ids) != len(exp) {
+			t.Fatalf("IdentsAllBuilds (%d): exp (%d) idents got (%d): %+v", i, len(exp), len(ids), ids)
+		}
+		for _, id := range ids {
+			if !exp[id.Constraint] {
+				t.Errorf("IdentsAllBuilds (%d): unexpected constraint: %q", i, id.Constraint)
+			}
+		}
+	}
+	if n := len(c.idents.ignored["a"]); n != 3 {
+		t.Errorf("IdentsAllBuilds: cached idents: exp (%d) got (%d)", 3, n)
+	}
+	if ids := c.IdentsAllBuilds("Close"); len(ids) != 1 || ids[0].Constraint != goos {
+		t.Errorf("IdentsAllBuilds: Close: %+v", ids)
+	}
+}
+
+func TestIndexPos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const src = "package a\n\n// F is a func.\nfunc F() {}\n\ntype T struct{}\n"
+	path := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg := &Package{
+		Dir:        dir,
+		Name:       "a",
+		ImportPath: "a",
+	}
+	pkg.addFile(GoFile, File{Name: "a.go", Path: path})
+
+	c := &Corpus{IndexGoCode: true}
+	c.idents = newIndex(c)
+	c.idents.indexPackage(pkg)
+
+	fset := c.idents.FileSet()
+	for _, name := range []string{"F", "T"} {
+		id, ok := c.idents.lookupExports("a")[name]
+		if !ok {
+			t.Fatalf("Index: missing ident: %s", name)
+		}
+		pos, ok := c.idents.Pos(id)
+		if !ok {
+			t.Fatalf("Index: Pos (%s): not found", name)
+		}
+		p := fset.Position(pos)
+		p.Column = 0 // not recorded by Ident
+		if p != id.Position() {
+			t.Errorf("Index: Pos (%s): exp (%s) got (%s)", name, id.Position(), p)
+		}
+		if s := src[fset.Position(pos).Offset:]; !strings.HasPrefix(s, name) {
+			t.Errorf("Index: Pos (%s): source: %q", name, s)
+		}
+	}
+	if _, ok := c.idents.Pos(Ident{File: filepath.Join(dir, "missing.go")}); ok {
+		t.Error("Index: Pos: found missing file")
+	}
+}
+
+func TestIndexCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var pkgs []*Package
+	for _, name := range []string{"cmd1", "cmd2"} {
+		path := filepath.Join(dir, name, "main.go")
+		src := "package main\n\nfunc run() {}\n\nfunc main() {}\n"
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p := &Package{
+			Dir:        filepath.Dir(path),
+			Name:       "main",
+			ImportPath: name,
+		}
+		p.addFile(GoFile, File{Name: "main.go", Path: path})
+		pkgs = append(pkgs, p)
+	}
+
+	for _, enabled := range []bool{false, true} {
+		c := &Corpus{IndexGoCode: true, IndexCommands: enabled}
+		c.idents = newIndex(c)
+		for _, p := range pkgs {
+			c.idents.indexPackage(p)
+		}
+		ids := c.idents.lookupName("run", AllKinds)
+		if !enabled {
+			if len(ids) != 0 {
+				t.Errorf("IndexCommands (%v): indexed commands: %+v", enabled, ids)
+			}
+			continue
+		}
+		if len(ids) != len(pkgs) {
+			t.Fatalf("IndexCommands (%v): exp (%d) idents got (%d)", enabled, len(pkgs), len(ids))
+		}
+		for i, p := range pkgs {
+			if ids[i].Path != p.ImportPath || ids[i].Package != "main" {
+				t.Errorf("IndexCommands (%v): ident: %+v", enabled, ids[i])
+			}
+			if !c.idents.packagePath["main"][p.ImportPath] {
+				t.Errorf("IndexCommands (%v): missing packagePath: %s", enabled, p.ImportPath)
+			}
+		}
+	}
+}
+
+func TestIndexPackageFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The package name differs from the import path.
+	goroot := filepath.Join(tmp, "go")
+	gopath := filepath.Join(tmp, "gopath")
+	writeTestFiles(t, tmp, map[string]string{
+		"go/src/.keep":                  "",
+		"gopath/src/example.com/a/1.go": "package aname\n\nfunc A1() {}\n",
+		"gopath/src/example.com/a/2.go": "package aname\n\nfunc A2() {}\n",
+	})
+
+	c := NewCorpus()
+	c.IndexGoCode = true
+	c.ctxt.doUpdate(goroot, gopath)
+	c.packages = newPackageIndex(c)
+	c.idents = newIndex(c)
+	if err := c.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file of the package is indexed.
+	for _, name := range []string{"A1", "A2"} {
+		if ids := c.idents.lookupName(name, AllKinds); len(ids) != 1 || ids[0].Path != "example.com/a" {
+			t.Errorf("ident (%s): %+v", name, ids)
+		}
+	}
+	if n := len(c.idents.lookupExports("example.com/a")); n != 2 {
+		t.Errorf("exports: exp (2) got (%d)", n)
+	}
+	if c.idents.hasPackage("aname") {
+		t.Error("exports indexed by package name")
+	}
+}
+
 func BenchmarkAstIndexer(b *testing.B) {
 	filename := filepath.Join(runtime.GOROOT(), "src/crypto/x509/x509.go")
 	if _, err := os.Stat(filename); err != nil {
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"encoding/json"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A packageJSON, is the JSON representation of a Package and its exported
+// idents, see Corpus.PackageJSON.
+type packageJSON struct {
+	Dir            string
+	Name           string
+	ImportPath     string
+	Root           string
+	SrcRoot        string
+	Goroot         bool
+	ModCache       bool
+	Installed      bool
+	BinaryOnly     bool `json:",omitempty"`
+	IsCommand      bool
+	LastIndexed    time.Time
+	ImportComment  string   `json:",omitempty"`
+	MinGoVersion   string   `json:",omitempty"`
+	GoFiles        []string `json:",omitempty"`
+	IgnoredGoFiles []string `json:",omitempty"`
+	TestGoFiles    []string `json:",omitempty"`
+	OtherFiles     []string `json:",omitempty"`
+	InvalidGoFiles []string `json:",omitempty"`
+	Error          string   `json:",omitempty"`
+	Exports        []Ident  `json:",omitempty"`
+}
+
+// PackageJSON, returns a self-contained JSON encoding of the package with
+// import path importPath: its metadata, the names of its files by type, its
+// error, if any, and its exported idents sorted by name.  It is intended as
+// the unit of caching for clients that fetch the API of individual packages.
+//
+// Like Definition, the package is imported and its idents indexed if it is
+// not in the index.  Idents are only included if IndexGoCode is enabled.
+func (c *Corpus) PackageJSON(importPath string) ([]byte, error) {
+	p, err := c.LookupOrImport(importPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.idents != nil {
+		c.idents.touch(importPath)
+	}
+	return json.Marshal(c.newPackageJSON(p))
+}
+
+// newPackageJSON, returns the JSON representation of Package p, see
+// PackageJSON.
+func (c *Corpus) newPackageJSON(p *Package) packageJSON {
+	v := packageJSON{
+		Dir:            p.Dir,
+		Name:           p.Name,
+		ImportPath:     p.ImportPath,
+		Root:           p.Root,
+		SrcRoot:        p.SrcRoot,
+		Goroot:         p.Goroot,
+		ModCache:       p.ModCache,
+		Installed:      p.Installed,
+		BinaryOnly:     p.BinaryOnly,
+		IsCommand:      p.IsCommand(),
+		LastIndexed:    p.LastIndexed,
+		ImportComment:  p.ImportComment,
+		MinGoVersion:   p.MinGoVersion(),
+		GoFiles:        p.files[GoFile].FileNames(),
+		IgnoredGoFiles: p.files[IgnoredGoFile].FileNames(),
+		TestGoFiles:    p.files[TestGoFile].FileNames(),
+		OtherFiles:     p.other.FileNames(),
+		InvalidGoFiles: p.invalid.FileNames(),
+	}
+	if err := p.Error(); err != nil {
+		v.Error = err.Error()
+	}
+	if x := c.idents; x != nil {
+		// Don't touch the package, encoding the whole Corpus would reset
+		// the order in which packages are evicted.
+		x.mu.RLock()
+		for name, id := range x.exports[p.ImportPath] {
+			if exportedName(name) {
+				v.Exports = append(v.Exports, id)
+			}
+		}
+		x.mu.RUnlock()
+		sort.Slice(v.Exports, func(i, j int) bool {
+			return v.Exports[i].Name < v.Exports[j].Name
+		})
+	}
+	return v
+}
+
+// MarshalJSON, returns the JSON encoding of the indexed packages of the
+// Corpus: an object mapping each source root to an object mapping the import
+// paths of its packages to their encoding, see PackageJSON.  Roots and import
+// paths are sorted, so the encoding is the same as that of json.Marshal for
+// the equivalent map.
+//
+// Encoding a full index is dominated by encoding the packages, which are
+// encoded in parallel and then concatenated.  Updates to the package index
+// wait until the encoding is complete.
+func (c *Corpus) MarshalJSON() ([]byte, error) {
+	if c.packages == nil {
+		return []byte("{}"), nil
+	}
+	x := c.packages
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	roots := make([]string, 0, len(x.packages))
+	for root, m := range x.packages {
+		if len(m) != 0 {
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	type entry struct {
+		path string // key of the package in its source root
+		p    *Package
+	}
+	var pkgs []entry
+	start := make([]int, len(roots)) // index of the first package of each root
+	for i, root := range roots {
+		start[i] = len(pkgs)
+		for path, p := range x.packages[root] {
+			pkgs = append(pkgs, entry{path, p})
+		}
+		list := pkgs[start[i]:]
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].path < list[j].path
+		})
+	}
+
+	// Encode the packages in parallel, each into its own buffer.
+	bufs := make([][]byte, len(pkgs))
+	errs := make([]error, len(pkgs))
+	var next int64 = -1
+	var wg sync.WaitGroup
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(pkgs) {
+		workers = len(pkgs)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(pkgs) {
+					return
+				}
+				bufs[i], errs[i] = json.Marshal(c.newPackageJSON(pkgs[i].p))
+			}
+		}()
+	}
+	wg.Wait()
+
+	size := 2
+	for i, b := range bufs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		size += len(b) + len(pkgs[i].path) + 4
+	}
+	for _, root := range roots {
+		size += len(root) + 5
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, '{')
+	for i, root := range roots {
+		if i != 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONKey(buf, root)
+		buf = append(buf, '{')
+		end := len(pkgs)
+		if i+1 < len(roots) {
+			end = start[i+1]
+		}
+		for j := start[i]; j < end; j++ {
+			if j != start[i] {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONKey(buf, pkgs[j].path)
+			buf = append(buf, bufs[j]...)
+		}
+		buf = append(buf, '}')
+	}
+	buf = append(buf, '}')
+	return buf, nil
+}
+
+// appendJSONKey, appends the JSON encoding of object key s, followed by a
+// colon, to b.
+func appendJSONKey(b []byte, s string) []byte {
+	k, _ := json.Marshal(s) // strings cannot fail to encode
+	b = append(b, k...)
+	return append(b, ':')
+}
@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPackageJSON(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.PackageJSON("missing"); err == nil {
+		t.Error("PackageJSON: expected error for missing package")
+	}
+
+	b, err := f.PackageJSON("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v packageJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Dir != f.path("alpha") || v.Name != "alpha" || v.ImportPath != "alpha" ||
+		v.SrcRoot != f.root || v.IsCommand || v.Error != "" {
+		t.Errorf("PackageJSON: alpha: %+v", v)
+	}
+	files := map[string][]string{
+		"GoFiles":        {"alpha.go"},
+		"IgnoredGoFiles": {"alpha_tagged.go"},
+		"TestGoFiles":    {"alpha_test.go"},
+	}
+	for name, got := range map[string][]string{
+		"GoFiles":        v.GoFiles,
+		"IgnoredGoFiles": v.IgnoredGoFiles,
+		"TestGoFiles":    v.TestGoFiles,
+	} {
+		if exp := files[name]; !reflect.DeepEqual(got, exp) {
+			t.Errorf("PackageJSON: %s: exp: %q got: %q", name, exp, got)
+		}
+	}
+
+	var names []string
+	for _, id := range v.Exports {
+		names = append(names, id.Name)
+		if id.Path != "alpha" || id.File != f.path("alpha/alpha.go") || id.Info.Line() == 0 {
+			t.Errorf("PackageJSON: invalid ident: %+v", id)
+		}
+	}
+	exp := []string{
+		"AlphaConst",
+		"AlphaFunc",
+		"AlphaIface",
+		"AlphaType",
+		"AlphaType.Method",
+		"AlphaVar",
+	}
+	if !reflect.DeepEqual(names, exp) {
+		t.Errorf("PackageJSON: Exports:\nExp: %q\nGot: %q", exp, names)
+	}
+}
+
+// marshalPackagesSerial, returns the JSON encoding of the packages of Corpus c
+// by encoding the equivalent map with json.Marshal, see Corpus.MarshalJSON.
+func marshalPackagesSerial(c *Corpus) ([]byte, error) {
+	m := make(map[string]map[string]packageJSON)
+	c.packages.mu.RLock()
+	for root, pkgs := range c.packages.packages {
+		if len(pkgs) == 0 {
+			continue
+		}
+		m[root] = make(map[string]packageJSON, len(pkgs))
+		for path, p := range pkgs {
+			m[root][path] = c.newPackageJSON(p)
+		}
+	}
+	c.packages.mu.RUnlock()
+	return json.Marshal(m)
+}
+
+func TestCorpusMarshalJSON(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(f.Corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := marshalPackagesSerial(f.Corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(exp) {
+		t.Errorf("MarshalJSON:\nExp: %s\nGot: %s", exp, b)
+	}
+
+	var m map[string]map[string]packageJSON
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if p := m[f.root]["alpha"]; p.Name != "alpha" || len(p.Exports) == 0 {
+		t.Errorf("MarshalJSON: alpha: %+v", p)
+	}
+	if _, ok := m[f.root]["empty"]; ok {
+		t.Error("MarshalJSON: unexpected package: empty")
+	}
+
+	if b, err := json.Marshal(&Corpus{}); err != nil || string(b) != "{}" {
+		t.Errorf("MarshalJSON: empty Corpus: %s %v", b, err)
+	}
+}
+
+// Encoding the Corpus must not change the order in which packages are
+// evicted from the Index.
+func TestCorpusMarshalJSONAccess(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	f.MaxIndexBytes = 1 << 30
+	f.Exports("beta")
+	f.Exports("alpha")
+	clock := f.idents.clock
+	if _, err := json.Marshal(f.Corpus); err != nil {
+		t.Fatal(err)
+	}
+	if f.idents.clock != clock {
+		t.Errorf("MarshalJSON: packages accessed: clock: exp: %d got: %d", clock, f.idents.clock)
+	}
+
+	if _, err := f.PackageJSON("beta"); err != nil {
+		t.Fatal(err)
+	}
+	if f.idents.access["beta"] <= f.idents.access["alpha"] {
+		t.Error("PackageJSON: package not accessed")
+	}
+}
+
+var (
+	benchCorpusOnce sync.Once
+	benchCorpus     *Corpus
+)
+
+// loadBenchCorpus, returns a Corpus of the GOROOT and GOPATH with packages
+// and idents indexed.
+func loadBenchCorpus(b *testing.B) *Corpus {
+	benchCorpusOnce.Do(func() {
+		c := NewCorpus()
+		c.LogEvents = false
+		c.packages = newPackageIndex(c)
+		c.idents = newIndex(c)
+		if err := c.initDirTree(); err != nil {
+			b.Fatal(err)
+		}
+		benchCorpus = c
+	})
+	if len(benchCorpus.Packages()) == 0 {
+		b.Skip("no packages indexed")
+	}
+	return benchCorpus
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	c := loadBenchCorpus(b)
+	b.Run("Serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := marshalPackagesSerial(c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := c.MarshalJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PackagesMatching returns the indexed packages matched by the go command
+// package pattern pattern, sorted by import path then directory.  Patterns
+// are matched against:
+//
+//   - The import path of packages, such as "net/http" or
+//     "golang.org/x/tools/...".
+//   - The directory of packages, if the pattern starts with "./" or "../",
+//     or is an absolute path, such as "./cmd/...".  Relative patterns are
+//     relative to the current working directory.
+//
+// The wildcard "..." matches any string, including the empty string and
+// strings containing slashes, and a trailing "/..." also matches the path
+// before it, so "net/..." matches both "net" and "net/http".  Like the go
+// command, wildcards do not match vendor directories unless the pattern
+// names one, such as "./vendor/...".
+//
+// The patterns "all", "std" and "cmd" match every package, the packages of
+// the standard library and the commands of the Go root and their packages.
+func (c *Corpus) PackagesMatching(pattern string) ([]*Package, error) {
+	if pattern == "" {
+		return nil, errors.New("pkg: empty package pattern")
+	}
+	if c.packages == nil {
+		return nil, nil
+	}
+	var fn func(p *Package) bool
+	switch pattern {
+	case "all":
+		fn = func(p *Package) bool { return true }
+	case "std":
+		fn = func(p *Package) bool { return p.Goroot && !isCmdPath(p.ImportPath) }
+	case "cmd":
+		fn = func(p *Package) bool { return p.Goroot && isCmdPath(p.ImportPath) }
+	default:
+		if isLocalPattern(pattern) {
+			abs, err := filepath.Abs(pattern)
+			if err != nil {
+				return nil, err
+			}
+			match := matchPattern(clean(abs))
+			fn = func(p *Package) bool { return match(p.Dir) }
+		} else {
+			match := matchPattern(pattern)
+			fn = func(p *Package) bool { return match(p.ImportPath) }
+		}
+	}
+	return c.packages.packageList(fn), nil
+}
+
+// isCmdPath, returns if importPath is "cmd" or is under it, which in the Go
+// root are the commands of the go distribution and their packages.
+func isCmdPath(importPath string) bool {
+	return importPath == "cmd" || strings.HasPrefix(importPath, "cmd/")
+}
+
+// isLocalPattern, returns if package pattern pattern names directories
+// instead of import paths: it is "." or "..", starts with "./" or "../" or
+// is an absolute path.
+func isLocalPattern(pattern string) bool {
+	return pattern == "." || pattern == ".." ||
+		strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") ||
+		filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "/")
+}
+
+// vendorChar, replaces the vendor path elements of patterns and paths that
+// wildcards cannot match, see matchPattern.  It is not valid in a path.
+const vendorChar = "\x00"
+
+// matchPattern, returns a function that reports if a slash-separated path
+// is matched by package pattern pattern, see Corpus.PackagesMatching.  The
+// pattern is compiled to a regular expression, which guarantees linear time
+// matching.  To exclude vendor directories, the vendor elements of the
+// pattern and the path are replaced with vendorChar, which "..." does not
+// match.  Adapted from the go command.
+func matchPattern(pattern string) func(path string) bool {
+	if strings.Contains(pattern, vendorChar) {
+		return func(string) bool { return false }
+	}
+	re := regexp.QuoteMeta(pattern)
+	re = replaceVendor(re, vendorChar)
+	switch {
+	case strings.HasSuffix(re, `/`+vendorChar+`/\.\.\.`):
+		re = strings.TrimSuffix(re, `/`+vendorChar+`/\.\.\.`) + `(/vendor|/` + vendorChar + `/\.\.\.)`
+	case re == vendorChar+`/\.\.\.`:
+		re = `(vendor|` + vendorChar + `/\.\.\.)`
+	case strings.HasSuffix(re, `/\.\.\.`):
+		re = strings.TrimSuffix(re, `/\.\.\.`) + `(/\.\.\.)?`
+	}
+	re = strings.Replace(re, `\.\.\.`, `[^`+vendorChar+`]*`, -1)
+	reg := regexp.MustCompile(`^` + re + `$`)
+	return func(path string) bool {
+		if strings.Contains(path, vendorChar) {
+			return false
+		}
+		return reg.MatchString(replaceVendor(path, vendorChar))
+	}
+}
+
+// replaceVendor, replaces the vendor elements of slash-separated path s,
+// other than the last element, with repl.
+func replaceVendor(s, repl string) string {
+	if !strings.Contains(s, "vendor") {
+		return s
+	}
+	elem := strings.Split(s, "/")
+	for i := 0; i < len(elem)-1; i++ {
+		if elem[i] == "vendor" {
+			elem[i] = repl
+		}
+	}
+	return strings.Join(elem, "/")
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		exp     bool
+	}{
+		{"net/http", "net/http", true},
+		{"net/http", "net/http/httptest", false},
+		{"net/...", "net", true},
+		{"net/...", "net/http", true},
+		{"net/...", "netx", false},
+		{"net...", "netx", true},
+		{"net/.../httptest", "net/http/httptest", true},
+		{"net/.../httptest", "net/httptest", false},
+		{".../httptest", "net/http/httptest", true},
+		{"...", "net/http", true},
+		{"...", "", true},
+
+		// Wildcards do not match vendor directories.
+		{"...", "a/vendor/b", false},
+		{"a/...", "a/vendor/b", false},
+		{"a/...", "a/vendor", true},
+		{"a/vendor/...", "a/vendor/b", true},
+		{"a/vendor/...", "a/vendor", true},
+		{"a/vendor/b", "a/vendor/b", true},
+		{"vendor/...", "vendor/b", true},
+		{"a/\x00/...", "a/\x00/b", false},
+	}
+	for _, test := range tests {
+		if got := matchPattern(test.pattern)(test.path); got != test.exp {
+			t.Errorf("matchPattern(%q)(%q): exp: %t got: %t", test.pattern, test.path, test.exp, got)
+		}
+	}
+}
+
+func TestPackagesMatching(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if _, err := f.PackagesMatching("..."); err != nil {
+		t.Errorf("PackagesMatching: uninitialized: %v", err)
+	}
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, f.path("nested"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel = filepath.ToSlash(rel); !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+
+	var all []string
+	for _, p := range f.packages.packageList(func(*Package) bool { return true }) {
+		all = append(all, p.ImportPath)
+	}
+	tests := []struct {
+		pattern string
+		exp     []string
+	}{
+		{"all", all},
+		{"...", []string{"alpha", "beta", "multi", "nested/inner"}},
+		{"nested/...", []string{"nested/inner"}},
+		{"n.../inner", []string{"nested/inner"}},
+		{".../inner", []string{"nested/inner"}},
+		{"beta/...", []string{"beta"}},
+		{"beta/vendor/...", []string{"beta/vendor/vendored"}},
+		{"alpha", []string{"alpha"}},
+		{"missing/...", nil},
+		{"std", nil},
+		{"cmd", nil},
+		{filepath.ToSlash(f.path("nested")) + "/...", []string{"nested/inner"}},
+		{rel + "/...", []string{"nested/inner"}},
+		{rel, nil},
+	}
+	for _, test := range tests {
+		pkgs, err := f.PackagesMatching(test.pattern)
+		if err != nil {
+			t.Errorf("PackagesMatching(%q): %v", test.pattern, err)
+			continue
+		}
+		var got []string
+		for _, p := range pkgs {
+			got = append(got, p.ImportPath)
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("PackagesMatching(%q):\nExp: %q\nGot: %q", test.pattern, test.exp, got)
+		}
+	}
+	if _, err := f.PackagesMatching(""); err == nil {
+		t.Error("PackagesMatching: expected error for empty pattern")
+	}
+}
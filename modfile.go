@@ -0,0 +1,284 @@
+package pkg
+
+// This file contains utilities for reading go.mod files.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"strconv"
+	"strings"
+
+	"github.com/charlievieth/pkg/fs"
+)
+
+// A Module describes the go.mod file of a module.
+type Module struct {
+	Dir       string          // Module root directory, containing go.mod
+	Path      string          // Module path "example.com/mod"
+	GoVersion string          // Go version of the go directive "1.16"
+	Require   []ModuleVersion // Required modules
+	Replace   []ModuleReplace // Replaced modules
+}
+
+// A ModuleVersion is a module path and version.  The version is empty for
+// replacements by a directory, and for replacements of all versions.
+type ModuleVersion struct {
+	Path     string
+	Version  string
+	Indirect bool // Requirement is marked "// indirect"
+}
+
+// A ModuleReplace is a replace directive.  If New.Version is empty New.Path
+// is a directory, which may be relative to the module root.
+type ModuleReplace struct {
+	Old ModuleVersion
+	New ModuleVersion
+}
+
+// Replacement, returns the replacement of module path at version, and
+// reports if the module is replaced.  A replacement of a specific version
+// takes precedence over one of all versions.
+func (m *Module) Replacement(path, version string) (ModuleVersion, bool) {
+	var rep ModuleVersion
+	found := false
+	for _, r := range m.Replace {
+		if r.Old.Path != path {
+			continue
+		}
+		if r.Old.Version == version && version != "" {
+			return r.New, true
+		}
+		if r.Old.Version == "" {
+			rep, found = r.New, true
+		}
+	}
+	return rep, found
+}
+
+// A moduleEntry is a cached go.mod file.
+type moduleEntry struct {
+	info os.FileInfo // FileInfo of go.mod when parsed
+	mod  *Module
+	err  error
+}
+
+// Module returns the go.mod of the module containing directory dir, which is
+// found by searching dir and its parent directories for a go.mod file.  The
+// returned Module is shared and must not be modified.
+//
+// Parsed go.mod files are cached by module root and re-parsed when they
+// change.  Module requires ModuleMode.
+func (c *Corpus) Module(dir string) (*Module, error) {
+	if !c.ModuleMode {
+		return nil, errors.New("pkg: module mode is disabled (see Corpus.ModuleMode)")
+	}
+	if dir == "" {
+		return nil, errors.New("pkg: empty directory")
+	}
+	root, fi := findModuleRoot(clean(dir))
+	if root == "" {
+		return nil, fmt.Errorf("pkg: cannot find go.mod for directory %q", dir)
+	}
+
+	c.mu.RLock()
+	e := c.modules[root]
+	c.mu.RUnlock()
+	if e != nil && fs.SameFile(e.info, fi) {
+		return e.mod, e.err
+	}
+
+	e = &moduleEntry{info: fi}
+	name := pathpkg.Join(root, "go.mod")
+	data, err := fs.ReadFile(name)
+	if err == nil {
+		e.mod, err = parseModFile(name, data)
+	}
+	if err != nil {
+		// Cache the error, it is returned until go.mod changes.
+		e.mod, e.err = nil, err
+	} else {
+		e.mod.Dir = root
+	}
+	c.mu.Lock()
+	if c.modules == nil {
+		c.modules = make(map[string]*moduleEntry)
+	}
+	c.modules[root] = e
+	c.mu.Unlock()
+	return e.mod, e.err
+}
+
+// findModuleRoot, returns the first of directory dir and its parents that
+// contains a go.mod file, and the FileInfo of the go.mod file.
+func findModuleRoot(dir string) (string, os.FileInfo) {
+	for {
+		if fi, err := fs.Stat(pathpkg.Join(dir, "go.mod")); err == nil && fi.Mode().IsRegular() {
+			return dir, fi
+		}
+		parent := pathpkg.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// parseModFile, parses the go.mod file data, name is used for errors.  Only
+// the module, go, require and replace directives are parsed, other
+// directives are ignored.
+func parseModFile(name string, data []byte) (*Module, error) {
+	m := &Module{}
+	block := "" // directive of the current block, if any
+	for i, line := range strings.Split(string(data), "\n") {
+		comment := ""
+		if j := strings.Index(line, "//"); j != -1 {
+			line, comment = line[:j], strings.TrimSpace(line[j+2:])
+		}
+		f, err := modFields(line)
+		if err == nil && len(f) != 0 {
+			switch {
+			case block != "" && len(f) == 1 && f[0] == ")":
+				block = ""
+			case block != "":
+				err = m.addDirective(block, f, comment)
+			case len(f) == 2 && f[1] == "(":
+				block = f[0]
+			default:
+				err = m.addDirective(f[0], f[1:], comment)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, i+1, err)
+		}
+	}
+	if block != "" {
+		return nil, fmt.Errorf("%s: unterminated %s block", name, block)
+	}
+	return m, nil
+}
+
+// addDirective, adds the directive verb with arguments args to m, comment is
+// the text of the line comment, if any.
+func (m *Module) addDirective(verb string, args []string, comment string) error {
+	switch verb {
+	case "module":
+		if len(args) != 1 {
+			return errors.New("usage: module module/path")
+		}
+		m.Path = args[0]
+	case "go":
+		if len(args) != 1 {
+			return errors.New("usage: go 1.23")
+		}
+		m.GoVersion = args[0]
+	case "require":
+		if len(args) != 2 {
+			return errors.New("usage: require module/path v1.2.3")
+		}
+		m.Require = append(m.Require, ModuleVersion{
+			Path:     args[0],
+			Version:  args[1],
+			Indirect: comment == "indirect" || strings.HasPrefix(comment, "indirect;"),
+		})
+	case "replace":
+		i := 0
+		for i < len(args) && args[i] != "=>" {
+			i++
+		}
+		old, rep := args[:i], []string(nil)
+		if i < len(args) {
+			rep = args[i+1:]
+		}
+		if len(old) < 1 || len(old) > 2 || len(rep) < 1 || len(rep) > 2 {
+			return errors.New("usage: replace module/path [v1.2.3] => other/module v1.4\n" +
+				"\t or replace module/path [v1.2.3] => ../local/directory")
+		}
+		var r ModuleReplace
+		r.Old.Path = old[0]
+		if len(old) == 2 {
+			r.Old.Version = old[1]
+		}
+		r.New.Path = rep[0]
+		if len(rep) == 2 {
+			r.New.Version = rep[1]
+		}
+		m.Replace = append(m.Replace, r)
+	}
+	return nil
+}
+
+// modFields, splits a go.mod line into fields, unquoting quoted fields.
+func modFields(line string) ([]string, error) {
+	var f []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" {
+			return f, nil
+		}
+		var s string
+		switch q := line[0]; q {
+		case '"', '`':
+			i := 1
+			for i < len(line) && line[i] != q {
+				if q == '"' && line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(line) {
+				return nil, errors.New("unterminated quoted string")
+			}
+			var err error
+			if s, err = strconv.Unquote(line[:i+1]); err != nil {
+				return nil, err
+			}
+			line = line[i+1:]
+		default:
+			i := strings.IndexAny(line, " \t\r")
+			if i == -1 {
+				i = len(line)
+			}
+			s, line = line[:i], line[i:]
+		}
+		f = append(f, s)
+	}
+}
+
+// goModInfo, returns the FileInfo of the go.mod file in the directory listing
+// list, or nil if there is none.
+func goModInfo(list []os.FileInfo) os.FileInfo {
+	for _, fi := range list {
+		if fi.Name() == "go.mod" && fi.Mode().IsRegular() {
+			return fi
+		}
+	}
+	return nil
+}
+
+// statGoMod, returns the FileInfo of the go.mod file in directory dir, or nil
+// if there is none.
+func statGoMod(dir string) os.FileInfo {
+	fi, err := fs.Stat(pathpkg.Join(dir, "go.mod"))
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	return fi
+}
+
+// readModulePath, returns the module path declared by the go.mod file in
+// directory dir, or an empty string if it cannot be read or parsed, see
+// parseModFile.
+func readModulePath(dir string) string {
+	name := pathpkg.Join(dir, "go.mod")
+	data, err := fs.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	m, err := parseModFile(name, data)
+	if err != nil {
+		return ""
+	}
+	return m.Path
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadModulePath(t *testing.T) {
+	tests := []struct {
+		data, path string
+	}{
+		{"module example.com/m\n", "example.com/m"},
+		{"module example.com/m", "example.com/m"},
+		{"\n// module example.com/comment\nmodule   example.com/m // comment\n", "example.com/m"},
+		{"module \"example.com/quoted\"\n", "example.com/quoted"},
+		{"module `example.com/raw`\n", "example.com/raw"},
+		{"module \"example.com/bad\n", ""},
+		{"go 1.16\nrequire example.com/r v1.0.0\n", ""},
+		{"require (\n\texample.com/r v1.0.0\n)\nmodule example.com/m\n", "example.com/m"},
+		{"", ""},
+	}
+	dir, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, x := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(x.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if path := readModulePath(filepath.ToSlash(dir)); path != x.path {
+			t.Errorf("readModulePath(%q) = %q; want: %q", x.data, path, x.path)
+		}
+	}
+	if path := readModulePath(filepath.ToSlash(filepath.Join(dir, "missing"))); path != "" {
+		t.Errorf("readModulePath: missing go.mod: %q", path)
+	}
+}
+
+const testModFile = `// Module comment.
+module example.com/mod
+
+go 1.16
+
+require example.com/a v1.0.0
+
+require (
+	example.com/b v1.2.3 // indirect
+	"example.com/c" v0.1.0 // comment
+)
+
+replace example.com/a => ../a
+
+replace (
+	example.com/b v1.2.3 => example.com/fork/b v1.2.4
+	example.com/b => example.com/fork/b v1.3.0
+)
+
+exclude example.com/c v0.0.1
+retract v0.9.0
+`
+
+func TestParseModFile(t *testing.T) {
+	m, err := parseModFile("go.mod", []byte(testModFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &Module{
+		Path:      "example.com/mod",
+		GoVersion: "1.16",
+		Require: []ModuleVersion{
+			{Path: "example.com/a", Version: "v1.0.0"},
+			{Path: "example.com/b", Version: "v1.2.3", Indirect: true},
+			{Path: "example.com/c", Version: "v0.1.0"},
+		},
+		Replace: []ModuleReplace{
+			{
+				Old: ModuleVersion{Path: "example.com/a"},
+				New: ModuleVersion{Path: "../a"},
+			},
+			{
+				Old: ModuleVersion{Path: "example.com/b", Version: "v1.2.3"},
+				New: ModuleVersion{Path: "example.com/fork/b", Version: "v1.2.4"},
+			},
+			{
+				Old: ModuleVersion{Path: "example.com/b"},
+				New: ModuleVersion{Path: "example.com/fork/b", Version: "v1.3.0"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Errorf("parseModFile:\nExp: %+v\nGot: %+v", exp, m)
+	}
+
+	replacements := []struct {
+		path, version string
+		rep           ModuleVersion
+		ok            bool
+	}{
+		{"example.com/a", "v1.0.0", ModuleVersion{Path: "../a"}, true},
+		{"example.com/b", "v1.2.3", ModuleVersion{Path: "example.com/fork/b", Version: "v1.2.4"}, true},
+		{"example.com/b", "v1.2.0", ModuleVersion{Path: "example.com/fork/b", Version: "v1.3.0"}, true},
+		{"example.com/c", "v0.1.0", ModuleVersion{}, false},
+	}
+	for _, x := range replacements {
+		rep, ok := m.Replacement(x.path, x.version)
+		if rep != x.rep || ok != x.ok {
+			t.Errorf("Replacement(%q, %q) = %+v, %t; want: %+v, %t",
+				x.path, x.version, rep, ok, x.rep, x.ok)
+		}
+	}
+
+	for _, data := range []string{
+		"module\n",
+		"require example.com/a\n",
+		"replace example.com/a v1.0.0\n",
+		"require (\nexample.com/a v1.0.0\n",
+		"module \"example.com/bad\n",
+	} {
+		if _, err := parseModFile("go.mod", []byte(data)); err == nil {
+			t.Errorf("parseModFile(%q): expected error", data)
+		}
+	}
+}
+
+func TestModule(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "mod/go.mod", testModFile)
+	f.write(t, "mod/pkg/pkg.go", "package pkg\n")
+	f.write(t, "mod/sub/go.mod", "module example.com/mod/sub\n\ngo 1.18\n")
+
+	if _, err := f.Module(f.path("mod")); err == nil {
+		t.Error("Module: expected error when ModuleMode is disabled")
+	}
+	f.ModuleMode = true
+
+	m, err := f.Module(f.path("mod/pkg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Dir != clean(f.path("mod")) || m.Path != "example.com/mod" || len(m.Require) != 3 {
+		t.Errorf("Module: mod/pkg: %+v", m)
+	}
+	if m2, _ := f.Module(f.path("mod")); m2 != m {
+		t.Error("Module: mod: result not cached")
+	}
+
+	sub, err := f.Module(f.path("mod/sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Path != "example.com/mod/sub" || sub.GoVersion != "1.18" {
+		t.Errorf("Module: mod/sub: %+v", sub)
+	}
+
+	// Changes to go.mod are detected.
+	f.write(t, "mod/sub/go.mod", "module example.com/mod/sub/v2\n\ngo 1.20\n\nrequire example.com/x v1.0.0\n")
+	sub, err = f.Module(f.path("mod/sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Path != "example.com/mod/sub/v2" || sub.GoVersion != "1.20" {
+		t.Errorf("Module: mod/sub: not updated: %+v", sub)
+	}
+
+	f.write(t, "mod/sub/go.mod", "module\n")
+	if _, err := f.Module(f.path("mod/sub")); err == nil {
+		t.Error("Module: expected parse error")
+	}
+	if _, err := f.Module(f.path("alpha")); err == nil {
+		t.Error("Module: expected error for directory outside of a module")
+	}
+}
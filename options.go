@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"go/build"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// An Option, configures a Corpus created by New.
+type Option func(*Corpus)
+
+// New, returns a new Corpus for the current GOROOT and GOPATH configured by
+// opts, which are applied in order.  Unlike setting the fields of the
+// Corpus returned by NewCorpus, the Corpus is fully configured before it is
+// returned, so it is safe to share once Init is called.
+func New(opts ...Option) *Corpus {
+	c := newCorpus()
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithContext, sets the build.Context of the Corpus to a copy of ctxt, see
+// Corpus.SetContext.  If ctxt is nil build.Default is used.
+func WithContext(ctxt *build.Context) Option {
+	return func(c *Corpus) {
+		c.ctxt.SetContext(ctxt)
+	}
+}
+
+// WithRoots, sets the source root directories indexed by the Corpus, see
+// Corpus.SetRoots.
+func WithRoots(roots []string) Option {
+	return func(c *Corpus) {
+		c.ctxt.SetSrcDirs(roots)
+	}
+}
+
+// WithMaxDepth, sets the maximum depth of the directory trees walked by the
+// Corpus, see Corpus.MaxDepth.
+func WithMaxDepth(depth int) Option {
+	return func(c *Corpus) {
+		c.MaxDepth = depth
+	}
+}
+
+// WithIndexGoCode, sets if the idents of packages are indexed, see
+// Corpus.IndexGoCode.
+func WithIndexGoCode(index bool) Option {
+	return func(c *Corpus) {
+		c.IndexGoCode = index
+	}
+}
+
+// WithLogger, sets the logger of the Corpus, which defaults to standard
+// output.  If logger is nil nothing is logged.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Corpus) {
+		if logger == nil {
+			logger = log.New(ioutil.Discard, "", 0)
+		}
+		c.log = logger
+	}
+}
+
+// WithIndexInterval, sets the interval at which the index is polled for
+// changes, see Corpus.IndexInterval.
+func WithIndexInterval(d time.Duration) Option {
+	return func(c *Corpus) {
+		c.IndexInterval = d
+	}
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewDefaults(t *testing.T) {
+	c, d := New(), NewCorpus()
+	if c.MaxDepth != d.MaxDepth || c.IndexGoCode != d.IndexGoCode ||
+		c.IndexInterval != d.IndexInterval || c.IndexFileInfo != d.IndexFileInfo {
+		t.Errorf("New: defaults differ from NewCorpus:\nExp: %+v\nGot: %+v", d, c)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkg-options-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "gopath", "src")
+	writeTestFiles(t, root, fixtureFiles)
+
+	ctxt := build.Default
+	ctxt.GOOS = "plan9"
+	var buf bytes.Buffer
+	c := New(
+		WithContext(&ctxt),
+		WithRoots([]string{root}),
+		WithMaxDepth(2),
+		WithIndexGoCode(false),
+		WithLogger(log.New(&buf, "", 0)),
+		WithIndexInterval(time.Hour),
+	)
+	if c.MaxDepth != 2 || c.IndexGoCode || c.IndexInterval != time.Hour {
+		t.Errorf("New: options not applied: %+v", c)
+	}
+	if goos := c.ctxt.Snapshot().GOOS; goos != "plan9" {
+		t.Errorf("WithContext: GOOS: exp: %q got: %q", "plan9", goos)
+	}
+	if dirs := c.ctxt.SrcDirs(); !reflect.DeepEqual(dirs, []string{root}) {
+		t.Errorf("WithRoots: exp: %q got: %q", []string{root}, dirs)
+	}
+
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+	if c.idents != nil {
+		t.Error("WithIndexGoCode: idents indexed")
+	}
+	if _, ok := c.packages.lookupPath(filepath.Join(root, "alpha")); !ok {
+		t.Error("New: missing package: alpha")
+	}
+	// Packages deeper than MaxDepth are not indexed.
+	if _, ok := c.packages.lookupPath(filepath.Join(root, "nested", "inner")); ok {
+		t.Error("WithMaxDepth: indexed package: nested/inner")
+	}
+	if buf.Len() == 0 {
+		t.Error("WithLogger: nothing logged")
+	}
+
+	// A nil logger discards output.
+	c = New(WithLogger(nil))
+	c.log.Println("discarded")
+}
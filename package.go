@@ -6,20 +6,51 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"hash/fnv"
 	"os"
 	pathpkg "path"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/charlievieth/pkg/fs"
 	"github.com/charlievieth/pkg/util"
 )
 
 type File struct {
-	Name string      // file name
-	Path string      // absolute file path
-	Info os.FileInfo // file info, used for updating
+	Name          string      // file name
+	Path          string      // absolute file path
+	Info          os.FileInfo // file info, used for updating
+	imports       []string    // import paths, only set for buildable and test Go files
+	goVersion     int         // N of the "go1.N" build constraint, only set for buildable Go files
+	reason        string      // why the file is excluded, only set for ignored and invalid Go files
+	importComment string      // path of the import comment, only set for buildable Go files
+	xtest         bool        // declares the external "_test" package, only set for test Go files
+	doc           string      // synopsis of the package doc comment, only set for buildable Go files
+	hash          uint64      // FNV-1a hash of the contents, only set if Corpus.VerifyContent
+}
+
+// hashFile, returns the FNV-1a hash of the contents of the file at path.
+func hashFile(path string) (uint64, error) {
+	b, err := fs.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64(), nil
+}
+
+// An IgnoredFile is a Go file excluded by the build context, or a file that
+// is not Go source, see Package.IgnoredFiles and Package.InvalidGoFiles.
+type IgnoredFile struct {
+	File
+	Reason string // Why the file is excluded, such as its build constraint
 }
 
 // TODO: Remove if unused.
@@ -56,7 +87,19 @@ type byFileName []File
 
 func (f byFileName) Len() int           { return len(f) }
 func (f byFileName) Less(i, j int) bool { return f[i].Name < f[j].Name }
-func (f byFileName) Swap(i, j int)      { f[i].Name, f[j].Name = f[j].Name, f[i].Name }
+func (f byFileName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// byImportPath, sorts Packages by ImportPath then Dir.
+type byImportPath []*Package
+
+func (b byImportPath) Len() int      { return len(b) }
+func (b byImportPath) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byImportPath) Less(i, j int) bool {
+	if b[i].ImportPath != b[j].ImportPath {
+		return b[i].ImportPath < b[j].ImportPath
+	}
+	return b[i].Dir < b[j].Dir
+}
 
 // A FileMap is a map of related files.
 type FileMap map[string]File
@@ -103,15 +146,40 @@ func (m FileMap) appendFilePaths(s []string) []string {
 	return s
 }
 
+func (m FileMap) appendImports(s []string) []string {
+	for _, f := range m {
+		s = append(s, f.imports...)
+	}
+	return s
+}
+
 // removeNotSeen, removes files not present in sorted slice seen.
 func (m FileMap) removeNotSeen(seen []string) {
 	for name, file := range m {
-		if sort.SearchStrings(seen, file.Name) == len(seen) {
+		if i := sort.SearchStrings(seen, file.Name); i == len(seen) || seen[i] != file.Name {
 			delete(m, name)
 		}
 	}
 }
 
+// equal, reports whether FileMaps m and n contain the same files, see
+// Package.Equal for more information.
+func (m FileMap) equal(n FileMap) bool {
+	if len(m) != len(n) {
+		return false
+	}
+	for name, f := range m {
+		g, ok := n[name]
+		if !ok || f.Path != g.Path {
+			return false
+		}
+		if f.Info != nil && g.Info != nil && !fs.SameFile(f.Info, g.Info) {
+			return false
+		}
+	}
+	return true
+}
+
 // first, returns the first File from the map, note this is not guaranteed to
 // be the first file added.
 func (m FileMap) first() File {
@@ -147,33 +215,212 @@ func (t GoFileType) String() string {
 	return "Invalid"
 }
 
+// An ImportMode controls how much of a package is indexed.
+type ImportMode int
+
+const (
+	// FindPackageFiles, classifies each Go file of a package by type and
+	// records the imports of its buildable Go files.  This is the default.
+	FindPackageFiles ImportMode = iota
+
+	// FindPackageName, only finds the name of a package by parsing the
+	// package clause of its Go files.  Files are not classified by type.
+	FindPackageName
+)
+
+var importModeStr = [...]string{
+	FindPackageFiles: "FindPackageFiles",
+	FindPackageName:  "FindPackageName",
+}
+
+func (m ImportMode) String() string {
+	if 0 <= m && int(m) < len(importModeStr) {
+		return importModeStr[m]
+	}
+	return "Invalid"
+}
+
 // A Package describes a Go package or command.
+//
+// LastIndexed is the time the package was last indexed or checked for
+// changes, by Init, an update or ImportDir, whether or not it changed.  It
+// shows how stale the index of the package may be, such as between the
+// refreshes of RefreshSignal mode.
 type Package struct {
-	Dir        string                 // Directory path "$GOROOT/src/net/http"
-	Name       string                 // Package name "http"
-	ImportPath string                 // Import path of package "net/http"
-	Root       string                 // Root of Go tree where this package lives
-	SrcRoot    string                 // package source root directory
-	Goroot     bool                   // Package found in Go root
-	Installed  bool                   // True if the package or command is installed
-	Info       os.FileInfo            // File info as of last update
-	files      map[GoFileType]FileMap // Go source files indexed by type
-	err        error                  // Either NoGoError of MultiplePackageError
-}
-
-// Error, returns either NoGoError or MultiplePackageError.
+	Dir           string                 // Directory path "$GOROOT/src/net/http"
+	Name          string                 // Package name "http"
+	ImportPath    string                 // Import path of package "net/http"
+	Root          string                 // Root of Go tree where this package lives
+	SrcRoot       string                 // package source root directory
+	Goroot        bool                   // Package found in Go root
+	ModCache      bool                   // Package found in the (read-only) module cache
+	Installed     bool                   // True if the package or command is installed
+	BinaryOnly    bool                   // Package only exists as an installed archive, see Corpus.AllowBinary
+	ImportComment string                 // Import path of the import comment "// import \"net/http\""
+	Info          os.FileInfo            // File info as of last update
+	LastIndexed   time.Time              // Time the package was last indexed
+	files         map[GoFileType]FileMap // Go source files indexed by type
+	other         FileMap                // Files matched by Corpus.ExtraFileFilter
+	invalid       FileMap                // Go files that are not Go source, see InvalidGoFiles
+	mode          ImportMode             // Mode the package was indexed with
+	err           error                  // Either NoBuildableGoError, MultiplePackageError or ImportCommentError
+	parseErrs     fileErrors             // Go files that failed to parse, they are not indexed
+	sorted        atomic.Value           // *fileCache of files, replaced when files change
+}
+
+// A fileCache, is the files of a Package sorted by name.  Since sorting the
+// files accounts for most of the cost of Files, FileNames and FilePaths the
+// sorted files are cached until the files of the package change.
+type fileCache struct {
+	files []typedFile
+	valid bool // false for the markers stored by invalidateFiles
+}
+
+// A typedFile, is a File and its GoFileType.
+type typedFile struct {
+	File
+	typ GoFileType
+}
+
+// sortedFiles, returns the files of the package sorted by name.
+func (p *Package) sortedFiles() []typedFile {
+	old := p.sorted.Load()
+	if fc, _ := old.(*fileCache); fc != nil && fc.valid {
+		return fc.files
+	}
+	fc := &fileCache{
+		files: make([]typedFile, 0, p.fileLen(-1)),
+		valid: true,
+	}
+	for t, m := range p.files {
+		for _, f := range m {
+			fc.files = append(fc.files, typedFile{File: f, typ: t})
+		}
+	}
+	sort.Slice(fc.files, func(i, j int) bool {
+		return fc.files[i].Name < fc.files[j].Name
+	})
+	// If the files changed while sorting, invalidateFiles replaced old
+	// and the stale result is not cached.
+	p.sorted.CompareAndSwap(old, fc)
+	return fc.files
+}
+
+// invalidateFiles, invalidates the sorted files of the package.  Call after
+// modifying the files.  A new marker is stored each time, so that results
+// computed before the modification are not cached.
+func (p *Package) invalidateFiles() {
+	p.sorted.Store(&fileCache{})
+}
+
+// matchFiles, returns the number of files in sorted files s that match
+// GoFileType typ.
+func matchFiles(s []typedFile, typ GoFileType) int {
+	n := 0
+	for _, f := range s {
+		if typ < 0 || f.typ&typ != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Error, returns either NoBuildableGoError, MultiplePackageError or
+// ImportCommentError.  Packages with a NoBuildableGoError are still indexed,
+// their name is found from the Go files excluded by build constraints.
 func (p *Package) Error() error {
 	return p.err
 }
 
+// indexError, returns the error encountered indexing the package, if any.
+// Unlike Error, a NoBuildableGoError is not considered an error, since the
+// package is still indexed.  The errors of Go files that failed to parse are
+// returned if the package has no other error.
+func (p *Package) indexError() error {
+	if p.err != nil && !IsNoBuildableGo(p.err) {
+		return p.err
+	}
+	if len(p.parseErrs) != 0 {
+		return p.parseErrs
+	}
+	return nil
+}
+
+// Mode, returns the ImportMode the package was indexed with, which reports
+// whether its files were classified or only its name was found.
+func (p *Package) Mode() ImportMode {
+	return p.mode
+}
+
+// Equal, reports whether packages p and q describe the same package.  The
+// scalar fields of p and q are compared along with their Go files, which are
+// compared by type and name.  If both packages, or both Files, have a FileInfo
+// they are compared by name, size and modification time.
+//
+// The package error, LastIndexed and the identity of the FileInfo values are
+// ignored.
+func (p *Package) Equal(q *Package) bool {
+	if p == nil || q == nil {
+		return p == q
+	}
+	if p.Dir != q.Dir || p.Name != q.Name || p.ImportPath != q.ImportPath ||
+		p.Root != q.Root || p.SrcRoot != q.SrcRoot || p.Goroot != q.Goroot ||
+		p.ModCache != q.ModCache || p.Installed != q.Installed || p.mode != q.mode ||
+		p.BinaryOnly != q.BinaryOnly || p.ImportComment != q.ImportComment {
+		return false
+	}
+	if p.Info != nil && q.Info != nil && !fs.SameFile(p.Info, q.Info) {
+		return false
+	}
+	for _, typ := range [...]GoFileType{IgnoredGoFile, TestGoFile, GoFile} {
+		if !p.files[typ].equal(q.files[typ]) {
+			return false
+		}
+	}
+	return p.other.equal(q.other) && p.invalid.equal(q.invalid)
+}
+
+// relPath, returns the path of the package directory relative to its source
+// root, which is the key used by the PackageIndex.  This is the same as the
+// import path, except for packages in the module cache.
+func (p *Package) relPath() string {
+	return trimPathPrefix(p.Dir, p.SrcRoot)
+}
+
 // IsCommand reports whether the package is considered a command to be installed
 // (not just a library). Packages named "main" are treated as commands.
 func (p *Package) IsCommand() bool {
 	return p.Name == "main"
 }
 
+// BinaryPath returns the path of the binary installed for command p,
+// "$GOPATH/bin/name" or "$GOROOT/bin/name", and if the binary exists.  If p
+// is not a command an empty string and false are returned.
+//
+// If c is not nil, the GOOS and GOARCH of its build context are used to find
+// the binaries of cross-compiled commands, which are installed to
+// "bin/GOOS_GOARCH".
+func (p *Package) BinaryPath(c *Context) (string, bool) {
+	if !p.IsCommand() || p.Root == "" || p.ImportPath == "" {
+		return "", false
+	}
+	dir := pathpkg.Join(p.Root, "bin")
+	name := pathpkg.Base(p.ImportPath)
+	if c != nil {
+		ctxt := c.Snapshot()
+		if ctxt.GOOS != runtime.GOOS || ctxt.GOARCH != runtime.GOARCH {
+			dir = pathpkg.Join(dir, ctxt.GOOS+"_"+ctxt.GOARCH)
+		}
+		if ctxt.GOOS == "windows" {
+			name += ".exe"
+		}
+	}
+	path := pathpkg.Join(dir, name)
+	return path, fs.IsFile(path)
+}
+
 func (p *Package) IsValid() bool {
-	return p.Name != "" && p.isPkgDir()
+	return p.Name != "" && (p.isPkgDir() || p.BinaryOnly)
 }
 
 // GoFiles, returns a slice of buildable Go source files in the package.
@@ -181,6 +428,98 @@ func (p *Package) GoFiles() []string {
 	return p.files[GoFile].FileNames()
 }
 
+// GoFilesWithTests, returns the buildable Go source files of the package and
+// its in-package test files, those that do not declare the external "_test"
+// package, sorted by name.  These are the files compiled for the package by
+// a test build.
+func (p *Package) GoFilesWithTests() []string {
+	s := p.files[GoFile].appendFileNames(nil)
+	for _, f := range p.files[TestGoFile] {
+		if !f.xtest {
+			s = append(s, f.Name)
+		}
+	}
+	sort.Strings(s)
+	return s
+}
+
+// XTestGoFiles, returns the test files of the package that declare the
+// external "_test" package, sorted by name.  Test files are not checked
+// against the build context.
+func (p *Package) XTestGoFiles() []string {
+	var s []string
+	for _, f := range p.files[TestGoFile] {
+		if f.xtest {
+			s = append(s, f.Name)
+		}
+	}
+	sort.Strings(s)
+	return s
+}
+
+// Synopsis, returns the synopsis of the package documentation: the first
+// sentence of the package doc comment of the first buildable Go file, by
+// name, that has one.  Like go/build, other doc comments are ignored.
+func (p *Package) Synopsis() string {
+	for _, f := range p.sortedFiles() {
+		if f.typ == GoFile && f.doc != "" {
+			return f.doc
+		}
+	}
+	return ""
+}
+
+// IgnoredFiles, returns the Go files of the package excluded by the build
+// context, sorted by name, along with the reason each file is excluded: the
+// GOOS/GOARCH suffix of its name, an unsatisfied build constraint or an
+// error reading the file.
+func (p *Package) IgnoredFiles() []IgnoredFile {
+	files := p.files[IgnoredGoFile].Files()
+	if len(files) == 0 {
+		return nil
+	}
+	s := make([]IgnoredFile, len(files))
+	for i, f := range files {
+		s[i] = IgnoredFile{File: f, Reason: f.reason}
+	}
+	return s
+}
+
+// InvalidGoFiles, returns the files of the package with a Go file name that
+// are not Go source, such as binary files, sorted by name, along with the
+// reason each file is invalid.  Invalid files are not parsed.
+func (p *Package) InvalidGoFiles() []IgnoredFile {
+	files := p.invalid.Files()
+	if len(files) == 0 {
+		return nil
+	}
+	s := make([]IgnoredFile, len(files))
+	for i, f := range files {
+		s[i] = IgnoredFile{File: f, Reason: f.reason}
+	}
+	return s
+}
+
+// MinGoVersion, returns the lowest Go release, such as "go1.21", required by
+// the build constraints of the buildable Go files of the package, or an empty
+// string if none of the files require one.  Since release tags are satisfied
+// by all later releases, this is the highest "go1.N" constraint of the files.
+//
+// Constraints are read when files are parsed, files that become buildable
+// when the build context changes are not re-parsed and are not considered.
+func (p *Package) MinGoVersion() string {
+	n := 0
+	for _, f := range p.files[GoFile] {
+		if f.goVersion > n {
+			n = f.goVersion
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return "go1." + strconv.Itoa(n)
+}
+
 func (p *Package) LookupFile(name string) (File, bool) {
 	for _, m := range p.files {
 		if m == nil {
@@ -207,42 +546,108 @@ func (p *Package) fileLen(typ GoFileType) int {
 // File, returns the files that match GoFileType typ.
 // If GoFileType typ is less than zero all files are matched.
 func (p *Package) Files(typ GoFileType) []File {
-	s := make([]File, 0, p.fileLen(typ))
-	for t, m := range p.files {
-		if typ < 0 || t&typ != 0 {
-			s = m.appendFiles(s)
+	files := p.sortedFiles()
+	s := make([]File, 0, matchFiles(files, typ))
+	for _, f := range files {
+		if typ < 0 || f.typ&typ != 0 {
+			s = append(s, f.File)
 		}
 	}
-	sort.Sort(byFileName(s))
 	return s
 }
 
 // FileNames, returns the names of files that match GoFileType typ.
 // If GoFileType typ is less than zero all files are matched.
 func (p *Package) FileNames(typ GoFileType) []string {
-	s := make([]string, 0, p.fileLen(typ))
-	for t, m := range p.files {
-		if typ < 0 || t&typ != 0 {
-			s = m.appendFileNames(s)
+	files := p.sortedFiles()
+	s := make([]string, 0, matchFiles(files, typ))
+	for _, f := range files {
+		if typ < 0 || f.typ&typ != 0 {
+			s = append(s, f.Name)
 		}
 	}
-	sort.Strings(s)
 	return s
 }
 
 // FilePaths, returns the paths of files that match GoFileType typ.
 // If GoFileType typ is less than zero all files are matched.
 func (p *Package) FilePaths(typ GoFileType) []string {
-	s := make([]string, 0, p.fileLen(typ))
-	for t, m := range p.files {
-		if typ < 0 || t&typ != 0 {
-			s = m.appendFilePaths(s)
+	files := p.sortedFiles()
+	s := make([]string, 0, matchFiles(files, typ))
+	for _, f := range files {
+		if typ < 0 || f.typ&typ != 0 {
+			s = append(s, f.Path)
 		}
 	}
-	sort.Strings(s)
 	return s
 }
 
+// OtherFiles, returns the files of the package matched by the ExtraFileFilter
+// of the Corpus, and its assembly files if IndexAsm is enabled, sorted by
+// name.  Other files are not parsed, but are updated along with the package.
+func (p *Package) OtherFiles() []File {
+	s := p.other.Files()
+	sort.Sort(byFileName(s))
+	return s
+}
+
+// asmFiles, returns the assembly files of the package, sorted by name.  Only
+// recorded if IndexAsm is enabled.
+func (p *Package) asmFiles() []File {
+	var s []File
+	for _, f := range p.other {
+		if isAsmFile(f.Name) {
+			s = append(s, f)
+		}
+	}
+	sort.Sort(byFileName(s))
+	return s
+}
+
+// numAsmFiles, returns the number of assembly files of the package.
+func (p *Package) numAsmFiles() int {
+	n := 0
+	for name := range p.other {
+		if isAsmFile(name) {
+			n++
+		}
+	}
+	return n
+}
+
+// Imports, returns the sorted import paths imported by the buildable Go
+// files of the package.  Imports are not recorded in FindPackageName mode.
+func (p *Package) Imports() []string {
+	return p.importPaths()
+}
+
+// AllImports, is like Imports but also includes the imports of the test Go
+// files of the package, including those of the external "_test" package.
+func (p *Package) AllImports() []string {
+	return p.importPaths(TestGoFile)
+}
+
+// importPaths, returns the sorted, de-duplicated import paths of the
+// package's buildable Go files and of its files of the other types.
+func (p *Package) importPaths(other ...GoFileType) []string {
+	s := p.files[GoFile].appendImports(nil)
+	for _, typ := range other {
+		s = p.files[typ].appendImports(s)
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	sort.Strings(s)
+	n := 1
+	for i := 1; i < len(s); i++ {
+		if s[i] != s[n-1] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	return s[:n]
+}
+
 func (p *Package) addFile(typ GoFileType, f File) {
 	if p.files == nil {
 		p.files = make(map[GoFileType]FileMap)
@@ -256,16 +661,122 @@ func (p *Package) addFile(typ GoFileType, f File) {
 			delete(m, f.Name)
 		}
 	}
+	delete(p.invalid, f.Name)
+	p.invalidateFiles()
+}
+
+// addInvalidFile, adds File f, which is not Go source, to the invalid files
+// of the package and removes it from the Go files.
+func (p *Package) addInvalidFile(f File) {
+	if p.invalid == nil {
+		p.invalid = make(FileMap)
+	}
+	p.invalid[f.Name] = f
+	for _, m := range p.files {
+		delete(m, f.Name)
+	}
+	p.invalidateFiles()
+}
+
+// addInvalidGoFile, adds File f to the invalid files of the package and
+// reports true if err, returned by parseFile, reports that f is not Go source.
+func (p *Package) addInvalidGoFile(f File, err error) bool {
+	e, ok := err.(*invalidGoFileError)
+	if ok {
+		p.addInvalidFile(File{Name: f.Name, Path: f.Path, Info: f.Info, reason: e.reason})
+	}
+	return ok
+}
+
+// setFile, replaces File f, which must be in the package, without changing
+// its type.
+func (p *Package) setFile(f File) {
+	for _, m := range p.files {
+		if _, ok := m[f.Name]; ok {
+			m[f.Name] = f
+			p.invalidateFiles()
+			return
+		}
+	}
 }
 
 func (p *Package) removeFile(name string) {
 	for _, m := range p.files {
 		delete(m, name)
 	}
+	delete(p.invalid, name)
+	p.invalidateFiles()
+}
+
+// setNoBuildableError, sets the error of package p to a NoBuildableGoError
+// if all of its non-test Go files are excluded by build constraints, or
+// clears a previously set NoBuildableGoError if they no longer are.  Other
+// errors are not replaced.
+func (p *Package) setNoBuildableError() {
+	if len(p.files[GoFile]) == 0 && len(p.files[IgnoredGoFile]) != 0 {
+		if p.err == nil || IsNoBuildableGo(p.err) {
+			p.err = &NoBuildableGoError{
+				Dir:     p.Dir,
+				Ignored: p.files[IgnoredGoFile].FileNames(),
+			}
+		}
+	} else if IsNoBuildableGo(p.err) {
+		p.err = nil
+	}
+}
+
+// setImportComment, sets the ImportComment of package p from its buildable
+// Go files and sets the error of p to an ImportCommentError if the comments
+// of the files conflict, in which case the comment of the first file by name
+// is used, or if the comment does not match the import path of p.  Like the
+// go command, the import path is not checked in module mode or for packages
+// in the module cache or vendor directories.  A previously set
+// ImportCommentError is cleared, other errors are not replaced.
+func (p *Package) setImportComment(moduleMode bool) {
+	if IsImportComment(p.err) {
+		p.err = nil
+	}
+	p.ImportComment = ""
+	var first File
+	for _, f := range p.files[GoFile].Files() {
+		switch {
+		case f.importComment == "":
+		case p.ImportComment == "":
+			p.ImportComment = f.importComment
+			first = f
+		case f.importComment != p.ImportComment:
+			if p.err == nil {
+				p.err = &ImportCommentError{
+					Dir:        p.Dir,
+					ImportPath: p.ImportPath,
+					Comments:   []string{p.ImportComment, f.importComment},
+					Files:      []string{first.Name, f.Name},
+				}
+			}
+			return
+		}
+	}
+	if p.ImportComment == "" || p.ImportComment == p.ImportPath || moduleMode || p.ModCache ||
+		strings.HasPrefix(p.ImportPath, "vendor/") || strings.Contains(p.ImportPath, "/vendor/") {
+		return
+	}
+	if p.err == nil {
+		p.err = &ImportCommentError{
+			Dir:        p.Dir,
+			ImportPath: p.ImportPath,
+			Comments:   []string{p.ImportComment},
+			Files:      []string{first.Name},
+		}
+	}
 }
 
 // isPkgDir, returns if the Package contains any source files.
 func (p *Package) isPkgDir() bool {
+	if p.mode == FindPackageName {
+		// Files are not recorded, but the name is only found in a
+		// directory with Go files.
+		return p.Name != ""
+	}
 	for _, m := range p.files {
 		if len(m) != 0 {
 			return true
@@ -285,14 +796,39 @@ func (p *Package) removeNotSeen(seen []string) {
 	for _, m := range p.files {
 		m.removeNotSeen(seen)
 	}
+	p.invalidateFiles()
+	p.other.removeNotSeen(seen)
+	p.invalid.removeNotSeen(seen)
+}
+
+// addOtherFile, adds File f to the other files of the package.
+func (p *Package) addOtherFile(f File) {
+	if p.other == nil {
+		p.other = make(FileMap)
+	}
+	p.other[f.Name] = f
 }
 
 type PackageIndex struct {
 	c           *Corpus
 	packages    map[string]map[string]*Package // "$GOROOT/src" => "net/http" => Package
-	packagePath map[string]string              // "http" => "$GOROOT/src/net/http"
-	strings     util.StringInterner
+	packagePath map[string][]string            // "http" => ["$GOROOT/src/net/http"]
+	importedBy  map[string]map[string]string   // "fmt" => "$GOROOT/src/net/http" => "net/http"
+	imports     map[string][]string            // "$GOROOT/src/net/http" => imports recorded in importedBy
+	strings     util.StringInterner            // names, import paths and roots, see intern
 	mu          sync.RWMutex
+
+	matches  map[string]matchEntry // file path => MatchFile result
+	matchGen uint64                // Context generation of matches
+	mmu      sync.Mutex            // protects matches and matchGen
+}
+
+// A matchEntry is a cached result of Context.MatchFile for a file with the
+// given modification time and size.
+type matchEntry struct {
+	modTime time.Time
+	size    int64
+	fileMatch
 }
 
 func newPackageIndex(c *Corpus) *PackageIndex {
@@ -302,27 +838,130 @@ func newPackageIndex(c *Corpus) *PackageIndex {
 	}
 }
 
-func (x *PackageIndex) notify(typ EventType, path string) {
+// notify, sends an event for the package at path, d is the duration of the
+// create or update, if measured.
+func (x *PackageIndex) notify(typ EventType, path string, d time.Duration) {
 	if x.c == nil {
 		return
 	}
-	e := Event{
-		typ: typ,
-		msg: fmt.Sprintf("Package: %s %q", typ.color(), path),
+	// Any type-checked package may depend on an updated or deleted
+	// package.  New packages are ignored, since they are created when
+	// the imports of type-checked packages are imported.
+	if typ != CreateEvent {
+		x.c.invalidateTypes()
+	}
+	x.c.notify(newEvent(typ, "Package", path, d))
+}
+
+// errorEvent, sends an ErrorEvent for error err of the package in directory
+// dir.
+func (x *PackageIndex) errorEvent(err error, dir string) {
+	if x.c == nil || !x.c.notifying() {
+		return
 	}
+	e := newEvent(ErrorEvent, "Package", dir, 0)
+	e.Err = err
+	e.Detail = err.Error()
 	x.c.notify(e)
 }
 
+// intern, returns the interned string for s.  Only strings that are likely to
+// be repeated, such as file names and import paths, are interned.  Interned
+// strings are never released, so strings unique to a package or file, such as
+// its directory or path, are not.
 func (p *PackageIndex) intern(s string) string {
 	return p.strings.Intern(s)
 }
 
-func (x *PackageIndex) matchFile(p *Package, name string) bool {
+// matchFile, reports if Go file f of package p matches the build context.
+// MatchFile reads the build constraints of the file, so results are cached
+// by file path, modification time and size until the build context changes.
+func (x *PackageIndex) matchFile(p *Package, f File) bool {
+	return x.match(p, f).match
+}
+
+// match, is like matchFile but also returns why an ignored file is excluded
+// by the build context, which is cached along with the match.
+func (x *PackageIndex) match(p *Package, f File) fileMatch {
 	if x.c == nil || x.c.ctxt == nil {
 		// Internal error
 		panic("pkg: internal error (PackageIndex.matchFile)")
 	}
-	return x.c.ctxt.MatchFile(p.Dir, name)
+	if f.Info == nil {
+		return x.c.ctxt.matchFile(p.Dir, f.Name)
+	}
+	gen := x.c.ctxt.generation()
+	x.mmu.Lock()
+	e, ok := x.matches[f.Path]
+	ok = ok && x.matchGen == gen
+	x.mmu.Unlock()
+	if ok && e.size == f.Info.Size() && e.modTime.Equal(f.Info.ModTime()) {
+		return e.fileMatch
+	}
+
+	m := x.c.ctxt.matchFile(p.Dir, f.Name)
+	x.mmu.Lock()
+	if x.matches == nil || x.matchGen != gen {
+		// The build context changed, drop all results.
+		x.matches = make(map[string]matchEntry)
+		x.matchGen = gen
+	}
+	x.matches[f.Path] = matchEntry{
+		modTime:   f.Info.ModTime(),
+		size:      f.Info.Size(),
+		fileMatch: m,
+	}
+	x.mmu.Unlock()
+	return m
+}
+
+// forgetMatches, removes the cached MatchFile results of the Go files of
+// package p.
+func (x *PackageIndex) forgetMatches(p *Package) {
+	x.mmu.Lock()
+	if x.matches != nil {
+		for _, typ := range [...]GoFileType{IgnoredGoFile, GoFile} {
+			for _, f := range p.files[typ] {
+				delete(x.matches, f.Path)
+			}
+		}
+	}
+	x.mmu.Unlock()
+}
+
+// sameContent, reports if the contents of File f are unchanged, even though
+// its FileInfo changed to fi, see Corpus.VerifyContent.  Only files with the
+// same size are compared.
+func (x *PackageIndex) sameContent(f File, fi os.FileInfo) bool {
+	if !x.c.VerifyContent || f.hash == 0 || f.Info == nil || f.Info.Size() != fi.Size() {
+		return false
+	}
+	h, err := hashFile(f.Path)
+	return err == nil && h == f.hash
+}
+
+// matchOther, reports if the non-Go file name is matched by the
+// ExtraFileFilter of the Corpus or is an assembly file and IndexAsm is
+// enabled.
+func (x *PackageIndex) matchOther(name string) bool {
+	if x.c == nil || !validName(name) {
+		return false
+	}
+	return x.c.IndexAsm && isAsmFile(name) ||
+		x.c.ExtraFileFilter != nil && x.c.ExtraFileFilter(name)
+}
+
+// mode, returns the ImportMode packages are indexed with.
+func (x *PackageIndex) mode() ImportMode {
+	if x.c == nil {
+		return FindPackageFiles
+	}
+	return x.c.PackageMode
+}
+
+// filterFiles, is the fs.FilterFunc used when listing package directories.
+func (x *PackageIndex) filterFiles(name string) bool {
+	return fs.FilterGo(name) || x.matchOther(name)
 }
 
 // addPackage, adds package p to the index.
@@ -334,18 +973,122 @@ func (x *PackageIndex) addPackage(p *Package) {
 	if x.packages[p.SrcRoot] == nil {
 		x.packages[p.SrcRoot] = make(map[string]*Package)
 	}
-	x.packages[p.SrcRoot][p.ImportPath] = p
+	key := p.relPath()
+	if prev, ok := x.packages[p.SrcRoot][key]; ok && prev.Name != p.Name {
+		x.removePackagePath(prev.Name, prev.Dir)
+	}
+	x.packages[p.SrcRoot][key] = p
 
 	if !p.IsCommand() {
-		if x.packagePath == nil {
-			x.packagePath = make(map[string]string)
-		}
-		x.packagePath[p.Name] = p.Dir
+		x.addPackagePath(p.Name, p.Dir)
 	}
+	x.setImports(p)
 	x.mu.Unlock()
 }
 
+// setImports, records the imports of the buildable Go files of Package p in
+// the importedBy map, replacing the imports previously recorded for its
+// directory.  Lock the mutex for writing before calling.
+func (x *PackageIndex) setImports(p *Package) {
+	x.removeImports(p.Dir)
+	paths := p.importPaths()
+	if len(paths) == 0 {
+		return
+	}
+	if x.importedBy == nil {
+		x.importedBy = make(map[string]map[string]string)
+		x.imports = make(map[string][]string)
+	}
+	for _, path := range paths {
+		m := x.importedBy[path]
+		if m == nil {
+			m = make(map[string]string)
+			x.importedBy[path] = m
+		}
+		m[p.Dir] = p.ImportPath
+	}
+	x.imports[p.Dir] = paths
+}
+
+// removeImports, removes the imports recorded for the package at directory
+// dir from the importedBy map.  Lock the mutex for writing before calling.
+func (x *PackageIndex) removeImports(dir string) {
+	for _, path := range x.imports[dir] {
+		m := x.importedBy[path]
+		delete(m, dir)
+		if len(m) == 0 {
+			delete(x.importedBy, path)
+		}
+	}
+	delete(x.imports, dir)
+}
+
+// Importers returns the sorted import paths of the indexed packages whose
+// buildable Go files import the package with import path importPath.  Import
+// paths are matched literally, vendored imports are not resolved.  The
+// imports of packages are recorded when they are indexed, so unlike a scan
+// of every package this is proportional to the number of importers.
+func (x *PackageIndex) Importers(importPath string) []string {
+	x.mu.RLock()
+	m := x.importedBy[importPath]
+	s := make([]string, 0, len(m))
+	for _, path := range m {
+		s = append(s, path)
+	}
+	x.mu.RUnlock()
+	if len(s) == 0 {
+		return nil
+	}
+	sort.Strings(s)
+	// Packages with the same import path may be in multiple roots.
+	n := 1
+	for i := 1; i < len(s); i++ {
+		if s[i] != s[n-1] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	return s[:n]
+}
+
+// addPackagePath, adds directory dir to the sorted list of directories of
+// packages named name.  Lock the mutex for writing before calling.
+func (x *PackageIndex) addPackagePath(name, dir string) {
+	if x.packagePath == nil {
+		x.packagePath = make(map[string][]string)
+	}
+	dirs := x.packagePath[name]
+	i := sort.SearchStrings(dirs, dir)
+	if i < len(dirs) && dirs[i] == dir {
+		return
+	}
+	// Copy to prevent modifying slices returned by DirsForName.
+	s := make([]string, 0, len(dirs)+1)
+	s = append(s, dirs[:i]...)
+	s = append(s, dir)
+	x.packagePath[name] = append(s, dirs[i:]...)
+}
+
+// removePackagePath, removes directory dir from the list of directories of
+// packages named name.  Lock the mutex for writing before calling.
+func (x *PackageIndex) removePackagePath(name, dir string) {
+	dirs := x.packagePath[name]
+	i := sort.SearchStrings(dirs, dir)
+	if i == len(dirs) || dirs[i] != dir {
+		return
+	}
+	if len(dirs) == 1 {
+		delete(x.packagePath, name)
+		return
+	}
+	// Copy to prevent modifying slices returned by DirsForName.
+	s := make([]string, 0, len(dirs)-1)
+	s = append(s, dirs[:i]...)
+	x.packagePath[name] = append(s, dirs[i+1:]...)
+}
+
 // lookup returns the package located at path in directory root, if any.
+// Path is the directory of the package relative to root.
 func (x *PackageIndex) lookup(root, path string) (pkg *Package, ok bool) {
 	x.mu.RLock()
 	if x.packages != nil && x.packages[root] != nil {
@@ -357,50 +1100,235 @@ func (x *PackageIndex) lookup(root, path string) (pkg *Package, ok bool) {
 
 // lookupPath returns the package located at path, if any.
 func (x *PackageIndex) lookupPath(path string) (*Package, bool) {
-	if root := x.matchSrcRoot(path); root != "" {
-		return x.lookup(root, trimPathPrefix(path, root))
+	if root, dir := x.matchSrcRoot(path); root != "" {
+		return x.lookup(root, trimPathPrefix(dir, root))
 	}
 	return nil, false
 }
 
 // lookupPackage returns a package by name.  For example "http" should return
 // the "net/http" package located at "$GOROOT/src/net/http".
+//
+// If multiple packages share the name, the first package in the Go root is
+// returned, otherwise the package with the lexically first directory.  Use
+// lookupPackages to return all packages with the name.
 func (x *PackageIndex) lookupPackage(name string) (*Package, bool) {
-	if x.packages == nil || x.packagePath == nil {
+	pkgs := x.lookupPackages(name)
+	if len(pkgs) == 0 {
 		return nil, false
 	}
-	x.mu.Lock()
-	if path, ok := x.packagePath[name]; ok {
-		x.mu.Unlock()
-		return x.lookupPath(path)
+	for _, p := range pkgs {
+		if p.Goroot {
+			return p, true
+		}
 	}
-	x.mu.Unlock()
-	return nil, false
+	return pkgs[0], true
+}
+
+// lookupPackages returns all of the packages named name, sorted by directory.
+func (x *PackageIndex) lookupPackages(name string) []*Package {
+	dirs := x.DirsForName(name)
+	if len(dirs) == 0 {
+		return nil
+	}
+	pkgs := make([]*Package, 0, len(dirs))
+	for _, dir := range dirs {
+		if p, ok := x.lookupPath(dir); ok {
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs
+}
+
+// LookupPackageFold returns the packages whose name matches name under
+// Unicode case-folding, sorted by directory.  For example "Http" returns the
+// "net/http" package.  If any packages are named exactly name only they are
+// returned, otherwise all of the package names are searched.
+func (x *PackageIndex) LookupPackageFold(name string) []*Package {
+	if pkgs := x.lookupPackages(name); len(pkgs) != 0 {
+		return pkgs
+	}
+	var dirs []string
+	x.mu.RLock()
+	for s, d := range x.packagePath {
+		if strings.EqualFold(s, name) {
+			dirs = append(dirs, d...)
+		}
+	}
+	x.mu.RUnlock()
+	if len(dirs) == 0 {
+		return nil
+	}
+	sort.Strings(dirs)
+	pkgs := make([]*Package, 0, len(dirs))
+	for _, dir := range dirs {
+		if p, ok := x.lookupPath(dir); ok {
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs
+}
+
+// DirsForName returns the sorted directories of all the indexed packages named
+// name.  Commands (packages named "main") are not included.
+func (x *PackageIndex) DirsForName(name string) []string {
+	x.mu.RLock()
+	dirs := x.packagePath[name]
+	x.mu.RUnlock()
+	return dirs
+}
+
+// ignoredPackages, returns the indexed packages that contain Go files
+// ignored by the current build context.
+func (x *PackageIndex) ignoredPackages() []*Package {
+	var pkgs []*Package
+	x.mu.RLock()
+	for _, m := range x.packages {
+		for _, p := range m {
+			if len(p.files[IgnoredGoFile]) != 0 {
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	return pkgs
+}
+
+// importPathList, returns the sorted, de-duplicated import paths of the
+// indexed packages.
+func (x *PackageIndex) importPathList() []string {
+	seen := make(map[string]bool)
+	x.mu.RLock()
+	for _, m := range x.packages {
+		for _, p := range m {
+			seen[p.ImportPath] = true
+		}
+	}
+	x.mu.RUnlock()
+	s := make([]string, 0, len(seen))
+	for path := range seen {
+		s = append(s, path)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// each, calls fn for each indexed package, in no particular order, until fn
+// returns false.  The read lock is held while iterating.
+func (x *PackageIndex) each(fn func(p *Package) bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for _, m := range x.packages {
+		for _, p := range m {
+			if !fn(p) {
+				return
+			}
+		}
+	}
+}
+
+// numPackages, returns the number of indexed packages for which fn returns
+// true, or of every indexed package if fn is nil.
+func (x *PackageIndex) numPackages(fn func(p *Package) bool) int {
+	n := 0
+	x.mu.RLock()
+	for _, m := range x.packages {
+		if fn == nil {
+			n += len(m)
+			continue
+		}
+		for _, p := range m {
+			if fn(p) {
+				n++
+			}
+		}
+	}
+	x.mu.RUnlock()
+	return n
+}
+
+// packageList, returns the indexed packages for which fn returns true, sorted
+// by import path then directory.
+func (x *PackageIndex) packageList(fn func(p *Package) bool) []*Package {
+	var pkgs []*Package
+	x.mu.RLock()
+	for _, m := range x.packages {
+		for _, p := range m {
+			if fn(p) {
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	sort.Sort(byImportPath(pkgs))
+	return pkgs
+}
+
+// findPackages, returns the packages with import path or name query.  Packages
+// with import path query are listed first, followed by packages named query,
+// each group is sorted by import path then directory.
+func (x *PackageIndex) findPackages(query string) []*Package {
+	var paths, names []*Package
+	x.mu.RLock()
+	for _, m := range x.packages {
+		for _, p := range m {
+			switch {
+			case p.ImportPath == query:
+				paths = append(paths, p)
+			case p.Name == query:
+				names = append(names, p)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	sort.Sort(byImportPath(paths))
+	sort.Sort(byImportPath(names))
+	return append(paths, names...)
 }
 
 // remove, removes the package located at path from directory root.
 func (x *PackageIndex) remove(root, path string) {
-	if x.packages == nil || x.packagePath == nil {
+	if x.packages == nil {
 		return
 	}
 	x.mu.Lock()
 	if m := x.packages[root]; m != nil {
-		if _, ok := m[path]; ok {
+		if p, ok := m[path]; ok {
 			delete(m, path)
-			x.notify(DeleteEvent, path)
+			x.removePackagePath(p.Name, p.Dir)
+			x.removeImports(p.Dir)
+			x.forgetMatches(p)
+			x.notify(DeleteEvent, path, 0)
 		}
 	}
-	name := pathpkg.Base(path)
-	if x.packagePath[name] == pathpkg.Join(root, path) {
-		delete(x.packagePath, name)
-	}
 	x.mu.Unlock()
 }
 
 // removePath removes the package rooted at path from the index.
 func (x *PackageIndex) removePath(path string) {
-	if root := x.matchSrcRoot(path); root != "" {
-		x.remove(root, trimPathPrefix(path, root))
+	if root, dir := x.matchSrcRoot(path); root != "" {
+		x.remove(root, trimPathPrefix(dir, root))
+	}
+}
+
+// reset, removes every package from the index.
+func (x *PackageIndex) reset() {
+	x.mu.Lock()
+	prev := x.packages
+	x.packages = make(map[string]map[string]*Package)
+	x.packagePath = nil
+	x.importedBy = nil
+	x.imports = nil
+	x.mu.Unlock()
+
+	x.mmu.Lock()
+	x.matches = nil
+	x.mmu.Unlock()
+
+	for _, m := range prev {
+		for path := range m {
+			x.notify(DeleteEvent, path, 0)
+		}
 	}
 }
 
@@ -417,32 +1345,135 @@ func (x *PackageIndex) ImportDir(dir string) (*Package, error) {
 	return x.indexPkg(dir, fi, list)
 }
 
-// matchSrcRoot, returns the GOPATH/GOROOT that contains path.
-func (x *PackageIndex) matchSrcRoot(path string) string {
-	for _, srcDir := range x.c.ctxt.SrcDirs() {
-		if hasRoot(path, srcDir) {
-			return srcDir
+// matchSrcRoot, returns the GOPATH/GOROOT that contains path and path, which
+// has its symbolic links resolved if FollowSymlinks is enabled and path is
+// only contained by a source root once resolved.
+func (x *PackageIndex) matchSrcRoot(path string) (root, dir string) {
+	srcDir, dir, _ := x.matchSrcDir(path)
+	return srcDir.Path, dir
+}
+
+// matchSrcDir, is like matchSrcRoot, but returns the source root directory
+// and reports if it was found.
+func (x *PackageIndex) matchSrcDir(path string) (SrcDir, string, bool) {
+	dirs := x.c.srcDirs()
+	for _, srcDir := range dirs {
+		if hasRoot(path, srcDir.Path) {
+			return srcDir, path, true
 		}
 	}
-	return ""
+	if !x.c.FollowSymlinks {
+		return SrcDir{}, path, false
+	}
+	// The roots are canonical, path may be given through a symbolic link.
+	s, err := fs.EvalSymlinks(path)
+	if err != nil {
+		return SrcDir{}, path, false
+	}
+	if s = clean(s); s != path {
+		for _, srcDir := range dirs {
+			if hasRoot(s, srcDir.Path) {
+				return srcDir, s, true
+			}
+		}
+	}
+	return SrcDir{}, path, false
 }
 
-// isInstalled, returns if package is installed.
+// isInstalled, returns if package is installed.  Commands are installed if
+// their binary exists and packages if their archive exists, see
+// Context.PkgTargetRoot.  The standard library of gccgo is part of the
+// compiler (libgo) and is not installed to GOROOT, like go/build the
+// packages of the Go root are considered installed.
 func (x *PackageIndex) isInstalled(p *Package) bool {
 	if p.Root == "" {
 		return false
 	}
-	var target string
+	if p.ModCache {
+		return true
+	}
 	if p.IsCommand() {
-		target = pathpkg.Join(p.Root, "bin", pathpkg.Base(p.ImportPath))
-	} else {
-		_, pkga, err := x.c.ctxt.PkgTargetRoot(p.ImportPath)
-		if err != nil {
-			return false
+		_, ok := p.BinaryPath(x.c.ctxt)
+		return ok
+	}
+	if p.Goroot && x.c.ctxt.Snapshot().Compiler == "gccgo" {
+		return true
+	}
+	_, pkga, err := x.c.ctxt.PkgTargetRoot(p.ImportPath)
+	if err != nil {
+		return false
+	}
+	return fs.IsFile(pathpkg.Join(p.Root, pkga))
+}
+
+// importBinary, adds the binary-only package with import path importPath,
+// if its archive is installed in the root of srcDir, and reports if it was
+// found, see Corpus.AllowBinary.  The Dir of the package is the directory
+// its sources would have, which does not exist, and its Info describes the
+// archive.
+func (x *PackageIndex) importBinary(srcDir SrcDir, importPath string) (*Package, bool) {
+	_, pkga, err := x.c.ctxt.PkgTargetRoot(importPath)
+	if err != nil {
+		return nil, false
+	}
+	root := pathpkg.Dir(srcDir.Path)
+	archive := pathpkg.Join(root, pkga)
+	fi, err := fs.Stat(archive)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, false
+	}
+	p := &Package{
+		Dir:         pathpkg.Join(clean(srcDir.Path), importPath),
+		Name:        x.intern(archivePackageName(x.c.ctxt.Snapshot().Compiler, archive)),
+		ImportPath:  x.intern(importPath),
+		Root:        x.intern(root),
+		SrcRoot:     x.intern(srcDir.Path),
+		Goroot:      srcDir.Goroot,
+		Installed:   true,
+		BinaryOnly:  true,
+		Info:        fi,
+		LastIndexed: time.Now(),
+		files:       make(map[GoFileType]FileMap),
+	}
+	x.addPackage(p)
+	return p, true
+}
+
+// updateBinary, re-stats the archives of the binary-only packages added by
+// importBinary, since they are not found by walking the source roots.  The
+// packages whose archive changed are re-imported and those whose archive no
+// longer exists are removed.
+func (x *PackageIndex) updateBinary() {
+	var pkgs []*Package
+	x.each(func(p *Package) bool {
+		if p.BinaryOnly {
+			pkgs = append(pkgs, p)
+		}
+		return true
+	})
+	for _, p := range pkgs {
+		if _, pkga, err := x.c.ctxt.PkgTargetRoot(p.ImportPath); err == nil {
+			fi, err := fs.Stat(pathpkg.Join(p.Root, pkga))
+			if err == nil && fs.SameFile(p.Info, fi) {
+				continue
+			}
 		}
-		target = pathpkg.Join(p.Root, pkga)
+		srcDir := SrcDir{Path: p.SrcRoot, Goroot: p.Goroot}
+		if _, ok := x.importBinary(srcDir, p.ImportPath); !ok {
+			x.remove(p.SrcRoot, p.ImportPath)
+		}
+	}
+}
+
+// archivePackageName, returns the package name of the archive at path built
+// by compiler, which like go/build is the last element of its import path:
+// "foo.a" for gc and "libfoo.a" for gccgo.
+func archivePackageName(compiler, path string) string {
+	name := strings.TrimSuffix(pathpkg.Base(path), ".a")
+	if compiler == "gccgo" {
+		name = strings.TrimPrefix(name, "lib")
 	}
-	return fs.IsFile(target)
+	return name
 }
 
 func (x *PackageIndex) UpdatePackage(p *Package) (*Package, error) {
@@ -451,31 +1482,86 @@ func (x *PackageIndex) UpdatePackage(p *Package) (*Package, error) {
 	}
 	fi, err := fs.Stat(p.Dir)
 	if err != nil {
-		x.remove(p.SrcRoot, p.ImportPath)
+		x.remove(p.SrcRoot, p.relPath())
 		return nil, err
 	}
 	return x.updatePkg(p.Dir, fi)
 }
 
+// InvalidateContext, updates the indexed packages after a change of the build
+// context, such as by Context.SetGOOS.  If matchFiles is true the Go files
+// of each package are re-matched against the build context and the idents
+// of packages with files that became buildable or ignored are re-indexed.
 func (x *PackageIndex) InvalidateContext(matchFiles bool) {
 	for _, m := range x.packages {
 		for _, p := range m {
-			x.updatePkgContext(p, matchFiles)
+			changed := x.updatePkgContext(p, matchFiles)
+			if matchFiles {
+				// Files may have become buildable or ignored.
+				x.mu.Lock()
+				x.setImports(p)
+				x.mu.Unlock()
+			}
+			if changed && x.c.idents != nil {
+				x.c.idents.indexPackage(p)
+			}
 		}
 	}
+	if matchFiles && x.c.idents != nil {
+		x.c.idents.invalidateIgnored()
+	}
 }
 
-func (x *PackageIndex) updatePkgContext(p *Package, matchFiles bool) {
+// updatePkgContext, updates package p for a change of the build context and
+// reports if any of its Go files became buildable or ignored.  Files are only
+// re-matched if matchFiles is true.  What is parsed from buildable files,
+// see indexPkg, is read for files that became buildable and dropped from
+// files that became ignored.
+func (x *PackageIndex) updatePkgContext(p *Package, matchFiles bool) bool {
+	changed := false
 	if matchFiles {
+		var fset *token.FileSet
 		for _, f := range p.Files(GoFile | IgnoredGoFile) {
-			if x.matchFile(p, f.Name) {
+			_, buildable := p.files[GoFile][f.Name]
+			m := x.match(p, f)
+			match := m.match
+			switch {
+			case match && !buildable:
+				if fset == nil {
+					fset = token.NewFileSet()
+				}
+				af, err := parseFile(fset, f.Path, parser.ImportsOnly|parser.ParseComments)
+				if err != nil {
+					// Leave the file ignored, the directory is
+					// re-read by the next update, see updatePkg.
+					p.parseErrs = append(p.parseErrs, err)
+					continue
+				}
+				f.imports = x.importPaths(af)
+				f.goVersion = constraintGoVersion(buildConstraint(af))
+				f.importComment = importComment(fset, af)
+				f.doc = packageDoc(af)
+				changed = true
+			case !match && buildable:
+				f.imports = nil
+				f.goVersion = 0
+				f.importComment = ""
+				f.doc = ""
+				changed = true
+			}
+			if match {
+				f.reason = ""
 				p.addFile(GoFile, f)
 			} else {
+				f.reason = m.reason
 				p.addFile(IgnoredGoFile, f)
 			}
 		}
+		p.setImportComment(x.c != nil && x.c.ModuleMode)
+		p.setNoBuildableError()
 	}
 	p.Installed = x.isInstalled(p)
+	return changed
 }
 
 func (x *PackageIndex) updatePkg(dir string, fi os.FileInfo) (*Package, error) {
@@ -487,15 +1573,32 @@ func (x *PackageIndex) updatePkg(dir string, fi os.FileInfo) (*Package, error) {
 		return exitErr(&NoGoError{dir})
 	}
 	p, pkgFound := x.lookupPath(dir)
-	if p == nil || !pkgFound || !fs.SameFile(p.Info, fi) {
-		// Stat only Go files.
-		files, err := fs.ReaddirFunc(dir, fs.FilterGo)
+	if p == nil || !pkgFound || !fs.SameFile(p.Info, fi) || p.mode != x.mode() ||
+		len(p.parseErrs) != 0 || IsMultiplePackage(p.err) {
+		// Stat only Go files and other files.  Files that failed to
+		// parse or declare another package are not recorded by the
+		// package, so the directory is read until the error is fixed.
+		files, err := fs.ReaddirFunc(dir, x.filterFiles)
 		if err != nil {
 			return exitErr(err)
 		}
 		return x.indexPkg(dir, fi, files)
 	}
 
+	// Files are not recorded in FindPackageName mode, the package
+	// name is only re-parsed when the directory changes.
+	if p.mode == FindPackageName {
+		p.LastIndexed = time.Now()
+		return p, nil
+	}
+
+	// The directory did not change and IndexFileInfo is disabled,
+	// so assume that none of the files changed either.
+	if !x.c.IndexFileInfo {
+		p.LastIndexed = time.Now()
+		return p, nil
+	}
+
 	// If the directory did not change, we can just stat
 	// the previously indexed files and use that as the
 	// file list to indexPkg.
@@ -503,7 +1606,7 @@ func (x *PackageIndex) updatePkg(dir string, fi os.FileInfo) (*Package, error) {
 	// The goal here is to minimize the number of files
 	// that we open as file system contention accounts
 	// for the majority of the runtime.
-	files := make([]os.FileInfo, 0, p.fileLen(-1))
+	files := make([]os.FileInfo, 0, p.fileLen(-1)+len(p.other)+len(p.invalid))
 	for _, m := range p.files {
 		for _, f := range m {
 			fi, err := fs.Stat(f.Path)
@@ -514,6 +1617,16 @@ func (x *PackageIndex) updatePkg(dir string, fi os.FileInfo) (*Package, error) {
 			}
 		}
 	}
+	for _, f := range p.invalid {
+		if fi, err := fs.Stat(f.Path); err == nil {
+			files = append(files, fi)
+		}
+	}
+	for _, f := range p.other {
+		if fi, err := fs.Stat(f.Path); err == nil {
+			files = append(files, fi)
+		}
+	}
 	return x.indexPkg(dir, fi, files)
 }
 
@@ -522,29 +1635,36 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 	// TODO: Write doc for this monster.
 	// TODO: Test if we need to use filepath.EvalSymlinks to prevent duplicate
 	// entries and other gremlins.
+	start := time.Now()
 
-	srcRoot := x.matchSrcRoot(dir)
-	if srcRoot == "" {
+	srcDir, dir, ok := x.matchSrcDir(dir)
+	if !ok {
 		return nil, fmt.Errorf("pkg: missing srcRoot for dir %q", dir)
 	}
-	importPath := trimPathPrefix(dir, srcRoot)
+	srcRoot := srcDir.Path
+	rel := trimPathPrefix(dir, srcRoot)
+	importPath := rel
+	if srcDir.ModCache {
+		importPath = modImportPath(rel)
+	}
 
 	if !isPkgDir(fi) || !hasGoFiles(files) {
-		x.remove(dir, importPath)
+		x.remove(srcRoot, rel)
 		return nil, &NoGoError{dir}
 	}
 
-	p, pkgFound := x.lookup(srcRoot, importPath)
+	p, pkgFound := x.lookup(srcRoot, rel)
 	if !pkgFound {
-		// Create a new package.
+		// Create a new package.  The directory is unique to the
+		// package, so it is not interned.
 		root := pathpkg.Dir(srcRoot)
-		goroot := x.c.ctxt.GOROOT()
 		p = &Package{
-			Dir:        x.intern(dir),
+			Dir:        dir,
 			ImportPath: x.intern(importPath),
 			Root:       x.intern(root),
 			SrcRoot:    x.intern(srcRoot),
-			Goroot:     hasRoot(dir, goroot),
+			Goroot:     srcDir.Goroot,
+			ModCache:   srcDir.ModCache,
 			Info:       fi,
 			files:      make(map[GoFileType]FileMap),
 		}
@@ -553,18 +1673,34 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 	// Removes the package from the index on error.
 	exitErr := func(err error) (*Package, error) {
 		if pkgFound {
-			x.remove(srcRoot, importPath)
+			x.remove(srcRoot, rel)
 		}
 		return nil, err
 	}
 
 	// Set error to nil, if whatever triggered
 	// it is still present it will be reset.
+	prevErr := p.err
 	p.err = nil
+	p.parseErrs = nil
+	p.Info = fi
+	p.LastIndexed = start
+	p.BinaryOnly = false // sources were added
+
+	if x.mode() == FindPackageName {
+		return x.indexPkgName(p, pkgFound, fi, files, start)
+	}
+	if p.mode != FindPackageFiles {
+		// Previously indexed in FindPackageName mode, reset the name
+		// so that it is found from the classified files.
+		x.removePackageName(p)
+		p.mode = FindPackageFiles
+	}
 
 	// If Go code indexing is enabled we will pass
 	// the AST that we parsed here to the Index.
 	updateAst := false
+	updateOther := false
 	astFiles := make(map[string]*ast.File)
 	fset := token.NewFileSet()
 
@@ -573,26 +1709,47 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 	// Used for removing deleted/missing files.
 	seen := make([]string, 0, len(files))
 
+	// Assembly files are indexed along with the Go files, see IndexAsm.
+	updateAsm := false
+	numAsm := p.numAsmFiles()
+
 	// Add new files and update any that changed.
 	for _, fi := range files {
 		seen = append(seen, fi.Name())
 		if !isGoFile(fi) {
+			if !fi.IsDir() && x.matchOther(fi.Name()) && x.addOtherFile(p, fi) {
+				updateOther = true
+				updateAsm = updateAsm || isAsmFile(fi.Name())
+			}
 			continue
 		}
 
 		name := fi.Name()
+		if f, ok := p.invalid[name]; ok && fs.SameFile(f.Info, fi) {
+			continue // Unchanged and still not Go source.
+		}
 		f, found := p.LookupFile(name)
 		if !found {
-			// Create a new file.
-			path := pathpkg.Join(p.Dir, name)
+			// Create a new file.  Names, such as "doc.go", are
+			// often repeated but paths are unique.
 			f = File{
 				Name: x.intern(name),
-				Path: x.intern(path),
+				Path: pathpkg.Join(p.Dir, name),
 				Info: fi,
 			}
 		}
 		same := fs.SameFile(f.Info, fi)
+		if !same && found && x.sameContent(f, fi) {
+			// Only the modification time changed, record it so
+			// that the file is not hashed again.
+			same = true
+			f.Info = fi
+			p.setFile(f)
+		}
 		f.Info = fi
+		if (!same || !found) && x.c.VerifyContent {
+			f.hash, _ = hashFile(f.Path)
+		}
 
 		// Update AST if the file changed or is new.
 		updateAst = updateAst || !same || !found
@@ -602,11 +1759,34 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 			// No changes, and the file is already indexed.
 
 		case isGoTestFile(fi):
-			// Don't parse Go test files.
+			// Only parse the package clause and imports of Go
+			// test files.
+			af, err := parseFile(fset, f.Path, parser.ImportsOnly)
+			if p.addInvalidGoFile(f, err) {
+				continue
+			}
+			f.xtest = false
+			f.imports = nil
+			if af != nil && af.Name != nil {
+				f.xtest = strings.HasSuffix(af.Name.Name, "_test")
+				f.imports = x.importPaths(af)
+			}
 			p.addFile(TestGoFile, f)
 
-		case !x.matchFile(p, f.Name):
-			// Ignored Go file.
+		case !x.matchFile(p, f):
+			// Ignored Go file.  The file may have been buildable
+			// before its build constraint changed, drop what was
+			// parsed from it.
+			m := x.match(p, f) // cached by matchFile
+			if m.invalid != "" {
+				p.addInvalidFile(File{Name: f.Name, Path: f.Path, Info: fi, reason: m.invalid})
+				continue
+			}
+			f.imports = nil
+			f.goVersion = 0
+			f.importComment = ""
+			f.doc = ""
+			f.reason = m.reason
 			p.addFile(IgnoredGoFile, f)
 
 		default:
@@ -615,13 +1795,18 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 			// If we are indexing Go code, parse the entire file.
 			// This saves us from having to open/read/parse the
 			// file twice.
-			mode := parser.PackageClauseOnly
+			//
+			// Comments are parsed for the build constraint.
+			mode := parser.ImportsOnly | parser.ParseComments
 			if x.c.IndexGoCode {
 				mode = parser.ParseComments
 			}
 
 			af, err := parseFile(fset, f.Path, mode)
 			if err != nil {
+				if !p.addInvalidGoFile(f, err) {
+					p.parseErrs = append(p.parseErrs, err)
+				}
 				break
 			}
 
@@ -631,13 +1816,19 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 				x.addPackage(p)
 				return p, err
 			}
+			f.imports = x.importPaths(af)
+			f.goVersion = constraintGoVersion(buildConstraint(af))
+			f.importComment = importComment(fset, af)
+			f.doc = packageDoc(af)
+			f.reason = ""
 			p.addFile(GoFile, f)
-			astFiles[pkgName] = af
+			astFiles[f.Name] = af
 		}
 	}
 
 	// Remove deleted files from the package.
 	p.removeNotSeen(seen)
+	updateAsm = x.c.IndexAsm && (updateAsm || p.numAsmFiles() != numAsm)
 
 	// No Go source files
 	if !p.isPkgDir() {
@@ -660,13 +1851,18 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 		// If there were parse errors we may have
 		// removed all the Go source files.
 		if !p.isPkgDir() {
-			return exitErr(&NoGoError{})
+			return exitErr(&NoGoError{dir})
 		}
-		// TODO: Parse test files, or use a better error.
+		// TODO: Parse test files.
 		if p.Name == "" {
-			return exitErr(&NoGoError{dir})
+			return exitErr(&NoBuildableGoError{
+				Dir:     dir,
+				Ignored: p.files[IgnoredGoFile].FileNames(),
+			})
 		}
 	}
+	p.setImportComment(x.c != nil && x.c.ModuleMode)
+	p.setNoBuildableError()
 
 	p.Installed = x.isInstalled(p)
 	x.addPackage(p)
@@ -674,19 +1870,120 @@ func (x *PackageIndex) indexPkg(dir string, fi os.FileInfo, files []os.FileInfo)
 	// Send notification.
 	switch {
 	case !pkgFound:
-		x.notify(CreateEvent, p.Dir)
-	case pkgFound && updateAst:
-		x.notify(UpdateEvent, p.Dir)
+		x.notify(CreateEvent, p.Dir, time.Since(start))
+	case pkgFound && (updateAst || updateOther):
+		x.notify(UpdateEvent, p.Dir, time.Since(start))
+	}
+	if IsImportComment(p.err) && (prevErr == nil || prevErr.Error() != p.err.Error()) {
+		x.errorEvent(p.err, p.Dir)
 	}
 
 	// Index package idents
-	if x.c.IndexGoCode && updateAst {
-		// WARN: Make sure we parsed all pkg files!
+	if x.c.IndexGoCode && (updateAst || updateAsm) {
+		// Only changed files were parsed, if any files did not
+		// change re-parse the whole package.
+		if len(astFiles) != len(p.files[GoFile]) {
+			astFiles = nil
+		}
 		x.c.idents.indexPackageFiles(p, fset, astFiles)
 	}
 	return p, nil
 }
 
+// indexPkgName, indexes package p in FindPackageName mode.  The package name
+// is parsed from the package clause of the first non-test Go file, by name,
+// that has one.  Files are not classified or recorded, so unlike
+// FindPackageFiles build constraints are not considered when finding the
+// name.  Start is the time indexing began.
+func (x *PackageIndex) indexPkgName(p *Package, pkgFound bool, fi os.FileInfo, files []os.FileInfo, start time.Time) (*Package, error) {
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		if isGoFile(fi) && !isGoTestFile(fi) {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+
+	fset := token.NewFileSet()
+	name := ""
+	for _, s := range names {
+		if n, ok := parseFileName(fset, pathpkg.Join(p.Dir, s)); ok {
+			name = n
+			break
+		}
+	}
+	if name == "" {
+		if pkgFound {
+			x.remove(p.SrcRoot, p.relPath())
+		}
+		return nil, &NoGoError{p.Dir}
+	}
+
+	updated := p.mode != FindPackageName || p.Name != name
+	if pkgFound && updated {
+		x.removePackageName(p)
+	}
+	p.Name = x.intern(name)
+	p.Info = fi
+	p.LastIndexed = start
+	p.mode = FindPackageName
+	p.files = make(map[GoFileType]FileMap)
+	p.invalidateFiles()
+	p.other = nil
+	p.invalid = nil
+	p.Installed = x.isInstalled(p)
+	x.addPackage(p)
+
+	switch {
+	case !pkgFound:
+		x.notify(CreateEvent, p.Dir, time.Since(start))
+	case updated:
+		x.notify(UpdateEvent, p.Dir, time.Since(start))
+	}
+	return p, nil
+}
+
+// removePackageName, clears the name of indexed package p and removes it from
+// the list of packages with the name.
+func (x *PackageIndex) removePackageName(p *Package) {
+	x.mu.Lock()
+	x.removePackagePath(p.Name, p.Dir)
+	x.mu.Unlock()
+	p.Name = ""
+}
+
+// addOtherFile, adds or updates the other file described by fi in package p
+// and reports if it is new or changed.
+func (x *PackageIndex) addOtherFile(p *Package, fi os.FileInfo) bool {
+	name := fi.Name()
+	if f, ok := p.other[name]; ok && fs.SameFile(f.Info, fi) {
+		return false
+	}
+	p.addOtherFile(File{
+		Name: x.intern(name),
+		Path: pathpkg.Join(p.Dir, name),
+		Info: fi,
+	})
+	return true
+}
+
+// importPaths, returns the interned import paths of Go file af.
+func (x *PackageIndex) importPaths(af *ast.File) []string {
+	if len(af.Imports) == 0 {
+		return nil
+	}
+	s := make([]string, 0, len(af.Imports))
+	for _, spec := range af.Imports {
+		if spec.Path == nil {
+			continue
+		}
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			s = append(s, x.intern(path))
+		}
+	}
+	return s
+}
+
 // setPackageName, sets the package name and checks for multiple package errors.
 func (x *PackageIndex) setPackageName(p *Package, fileName, pkgName string) bool {
 	// TODO: Consider setting the error Package error.
@@ -721,30 +2018,45 @@ type NoGoError struct {
 }
 
 func (e *NoGoError) Error() string {
-	return "no buildable Go source files in " + e.Dir
+	return "no Go source files in " + e.Dir
+}
+
+// Is reports whether target is a *NoGoError with the same Dir, an empty
+// target Dir matches any NoGoError.  Used by errors.Is.
+func (e *NoGoError) Is(target error) bool {
+	t, ok := target.(*NoGoError)
+	return ok && (t.Dir == "" || t.Dir == e.Dir)
 }
 
-// Returns, if the error err is NoGoError error.
+// Returns, if the error err is or wraps a NoGoError error.
 func IsNoGo(err error) bool {
-	_, ok := err.(*NoGoError)
-	return ok
+	var e *NoGoError
+	return errors.As(err, &e)
 }
 
-// NoGoError is the error used by Import to describe a directory
+// NoBuildableGoError is the error used by Import to describe a directory
 // containing no buildable Go source files. (It may still contain
 // test files, files hidden by build tags, and so on.)
 type NoBuildableGoError struct {
-	Dir string
+	Dir     string
+	Ignored []string // Go files excluded by build constraints, if any
 }
 
 func (e *NoBuildableGoError) Error() string {
 	return "no buildable Go source files in " + e.Dir
 }
 
-// Returns, if the error err is NoBuildableGoError error.
+// Is reports whether target is a *NoBuildableGoError with the same Dir, an
+// empty target Dir matches any NoBuildableGoError.  Used by errors.Is.
+func (e *NoBuildableGoError) Is(target error) bool {
+	t, ok := target.(*NoBuildableGoError)
+	return ok && (t.Dir == "" || t.Dir == e.Dir)
+}
+
+// Returns, if the error err is or wraps a NoBuildableGoError error.
 func IsNoBuildableGo(err error) bool {
-	_, ok := err.(*NoBuildableGoError)
-	return ok
+	var e *NoBuildableGoError
+	return errors.As(err, &e)
 }
 
 // MultiplePackageError describes a directory containing
@@ -761,8 +2073,45 @@ func (e *MultiplePackageError) Error() string {
 		e.Files[0], e.Packages[1], e.Files[1], e.Dir)
 }
 
-// Returns, if the error err is MultiplePackageError error.
+// Is reports whether target is a *MultiplePackageError with the same Dir, an
+// empty target Dir matches any MultiplePackageError.  Used by errors.Is.
+func (e *MultiplePackageError) Is(target error) bool {
+	t, ok := target.(*MultiplePackageError)
+	return ok && (t.Dir == "" || t.Dir == e.Dir)
+}
+
+// Returns, if the error err is or wraps a MultiplePackageError error.
 func IsMultiplePackage(err error) bool {
-	_, ok := err.(*MultiplePackageError)
-	return ok
+	var e *MultiplePackageError
+	return errors.As(err, &e)
+}
+
+// ImportCommentError describes a package whose Go files have conflicting
+// import comments, or an import comment that does not match its import path.
+type ImportCommentError struct {
+	Dir        string   // directory containing files
+	ImportPath string   // import path of the package
+	Comments   []string // import comments found
+	Files      []string // corresponding files: Files[i] declares Comments[i]
+}
+
+func (e *ImportCommentError) Error() string {
+	if len(e.Comments) > 1 {
+		return fmt.Sprintf("found import comments %q (%s) and %q (%s) in %s",
+			e.Comments[0], e.Files[0], e.Comments[1], e.Files[1], e.Dir)
+	}
+	return fmt.Sprintf("code in directory %s expects import %q", e.Dir, e.Comments[0])
+}
+
+// Is reports whether target is a *ImportCommentError with the same Dir, an
+// empty target Dir matches any ImportCommentError.  Used by errors.Is.
+func (e *ImportCommentError) Is(target error) bool {
+	t, ok := target.(*ImportCommentError)
+	return ok && (t.Dir == "" || t.Dir == e.Dir)
+}
+
+// Returns, if the error err is or wraps an ImportCommentError error.
+func IsImportComment(err error) bool {
+	var e *ImportCommentError
+	return errors.As(err, &e)
 }
@@ -1,9 +1,25 @@
 package pkg
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"go/build"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/charlievieth/pkg/fs"
 )
 
 func TestIsInstalled(t *testing.T) {
@@ -53,6 +69,101 @@ func TestIsInstalled(t *testing.T) {
 	}
 }
 
+func TestIsInstalledCompiler(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.ToSlash(tmp)
+	writeTestFiles(t, tmp, map[string]string{
+		"pkg/linux_amd64/example.com/gc.a":                 "",
+		"pkg/linux_amd64_race/example.com/race.a":          "",
+		"pkg/gccgo_linux_amd64/example.com/libgccgo.a":     "",
+		"pkg/gccgo_linux_amd64/libtop.a":                   "",
+		"pkg/gccgo_linux_amd64_race/example.com/librace.a": "",
+		"bin/gccgocmd": "",
+	})
+
+	newIndex := func(compiler, suffix string) *PackageIndex {
+		ctxt := build.Default
+		ctxt.GOOS = "linux"
+		ctxt.GOARCH = "amd64"
+		ctxt.Compiler = compiler
+		ctxt.InstallSuffix = suffix
+		return &PackageIndex{c: &Corpus{ctxt: NewContext(&ctxt, 0)}}
+	}
+	tests := []struct {
+		compiler, suffix string
+		p                Package
+		exp              bool
+	}{
+		{"gc", "", Package{Root: root, Name: "gc", ImportPath: "example.com/gc"}, true},
+		{"gc", "race", Package{Root: root, Name: "race", ImportPath: "example.com/race"}, true},
+		{"gc", "", Package{Root: root, Name: "gccgo", ImportPath: "example.com/gccgo"}, false},
+		{"gccgo", "", Package{Root: root, Name: "gccgo", ImportPath: "example.com/gccgo"}, true},
+		{"gccgo", "", Package{Root: root, Name: "top", ImportPath: "top"}, true},
+		{"gccgo", "race", Package{Root: root, Name: "race", ImportPath: "example.com/race"}, true},
+		{"gccgo", "", Package{Root: root, Name: "gc", ImportPath: "example.com/gc"}, false},
+		{"gccgo", "", Package{Root: root, Name: "main", ImportPath: "cmd/gccgocmd"}, true},
+		// The standard library of gccgo is part of the compiler.
+		{"gccgo", "", Package{Root: root, Name: "missing", ImportPath: "missing", Goroot: true}, true},
+		{"gc", "", Package{Root: root, Name: "missing", ImportPath: "missing", Goroot: true}, false},
+		{"unknown", "", Package{Root: root, Name: "gc", ImportPath: "example.com/gc"}, false},
+	}
+	for _, test := range tests {
+		x := newIndex(test.compiler, test.suffix)
+		if got := x.isInstalled(&test.p); got != test.exp {
+			t.Errorf("isInstalled (%s, %q): %s: exp: %t got: %t", test.compiler,
+				test.suffix, test.p.ImportPath, test.exp, got)
+		}
+	}
+}
+
+func TestBinaryPath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.ToSlash(tmp)
+
+	newContext := func(goos, goarch string) *Context {
+		ctxt := build.Default
+		ctxt.GOOS = goos
+		ctxt.GOARCH = goarch
+		return NewContext(&ctxt, 0)
+	}
+	exe := ""
+	if runtime.GOOS == "windows" {
+		exe = ".exe"
+	}
+	writeTestFiles(t, tmp, map[string]string{
+		"bin/foo" + exe:       "",
+		"bin/plan9_386/cross": "",
+	})
+
+	tests := []struct {
+		p    Package
+		c    *Context
+		path string
+		ok   bool
+	}{
+		{Package{Root: root, Name: "main", ImportPath: "cmd/foo"}, newContext(runtime.GOOS, runtime.GOARCH), root + "/bin/foo" + exe, true},
+		{Package{Root: root, Name: "main", ImportPath: "cmd/missing"}, newContext(runtime.GOOS, runtime.GOARCH), root + "/bin/missing" + exe, false},
+		{Package{Root: root, Name: "main", ImportPath: "cross"}, newContext("plan9", "386"), root + "/bin/plan9_386/cross", true},
+		{Package{Root: root, Name: "main", ImportPath: "cmd/foo"}, newContext("windows", "mips"), root + "/bin/windows_mips/foo.exe", false},
+		{Package{Root: root, Name: "foo", ImportPath: "cmd/foo"}, nil, "", false},
+	}
+	for _, test := range tests {
+		path, ok := test.p.BinaryPath(test.c)
+		if path != test.path || ok != test.ok {
+			t.Errorf("BinaryPath (%s): Exp (%q, %t) Got (%q, %t)", test.p.ImportPath,
+				test.path, test.ok, path, ok)
+		}
+	}
+}
+
 func TestLookup(t *testing.T) {
 	c := &Corpus{
 		ctxt: NewContext(&build.Default, 0),
@@ -76,3 +187,1261 @@ func TestLookup(t *testing.T) {
 		t.Errorf("PackageIndex lookup: (%+v)\n", pkg)
 	}
 }
+
+func TestNoGoErrorMessages(t *testing.T) {
+	const dir = "/go/src/p"
+	noGo := (&NoGoError{dir}).Error()
+	noBuildable := (&NoBuildableGoError{Dir: dir}).Error()
+	if noGo == noBuildable {
+		t.Fatalf("NoGoError and NoBuildableGoError messages are identical: %q", noGo)
+	}
+	if exp := "no Go source files in " + dir; noGo != exp {
+		t.Errorf("NoGoError: exp (%s) got (%s)", exp, noGo)
+	}
+	if exp := "no buildable Go source files in " + dir; noBuildable != exp {
+		t.Errorf("NoBuildableGoError: exp (%s) got (%s)", exp, noBuildable)
+	}
+}
+
+func TestErrorsIsAs(t *testing.T) {
+	const dir = "/go/src/p"
+	multi := &MultiplePackageError{
+		Dir:      dir,
+		Packages: []string{"a", "b"},
+		Files:    []string{"a.go", "b.go"},
+	}
+	var tests = []struct {
+		err    error
+		target error
+		is     func(error) bool
+	}{
+		{&NoGoError{dir}, &NoGoError{}, IsNoGo},
+		{&NoBuildableGoError{Dir: dir}, &NoBuildableGoError{}, IsNoBuildableGo},
+		{multi, &MultiplePackageError{}, IsMultiplePackage},
+		{&ImportCommentError{Dir: dir, Comments: []string{"q"}}, &ImportCommentError{}, IsImportComment},
+	}
+	for _, x := range tests {
+		wrapped := fmt.Errorf("wrapped: %w", x.err)
+		if !x.is(x.err) || !x.is(wrapped) {
+			t.Errorf("%T: predicate failed for wrapped error", x.err)
+		}
+		if !errors.Is(wrapped, x.target) {
+			t.Errorf("%T: errors.Is failed for empty target", x.err)
+		}
+		if !errors.Is(wrapped, x.err) {
+			t.Errorf("%T: errors.Is failed for same error", x.err)
+		}
+	}
+	if errors.Is(&NoGoError{dir}, &NoGoError{"/other"}) {
+		t.Error("NoGoError: errors.Is matched different Dir")
+	}
+	if errors.Is(&NoGoError{dir}, &NoBuildableGoError{}) || IsNoBuildableGo(&NoGoError{dir}) {
+		t.Error("NoGoError: matched NoBuildableGoError")
+	}
+
+	var e *MultiplePackageError
+	if !errors.As(fmt.Errorf("wrapped: %w", multi), &e) || e != multi {
+		t.Errorf("MultiplePackageError: errors.As: %v", e)
+	}
+}
+
+func TestLookupPackages(t *testing.T) {
+	c := &Corpus{
+		ctxt: NewContext(&build.Default, 0),
+	}
+	x := PackageIndex{c: c}
+	srcDirs := c.ctxt.SrcDirsTagged()
+	if len(srcDirs) == 0 || !srcDirs[0].Goroot {
+		t.Skip("GOROOT must be set to run test")
+	}
+	root := srcDirs[0].Path
+
+	newPkg := func(importPath, name string) *Package {
+		return &Package{
+			Dir:        root + "/" + importPath,
+			Name:       name,
+			ImportPath: importPath,
+			SrcRoot:    root,
+			Goroot:     importPath != "a/rand",
+		}
+	}
+	pkgs := []*Package{
+		newPkg("math/rand", "rand"),
+		newPkg("crypto/rand", "rand"),
+		newPkg("a/rand", "rand"),
+		newPkg("cmd/rand", "main"),
+	}
+	for _, p := range pkgs {
+		x.addPackage(p)
+	}
+
+	exp := []string{root + "/a/rand", root + "/crypto/rand", root + "/math/rand"}
+	if dirs := x.DirsForName("rand"); !reflect.DeepEqual(dirs, exp) {
+		t.Fatalf("DirsForName: exp (%v) got (%v)", exp, dirs)
+	}
+	if dirs := x.DirsForName("main"); len(dirs) != 0 {
+		t.Errorf("DirsForName: indexed commands: %v", dirs)
+	}
+	if p, ok := x.lookupPackage("rand"); !ok || p.ImportPath != "crypto/rand" {
+		t.Errorf("lookupPackage: exp (%s) got (%+v)", "crypto/rand", p)
+	}
+	if n := len(x.lookupPackages("rand")); n != 3 {
+		t.Errorf("lookupPackages: exp (%d) got (%d)", 3, n)
+	}
+
+	// Remove one of the packages.
+	x.remove(root, "crypto/rand")
+	exp = []string{root + "/a/rand", root + "/math/rand"}
+	if dirs := x.DirsForName("rand"); !reflect.DeepEqual(dirs, exp) {
+		t.Errorf("DirsForName: remove: exp (%v) got (%v)", exp, dirs)
+	}
+
+	// Rename a package.
+	renamed := newPkg("math/rand", "random")
+	x.addPackage(renamed)
+	exp = []string{root + "/a/rand"}
+	if dirs := x.DirsForName("rand"); !reflect.DeepEqual(dirs, exp) {
+		t.Errorf("DirsForName: rename: exp (%v) got (%v)", exp, dirs)
+	}
+	if p, ok := x.lookupPackage("random"); !ok || p != renamed {
+		t.Errorf("lookupPackage: rename: %+v", p)
+	}
+}
+
+func TestLookupPackageFold(t *testing.T) {
+	c := &Corpus{
+		ctxt: NewContext(&build.Default, 0),
+	}
+	x := PackageIndex{c: c}
+	srcDirs := c.ctxt.SrcDirsTagged()
+	if len(srcDirs) == 0 || !srcDirs[0].Goroot {
+		t.Skip("GOROOT must be set to run test")
+	}
+	root := srcDirs[0].Path
+	for _, path := range []string{"net/http", "crypto/rand", "math/rand", "a/Rand", "a/RAND"} {
+		x.addPackage(&Package{
+			Dir:        root + "/" + path,
+			Name:       pathpkg.Base(path),
+			ImportPath: path,
+			SrcRoot:    root,
+			Goroot:     true,
+		})
+	}
+
+	dirs := func(pkgs []*Package) []string {
+		var s []string
+		for _, p := range pkgs {
+			s = append(s, strings.TrimPrefix(p.Dir, root+"/"))
+		}
+		return s
+	}
+	tests := []struct {
+		name string
+		exp  []string
+	}{
+		{"http", []string{"net/http"}},
+		{"Http", []string{"net/http"}},
+		{"HTTP", []string{"net/http"}},
+		{"rand", []string{"crypto/rand", "math/rand"}}, // exact match
+		{"Rand", []string{"a/Rand"}},                   // exact match
+		{"rAnD", []string{"a/RAND", "a/Rand", "crypto/rand", "math/rand"}},
+		{"htt", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		got := dirs(x.LookupPackageFold(test.name))
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("LookupPackageFold(%q): exp: %q got: %q", test.name, test.exp, got)
+		}
+	}
+}
+
+func TestPackageEqual(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+
+	dir := f.path("alpha")
+	p, err := f.packages.ImportDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.packages.removePath(dir)
+	q, err := f.packages.ImportDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == q {
+		t.Fatal("PackageEqual: ImportDir returned the same package")
+	}
+	if !p.Equal(q) || !q.Equal(p) {
+		t.Errorf("PackageEqual: re-imported package is not equal:\n%+v\n%+v", p, q)
+	}
+	if !p.Equal(p) || (*Package)(nil).Equal(p) || p.Equal(nil) {
+		t.Error("PackageEqual: nil or identity")
+	}
+
+	// Errors are ignored.
+	q.err = &NoGoError{Dir: dir}
+	if !p.Equal(q) {
+		t.Error("PackageEqual: compared errors")
+	}
+
+	// Adding a file.
+	f.write(t, "alpha/alpha2.go", "package alpha\n")
+	q, err = f.packages.UpdatePackage(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Equal(q) {
+		t.Error("PackageEqual: added file")
+	}
+
+	// Scalar fields.
+	r := *p
+	r.Installed = !r.Installed
+	if p.Equal(&r) {
+		t.Error("PackageEqual: Installed")
+	}
+	r = *p
+	r.mode = FindPackageName
+	if p.Equal(&r) {
+		t.Error("PackageEqual: Mode")
+	}
+}
+
+func TestLastIndexed(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	start := time.Now()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	lastIndexed := func(rel string) time.Time {
+		p, ok := f.packages.lookupPath(f.path(rel))
+		if !ok {
+			t.Fatalf("LastIndexed: missing package: %s", rel)
+		}
+		return p.LastIndexed
+	}
+	alpha, beta := lastIndexed("alpha"), lastIndexed("beta")
+	if alpha.Before(start) || beta.Before(start) {
+		t.Fatalf("LastIndexed: %s, %s before %s", alpha, beta, start)
+	}
+
+	// Packages are checked by each update, whether or not they changed,
+	// including when only the package directory is compared.
+	f.write(t, "alpha/alpha2.go", "package alpha\n")
+	f.Update()
+	if tm := lastIndexed("alpha"); !tm.After(alpha) {
+		t.Errorf("LastIndexed: changed package: %s not after %s", tm, alpha)
+	}
+	if tm := lastIndexed("beta"); !tm.After(beta) {
+		t.Errorf("LastIndexed: unchanged package: %s not after %s", tm, beta)
+	}
+	f.IndexFileInfo = false
+	beta = lastIndexed("beta")
+	f.Update()
+	if tm := lastIndexed("beta"); !tm.After(beta) {
+		t.Errorf("LastIndexed: IndexFileInfo disabled: %s not after %s", tm, beta)
+	}
+
+	b, err := f.PackageJSON("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v packageJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if tm := lastIndexed("alpha"); !v.LastIndexed.Equal(tm) {
+		t.Errorf("LastIndexed: PackageJSON: exp: %s got: %s", tm, v.LastIndexed)
+	}
+}
+
+func TestPackageMode(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+
+	// lookup, returns the package named name and fails if it is not in
+	// directory rel.
+	lookup := func(name, rel string) *Package {
+		t.Helper()
+		p, ok := f.packages.lookupPackage(name)
+		if !ok {
+			t.Fatalf("%s: missing package: %s", f.PackageMode, name)
+		}
+		if p.Dir != f.path(rel) {
+			t.Fatalf("%s: package %s: Dir: Exp (%s) Got (%s)", f.PackageMode, name, f.path(rel), p.Dir)
+		}
+		return p
+	}
+
+	f.PackageMode = FindPackageName
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p := lookup("alpha", "alpha")
+	if p.Mode() != FindPackageName {
+		t.Errorf("%s: Mode: Got (%s)", f.PackageMode, p.Mode())
+	}
+	if n := p.fileLen(-1); n != 0 {
+		t.Errorf("%s: expected no files got: %d", f.PackageMode, n)
+	}
+	if d := f.lookupDir(f.path("alpha")); d == nil || !d.HasPkg || d.PkgName != "alpha" {
+		t.Errorf("%s: directory: %+v", f.PackageMode, d)
+	}
+	if ids := f.Exports("alpha"); len(ids) != 0 {
+		t.Errorf("%s: indexed idents: %q", f.PackageMode, ids)
+	}
+
+	// The name is parsed from the first file by name.
+	f.write(t, "alpha/0.go", "package zero\n")
+	f.updateIndex()
+	lookup("zero", "alpha")
+	if _, ok := f.packages.lookupPackage("alpha"); ok {
+		t.Errorf("%s: stale package name: alpha", f.PackageMode)
+	}
+	f.remove(t, "alpha/0.go")
+
+	f.PackageMode = FindPackageFiles
+	f.updateIndex()
+	p = lookup("alpha", "alpha")
+	if p.Mode() != FindPackageFiles {
+		t.Errorf("%s: Mode: Got (%s)", f.PackageMode, p.Mode())
+	}
+	exp := []string{"alpha.go"}
+	if names := p.GoFiles(); !reflect.DeepEqual(names, exp) {
+		t.Errorf("%s: GoFiles: Exp (%q) Got (%q)", f.PackageMode, exp, names)
+	}
+	if _, ok := f.packages.lookupPackage("zero"); ok {
+		t.Errorf("%s: stale package name: zero", f.PackageMode)
+	}
+	if _, ok := f.Definition("alpha", "AlphaFunc"); !ok {
+		t.Errorf("%s: idents not indexed", f.PackageMode)
+	}
+}
+
+func TestImportMode(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+
+	p, err := f.packages.ImportDir(f.path("alpha"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode() != FindPackageFiles {
+		t.Errorf("Mode: Exp (%s) Got (%s)", FindPackageFiles, p.Mode())
+	}
+	for m, exp := range map[ImportMode]string{
+		FindPackageFiles: "FindPackageFiles",
+		FindPackageName:  "FindPackageName",
+		ImportMode(-1):   "Invalid",
+		ImportMode(100):  "Invalid",
+	} {
+		if s := m.String(); s != exp {
+			t.Errorf("ImportMode (%d): String: Exp (%s) Got (%s)", int(m), exp, s)
+		}
+	}
+}
+
+func TestOtherFiles(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.ExtraFileFilter = func(name string) bool {
+		return strings.HasSuffix(name, ".proto")
+	}
+	f.write(t, "alpha/alpha.proto", "syntax = \"proto3\";\n")
+	f.write(t, "alpha/.hidden.proto", "")
+	f.write(t, "empty/empty.proto", "")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(files []File) []string {
+		var s []string
+		for _, f := range files {
+			s = append(s, f.Name)
+		}
+		return s
+	}
+	p, ok := f.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("OtherFiles: missing package: alpha")
+	}
+	if s := names(p.OtherFiles()); !reflect.DeepEqual(s, []string{"alpha.proto"}) {
+		t.Errorf("OtherFiles: exp (%q) got (%q)", []string{"alpha.proto"}, s)
+	}
+	if p.OtherFiles()[0].Path != f.path("alpha/alpha.proto") {
+		t.Errorf("OtherFiles: path: %s", p.OtherFiles()[0].Path)
+	}
+	if _, ok := p.LookupFile("alpha.proto"); ok {
+		t.Error("OtherFiles: other file listed as a Go file")
+	}
+	if _, ok := f.packages.lookupPath(f.path("empty")); ok {
+		t.Error("OtherFiles: directory without Go files indexed as a package")
+	}
+
+	f.remove(t, "alpha/alpha.proto")
+	f.write(t, "alpha/b.proto", "")
+	f.write(t, "alpha/a.proto", "")
+	f.updateIndex()
+	exp := []string{"a.proto", "b.proto"}
+	if s := names(p.OtherFiles()); !reflect.DeepEqual(s, exp) {
+		t.Errorf("OtherFiles: update: exp (%q) got (%q)", exp, s)
+	}
+}
+
+func TestMinGoVersion(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "ver/a.go", "package ver\n")
+	f.write(t, "ver/b.go", "//go:build go1.10\n\npackage ver\n")
+	f.write(t, "ver/c.go", "//go:build go1.18 || go1.5\n\npackage ver\n")
+	f.write(t, "ver/d.go", "//go:build !go1.99999 && go1.16\n\npackage ver\n")
+	f.write(t, "ver/e.go", "// +build go1.14\n\npackage ver\n")
+	f.write(t, "ver/ignored.go", "//go:build go1.99999\n\npackage ver\n")
+	f.write(t, "ver/ver_test.go", "//go:build go1.20\n\npackage ver\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"ver":   "go1.16",
+		"alpha": "",
+	}
+	for rel, exp := range tests {
+		p, ok := f.packages.lookupPath(f.path(rel))
+		if !ok {
+			t.Fatalf("MinGoVersion: missing package: %s", rel)
+		}
+		if v := p.MinGoVersion(); v != exp {
+			t.Errorf("MinGoVersion: %s: exp %q got %q", rel, exp, v)
+		}
+	}
+}
+
+func TestGoFilesWithTests(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "alpha/x_test.go", "package alpha_test\n\nimport \"alpha\"\n")
+	f.write(t, "alpha/y_test.go", "// Comment.\n\npackage alpha_test\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := f.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("GoFilesWithTests: missing package: alpha")
+	}
+	if names, exp := p.GoFilesWithTests(), []string{"alpha.go", "alpha_test.go"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("GoFilesWithTests: exp: %q got: %q", exp, names)
+	}
+	if names, exp := p.XTestGoFiles(), []string{"x_test.go", "y_test.go"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("XTestGoFiles: exp: %q got: %q", exp, names)
+	}
+
+	// Test files are re-classified when they change.
+	f.write(t, "alpha/x_test.go", "package alpha\n")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(f.path("alpha/x_test.go"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	f.Update()
+	p, _ = f.packages.lookupPath(f.path("alpha"))
+	if names, exp := p.GoFilesWithTests(), []string{"alpha.go", "alpha_test.go", "x_test.go"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("GoFilesWithTests: update: exp: %q got: %q", exp, names)
+	}
+	if names, exp := p.XTestGoFiles(), []string{"y_test.go"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("XTestGoFiles: update: exp: %q got: %q", exp, names)
+	}
+}
+
+// Test that names are interned but the unique paths of packages and files
+// are not.
+func TestPackageIntern(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	n := f.packages.strings.Len()
+
+	// The name of the file is interned once, the directory of the package
+	// and the paths of the files are not interned.
+	f.write(t, "alpha/common.go", "package alpha\n")
+	f.write(t, "beta/common.go", "package beta\n")
+	f.write(t, "other/other.go", "package other\n")
+	f.Update()
+	if _, ok := f.packages.lookupPath(f.path("other")); !ok {
+		t.Fatal("intern: missing package: other")
+	}
+	// "common.go", "other.go" and the import path "other".
+	if got, exp := f.packages.strings.Len(), n+3; got != exp {
+		t.Errorf("intern: exp: %d strings got: %d", exp, got)
+	}
+}
+
+func TestImportComment(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "cmt/same/a.go", "package same // import \"cmt/same\"\n")
+	f.write(t, "cmt/same/b.go", "package same /* import \"cmt/same\" */\n")
+	f.write(t, "cmt/same/c.go", "package same\n")
+	f.write(t, "cmt/moved/a.go", "package moved // import \"canonical/path\"\n")
+	f.write(t, "cmt/conflict/a.go", "package conflict // import \"cmt/conflict\"\n")
+	f.write(t, "cmt/conflict/b.go", "package conflict // import \"cmt/other\"\n")
+	f.write(t, "vendor/canonical/path/a.go", "package path // import \"canonical/path\"\n")
+
+	f.log = log.New(ioutil.Discard, "", 0)
+	events, cancel := f.Subscribe()
+	defer cancel()
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	tests := []struct {
+		rel     string
+		comment string
+		err     string
+	}{
+		{"cmt/same", "cmt/same", ""},
+		{"cmt/moved", "canonical/path", "code in directory " + f.path("cmt/moved") +
+			` expects import "canonical/path"`},
+		{"cmt/conflict", "cmt/conflict", `found import comments "cmt/conflict" (a.go) and ` +
+			`"cmt/other" (b.go) in ` + f.path("cmt/conflict")},
+		{"vendor/canonical/path", "canonical/path", ""},
+		{"alpha", "", ""},
+	}
+	errs := f.Errors()
+	for _, x := range tests {
+		p, ok := f.packages.lookupPath(f.path(x.rel))
+		if !ok {
+			t.Fatalf("ImportComment: missing package: %s", x.rel)
+		}
+		if p.ImportComment != x.comment {
+			t.Errorf("ImportComment: %s: exp %q got %q", x.rel, x.comment, p.ImportComment)
+		}
+		err := errs[p.Dir]
+		switch {
+		case x.err == "" && err != nil:
+			t.Errorf("ImportComment: %s: unexpected error: %v", x.rel, err)
+		case x.err != "" && (err == nil || err.Error() != x.err || !IsImportComment(err)):
+			t.Errorf("ImportComment: %s: exp error %q got %v", x.rel, x.err, err)
+		}
+	}
+
+	found := make(map[string]bool)
+	timeout := time.After(time.Second * 5)
+	for len(found) < 2 {
+		select {
+		case e := <-events:
+			if ev, ok := e.(Event); ok && ev.Event() == ErrorEvent && IsImportComment(ev.Err) {
+				found[ev.Path] = true
+			}
+		case <-timeout:
+			t.Fatalf("ImportComment: timed out waiting for ErrorEvents: %v", found)
+		}
+	}
+
+	// Fixing the comment clears the error.
+	f.write(t, "cmt/moved/a.go", "package moved // import \"cmt/moved\"\n")
+	f.Update()
+	p, _ := f.packages.lookupPath(f.path("cmt/moved"))
+	if p == nil || p.ImportComment != "cmt/moved" || p.Error() != nil {
+		t.Errorf("ImportComment: update: %+v", p)
+	}
+
+	// Like the go command, the import path is not checked in module mode,
+	// but conflicting comments are still an error.
+	f.write(t, "cmt/moved/a.go", "package moved // import \"canonical/path\"\n")
+	f.ModuleMode = true
+	p, err := f.packages.ImportDir(f.path("cmt/moved"))
+	if err != nil || p.ImportComment != "canonical/path" || p.Error() != nil {
+		t.Errorf("ImportComment: module mode: %+v: %v", p, err)
+	}
+	p, _ = f.packages.ImportDir(f.path("cmt/conflict"))
+	if p == nil || !IsImportComment(p.Error()) {
+		t.Errorf("ImportComment: module mode: conflict: %+v", p)
+	}
+}
+
+func TestParseImportComment(t *testing.T) {
+	tests := map[string]string{
+		"package p // import \"a/b\"\n":       "a/b",
+		"package p /* import \"a/b\" */\n":    "a/b",
+		"package p //import \"a/b\"\n":        "a/b",
+		"package p // import a/b\n":           "",
+		"package p // imports \"a/b\"\n":      "",
+		"package p\n// import \"a/b\"\n":      "",
+		"package p // import `a/b`\n":         "a/b",
+		"package p // import \"a/b\" extra\n": "",
+	}
+	for src, exp := range tests {
+		fset := token.NewFileSet()
+		af, err := parser.ParseFile(fset, "p.go", src, parser.ImportsOnly|parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := importComment(fset, af); path != exp {
+			t.Errorf("importComment(%q) = %q; want: %q", src, path, exp)
+		}
+	}
+}
+
+func TestConstraintGoVersion(t *testing.T) {
+	tests := []struct {
+		line string
+		n    int
+	}{
+		{"//go:build linux", 0},
+		{"//go:build go1.21", 21},
+		{"//go:build !go1.21", 0},
+		{"//go:build go1.12 && go1.21", 21},
+		{"//go:build go1.12 || go1.21", 12},
+		{"//go:build go1.12 || linux", 0},
+		{"//go:build (go1.12 || go1.14) && !go1.21", 12},
+		{"//go:build go1.x", 0},
+		{"//go:build go2.1", 0},
+	}
+	for _, x := range tests {
+		expr, err := constraint.Parse(x.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := constraintGoVersion(expr); n != x.n {
+			t.Errorf("constraintGoVersion(%q) = %d; want: %d", x.line, n, x.n)
+		}
+	}
+}
+
+func TestIgnoredFiles(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	f.ctxt = NewContext(&ctxt, 0)
+	f.SetRoots([]string{f.root})
+
+	f.write(t, "alpha/alpha_windows.go", "package alpha\n")
+	f.write(t, "alpha/alpha_arm64.go", "package alpha\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := f.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("IgnoredFiles: missing package: alpha")
+	}
+	exp := map[string]string{
+		"alpha_arm64.go":   "file name does not match linux/amd64",
+		"alpha_tagged.go":  "build constraint not satisfied: ignore",
+		"alpha_windows.go": "file name does not match linux/amd64",
+	}
+	got := make(map[string]string)
+	for _, ig := range p.IgnoredFiles() {
+		got[ig.Name] = ig.Reason
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("IgnoredFiles:\nExp: %q\nGot: %q", exp, got)
+	}
+}
+
+func TestInvalidGoFiles(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "alpha/blob.go", "\x7fELF\x02\x01\x01\x00\x00\x00")
+	f.write(t, "alpha/latin1.go", "package alpha\n\n// caf\xe9\n")
+	f.write(t, "alpha/blob_test.go", "\x7fELF\x02\x01\x01\x00\x00\x00")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := f.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("InvalidGoFiles: missing package: alpha")
+	}
+	exp := map[string]string{
+		"blob.go":      "contains NUL bytes",
+		"blob_test.go": "contains NUL bytes",
+		"latin1.go":    "invalid UTF-8",
+	}
+	got := make(map[string]string)
+	for _, ig := range p.InvalidGoFiles() {
+		got[ig.Name] = ig.Reason
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("InvalidGoFiles:\nExp: %q\nGot: %q", exp, got)
+	}
+	// Invalid files are not parsed.
+	if err := p.indexError(); err != nil {
+		t.Errorf("InvalidGoFiles: unexpected error: %v", err)
+	}
+	if names, exp := p.GoFiles(), []string{"alpha.go"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("InvalidGoFiles: GoFiles: exp: %q got: %q", exp, names)
+	}
+	if list := f.Verify(); len(list) != 0 {
+		t.Errorf("InvalidGoFiles: Verify: unexpected discrepancies: %v", list)
+	}
+
+	// A file that becomes valid is parsed.
+	f.write(t, "alpha/blob.go", "package alpha\n\nfunc Blob() {}\n")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(f.path("alpha/blob.go"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	f.remove(t, "alpha/latin1.go")
+	f.remove(t, "alpha/blob_test.go")
+	f.Update()
+	p, _ = f.packages.lookupPath(f.path("alpha"))
+	if files := p.InvalidGoFiles(); len(files) != 0 {
+		t.Errorf("InvalidGoFiles: update: %+v", files)
+	}
+	if names, exp := p.GoFiles(), []string{"alpha.go", "blob.go"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("InvalidGoFiles: update: GoFiles: exp: %q got: %q", exp, names)
+	}
+	if _, ok := f.Definition("alpha", "Blob"); !ok {
+		t.Error("InvalidGoFiles: update: idents not indexed")
+	}
+}
+
+func TestSniffGoSource(t *testing.T) {
+	tests := []struct {
+		src       string
+		truncated bool
+		exp       string
+	}{
+		{"package p\n", false, ""},
+		{"package p // 世界\n", false, ""},
+		{"package p\x00", false, "contains NUL bytes"},
+		{"package p // \xff", false, "invalid UTF-8"},
+		// An incomplete rune at the end of a truncated file.
+		{"package p // \xe4\xb8", true, ""},
+		{"package p // \xe4\xb8", false, "invalid UTF-8"},
+	}
+	for _, test := range tests {
+		if got := sniffGoSource([]byte(test.src), test.truncated); got != test.exp {
+			t.Errorf("sniffGoSource(%q, %t): exp: %q got: %q", test.src, test.truncated, test.exp, got)
+		}
+	}
+}
+
+func TestNoBuildableGoError(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	f.ctxt = NewContext(&ctxt, 0)
+	f.SetRoots([]string{f.root})
+
+	f.write(t, "win/a_windows.go", "package win\n")
+	f.write(t, "win/b_windows.go", "package win\n")
+	f.write(t, "win/win_test.go", "package win\n")
+	f.write(t, "winbad/a_windows.go", "not Go\n")
+	f.write(t, "docs/README.md", "# no Go files\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Packages with only excluded files are indexed with the error.
+	p, ok := f.packages.lookupPath(f.path("win"))
+	if !ok {
+		t.Fatal("NoBuildableGoError: missing package: win")
+	}
+	var e *NoBuildableGoError
+	if !errors.As(p.Error(), &e) {
+		t.Fatalf("NoBuildableGoError: win: expected NoBuildableGoError got: %v", p.Error())
+	}
+	exp := []string{"a_windows.go", "b_windows.go"}
+	if e.Dir != p.Dir || !reflect.DeepEqual(e.Ignored, exp) {
+		t.Errorf("NoBuildableGoError: win: exp (%s, %q) got (%s, %q)", p.Dir, exp, e.Dir, e.Ignored)
+	}
+
+	// The name of the package cannot be found from the excluded file.
+	_, err := f.packages.ImportDir(f.path("winbad"))
+	if !errors.As(err, &e) || !reflect.DeepEqual(e.Ignored, []string{"a_windows.go"}) {
+		t.Errorf("NoBuildableGoError: winbad: got: %#v", err)
+	}
+
+	_, err = f.packages.ImportDir(f.path("docs"))
+	if !IsNoGo(err) || IsNoBuildableGo(err) {
+		t.Errorf("NoBuildableGoError: docs: expected NoGoError got: %v", err)
+	}
+
+	// The error is cleared once a file is buildable.
+	f.write(t, "win/c_linux.go", "package win\n")
+	if p, err = f.packages.ImportDir(f.path("win")); err != nil {
+		t.Fatal(err)
+	}
+	if p.Error() != nil {
+		t.Errorf("NoBuildableGoError: win: unexpected error: %v", p.Error())
+	}
+}
+
+func TestMatchFileCache(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := f.packages.lookupPath(f.path("alpha"))
+	if !ok {
+		t.Fatal("MatchFileCache: missing package: alpha")
+	}
+	file, ok := p.LookupFile("alpha.go")
+	if !ok {
+		t.Fatal("MatchFileCache: missing file: alpha.go")
+	}
+	if _, ok := f.packages.matches[file.Path]; !ok {
+		t.Fatal("MatchFileCache: result not cached")
+	}
+
+	// Cached results are used until the file changes.
+	f.packages.matches[file.Path] = matchEntry{
+		modTime: file.Info.ModTime(),
+		size:    file.Info.Size(),
+	}
+	if f.packages.matchFile(p, file) {
+		t.Error("MatchFileCache: cached result not used")
+	}
+	f.write(t, "alpha/alpha.go", "//go:build ignore\n\npackage alpha\n")
+	fi, err := os.Stat(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Info = fi
+	if f.packages.matchFile(p, file) {
+		t.Error("MatchFileCache: changed file not re-matched")
+	}
+	// The reason the file is ignored is cached with the result.
+	const reason = "build constraint not satisfied: ignore"
+	if e := f.packages.matches[file.Path]; e.reason != reason {
+		t.Errorf("MatchFileCache: reason: exp: %q got: %q", reason, e.reason)
+	}
+	e := f.packages.matches[file.Path]
+	e.reason = "cached"
+	f.packages.matches[file.Path] = e
+	if m := f.packages.match(p, file); m.reason != "cached" {
+		t.Errorf("MatchFileCache: cached reason not used: %q", m.reason)
+	}
+
+	// Changes to the build context drop all results.
+	gen := f.ctxt.generation()
+	f.ctxt.SetGoPath(f.dir)
+	if f.ctxt.generation() == gen {
+		t.Fatal("MatchFileCache: context generation not incremented")
+	}
+	f.packages.matchFile(p, file)
+	if n := len(f.packages.matches); n != 1 {
+		t.Errorf("MatchFileCache: expected 1 cached result got: %d", n)
+	}
+
+	// Results are removed with their package.
+	f.packages.removePath(p.Dir)
+	if _, ok := f.packages.matches[file.Path]; ok {
+		t.Error("MatchFileCache: result of removed package not removed")
+	}
+}
+
+func BenchmarkMatchFileCache(b *testing.B) {
+	c := NewCorpus()
+	root := c.ctxt.GOROOT()
+	if root == "" {
+		b.Skip("GOROOT must be set to run benchmark")
+		return
+	}
+	c.IndexGoCode = false
+	c.LogEvents = false
+	c.packages = newPackageIndex(c)
+	fi, err := fs.Stat(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	newTreeBuilder(c, c.MaxDepth).newDirTree(root, fi, 0, false)
+
+	// Re-match the files of the unchanged tree.
+	for _, noCache := range []bool{false, true} {
+		name := "Cache"
+		if noCache {
+			name = "NoCache"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if noCache {
+					c.packages.mmu.Lock()
+					c.packages.matches = nil
+					c.packages.mmu.Unlock()
+				}
+				c.packages.InvalidateContext(true)
+			}
+		})
+	}
+}
+
+func TestBuildConstraintChange(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Definition("alpha", "AlphaTagged"); ok {
+		t.Fatal("BuildConstraintChange: unexpected ident AlphaTagged")
+	}
+	// Cache the idents of the ignored files.
+	if ids := f.IdentsAllBuilds("AlphaTagged"); len(ids) != 1 {
+		t.Fatalf("BuildConstraintChange: IdentsAllBuilds: %+v", ids)
+	}
+
+	// Flip the build tag in place, without changing the size of the file
+	// or its directory, so that only the file is re-read.
+	rewrite := func(tag string) {
+		t.Helper()
+		path := f.path("alpha/alpha_tagged.go")
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := "//go:build " + tag + "\n\npackage alpha\n\nfunc AlphaTagged() {}\n"
+		if int64(len(src)) != fi.Size() {
+			t.Fatalf("BuildConstraintChange: size changed: %d => %d", fi.Size(), len(src))
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Ensure the modification time changes on file systems with
+		// a coarse resolution.
+		mtime := fi.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		f.Update()
+	}
+	lookup := func() (File, bool) {
+		t.Helper()
+		p, ok := f.packages.lookupPath(f.path("alpha"))
+		if !ok {
+			t.Fatal("BuildConstraintChange: missing package: alpha")
+		}
+		if _, ok := p.files[IgnoredGoFile]["alpha_tagged.go"]; ok {
+			return File{}, false
+		}
+		file, ok := p.files[GoFile]["alpha_tagged.go"]
+		return file, ok
+	}
+
+	rewrite("!ignor")
+	if _, ok := lookup(); !ok {
+		t.Error("BuildConstraintChange: alpha_tagged.go not moved to GoFiles")
+	}
+	if _, ok := f.Definition("alpha", "AlphaTagged"); !ok {
+		t.Error("BuildConstraintChange: missing ident AlphaTagged")
+	}
+	if _, ok := f.Definition("alpha", "AlphaFunc"); !ok {
+		t.Error("BuildConstraintChange: missing ident AlphaFunc")
+	}
+	if ids := f.IdentsAllBuilds("AlphaTagged"); len(ids) != 1 || ids[0].Constraint != "" {
+		t.Errorf("BuildConstraintChange: IdentsAllBuilds: %+v", ids)
+	}
+
+	rewrite("ignore")
+	if _, ok := lookup(); ok {
+		t.Error("BuildConstraintChange: alpha_tagged.go not moved to IgnoredGoFiles")
+	}
+	if _, ok := f.Definition("alpha", "AlphaTagged"); ok {
+		t.Error("BuildConstraintChange: ident AlphaTagged not removed")
+	}
+	if ids := f.IdentsAllBuilds("AlphaTagged"); len(ids) != 1 || ids[0].Constraint == "" {
+		t.Errorf("BuildConstraintChange: IdentsAllBuilds: %+v", ids)
+	}
+}
+
+func TestSetPlatform(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "alpha/alpha_linux.go", "package alpha\n\nimport \"os\"\n\nvar AlphaLinux = os.Getpid\n")
+	f.SetPlatform("linux", "amd64")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(goos string) *Package {
+		t.Helper()
+		f.SetPlatform(goos, "")
+		if s := f.ctxt.Context().GOOS; s != goos {
+			t.Fatalf("SetPlatform: GOOS: exp: %s got: %s", goos, s)
+		}
+		p, ok := f.packages.lookupPath(f.path("alpha"))
+		if !ok {
+			t.Fatal("SetPlatform: missing package: alpha")
+		}
+		return p
+	}
+
+	for _, goos := range []string{"linux", "windows", "linux"} {
+		p := lookup(goos)
+		_, buildable := p.files[GoFile]["alpha_linux.go"]
+		file, ignored := p.files[IgnoredGoFile]["alpha_linux.go"]
+		_, indexed := f.Definition("alpha", "AlphaLinux")
+		imports := p.Imports()
+		if goos == "linux" {
+			if !buildable || ignored {
+				t.Errorf("SetPlatform(%s): alpha_linux.go not in GoFiles", goos)
+			}
+			if !indexed {
+				t.Errorf("SetPlatform(%s): missing ident AlphaLinux", goos)
+			}
+			if !reflect.DeepEqual(imports, []string{"os"}) {
+				t.Errorf("SetPlatform(%s): Imports: %q", goos, imports)
+			}
+		} else {
+			if buildable || !ignored {
+				t.Errorf("SetPlatform(%s): alpha_linux.go not in IgnoredGoFiles", goos)
+			}
+			if file.reason == "" {
+				t.Errorf("SetPlatform(%s): alpha_linux.go: missing ignore reason", goos)
+			}
+			if indexed {
+				t.Errorf("SetPlatform(%s): ident AlphaLinux not removed", goos)
+			}
+			if len(imports) != 0 {
+				t.Errorf("SetPlatform(%s): Imports: %q", goos, imports)
+			}
+		}
+	}
+
+	// Setting the same platform does not change the Context.
+	gen := f.ctxt.generation()
+	f.SetPlatform("linux", "amd64")
+	if f.ctxt.generation() != gen {
+		t.Error("SetPlatform: unchanged platform incremented context generation")
+	}
+}
+
+func TestVerifyContent(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		f := newFixture(t, true)
+		f.VerifyContent = verify
+		if err := f.initDirTree(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Remove an ident from the index, it is restored only if the
+		// package is re-indexed.
+		f.idents.mu.Lock()
+		delete(f.idents.exports["alpha"], "AlphaFunc")
+		f.idents.mu.Unlock()
+
+		path := f.path("alpha/alpha.go")
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mtime := fi.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		f.Update()
+
+		_, reindexed := f.Definition("alpha", "AlphaFunc")
+		if reindexed == verify {
+			t.Errorf("VerifyContent (%t): touched file re-indexed: %t", verify, reindexed)
+		}
+		p, ok := f.packages.lookupPath(f.path("alpha"))
+		if !ok {
+			t.Fatal("VerifyContent: missing package: alpha")
+		}
+		if file, _ := p.LookupFile("alpha.go"); !file.Info.ModTime().Equal(mtime) {
+			t.Errorf("VerifyContent (%t): FileInfo not updated: %s", verify, file.Info.ModTime())
+		}
+		f.Close()
+	}
+}
+
+func TestPackageFilesCache(t *testing.T) {
+	p := &Package{Dir: "/go/src/p"}
+	add := func(typ GoFileType, name string) {
+		p.addFile(typ, File{Name: name, Path: p.Dir + "/" + name})
+	}
+	test := func(typ GoFileType, exp ...string) {
+		t.Helper()
+		if got := p.FileNames(typ); !reflect.DeepEqual(got, exp) {
+			t.Errorf("FileNames(%s): exp: %q got: %q", typ, exp, got)
+		}
+	}
+	if names := p.FileNames(-1); len(names) != 0 {
+		t.Errorf("FileNames: expected no files got: %q", names)
+	}
+	add(GoFile, "c.go")
+	add(GoFile, "a.go")
+	add(TestGoFile, "a_test.go")
+	test(-1, "a.go", "a_test.go", "c.go")
+	test(TestGoFile, "a.go", "a_test.go", "c.go") // TestGoFile&GoFile != 0
+
+	add(IgnoredGoFile, "b.go")
+	test(IgnoredGoFile, "a.go", "b.go", "c.go")
+	add(IgnoredGoFile, "a.go") // change type
+	if _, ok := p.files[IgnoredGoFile]["a.go"]; !ok {
+		t.Error("addFile: a.go: type not changed")
+	}
+	test(-1, "a.go", "a_test.go", "b.go", "c.go")
+
+	p.removeFile("b.go")
+	test(-1, "a.go", "a_test.go", "c.go")
+	p.removeNotSeen([]string{"a.go", "c.go"})
+	test(-1, "a.go", "c.go")
+	if paths := p.FilePaths(-1); !reflect.DeepEqual(paths, []string{"/go/src/p/a.go", "/go/src/p/c.go"}) {
+		t.Errorf("FilePaths: %q", paths)
+	}
+}
+
+func BenchmarkPackageFiles(b *testing.B) {
+	p := &Package{Dir: "/go/src/p"}
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("file%02d.go", 50-i)
+		typ := GoFile
+		switch i % 5 {
+		case 0:
+			typ = IgnoredGoFile
+		case 1:
+			typ = TestGoFile
+		}
+		p.addFile(typ, File{Name: name, Path: p.Dir + "/" + name})
+	}
+	b.Run("Files", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.Files(GoFile)
+		}
+	})
+	b.Run("FileNames", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.FileNames(TestGoFile)
+		}
+	})
+	b.Run("FilePaths", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.FilePaths(-1)
+		}
+	})
+}
+
+func TestFileMapFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Names in reverse order, each file has a unique size so that its Info
+	// can be matched to its Name.
+	m := make(FileMap)
+	names := []string{"z.go", "y.go", "m.go", "c.go", "b.go", "a.go"}
+	for i, name := range names {
+		path := filepath.Join(tmp, name)
+		if err := ioutil.WriteFile(path, make([]byte, i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[name] = File{Name: name, Path: path, Info: fi}
+	}
+	files := m.Files()
+	if len(files) != len(names) {
+		t.Fatalf("Files: exp: %d files got: %d", len(names), len(files))
+	}
+	for i, f := range files {
+		if i > 0 && files[i-1].Name >= f.Name {
+			t.Errorf("Files: not sorted: %q >= %q", files[i-1].Name, f.Name)
+		}
+		if f.Path != filepath.Join(tmp, f.Name) || f.Info.Name() != f.Name ||
+			f.Info.Size() != m[f.Name].Info.Size() {
+			t.Errorf("Files: mismatched File: %s", f)
+		}
+	}
+}
+
+func TestImporters(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "gamma/a.go", "package gamma\n\nimport \"alpha\"\n\nvar _ = alpha.AlphaConst\n")
+	f.write(t, "gamma/b.go", "package gamma\n\nimport (\n\t\"alpha\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint(alpha.AlphaConst)\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	test := func(stage, importPath string, exp []string) {
+		t.Helper()
+		if got := f.Importers(importPath); !reflect.DeepEqual(got, exp) {
+			t.Errorf("Importers (%s): %q: exp: %q got: %q", stage, importPath, exp, got)
+		}
+	}
+	test("init", "alpha", []string{"beta", "gamma"})
+	test("init", "vendored", []string{"beta"})
+	test("init", "fmt", []string{"gamma"})
+	test("init", "beta", nil)
+
+	fork := f.Fork()
+
+	// The imports of changed and removed files are updated.
+	f.write(t, "beta/beta.go", "package beta\n\nimport \"vendored\"\n\nfunc BetaFunc() { vendored.VendoredFunc() }\n")
+	f.remove(t, "gamma/b.go")
+	f.write(t, "delta/delta.go", "package delta\n\nimport \"beta\"\n")
+	f.Update()
+	test("update", "alpha", []string{"gamma"})
+	test("update", "fmt", nil)
+	test("update", "beta", []string{"delta"})
+
+	// Imports are only recorded for buildable Go files.
+	f.write(t, "gamma/a.go", "//go:build ignore\n\npackage gamma\n\nimport \"alpha\"\n")
+	f.write(t, "gamma/c.go", "package gamma\n")
+	f.Update()
+	test("ignored", "alpha", nil)
+
+	f.remove(t, "delta")
+	f.Update()
+	test("remove", "beta", nil)
+
+	// A fork keeps the imports of the Corpus when it was forked.
+	if got := fork.Importers("alpha"); !reflect.DeepEqual(got, []string{"beta", "gamma"}) {
+		t.Errorf("Importers (fork): %q", got)
+	}
+}
+
+func TestImports(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "gamma/a.go", "package gamma\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n")
+	f.write(t, "gamma/b.go", "package gamma\n\nimport (\n\t\"fmt\"\n\t\"io\"\n)\n\nvar _, _ = fmt.Sprint, io.EOF\n")
+	f.write(t, "gamma/c_ignored.go", "//go:build ignore\n\npackage gamma\n\nimport \"os\"\n")
+	f.write(t, "gamma/a_test.go", "package gamma\n\nimport \"testing\"\n")
+	f.write(t, "gamma/x_test.go", "package gamma_test\n\nimport (\n\t\"gamma\"\n\t\"io\"\n)\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := f.packages.lookupPath(f.path("gamma"))
+	if !ok {
+		t.Fatal("missing package: gamma")
+	}
+	if exp, got := []string{"fmt", "io"}, p.Imports(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Imports: exp: %q got: %q", exp, got)
+	}
+	if exp, got := []string{"fmt", "gamma", "io", "testing"}, p.AllImports(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("AllImports: exp: %q got: %q", exp, got)
+	}
+	if exp, got := []string{"x_test.go"}, p.XTestGoFiles(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("XTestGoFiles: exp: %q got: %q", exp, got)
+	}
+
+	// The imports of test files are updated.
+	f.write(t, "gamma/a_test.go", "package gamma\n\nimport \"strings\"\n\nvar _ = strings.Fields\n")
+	f.remove(t, "gamma/x_test.go")
+	f.Update()
+	if exp, got := []string{"fmt", "io", "strings"}, p.AllImports(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("AllImports: update: exp: %q got: %q", exp, got)
+	}
+	if exp, got := []string{"fmt", "io"}, p.Imports(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Imports: update: exp: %q got: %q", exp, got)
+	}
+}
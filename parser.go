@@ -1,10 +1,17 @@
 package pkg
 
 import (
+	"bytes"
+	"fmt"
 	"go/ast"
+	"go/build/constraint"
+	"go/doc"
 	"go/parser"
 	"go/token"
 	pathpkg "path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/charlievieth/pkg/fs"
 )
@@ -26,18 +33,223 @@ func parseFile(fset *token.FileSet, filename string, mode parser.Mode) (*ast.Fil
 	if err != nil {
 		return nil, err
 	}
+	// Do not parse binary or misnamed files, the parser reports confusing
+	// errors for them.
+	head, truncated := src, false
+	if len(head) > sniffLen {
+		head, truncated = head[:sniffLen], true
+	}
+	if reason := sniffGoSource(head, truncated); reason != "" {
+		return nil, &invalidGoFileError{path: filename, reason: reason}
+	}
 	return parser.ParseFile(fset, filename, src, mode)
 }
 
+// An invalidGoFileError, is returned by parseFile for a file named like a Go
+// file that is not Go source.
+type invalidGoFileError struct {
+	path   string
+	reason string // see sniffGoSource
+}
+
+func (e *invalidGoFileError) Error() string {
+	return e.path + ": " + e.reason
+}
+
+// sniffLen, is the number of bytes of a Go file checked by parseFile.  It is
+// enough to catch binary files and files in another encoding that are named
+// like Go files.
+const sniffLen = 1024
+
+// sniffGoSource, returns why src is not Go source, or an empty string if it
+// may be: Go source is UTF-8 and does not contain NUL bytes.  If truncated,
+// src is a prefix of the file and may end with an incomplete rune.
+func sniffGoSource(src []byte, truncated bool) string {
+	if bytes.IndexByte(src, 0) != -1 {
+		return "contains NUL bytes"
+	}
+	if truncated {
+		for i := len(src) - 1; i >= 0 && i >= len(src)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(src[i]) {
+				if !utf8.FullRune(src[i:]) {
+					src = src[:i]
+				}
+				break
+			}
+		}
+	}
+	if !utf8.Valid(src) {
+		return "invalid UTF-8"
+	}
+	return ""
+}
+
+// parseFiles, parses the Go files names in directory dirname.  Files that
+// fail to parse are skipped, the parsed files are returned along with a
+// fileErrors error listing the errors of the files that were skipped.
 func parseFiles(fset *token.FileSet, dirname string, names []string) (map[string]*ast.File, error) {
 	files := make(map[string]*ast.File, len(names))
+	var errs fileErrors
 	for _, n := range names {
 		p := pathpkg.Join(dirname, n)
 		af, err := parseFile(fset, p, parser.ParseComments)
 		if err != nil {
-			return nil, err
+			errs = append(errs, err)
+			continue
 		}
 		files[n] = af
 	}
+	if len(errs) != 0 {
+		return files, errs
+	}
 	return files, nil
 }
+
+// fileErrors, is a list of errors of files that failed to parse.
+type fileErrors []error
+
+func (e fileErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// fileConstraint, returns a description of the build constraints that gate
+// the Go file af with name filename.  The file name GOOS and GOARCH (if any)
+// and the file's build constraint line are joined with "&&".  An empty string
+// is returned if the file is not constrained.
+func fileConstraint(af *ast.File, filename string) string {
+	var tags []string
+	goos, goarch := fileNameTags(filename)
+	if goos != "" {
+		tags = append(tags, goos)
+	}
+	if goarch != "" {
+		tags = append(tags, goarch)
+	}
+	if expr := buildConstraint(af); expr != nil {
+		s := expr.String()
+		if _, ok := expr.(*constraint.OrExpr); ok {
+			s = "(" + s + ")"
+		}
+		tags = append(tags, s)
+	}
+	return strings.Join(tags, " && ")
+}
+
+// constraintGoVersion, returns the minor version N of the lowest Go release
+// "go1.N" required by build constraint expr, or zero if expr does not require
+// a release.  Negated release tags are not requirements, and of alternatives
+// the lowest required release is used.
+func constraintGoVersion(expr constraint.Expr) int {
+	switch x := expr.(type) {
+	case *constraint.TagExpr:
+		return releaseTagVersion(x.Tag)
+	case *constraint.AndExpr:
+		n, m := constraintGoVersion(x.X), constraintGoVersion(x.Y)
+		if m > n {
+			return m
+		}
+		return n
+	case *constraint.OrExpr:
+		n, m := constraintGoVersion(x.X), constraintGoVersion(x.Y)
+		if m < n {
+			return m
+		}
+		return n
+	}
+	return 0
+}
+
+// releaseTagVersion, returns N of release tag "go1.N", or zero if tag is not
+// a release tag.
+func releaseTagVersion(tag string) int {
+	if !strings.HasPrefix(tag, "go1.") {
+		return 0
+	}
+	n, err := strconv.Atoi(tag[len("go1."):])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// buildConstraint, returns the build constraint expression of Go file af,
+// preferring "//go:build" lines over "// +build" lines.  The file must have
+// been parsed with comments.
+func buildConstraint(af *ast.File) constraint.Expr {
+	var plus constraint.Expr
+	for _, g := range af.Comments {
+		if g.Pos() >= af.Package {
+			break
+		}
+		for _, c := range g.List {
+			switch {
+			case constraint.IsGoBuild(c.Text):
+				if expr, err := constraint.Parse(c.Text); err == nil {
+					return expr
+				}
+			case constraint.IsPlusBuild(c.Text):
+				expr, err := constraint.Parse(c.Text)
+				if err != nil {
+					continue
+				}
+				if plus == nil {
+					plus = expr
+				} else {
+					plus = &constraint.AndExpr{X: plus, Y: expr}
+				}
+			}
+		}
+	}
+	return plus
+}
+
+// packageDoc, returns the synopsis, the first sentence, of the package doc
+// comment of Go file af, or an empty string if it does not have one.  The
+// file must have been parsed with comments.
+func packageDoc(af *ast.File) string {
+	if af.Doc == nil {
+		return ""
+	}
+	return new(doc.Package).Synopsis(af.Doc.Text())
+}
+
+// importComment, returns the import path of the import comment of Go file
+// af, such as `package math // import "math"`, or an empty string if it does
+// not have one.  The comment must follow the package name on the same line.
+// The file must have been parsed with comments.
+func importComment(fset *token.FileSet, af *ast.File) string {
+	end := af.Name.End()
+	for _, g := range af.Comments {
+		if g.Pos() < end {
+			continue
+		}
+		c := g.List[0]
+		if fset.Position(c.Pos()).Line != fset.Position(end).Line {
+			return ""
+		}
+		text := c.Text[2:]
+		if c.Text[1] == '*' {
+			text = strings.TrimSuffix(text, "*/")
+		}
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, "import") {
+			return ""
+		}
+		rest := text[len("import"):]
+		if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			return ""
+		}
+		path, err := strconv.Unquote(strings.TrimSpace(rest))
+		if err != nil {
+			return ""
+		}
+		return path
+	}
+	return ""
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	pathpkg "path"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -53,6 +54,85 @@ func isWhitelisted(filename string) bool {
 	return whitelistedExts[key]
 }
 
+// Known GOOS and GOARCH values, used for interpreting file name build
+// constraints (i.e. "name_$GOOS_$GOARCH.go").
+//
+// See: go/build/syslist.go
+var knownOS = map[string]bool{
+	"aix":       true,
+	"android":   true,
+	"darwin":    true,
+	"dragonfly": true,
+	"freebsd":   true,
+	"hurd":      true,
+	"illumos":   true,
+	"ios":       true,
+	"js":        true,
+	"linux":     true,
+	"nacl":      true,
+	"netbsd":    true,
+	"openbsd":   true,
+	"plan9":     true,
+	"solaris":   true,
+	"wasip1":    true,
+	"windows":   true,
+	"zos":       true,
+}
+
+var knownArch = map[string]bool{
+	"386":         true,
+	"amd64":       true,
+	"amd64p32":    true,
+	"arm":         true,
+	"arm64":       true,
+	"arm64be":     true,
+	"armbe":       true,
+	"loong64":     true,
+	"mips":        true,
+	"mips64":      true,
+	"mips64le":    true,
+	"mips64p32":   true,
+	"mips64p32le": true,
+	"mipsle":      true,
+	"ppc":         true,
+	"ppc64":       true,
+	"ppc64le":     true,
+	"riscv":       true,
+	"riscv64":     true,
+	"s390":        true,
+	"s390x":       true,
+	"sparc":       true,
+	"sparc64":     true,
+	"wasm":        true,
+}
+
+// fileNameTags, returns the GOOS and GOARCH implied by the name of a Go
+// source file (i.e. "name_$GOOS_$GOARCH.go"), if any.
+//
+// See: go/build/build.go Context.goodOSArchFile for more information.
+func fileNameTags(name string) (goos, goarch string) {
+	name = strings.TrimSuffix(pathpkg.Base(name), pathpkg.Ext(name))
+	name = strings.TrimSuffix(name, "_test")
+	i := strings.IndexByte(name, '_')
+	if i < 0 {
+		return "", ""
+	}
+	l := strings.Split(name[i:], "_")
+	n := len(l)
+	if n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]] {
+		return l[n-2], l[n-1]
+	}
+	if n >= 1 {
+		switch {
+		case knownOS[l[n-1]]:
+			return l[n-1], ""
+		case knownArch[l[n-1]]:
+			return "", l[n-1]
+		}
+	}
+	return "", ""
+}
+
 // Filenames ignored by dirtree.
 var ignoredNames = map[string]bool{
 	// Conventional name for directories containing test data.
@@ -106,19 +186,48 @@ func isInternal(path string) bool {
 	return pathpkg.Base(path) == "internal"
 }
 
+// windowsPaths, is true if file paths are case-insensitive and may be
+// separated by backslashes, as on Windows.
+const windowsPaths = runtime.GOOS == "windows"
+
+// pathSeparators, are the separators trimmed from relative paths.
+const pathSeparators = "/" + string(os.PathSeparator)
+
+// isInternalPath, returns if any element of the slash-separated import path
+// is "internal", in which case the package may only be imported by the
+// packages of the tree rooted at its parent.
+func isInternalPath(path string) bool {
+	return path == "internal" || strings.HasPrefix(path, "internal/") ||
+		strings.HasSuffix(path, "/internal") || strings.Contains(path, "/internal/")
+}
+
 // trimPathPrefix, remove the prefix from path s.
 func trimPathPrefix(s, prefix string) string {
 	if hasRoot(s, prefix) {
-		return strings.TrimLeft(s[len(prefix):], "/")
+		return strings.TrimLeft(s[len(prefix):], pathSeparators)
 	}
 	return s
 }
 
 // hasRoot, returns if path is inside the directory tree rooted at root.
-// Should be used for internal paths (i.e. clean slash-separated paths).
+// Should be used for internal paths (i.e. clean slash-separated paths).  On
+// Windows the comparison ignores case and treats backslashes as slashes.
 func hasRoot(path, root string) bool {
 	// TODO: 'hasRoot' is a bad name, merge with 'hasPrefix'
-	return len(path) >= len(root) && path[0:len(root)] == root
+	if len(path) < len(root) {
+		return false
+	}
+	if path[0:len(root)] == root {
+		return true
+	}
+	return windowsPaths && equalFoldPath(path[0:len(root)], root)
+}
+
+// equalFoldPath, reports if paths a and b are equal ignoring case and
+// treating backslashes as slashes.
+func equalFoldPath(a, b string) bool {
+	return strings.EqualFold(strings.Replace(a, `\`, "/", -1),
+		strings.Replace(b, `\`, "/", -1))
 }
 
 // hasPrefix, returns if the path is inside the directory tree rooted at root.
@@ -131,12 +240,53 @@ func hasPrefix(path, prefix string) bool {
 	if len(path) < len(prefix) {
 		return false
 	}
+	if windowsPaths {
+		return hasRoot(path, prefix) || hasRoot(path, clean(prefix))
+	}
 	if strings.ContainsRune(prefix, os.PathSeparator) {
 		return strings.HasPrefix(path, clean(prefix))
 	}
 	return false
 }
 
+// modImportPath, returns the import path of the module cache directory rel,
+// which must be relative to the module cache root.  The "@version" suffix is
+// removed and the case-encoding of the module path is reversed, for example:
+//
+//	"github.com/!burnt!sushi/toml@v1.2.3/sub" => "github.com/BurntSushi/toml/sub"
+//
+// See: golang.org/x/mod/module.UnescapePath for more information.
+func modImportPath(rel string) string {
+	i := strings.IndexByte(rel, '@')
+	if i == -1 {
+		return unescapeModPath(rel)
+	}
+	mod, sub := rel[:i], ""
+	if j := strings.IndexByte(rel[i:], '/'); j != -1 {
+		sub = rel[i+j:]
+	}
+	return unescapeModPath(mod) + sub
+}
+
+// unescapeModPath, reverses the module cache case-encoding of path, in
+// which upper-case letters are replaced by '!' followed by the lower-case
+// letter.
+func unescapeModPath(path string) string {
+	if strings.IndexByte(path, '!') == -1 {
+		return path
+	}
+	b := make([]byte, 0, len(path))
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '!' && i+1 < len(path) && 'a' <= path[i+1] && path[i+1] <= 'z' {
+			i++
+			c = path[i] - 'a' + 'A'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
 // clean, converts OS specific separators to slashes and cleans path.
 func clean(path string) string {
 	return pathpkg.Clean(filepath.ToSlash(path))
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"testing"
+)
+
+func TestHasRootCaseSensitive(t *testing.T) {
+	var tests = []struct {
+		Path string
+		Root string
+		Ok   bool
+	}{
+		{`/usr/local/go/src/bufio`, `/usr/local/go/src`, true},
+		{`/usr/local/Go/src/bufio`, `/usr/local/go/src`, false},
+		{`C:/usr/local/go/src`, `C:\usr\local\go\src`, false},
+	}
+	for _, x := range tests {
+		if ok := hasRoot(x.Path, x.Root); ok != x.Ok {
+			t.Errorf("HasRoot (%+v): Exp (%v) Got (%v)", x, x.Ok, ok)
+		}
+	}
+	if ok := hasPrefix(`C:/usr/local/go/src`, `C:\usr\local\go\src`); ok {
+		t.Error("HasPrefix: backslashes are not separators")
+	}
+}
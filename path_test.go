@@ -45,13 +45,6 @@ func TestHasPrefix(t *testing.T) {
 			Prefix: `/usr/local/go/src/bufio`,
 			Ok:     false,
 		},
-
-		// Only works on Windows, TODO: Add build flags for tests.
-		{
-			Path:   `C:/usr/local/go/src`,
-			Prefix: `C:\usr\local\go\src`,
-			Ok:     false,
-		},
 	}
 	for _, x := range tests {
 		if ok := hasPrefix(x.Path, x.Prefix); ok != x.Ok {
@@ -60,6 +53,23 @@ func TestHasPrefix(t *testing.T) {
 	}
 }
 
+func TestEqualFoldPath(t *testing.T) {
+	var tests = []struct {
+		A, B string
+		Ok   bool
+	}{
+		{`C:/Go/src`, `c:/go/src`, true},
+		{`C:\Go\src`, `c:/go/src`, true},
+		{`C:\Go\src`, `c:/go/src/`, false},
+		{`C:/Go/src`, `C:/Go/bin`, false},
+	}
+	for _, x := range tests {
+		if ok := equalFoldPath(x.A, x.B); ok != x.Ok {
+			t.Errorf("EqualFoldPath (%+v): Exp (%v) Got (%v)", x, x.Ok, ok)
+		}
+	}
+}
+
 func TestIsInternal(t *testing.T) {
 	var tests = []struct {
 		Path string
@@ -80,3 +90,63 @@ func TestIsInternal(t *testing.T) {
 		}
 	}
 }
+
+func TestIsInternalPath(t *testing.T) {
+	var tests = []struct {
+		Path string
+		Ok   bool
+	}{
+		{"internal", true},
+		{"internal/poll", true},
+		{"crypto/internal/boring", true},
+		{"golang.org/x/tools/internal", true},
+		{"github.com/internals/foo", false},
+		{"foo/myinternal", false},
+	}
+	for _, x := range tests {
+		if ok := isInternalPath(x.Path); ok != x.Ok {
+			t.Errorf("IsInternalPath (%+v): Exp (%v) Got (%v)", x, x.Ok, ok)
+		}
+	}
+}
+
+func TestFileNameTags(t *testing.T) {
+	var tests = []struct {
+		Name   string
+		GOOS   string
+		GOARCH string
+	}{
+		{"file.go", "", ""},
+		{"linux.go", "", ""},
+		{"file_linux.go", "linux", ""},
+		{"file_amd64.go", "", "amd64"},
+		{"file_windows_386.go", "windows", "386"},
+		{"file_windows_386_test.go", "windows", "386"},
+		{"file_linux_test.go", "linux", ""},
+		{"file_foo_bar.go", "", ""},
+	}
+	for _, x := range tests {
+		goos, goarch := fileNameTags(x.Name)
+		if goos != x.GOOS || goarch != x.GOARCH {
+			t.Errorf("FileNameTags (%+v): Got (%s, %s)", x, goos, goarch)
+		}
+	}
+}
+
+func TestModImportPath(t *testing.T) {
+	var tests = []struct {
+		Rel  string
+		Path string
+	}{
+		{"example.com/foo@v1.2.3", "example.com/foo"},
+		{"example.com/foo@v1.2.3/bar/baz", "example.com/foo/bar/baz"},
+		{"github.com/!burnt!sushi/toml@v0.3.1", "github.com/BurntSushi/toml"},
+		{"example.com/foo/v2@v2.0.0-20190101000000-abcdef/x", "example.com/foo/v2/x"},
+		{"example.com/foo", "example.com/foo"},
+	}
+	for _, x := range tests {
+		if s := modImportPath(x.Rel); s != x.Path {
+			t.Errorf("ModImportPath (%s): Exp (%s) Got (%s)", x.Rel, x.Path, s)
+		}
+	}
+}
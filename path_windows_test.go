@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestHasRootWindows(t *testing.T) {
+	var tests = []struct {
+		Path string
+		Root string
+		Ok   bool
+	}{
+		{`c:/go/src/bufio`, `C:/Go/src`, true},
+		{`C:\Go\src\bufio`, `c:/go/src`, true},
+		{`C:/Go/src/bufio`, `C:\Go\src`, true},
+		{`D:/Go/src/bufio`, `C:/Go/src`, false},
+		{`C:/Go`, `C:/Go/src`, false},
+	}
+	for _, x := range tests {
+		if ok := hasRoot(x.Path, x.Root); ok != x.Ok {
+			t.Errorf("HasRoot (%+v): Exp (%v) Got (%v)", x, x.Ok, ok)
+		}
+	}
+}
+
+func TestTrimPathPrefixWindows(t *testing.T) {
+	var tests = []struct {
+		Path   string
+		Prefix string
+		Exp    string
+	}{
+		{`c:/go/src/bufio`, `C:/Go/src`, `bufio`},
+		{`C:\Go\src\bufio`, `c:/go/src`, `bufio`},
+		{`D:/Go/src/bufio`, `C:/Go/src`, `D:/Go/src/bufio`},
+	}
+	for _, x := range tests {
+		if s := trimPathPrefix(x.Path, x.Prefix); s != x.Exp {
+			t.Errorf("TrimPathPrefix (%+v): Exp (%s) Got (%s)", x, x.Exp, s)
+		}
+	}
+}
+
+func TestHasPrefixWindows(t *testing.T) {
+	var tests = []struct {
+		Path   string
+		Prefix string
+		Ok     bool
+	}{
+		{`C:/usr/local/go/src`, `C:\usr\local\go\src`, true},
+		{`c:/usr/local/go/src`, `C:\USR\local\`, true},
+		{`C:/usr/local/go/src`, `C:\usr\\local\\`, true},
+		{`C:/usr/local/go/src`, `D:\usr\local`, false},
+	}
+	for _, x := range tests {
+		if ok := hasPrefix(x.Path, x.Prefix); ok != x.Ok {
+			t.Errorf("HasPrefix (%+v): Exp (%v) Got (%v)", x, x.Ok, ok)
+		}
+	}
+}
+
+func TestMatchSrcDirWindows(t *testing.T) {
+	c := NewCorpus()
+	c.LogEvents = false
+	c.SetRoots([]string{`C:\Go\src`})
+	x := newPackageIndex(c)
+	srcDir, _, ok := x.matchSrcDir(`c:/go/src/net/http`)
+	if !ok {
+		t.Fatalf("MatchSrcDir: no source root for: %s", `c:/go/src/net/http`)
+	}
+	if rel := trimPathPrefix(`c:/go/src/net/http`, srcDir.Path); rel != "net/http" {
+		t.Errorf("MatchSrcDir: relative path: %q", rel)
+	}
+}
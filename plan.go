@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	pathpkg "path"
+	"sort"
+
+	"github.com/charlievieth/pkg/fs"
+)
+
+// An UpdatePlan lists the packages an update of the Corpus would change, by
+// package directory.
+type UpdatePlan struct {
+	Created []string // Packages that would be added to the index
+	Updated []string // Indexed packages that would be re-indexed
+	Deleted []string // Indexed packages that would be removed
+}
+
+// Empty, reports if the update would not change any packages.
+func (p *UpdatePlan) Empty() bool {
+	return len(p.Created) == 0 && len(p.Updated) == 0 && len(p.Deleted) == 0
+}
+
+// Plan, returns the changes an update of the Corpus (see Update) would make,
+// without modifying the index.  Like Update, the directory trees are walked
+// and the FileInfo of directories and package files are compared against the
+// index, but no files are parsed.  As a result packages that would fail to
+// index, such as those whose files fail to parse, may be reported as created
+// or updated.
+func (c *Corpus) Plan() (*UpdatePlan, error) {
+	if c.packages == nil {
+		return nil, errors.New("pkg: cannot plan update of uninitialized Corpus")
+	}
+	// Prevent concurrent updates from changing the index mid-plan.
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	t := newTreeBuilder(c, c.MaxDepth)
+	t.rescan = t.ignore != c.lastIgnore
+	pl := &planner{
+		t:       t,
+		x:       c.packages,
+		created: make(map[string]bool),
+		updated: make(map[string]bool),
+		deleted: make(map[string]bool),
+	}
+	prev := c.dirTrees()
+	seen := make(map[string]bool)
+	for _, srcDir := range c.srcDirs() {
+		root := srcDir.Path
+		seen[root] = true
+		fi, err := fs.Stat(root)
+		if err != nil || !fi.IsDir() {
+			pl.deleteTree(prev[root])
+			continue
+		}
+		pl.planDir(prev[root], root, fi, 0)
+	}
+	for root, dir := range prev {
+		if !seen[root] {
+			pl.deleteTree(dir)
+		}
+	}
+	return &UpdatePlan{
+		Created: sortedKeys(pl.created),
+		Updated: sortedKeys(pl.updated),
+		Deleted: sortedKeys(pl.deleted),
+	}, nil
+}
+
+// A planner, walks directory trees like updateDirTree and newDirTree, but
+// only records the package changes.  The treeBuilder is only used for its
+// seen and ignored methods, which do not modify the index.
+type planner struct {
+	t       *treeBuilder
+	x       *PackageIndex
+	created map[string]bool
+	updated map[string]bool
+	deleted map[string]bool
+}
+
+// planDir, plans the update of the directory at path, which is described by
+// the FileInfo fi.  Dir is the current Directory at path, or nil if path is
+// not indexed.
+func (pl *planner) planDir(dir *Directory, path string, fi os.FileInfo, depth int) {
+	if pl.t.seen(path) || pl.t.ignored(path, fi.Name()) {
+		pl.deleteTree(dir)
+		return
+	}
+	if depth >= pl.t.maxDepth {
+		// Packages below MaxDepth are removed.
+		if dir != nil {
+			for _, d := range dir.Dirs {
+				pl.deleteTree(d)
+			}
+		}
+		return
+	}
+
+	if dir != nil && !pl.t.rescan && fs.SameFile(dir.Info, fi) {
+		// The directory did not change, check the package files
+		// and existing sub-directories.
+		if dir.HasPkg {
+			pl.planPackageFiles(path)
+		}
+		for _, d := range dir.Dirs {
+			dfi, err := fs.Stat(d.Path)
+			if err != nil || !dfi.IsDir() {
+				pl.deleteTree(d)
+				continue
+			}
+			pl.planDir(d, d.Path, dfi, depth+1)
+		}
+		return
+	}
+
+	list, err := fs.Readdir(path)
+	if err != nil {
+		pl.deleteTree(dir)
+		return
+	}
+	pl.planPackage(path, fi, list)
+
+	names := make(map[string]bool)
+	for _, fi := range list {
+		if isPkgDir(fi) {
+			name := fi.Name()
+			names[name] = true
+			var d *Directory
+			if dir != nil {
+				d = dir.lookupLocal(name)
+			}
+			pl.planDir(d, pathpkg.Join(path, name), fi, depth+1)
+		}
+	}
+	if dir != nil {
+		for name, d := range dir.Dirs {
+			if !names[name] {
+				pl.deleteTree(d)
+			}
+		}
+	}
+}
+
+// planPackage, plans the update of the package in the new or changed
+// directory at path, list is the contents of the directory.
+func (pl *planner) planPackage(path string, fi os.FileInfo, list []os.FileInfo) {
+	p, found := pl.x.lookupPath(path)
+	if !isPkgDir(fi) || !hasGoFiles(list) {
+		if found {
+			pl.deleted[path] = true
+		}
+		return
+	}
+	if !found {
+		pl.created[path] = true
+		return
+	}
+	if p.mode != pl.x.mode() || p.mode == FindPackageName {
+		// Packages indexed by name are re-parsed when their
+		// directory changes.
+		pl.updated[path] = true
+		return
+	}
+	n := 0
+	for _, fi := range list {
+		name := fi.Name()
+		if fi.IsDir() || !pl.x.filterFiles(name) {
+			continue
+		}
+		var f File
+		var ok bool
+		if isGoFile(fi) {
+			if f, ok = p.LookupFile(name); !ok {
+				f, ok = p.invalid[name]
+			}
+		} else if pl.x.matchOther(name) {
+			f, ok = p.other[name]
+		} else {
+			continue
+		}
+		if !ok || !fs.SameFile(f.Info, fi) {
+			pl.updated[path] = true
+			return
+		}
+		n++
+	}
+	if n != p.fileLen(-1)+len(p.other)+len(p.invalid) {
+		// Files were removed.
+		pl.updated[path] = true
+	}
+}
+
+// planPackageFiles, plans the update of the package in the unchanged
+// directory at path, see PackageIndex.updatePkg.
+func (pl *planner) planPackageFiles(path string) {
+	p, found := pl.x.lookupPath(path)
+	switch {
+	case !found:
+		pl.created[path] = true
+		return
+	case p.mode != pl.x.mode():
+		pl.updated[path] = true
+		return
+	case p.mode == FindPackageName || !pl.x.c.IndexFileInfo:
+		return
+	}
+	for _, m := range [...]FileMap{p.files[IgnoredGoFile], p.files[TestGoFile], p.files[GoFile], p.other, p.invalid} {
+		for _, f := range m {
+			fi, err := fs.Stat(f.Path)
+			if err != nil || !fs.SameFile(f.Info, fi) {
+				pl.updated[path] = true
+				return
+			}
+		}
+	}
+}
+
+// deleteTree, records the deletion of the indexed packages rooted at dir.
+func (pl *planner) deleteTree(dir *Directory) {
+	if dir == nil {
+		return
+	}
+	if _, ok := pl.x.lookupPath(dir.Path); ok {
+		pl.deleted[dir.Path] = true
+	}
+	for _, d := range dir.Dirs {
+		pl.deleteTree(d)
+	}
+}
+
+// sortedKeys, returns the sorted keys of m.
+func sortedKeys(m map[string]bool) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	s := make([]string, 0, len(m))
+	for k := range m {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	if _, err := NewCorpus().Plan(); err == nil {
+		t.Error("Plan: expected error for uninitialized Corpus")
+	}
+
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	pl, err := f.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pl.Empty() {
+		t.Fatalf("Plan: expected empty plan for unchanged tree: %+v", pl)
+	}
+
+	f.remove(t, "beta")
+	f.write(t, "gamma/gamma.go", "package gamma\n")
+	f.write(t, "alpha/alpha.go", "package alpha\n\nfunc Changed() {}\n") // directory unchanged
+	f.write(t, "nested/inner/inner2.go", "package inner\n")
+
+	pl, err = f.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &UpdatePlan{
+		Created: []string{f.path("gamma")},
+		Updated: []string{f.path("alpha"), f.path("nested/inner")},
+		Deleted: []string{f.path("beta"), f.path("beta/vendor/vendored")},
+	}
+	if !reflect.DeepEqual(pl, exp) {
+		t.Errorf("Plan:\nExp: %+v\nGot: %+v", exp, pl)
+	}
+
+	// The index must not be modified.
+	if _, ok := f.packages.lookupPath(f.path("gamma")); ok {
+		t.Error("Plan: package created: gamma")
+	}
+	if _, ok := f.packages.lookupPath(f.path("beta")); !ok {
+		t.Error("Plan: package deleted: beta")
+	}
+	if pl2, _ := f.Plan(); !reflect.DeepEqual(pl2, pl) {
+		t.Errorf("Plan: plan changed:\nExp: %+v\nGot: %+v", pl, pl2)
+	}
+
+	f.updateIndex()
+	pl, err = f.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pl.Empty() {
+		t.Errorf("Plan: expected empty plan after update: %+v", pl)
+	}
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import "context"
+
+// scanBufferSize is the size of the buffer of the channel returned by Scan.
+const scanBufferSize = 64
+
+// Scan, walks the source roots and indexes their packages, like Init, and
+// sends a copy of each package to the returned channel as soon as it is
+// indexed, so that callers, such as command line tools, can report packages
+// while large trees are being walked.  The channel is closed once the walk
+// is complete and the directory trees are replaced.  Unlike Init, the index
+// is not refreshed afterwards, call Update to update it.
+//
+// Packages are sent in no particular order.  The channel is buffered, if the
+// caller stops receiving the walk blocks until ctx is done, so the caller
+// must either drain the channel or cancel ctx.  If ctx is canceled the walk
+// stops and the channel is closed, the packages indexed until then remain in
+// the index but only the directory trees of roots that were completely walked
+// are replaced.
+//
+// The options of the Corpus are validated like Init, if they are invalid
+// the error is logged and the channel closed without walking.  Updates are
+// blocked until the walk completes.  If the Corpus is a fork, the channel is
+// closed immediately.
+func (c *Corpus) Scan(ctx context.Context) <-chan *Package {
+	ch := make(chan *Package, scanBufferSize)
+	if c.forked {
+		close(ch)
+		return ch
+	}
+	go func() {
+		defer close(ch)
+		c.updateMu.Lock()
+		defer c.updateMu.Unlock()
+		if err := c.initIndexes(); err != nil {
+			c.log.Printf("Corpus: scan: %s", err)
+			return
+		}
+		t := newTreeBuilder(c, c.MaxDepth)
+		t.ctx = ctx
+		t.pkgs = ch
+		c.buildDirTrees(t)
+		// The package index is incomplete if the walk was canceled.
+		if !t.canceled() {
+			c.reconcile()
+		}
+	}()
+	return ch
+}
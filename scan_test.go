@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// scanPaths, receives the packages sent by Scan until the channel is closed
+// and returns their import paths, sorted.
+func scanPaths(t *testing.T, ch <-chan *Package) []string {
+	var paths []string
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case p, ok := <-ch:
+			if !ok {
+				sort.Strings(paths)
+				return paths
+			}
+			paths = append(paths, p.ImportPath)
+		case <-timeout:
+			t.Fatal("Scan: timed out waiting for the channel to close")
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+
+	paths := scanPaths(t, f.Scan(context.Background()))
+	var exp []string
+	f.EachPackage(func(p *Package) bool {
+		exp = append(exp, p.ImportPath)
+		return true
+	})
+	sort.Strings(exp)
+	if len(exp) == 0 || !reflect.DeepEqual(paths, exp) {
+		t.Errorf("Scan:\nExp: %q\nGot: %q", exp, paths)
+	}
+	if f.dirTrees()[f.root] == nil {
+		t.Error("Scan: missing directory tree")
+	}
+	if _, ok := f.Definition("alpha", "AlphaFunc"); !ok {
+		t.Error("Scan: idents not indexed")
+	}
+
+	// Packages that are already indexed are sent again and their idents are
+	// kept.
+	n := f.NumIdents()
+	if again := scanPaths(t, f.Scan(context.Background())); !reflect.DeepEqual(again, exp) {
+		t.Errorf("Scan: rescan:\nExp: %q\nGot: %q", exp, again)
+	}
+	if f.NumIdents() != n {
+		t.Errorf("Scan: rescan: NumIdents: exp: %d got: %d", n, f.NumIdents())
+	}
+
+	// The channel of a fork is closed immediately.
+	if paths := scanPaths(t, f.Fork().Scan(context.Background())); len(paths) != 0 {
+		t.Errorf("Scan: fork: %q", paths)
+	}
+}
+
+func TestScanCanceled(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if paths := scanPaths(t, f.Scan(ctx)); len(paths) != 0 {
+		t.Errorf("Scan: canceled: %q", paths)
+	}
+	if dirs := f.dirTrees(); len(dirs) != 0 {
+		t.Errorf("Scan: canceled: directory trees replaced: %v", dirs)
+	}
+
+	// Canceling while the caller is not receiving stops the walk.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < scanBufferSize*2; i++ {
+		f.write(t, fmt.Sprintf("many/p%03d/p.go", i), "package p\n")
+	}
+	ch := f.Scan(ctx)
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	if n := len(scanPaths(t, ch)); n >= scanBufferSize*2 {
+		t.Errorf("Scan: canceled: received %d packages", n)
+	}
+}
+
+func TestScanOptions(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.log = log.New(ioutil.Discard, "", 0)
+
+	// Invalid options are validated like Init.
+	f.IgnorePatterns = []string{"!negated"}
+	if paths := scanPaths(t, f.Scan(context.Background())); len(paths) != 0 {
+		t.Errorf("Scan: invalid IgnorePatterns: %q", paths)
+	}
+	f.IgnorePatterns = nil
+	f.RefreshMode = RefreshMode(-1)
+	if paths := scanPaths(t, f.Scan(context.Background())); len(paths) != 0 {
+		t.Errorf("Scan: invalid RefreshMode: %q", paths)
+	}
+	if dirs := f.dirTrees(); len(dirs) != 0 {
+		t.Errorf("Scan: invalid options: directory trees replaced: %v", dirs)
+	}
+}
+
+// Scan reconciles an Index loaded by LoadIndex, like Init.
+func TestScanLoadIndex(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.Index().Gob(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f.remove(t, "nested")
+
+	c := NewCorpus()
+	c.IndexGoCode = true
+	c.LogEvents = false
+	c.SetRoots([]string{f.root})
+	x, err := LoadIndex(&buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := c.Search("InnerFunc"); len(ids) == 0 {
+		t.Fatal("LoadIndex: missing idents")
+	}
+	scanPaths(t, c.Scan(context.Background()))
+	if c.Index() != x {
+		t.Error("Scan: loaded Index replaced")
+	}
+	if x.loaded {
+		t.Error("Scan: Index not reconciled")
+	}
+	if ids := c.Search("InnerFunc"); len(ids) != 0 {
+		t.Errorf("Scan: stale idents: %+v", ids)
+	}
+	if ids := c.Search("AlphaFunc"); len(ids) == 0 {
+		t.Error("Scan: missing idents")
+	}
+}
@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/charlievieth/pkg/fs"
+)
+
+// ErrStaleIdent is returned by Snippet when the file declaring an Ident
+// changed since it was indexed.
+var ErrStaleIdent = errors.New("pkg: file changed since ident was indexed")
+
+// maxLineCacheFiles is the maximum number of files in a lineCache.
+const maxLineCacheFiles = 256
+
+// A fileLines stores the line offsets of a file.
+type fileLines struct {
+	info  os.FileInfo // file info, used for invalidation
+	lines []int       // offset of the first byte of each line
+}
+
+// A lineCache caches the line offsets of files.
+type lineCache struct {
+	files map[string]*fileLines
+	mu    sync.Mutex
+}
+
+// lineOffsets, returns the offsets of the first byte of each line in src.
+func lineOffsets(src []byte) []int {
+	lines := make([]int, 1, bytes.Count(src, []byte{'\n'})+1)
+	for i, c := range src {
+		if c == '\n' && i+1 < len(src) {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// lookup, returns the line offsets of the file at path, reading the file if
+// it is not cached or changed.
+func (c *lineCache) lookup(path string) (*fileLines, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	fl := c.files[path]
+	c.mu.Unlock()
+	if fl != nil && fs.SameFile(fl.info, fi) {
+		return fl, nil
+	}
+	src, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fl = &fileLines{info: fi, lines: lineOffsets(src)}
+	c.mu.Lock()
+	if c.files == nil || len(c.files) >= maxLineCacheFiles {
+		c.files = make(map[string]*fileLines)
+	}
+	c.files[path] = fl
+	c.mu.Unlock()
+	return fl, nil
+}
+
+// Snippet returns the source line declaring Ident id and contextLines lines
+// before and after it.
+//
+// If the file changed since the Ident was indexed, such that the declaration
+// is no longer on the indexed line, ErrStaleIdent is returned along with the
+// snippet for the indexed line, if it still exists.
+func (c *Corpus) Snippet(id Ident, contextLines int) (string, error) {
+	if id.File == "" {
+		return "", errors.New("pkg: ident has no file")
+	}
+	fl, err := c.snippets.lookup(id.File)
+	if err != nil {
+		return "", err
+	}
+	line := id.Info.Line() - 1 // zero indexed
+	if line < 0 || line >= len(fl.lines) {
+		return "", ErrStaleIdent
+	}
+	if contextLines < 0 {
+		contextLines = 0
+	}
+	first := line - contextLines
+	if first < 0 {
+		first = 0
+	}
+	last := line + contextLines + 1
+	if last > len(fl.lines) {
+		last = len(fl.lines)
+	}
+	start := int64(fl.lines[first])
+	end := fl.info.Size()
+	if last < len(fl.lines) {
+		end = int64(fl.lines[last])
+	}
+
+	rc, err := fs.OpenFile(id.File)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	if _, err := io.CopyN(ioutil.Discard, rc, start); err != nil {
+		return "", err
+	}
+	b := make([]byte, end-start)
+	if _, err := io.ReadFull(rc, b); err != nil {
+		return "", ErrStaleIdent
+	}
+
+	// Make sure the declaration is still on the indexed line.
+	off := id.Info.Offset()
+	lineEnd := end
+	if line+1 < len(fl.lines) {
+		lineEnd = int64(fl.lines[line+1])
+	}
+	if off < fl.lines[line] || int64(off) >= lineEnd {
+		return string(b), ErrStaleIdent
+	}
+	return string(b), nil
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLineOffsets(t *testing.T) {
+	var tests = []struct {
+		src   string
+		lines []int
+	}{
+		{"", []int{0}},
+		{"a", []int{0}},
+		{"a\n", []int{0}},
+		{"a\nb", []int{0, 2}},
+		{"a\n\nb\n", []int{0, 2, 3}},
+	}
+	for _, x := range tests {
+		if lines := lineOffsets([]byte(x.src)); !reflect.DeepEqual(lines, x.lines) {
+			t.Errorf("lineOffsets (%q): exp (%v) got (%v)", x.src, x.lines, lines)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const src = "package a\n\n// F is a func.\nfunc F() {}\n\ntype T int\n"
+	path := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg := &Package{
+		Dir:        dir,
+		Name:       "a",
+		ImportPath: "a",
+	}
+	pkg.addFile(GoFile, File{Name: "a.go", Path: path})
+
+	c := &Corpus{IndexGoCode: true}
+	c.idents = newIndex(c)
+	c.idents.indexPackage(pkg)
+	exp := c.idents.lookupExports("a")
+
+	var tests = []struct {
+		name    string
+		context int
+		snippet string
+	}{
+		{"F", 0, "func F() {}\n"},
+		{"F", 1, "// F is a func.\nfunc F() {}\n\n"},
+		{"T", 2, "func F() {}\n\ntype T int\n"},
+		{"T", -1, "type T int\n"},
+	}
+	for _, x := range tests {
+		s, err := c.Snippet(exp[x.name], x.context)
+		if err != nil {
+			t.Errorf("Snippet (%s, %d): %v", x.name, x.context, err)
+			continue
+		}
+		if s != x.snippet {
+			t.Errorf("Snippet (%s, %d): exp (%q) got (%q)", x.name, x.context, x.snippet, s)
+		}
+	}
+
+	// Shift the declarations.
+	const changed = "package a\n\nfunc F() {}\n"
+	if err := ioutil.WriteFile(path, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Snippet(exp["F"], 0); err != ErrStaleIdent {
+		t.Errorf("Snippet: changed file: exp (%v) got (%v)", ErrStaleIdent, err)
+	}
+	if _, err := c.Snippet(exp["T"], 0); err != ErrStaleIdent {
+		t.Errorf("Snippet: truncated file: exp (%v) got (%v)", ErrStaleIdent, err)
+	}
+}
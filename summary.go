@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+)
+
+// A PackageSummary, is a compact description of a Package for list views and
+// API responses, which unlike a Package does not include its files.
+type PackageSummary struct {
+	ImportPath string // Import path of package "net/http"
+	Name       string // Package name "http"
+	Doc        string // Documentation synopsis, see Package.Synopsis
+	Goroot     bool   // Package found in Go root
+	Command    bool   // Package is a command, see Package.IsCommand
+	NumFiles   int    // Number of buildable Go files
+}
+
+// Summary, returns the PackageSummary of the package.
+func (p *Package) Summary() PackageSummary {
+	return PackageSummary{
+		ImportPath: p.ImportPath,
+		Name:       p.Name,
+		Doc:        p.Synopsis(),
+		Goroot:     p.Goroot,
+		Command:    p.IsCommand(),
+		NumFiles:   len(p.files[GoFile]),
+	}
+}
+
+// packageRank, returns the rank of Package p for SearchPackages query, lower
+// ranks are better matches, and if p matches query.  Argument lower is query
+// in lower case.
+func packageRank(p *Package, query, lower string) (int, bool) {
+	name := strings.ToLower(p.Name)
+	switch {
+	case p.Name == query || p.ImportPath == query:
+		return 0, true
+	case name == lower:
+		return 1, true
+	case strings.HasPrefix(name, lower):
+		return 2, true
+	case strings.Contains(name, lower):
+		return 3, true
+	case strings.Contains(strings.ToLower(p.ImportPath), lower):
+		return 4, true
+	}
+	return 0, false
+}
+
+// SearchPackages, returns the summaries of the indexed packages whose name or
+// import path contains query, ignoring case, ranked by how well they match:
+// packages named or with import path query, followed by packages named query
+// ignoring case, with a name starting with query, with a name containing
+// query and last with an import path containing query.  Packages of the same
+// rank are sorted by import path.
+func (c *Corpus) SearchPackages(query string) []PackageSummary {
+	if query == "" || c.packages == nil {
+		return nil
+	}
+	type match struct {
+		rank int
+		p    *Package
+		sum  PackageSummary
+	}
+	lower := strings.ToLower(query)
+	var matches []match
+	c.packages.each(func(p *Package) bool {
+		if rank, ok := packageRank(p, query, lower); ok {
+			matches = append(matches, match{rank: rank, p: p, sum: p.Summary()})
+		}
+		return true
+	})
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return byImportPath{matches[i].p, matches[j].p}.Less(0, 1)
+	})
+	if len(matches) == 0 {
+		return nil
+	}
+	s := make([]PackageSummary, len(matches))
+	for i, m := range matches {
+		s[i] = m.sum
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackageSummary(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	f.write(t, "alpha/doc.go", "// Package alpha does things.  More things.\npackage alpha\n")
+	f.write(t, "alpha/zdoc.go", "// Package alpha is ignored.\npackage alpha\n")
+	f.write(t, "cmd/alphacmd/main.go", "package main\n\nfunc main() {}\n")
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := mustPackage(t, f, "alpha")
+	exp := PackageSummary{
+		ImportPath: "alpha",
+		Name:       "alpha",
+		Doc:        "Package alpha does things.",
+		NumFiles:   len(p.files[GoFile]),
+	}
+	if got := p.Summary(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Summary:\nExp: %+v\nGot: %+v", exp, got)
+	}
+	if exp.NumFiles != 3 {
+		t.Errorf("Summary: NumFiles: exp: 3 got: %d", exp.NumFiles)
+	}
+
+	// The doc comment is removed with its file.
+	f.remove(t, "alpha/doc.go")
+	f.Update()
+	if s := p.Summary(); s.Doc != "Package alpha is ignored." {
+		t.Errorf("Summary: update: Doc: %q", s.Doc)
+	}
+
+	var got []string
+	for _, s := range f.SearchPackages("Alpha") {
+		got = append(got, s.ImportPath)
+	}
+	if want := []string{"alpha", "cmd/alphacmd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchPackages: exp: %q got: %q", want, got)
+	}
+	if s := f.SearchPackages("alphacmd"); len(s) != 1 || !s[0].Command {
+		t.Errorf("SearchPackages: command: %+v", s)
+	}
+
+	// Exact matches are ranked before partial matches.
+	got = got[:0]
+	for _, s := range f.SearchPackages("inner") {
+		got = append(got, s.ImportPath)
+	}
+	if want := []string{"nested/inner"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchPackages: exp: %q got: %q", want, got)
+	}
+	got = got[:0]
+	for _, s := range f.SearchPackages("e") {
+		got = append(got, s.ImportPath)
+	}
+	for i := 1; i < len(got); i++ {
+		ri, _ := packageRank(mustPackage(t, f, got[i-1]), "e", "e")
+		rj, _ := packageRank(mustPackage(t, f, got[i]), "e", "e")
+		if ri > rj {
+			t.Errorf("SearchPackages: %q ranked before %q", got[i-1], got[i])
+		}
+	}
+	if s := f.SearchPackages(""); s != nil {
+		t.Errorf("SearchPackages: empty query: %+v", s)
+	}
+	if s := f.SearchPackages("nomatch"); s != nil {
+		t.Errorf("SearchPackages: no match: %+v", s)
+	}
+}
+
+func TestPackageRank(t *testing.T) {
+	p := &Package{Name: "http", ImportPath: "net/http"}
+	tests := []struct {
+		query string
+		rank  int
+		ok    bool
+	}{
+		{"http", 0, true},
+		{"net/http", 0, true},
+		{"HTTP", 1, true},
+		{"ht", 2, true},
+		{"tp", 3, true},
+		{"net", 4, true},
+		{"NET/", 4, true},
+		{"https", 0, false},
+	}
+	for _, x := range tests {
+		rank, ok := packageRank(p, x.query, strings.ToLower(x.query))
+		if rank != x.rank || ok != x.ok {
+			t.Errorf("packageRank(%q): exp: %d, %t got: %d, %t", x.query, x.rank, x.ok, rank, ok)
+		}
+	}
+}
+
+// mustPackage, returns the indexed package with import path importPath.
+func mustPackage(t *testing.T, f *fixture, importPath string) *Package {
+	t.Helper()
+	for _, p := range f.packages.packageList(func(p *Package) bool { return p.ImportPath == importPath }) {
+		return p
+	}
+	t.Fatalf("package not found: %q", importPath)
+	return nil
+}
@@ -0,0 +1,269 @@
+package pkg
+
+import "strings"
+
+// A nameTrie, is a radix tree of ident names, used to walk the names of the
+// Index in sorted order or by prefix, which its maps of names per kind cannot
+// do without a full scan.  Names share the nodes of their common prefixes and
+// each name is stored once, regardless of how many kinds of ident use it.
+// The Idents themselves are only stored in the maps of the Index.
+//
+// To reduce memory the nodes are stored in a single slice and linked by
+// index, instead of being allocated individually and linked by pointer.
+// Removed nodes are kept on a free list and reused.
+//
+// A nameTrie is not safe for concurrent use, the Index guards it with its
+// mutex.  The zero value is an empty trie.
+type nameTrie struct {
+	nodes []trieNode // nodes[0] is the root, once initialized
+	root  [256]int32 // children of the root by the first byte of their prefix
+	free  int32      // first node of the free list, linked by next, or 0
+	names int        // number of names
+}
+
+// A trieNode, is a node of a nameTrie.  The name of a node is the
+// concatenation of the prefixes of the nodes on the path from the root.
+// Since the root is never a child, index 0 is used for no node.
+type trieNode struct {
+	prefix string // edge label, never empty except for the root
+	refs   int32  // times the name was added and not removed, 0 if not a name
+	child  int32  // first child, children are sorted by prefix
+	next   int32  // next sibling
+}
+
+// alloc, adds node n to the trie and returns its index.
+func (t *nameTrie) alloc(n trieNode) int32 {
+	if t.free != 0 {
+		i := t.free
+		t.free = t.nodes[i].next
+		t.nodes[i] = n
+		return i
+	}
+	t.nodes = append(t.nodes, n)
+	return int32(len(t.nodes) - 1)
+}
+
+// release, adds node i to the free list.
+func (t *nameTrie) release(i int32) {
+	t.nodes[i] = trieNode{next: t.free}
+	t.free = i
+}
+
+// child, returns the child of node n whose prefix starts with byte b, or 0,
+// and the sibling preceding it, or the sibling after which a child starting
+// with b would be linked.  If prev is 0 the child is, or would be, first.
+func (t *nameTrie) child(n int32, b byte) (prev, c int32) {
+	for c = t.nodes[n].child; c != 0; prev, c = c, t.nodes[c].next {
+		if x := t.nodes[c].prefix[0]; x >= b {
+			if x == b {
+				return prev, c
+			}
+			break
+		}
+	}
+	return prev, 0
+}
+
+// lookupChild, returns the child of node n whose prefix starts with byte b,
+// or 0.  Unlike child the root's children are found without a scan.
+func (t *nameTrie) lookupChild(n int32, b byte) int32 {
+	if n == 0 {
+		return t.root[b]
+	}
+	_, c := t.child(n, b)
+	return c
+}
+
+// link, links node i as a child of node n after sibling prev, see child.
+func (t *nameTrie) link(n, prev, i int32) {
+	if n == 0 {
+		t.root[t.nodes[i].prefix[0]] = i
+	}
+	if prev == 0 {
+		t.nodes[i].next = t.nodes[n].child
+		t.nodes[n].child = i
+	} else {
+		t.nodes[i].next = t.nodes[prev].next
+		t.nodes[prev].next = i
+	}
+}
+
+// unlink, unlinks node i, preceded by sibling prev, from the children of n.
+func (t *nameTrie) unlink(n, prev, i int32) {
+	if n == 0 {
+		t.root[t.nodes[i].prefix[0]] = 0
+	}
+	if prev == 0 {
+		t.nodes[n].child = t.nodes[i].next
+	} else {
+		t.nodes[prev].next = t.nodes[i].next
+	}
+}
+
+// merge, merges the only child of node n into n.
+func (t *nameTrie) merge(n int32) {
+	c := t.nodes[n].child
+	t.nodes[n].prefix += t.nodes[c].prefix
+	t.nodes[n].refs = t.nodes[c].refs
+	t.nodes[n].child = t.nodes[c].child
+	t.release(c)
+}
+
+// find, returns the node named name, or 0 if there is none.  The node may
+// not be a name.
+func (t *nameTrie) find(name string) int32 {
+	if len(t.nodes) == 0 {
+		return 0
+	}
+	var n int32
+	for name != "" {
+		c := t.lookupChild(n, name[0])
+		if c == 0 || !strings.HasPrefix(name, t.nodes[c].prefix) {
+			return 0
+		}
+		n = c
+		name = name[len(t.nodes[c].prefix):]
+	}
+	return n
+}
+
+// contains, reports if name is in the trie.
+func (t *nameTrie) contains(name string) bool {
+	if n := t.find(name); n != 0 || (name == "" && len(t.nodes) != 0) {
+		return t.nodes[n].refs != 0
+	}
+	return false
+}
+
+// add, adds name to the trie, or increments its reference count if it is
+// already in the trie.
+func (t *nameTrie) add(name string) {
+	if len(t.nodes) == 0 {
+		t.nodes = append(t.nodes, trieNode{})
+	}
+	var n int32
+	for name != "" {
+		prev, c := t.child(n, name[0])
+		if c == 0 {
+			c = t.alloc(trieNode{prefix: name})
+			t.link(n, prev, c)
+			n = c
+			break
+		}
+		l := commonPrefix(name, t.nodes[c].prefix)
+		if l < len(t.nodes[c].prefix) {
+			// Split the edge at the end of the common prefix.
+			mid := t.alloc(trieNode{prefix: t.nodes[c].prefix[:l], child: c})
+			t.unlink(n, prev, c)
+			t.link(n, prev, mid)
+			t.nodes[c].prefix = t.nodes[c].prefix[l:]
+			t.nodes[c].next = 0
+			c = mid
+		}
+		n = c
+		name = name[l:]
+	}
+	if t.nodes[n].refs == 0 {
+		t.names++
+	}
+	t.nodes[n].refs++
+}
+
+// remove, decrements the reference count of name and removes it, and any
+// nodes that are no longer needed, once it reaches zero.
+func (t *nameTrie) remove(name string) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	var parent, prev, n int32
+	for name != "" {
+		p, c := t.child(n, name[0])
+		if c == 0 || !strings.HasPrefix(name, t.nodes[c].prefix) {
+			return
+		}
+		parent, prev, n = n, p, c
+		name = name[len(t.nodes[c].prefix):]
+	}
+	if t.nodes[n].refs == 0 {
+		return
+	}
+	if t.nodes[n].refs--; t.nodes[n].refs != 0 {
+		return
+	}
+	t.names--
+	if n == 0 {
+		return // root
+	}
+	switch c := t.nodes[n].child; {
+	case c == 0:
+		t.unlink(parent, prev, n)
+		t.release(n)
+		if p := &t.nodes[parent]; parent != 0 && p.refs == 0 && t.nodes[p.child].next == 0 {
+			t.merge(parent)
+		}
+	case t.nodes[c].next == 0:
+		t.merge(n)
+	}
+}
+
+// walk, calls fn with each name starting with prefix, in sorted order, until
+// fn returns false.
+func (t *nameTrie) walk(prefix string, fn func(name string) bool) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	var n int32
+	var name []byte
+	for prefix != "" {
+		c := t.lookupChild(n, prefix[0])
+		if c == 0 {
+			return
+		}
+		l := commonPrefix(prefix, t.nodes[c].prefix)
+		if l < len(prefix) && l < len(t.nodes[c].prefix) {
+			return
+		}
+		name = append(name, t.nodes[c].prefix...)
+		n = c
+		prefix = prefix[l:]
+	}
+	t.walkNode(n, name, fn)
+}
+
+// walkNode, implements walk for the sub-tree rooted at node n with name name
+// and reports if the walk should continue.
+func (t *nameTrie) walkNode(n int32, name []byte, fn func(name string) bool) bool {
+	if t.nodes[n].refs != 0 && !fn(string(name)) {
+		return false
+	}
+	for c := t.nodes[n].child; c != 0; c = t.nodes[c].next {
+		if !t.walkNode(c, append(name, t.nodes[c].prefix...), fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// clone, returns a copy of the trie.
+func (t *nameTrie) clone() *nameTrie {
+	return &nameTrie{
+		nodes: append([]trieNode(nil), t.nodes...),
+		root:  t.root,
+		free:  t.free,
+		names: t.names,
+	}
+}
+
+// commonPrefix, returns the length of the common prefix of a and b.
+func commonPrefix(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
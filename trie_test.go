@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// trieNames, returns the names of trie t starting with prefix, in walk order.
+func trieNames(t *nameTrie, prefix string) []string {
+	var names []string
+	t.walk(prefix, func(name string) bool {
+		names = append(names, name)
+		return true
+	})
+	return names
+}
+
+// checkTrie, checks the invariants of trie t: the children of each node are
+// sorted, except for the root nodes that are not names have at least two
+// children, the index of the root's children is current and the names are
+// counted.
+func checkTrie(t *testing.T, tr *nameTrie) {
+	t.Helper()
+	if len(tr.nodes) == 0 {
+		return
+	}
+	names := 0
+	var check func(n int32)
+	check = func(n int32) {
+		node := tr.nodes[n]
+		if node.refs < 0 {
+			t.Errorf("nameTrie: node %q: negative refs: %d", node.prefix, node.refs)
+		}
+		if node.refs != 0 {
+			names++
+		}
+		if n != 0 && node.refs == 0 && (node.child == 0 || tr.nodes[node.child].next == 0) {
+			t.Errorf("nameTrie: node %q: fewer than 2 children and not a name", node.prefix)
+		}
+		var last byte
+		for c := node.child; c != 0; c = tr.nodes[c].next {
+			prefix := tr.nodes[c].prefix
+			if prefix == "" {
+				t.Errorf("nameTrie: node %q: empty child prefix", node.prefix)
+				continue
+			}
+			if c != node.child && last >= prefix[0] {
+				t.Errorf("nameTrie: node %q: children not sorted", node.prefix)
+			}
+			last = prefix[0]
+			check(c)
+		}
+	}
+	check(0)
+	if names != tr.names {
+		t.Errorf("nameTrie: names: exp: %d got: %d", names, tr.names)
+	}
+
+	// The root's children must match its index.
+	n := 0
+	for c := tr.nodes[0].child; c != 0; c = tr.nodes[c].next {
+		if tr.root[tr.nodes[c].prefix[0]] != c {
+			t.Errorf("nameTrie: root index: missing child %q", tr.nodes[c].prefix)
+		}
+		n++
+	}
+	for _, c := range tr.root {
+		if c != 0 {
+			n--
+		}
+	}
+	if n != 0 {
+		t.Errorf("nameTrie: root index: %d stale entries", -n)
+	}
+}
+
+func TestNameTrie(t *testing.T) {
+	tr := new(nameTrie)
+	if tr.contains("") || trieNames(tr, "") != nil {
+		t.Error("empty trie: unexpected names")
+	}
+	tr.remove("missing") // no-op
+
+	names := []string{"Read", "ReadAll", "Reader", "ReadFile", "Re", "Write", "r", ""}
+	for _, name := range names {
+		tr.add(name)
+	}
+	tr.add("Read") // Read is both a func and a method
+	checkTrie(t, tr)
+
+	if tr.names != len(names) {
+		t.Errorf("names: exp: %d got: %d", len(names), tr.names)
+	}
+	for _, name := range names {
+		if !tr.contains(name) {
+			t.Errorf("contains (%q): false", name)
+		}
+	}
+	for _, name := range []string{"Rea", "Readers", "R", "W", "x"} {
+		if tr.contains(name) {
+			t.Errorf("contains (%q): true", name)
+		}
+	}
+
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	if got := trieNames(tr, ""); !reflect.DeepEqual(got, sorted) {
+		t.Errorf("walk: exp: %q got: %q", sorted, got)
+	}
+	prefixTests := []struct {
+		prefix string
+		exp    []string
+	}{
+		{"Read", []string{"Read", "ReadAll", "ReadFile", "Reader"}},
+		{"Rea", []string{"Read", "ReadAll", "ReadFile", "Reader"}},
+		{"ReadF", []string{"ReadFile"}},
+		{"Re", []string{"Re", "Read", "ReadAll", "ReadFile", "Reader"}},
+		{"Ra", nil},
+		{"Readers", nil},
+		{"z", nil},
+	}
+	for _, x := range prefixTests {
+		if got := trieNames(tr, x.prefix); !reflect.DeepEqual(got, x.exp) {
+			t.Errorf("walk (%q): exp: %q got: %q", x.prefix, x.exp, got)
+		}
+	}
+
+	// Stop the walk early.
+	n := 0
+	tr.walk("", func(string) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("walk: expected walk to stop after %d names got: %d", 2, n)
+	}
+
+	clone := tr.clone()
+	for _, name := range []string{"Read", "Re", "", "r", "missing"} {
+		tr.remove(name)
+		checkTrie(t, tr)
+	}
+	// Read was added twice and is only removed once its count reaches zero.
+	if !tr.contains("Read") {
+		t.Error("remove: Read removed before its last reference")
+	}
+	tr.remove("Read")
+	checkTrie(t, tr)
+	if exp := []string{"ReadAll", "ReadFile", "Reader", "Write"}; !reflect.DeepEqual(trieNames(tr, ""), exp) {
+		t.Errorf("remove: exp: %q got: %q", exp, trieNames(tr, ""))
+	}
+	if tr.names != 4 {
+		t.Errorf("remove: names: exp: %d got: %d", 4, tr.names)
+	}
+	if got := trieNames(clone, ""); !reflect.DeepEqual(got, sorted) {
+		t.Errorf("clone: modified by remove: %q", got)
+	}
+
+	// Removed nodes are reused.
+	for _, name := range []string{"Read", "Re", "", "r"} {
+		tr.add(name)
+	}
+	checkTrie(t, tr)
+	if got := trieNames(tr, ""); !reflect.DeepEqual(got, sorted) {
+		t.Errorf("add: exp: %q got: %q", sorted, got)
+	}
+	if len(tr.nodes) != len(clone.nodes) {
+		t.Errorf("add: free nodes not reused: exp: %d nodes got: %d", len(clone.nodes), len(tr.nodes))
+	}
+}
+
+// Compare the trie to a map of reference counts with random operations.
+func TestNameTrieRandom(t *testing.T) {
+	rr := rand.New(rand.NewSource(1))
+	word := func() string {
+		const letters = "abc"
+		b := make([]byte, rr.Intn(6))
+		for i := range b {
+			b[i] = letters[rr.Intn(len(letters))]
+		}
+		return string(b)
+	}
+	tr := new(nameTrie)
+	m := make(map[string]int)
+	for i := 0; i < 5000; i++ {
+		name := word()
+		if rr.Intn(2) == 0 {
+			tr.add(name)
+			m[name]++
+		} else {
+			tr.remove(name)
+			if m[name]--; m[name] <= 0 {
+				delete(m, name)
+			}
+		}
+	}
+	checkTrie(t, tr)
+	var names []string
+	for name, refs := range m {
+		names = append(names, name)
+		if n := tr.find(name); (n != 0 || name == "") && int(tr.nodes[n].refs) != refs {
+			t.Errorf("refs (%q): exp: %d got: %d", name, refs, tr.nodes[n].refs)
+		}
+	}
+	sort.Strings(names)
+	if got := trieNames(tr, ""); !reflect.DeepEqual(got, names) {
+		t.Errorf("walk: exp: %q got: %q", names, got)
+	}
+	if tr.names != len(m) {
+		t.Errorf("names: exp: %d got: %d", len(m), tr.names)
+	}
+}
+
+var (
+	benchIdentsOnce sync.Once
+	benchIdents     []Ident
+)
+
+// loadBenchIdents, returns the Idents of the GOROOT and GOPATH.
+func loadBenchIdents(b *testing.B) []Ident {
+	benchIdentsOnce.Do(func() {
+		c := NewCorpus()
+		c.LogEvents = false
+		c.packages = newPackageIndex(c)
+		c.idents = newIndex(c)
+		if err := c.initDirTree(); err != nil {
+			b.Fatal(err)
+		}
+		benchIdents = c.idents.Idents()
+	})
+	if len(benchIdents) == 0 {
+		b.Skip("no idents indexed")
+	}
+	return benchIdents
+}
+
+// heapInUse, returns the bytes of allocated heap objects after a GC.
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// BenchmarkNameIndexMemory, reports the memory used to index the idents of
+// the GOROOT and GOPATH by kind and name, and the additional memory used by
+// the nameTrie of their names that is built for prefix searches.
+func BenchmarkNameIndexMemory(b *testing.B) {
+	ids := loadBenchIdents(b)
+	b.Run("Map", func(b *testing.B) {
+		var bytes uint64
+		for i := 0; i < b.N; i++ {
+			start := heapInUse()
+			m := make(map[TypKind]map[string][]Ident)
+			for _, id := range ids {
+				tk := id.Info.Kind()
+				if m[tk] == nil {
+					m[tk] = make(map[string][]Ident)
+				}
+				name := id.name()
+				m[tk][name] = append(m[tk][name], id)
+			}
+			bytes += heapInUse() - start
+			runtime.KeepAlive(m)
+		}
+		b.ReportMetric(float64(bytes)/float64(b.N), "heap-bytes/op")
+	})
+	b.Run("Names", func(b *testing.B) {
+		var bytes uint64
+		for i := 0; i < b.N; i++ {
+			start := heapInUse()
+			tr := new(nameTrie)
+			for _, id := range ids {
+				tr.add(id.name())
+			}
+			bytes += heapInUse() - start
+			runtime.KeepAlive(tr)
+		}
+		b.ReportMetric(float64(bytes)/float64(b.N), "heap-bytes/op")
+	})
+}
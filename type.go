@@ -24,6 +24,7 @@ const (
 	FuncDecl
 	MethodDecl
 	InterfaceDecl
+	AsmDecl // TEXT symbol of an assembly file, see Corpus.IndexAsm
 
 	// The last TypKind *must* be less than or equal to 8.
 	lastKind
@@ -37,6 +38,7 @@ var typKindStr = [...]string{
 	"FuncDecl",
 	"MethodDecl",
 	"InterfaceDecl",
+	"AsmDecl",
 }
 
 var typKindMap = map[string]TypKind{
@@ -47,6 +49,7 @@ var typKindMap = map[string]TypKind{
 	"FuncDecl":      FuncDecl,
 	"MethodDecl":    MethodDecl,
 	"InterfaceDecl": InterfaceDecl,
+	"AsmDecl":       AsmDecl,
 }
 
 // String, returns the string representation of t.
@@ -84,6 +87,39 @@ func (t *TypKind) UnmarshalJSON(b []byte) (err error) {
 	return err
 }
 
+// A KindSet is a set of TypKinds, used to filter query results by kind, see
+// QueryOptions.  The zero KindSet, AllKinds, matches all kinds.
+type KindSet uint32
+
+const (
+	// AllKinds, matches all kinds.
+	AllKinds KindSet = 0
+
+	// CallableKinds, matches funcs and methods, including interface methods.
+	CallableKinds KindSet = 1<<FuncDecl | 1<<MethodDecl | 1<<InterfaceDecl
+
+	// TypeKinds, matches types.
+	TypeKinds KindSet = 1 << TypeDecl
+
+	// ValueKinds, matches constants and variables.
+	ValueKinds KindSet = 1<<ConstDecl | 1<<VarDecl
+)
+
+// Kinds, returns a KindSet of kinds.
+func Kinds(kinds ...TypKind) KindSet {
+	var s KindSet
+	for _, k := range kinds {
+		s |= 1 << k
+	}
+	return s
+}
+
+// Has, reports if KindSet s contains kind k.  All kinds are in the zero
+// KindSet.
+func (s KindSet) Has(k TypKind) bool {
+	return s == AllKinds || s&(1<<k) != 0
+}
+
 // A TypeInfo value describes a particular identifier spot in a given file.
 // It encodes three values: the TypeKind, and the file line and offset.
 //
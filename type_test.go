@@ -125,3 +125,40 @@ func TestTypeInfoJSON(t *testing.T) {
 		t.Fatalf("TestTypeInfoJSON: Expected %v Got %v", k, v)
 	}
 }
+
+func TestKindSet(t *testing.T) {
+	for k := InvalidDecl; k < lastKind; k++ {
+		if !AllKinds.Has(k) {
+			t.Errorf("AllKinds: missing %s", k)
+		}
+		if s := Kinds(k); !s.Has(k) || s != 1<<k {
+			t.Errorf("Kinds(%s): %b", k, s)
+		}
+	}
+	// The predefined sets are typed, so their methods may be called directly.
+	if !CallableKinds.Has(FuncDecl) || !TypeKinds.Has(TypeDecl) || !ValueKinds.Has(VarDecl) {
+		t.Error("KindSet: predefined set missing kind")
+	}
+	tests := []struct {
+		set  KindSet
+		kind TypKind
+		has  bool
+	}{
+		{CallableKinds, FuncDecl, true},
+		{CallableKinds, MethodDecl, true},
+		{CallableKinds, InterfaceDecl, true},
+		{CallableKinds, TypeDecl, false},
+		{TypeKinds, TypeDecl, true},
+		{TypeKinds, VarDecl, false},
+		{ValueKinds, ConstDecl, true},
+		{ValueKinds, VarDecl, true},
+		{ValueKinds, FuncDecl, false},
+		{Kinds(TypeDecl, FuncDecl), FuncDecl, true},
+		{Kinds(TypeDecl, FuncDecl), MethodDecl, false},
+	}
+	for _, x := range tests {
+		if has := x.set.Has(x.kind); has != x.has {
+			t.Errorf("KindSet(%b).Has(%s): exp: %t got: %t", x.set, x.kind, x.has, has)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sync"
+	"sync/atomic"
+)
+
+// TypeInfo, returns the type-checked package with import path importPath.
+// Packages are type-checked from source using the build context of the
+// Corpus, their imports are resolved by the Corpus, including vendored
+// imports, and type-checked as needed.
+//
+// If the package has type errors, the partially checked package is returned
+// along with the first error.  Type checking must be enabled with
+// Corpus.TypeCheck.  Results are cached until a package is updated or
+// removed from the index.
+func (c *Corpus) TypeInfo(importPath string) (*types.Package, error) {
+	if !c.TypeCheck {
+		return nil, errors.New("pkg: type checking is disabled (see Corpus.TypeCheck)")
+	}
+	p, err := c.importPackage(importPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.typeChecker().check(p)
+}
+
+// typeMethodSet, returns the method set of type typeName declared by the
+// package with import path importPath using type information, see MethodSet.
+// Reports false if the type could not be resolved.
+func (c *Corpus) typeMethodSet(importPath, typeName string) ([]Ident, bool) {
+	p, err := c.importPackage(importPath)
+	if err != nil {
+		return nil, false
+	}
+	return c.typeChecker().methodSet(p, typeName)
+}
+
+// typeChecker, returns the typeChecker of the Corpus, creating it if needed.
+func (c *Corpus) typeChecker() *typeChecker {
+	c.mu.Lock()
+	if c.types == nil {
+		c.types = newTypeChecker(c)
+	}
+	tc := c.types
+	c.mu.Unlock()
+	return tc
+}
+
+// invalidateTypes, invalidates the cached type-checked packages.  Called
+// when a package is updated or removed, since any type-checked package may
+// depend on it.
+func (c *Corpus) invalidateTypes() {
+	c.mu.RLock()
+	tc := c.types
+	c.mu.RUnlock()
+	if tc != nil {
+		tc.invalidate()
+	}
+}
+
+// A typeChecker, type-checks the packages of a Corpus and caches the results
+// by package directory.
+type typeChecker struct {
+	// Incremented when the package index changes, atomic.  The cache is
+	// cleared when it no longer matches gen.  First for 64-bit alignment.
+	latest uint64
+
+	c    *Corpus
+	fset *token.FileSet
+	pkgs map[string]*typedPackage // package directory => result
+	gen  uint64                   // generation of pkgs
+	mu   sync.Mutex               // serializes type checking
+}
+
+// A typedPackage, is the result of type-checking a package, pkg is nil while
+// the package is being checked, which is used to detect import cycles.
+type typedPackage struct {
+	pkg *types.Package
+	err error
+}
+
+func newTypeChecker(c *Corpus) *typeChecker {
+	return &typeChecker{
+		c:    c,
+		fset: token.NewFileSet(),
+		pkgs: make(map[string]*typedPackage),
+	}
+}
+
+// invalidate, invalidates the cache.  The cache is cleared on the next call
+// to check, since invalidate may be called while packages are imported during
+// type checking.
+func (tc *typeChecker) invalidate() {
+	atomic.AddUint64(&tc.latest, 1)
+}
+
+// check, returns the type-checked Package p.
+func (tc *typeChecker) check(p *Package) (*types.Package, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.sync()
+	return tc.load(p)
+}
+
+// sync, clears the cache if the package index changed since it was filled.
+// Lock the mutex before calling.
+func (tc *typeChecker) sync() {
+	if gen := atomic.LoadUint64(&tc.latest); gen != tc.gen {
+		tc.fset = token.NewFileSet()
+		tc.pkgs = make(map[string]*typedPackage)
+		tc.gen = gen
+	}
+}
+
+// methodSet, returns the methods of the type typeName declared by Package p,
+// including promoted methods, and reports if the type was found.  The method
+// set of *T is used for non-interface types, since it includes the methods
+// of both T and *T.
+func (tc *typeChecker) methodSet(p *Package, typeName string) ([]Ident, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.sync()
+	pkg, _ := tc.load(p)
+	if pkg == nil {
+		return nil, false
+	}
+	obj, ok := pkg.Scope().Lookup(typeName).(*types.TypeName)
+	if !ok {
+		return nil, false
+	}
+	typ := obj.Type()
+	if !types.IsInterface(typ) {
+		typ = types.NewPointer(typ)
+	}
+	mset := types.NewMethodSet(typ)
+	ids := make([]Ident, 0, mset.Len())
+	for i := 0; i < mset.Len(); i++ {
+		fn, ok := mset.At(i).Obj().(*types.Func)
+		if !ok || fn.Pkg() == nil {
+			continue
+		}
+		// Unexported methods promoted from other packages cannot
+		// be selected, even by the package declaring typeName.
+		if !fn.Exported() && fn.Pkg() != pkg {
+			continue
+		}
+		ids = append(ids, tc.methodIdent(fn, typeName))
+	}
+	return ids, true
+}
+
+// methodIdent, returns the Ident of method fn.  The Ident is named after the
+// type that declares the method, which differs from typeName for promoted
+// methods.
+func (tc *typeChecker) methodIdent(fn *types.Func, typeName string) Ident {
+	tk := MethodDecl
+	recv := typeName
+	if sig, ok := fn.Type().(*types.Signature); ok && sig.Recv() != nil {
+		t := sig.Recv().Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if named, ok := t.(*types.Named); ok {
+			recv = named.Obj().Name()
+		}
+		if types.IsInterface(t) {
+			tk = InterfaceDecl
+		}
+	}
+	pos := tc.fset.Position(fn.Pos())
+	return Ident{
+		Name:    recv + "." + fn.Name(),
+		Package: fn.Pkg().Name(),
+		Path:    fn.Pkg().Path(),
+		File:    pos.Filename,
+		Info:    makeTypInfo(tk, pos.Offset, pos.Line),
+	}
+}
+
+// load, returns the type-checked Package p from the cache, or type-checks it.
+// Lock the mutex before calling.
+func (tc *typeChecker) load(p *Package) (*types.Package, error) {
+	if t, ok := tc.pkgs[p.Dir]; ok {
+		if t.pkg == nil && t.err == nil {
+			return nil, fmt.Errorf("pkg: import cycle through package %q", p.ImportPath)
+		}
+		return t.pkg, t.err
+	}
+	t := &typedPackage{}
+	tc.pkgs[p.Dir] = t
+
+	files, err := parseFiles(tc.fset, p.Dir, p.GoFiles())
+	if len(files) == 0 && err != nil {
+		t.err = err
+		return nil, err
+	}
+	list := make([]*ast.File, 0, len(files))
+	for _, name := range p.GoFiles() {
+		if af := files[name]; af != nil {
+			list = append(list, af)
+		}
+	}
+
+	ctxt := tc.c.ctxt.Snapshot()
+	conf := types.Config{
+		Importer:    &typeImporter{tc: tc, dir: p.Dir},
+		FakeImportC: true,
+		Sizes:       types.SizesFor(ctxt.Compiler, ctxt.GOARCH),
+		Error: func(e error) {
+			// Report the first error, but keep checking.
+			if err == nil {
+				err = e
+			}
+		},
+	}
+	t.pkg, _ = conf.Check(p.ImportPath, tc.fset, list, nil)
+	t.err = err
+	return t.pkg, t.err
+}
+
+// A typeImporter, imports packages for the package in directory dir.
+type typeImporter struct {
+	tc  *typeChecker
+	dir string
+}
+
+func (ti *typeImporter) Import(path string) (*types.Package, error) {
+	return ti.ImportFrom(path, ti.dir, 0)
+}
+
+// ImportFrom, implements types.ImporterFrom.  Vendored packages are resolved
+// relative to dir.  Type errors of imported packages are ignored so that
+// importers can still be checked, they are reported by TypeInfo.
+func (ti *typeImporter) ImportFrom(path, dir string, _ types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	p, err := ti.tc.c.resolveImport(path, dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := ti.tc.load(p)
+	if pkg != nil {
+		return pkg, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"go/types"
+	"reflect"
+	"testing"
+)
+
+func TestTypeInfo(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.TypeInfo("alpha"); err == nil {
+		t.Error("TypeInfo: expected error when TypeCheck is disabled")
+	}
+	f.TypeCheck = true
+
+	beta, err := f.TypeInfo("beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := beta.Scope().Lookup("BetaFunc").(*types.Func); !ok {
+		t.Errorf("TypeInfo: beta: missing func BetaFunc")
+	}
+	imports := make(map[string]bool)
+	for _, p := range beta.Imports() {
+		imports[p.Path()] = true
+	}
+	for _, path := range []string{"alpha", "beta/vendor/vendored"} {
+		if !imports[path] {
+			t.Errorf("TypeInfo: beta: missing import %q: %v", path, beta.Imports())
+		}
+	}
+
+	alpha, err := f.TypeInfo("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := alpha.Scope().Lookup("AlphaType").(*types.TypeName)
+	if !ok {
+		t.Fatal("TypeInfo: alpha: missing type AlphaType")
+	}
+	if m, _, _ := types.LookupFieldOrMethod(obj.Type(), false, alpha, "Method"); m == nil {
+		t.Error("TypeInfo: alpha: missing method AlphaType.Method")
+	}
+	if p, _ := f.TypeInfo("alpha"); p != alpha {
+		t.Error("TypeInfo: alpha: result not cached")
+	}
+
+	// Changes to the index invalidate the cache.
+	f.write(t, "alpha/alpha2.go", "package alpha\n\nfunc Alpha2() {}\n")
+	f.updateIndex()
+	if alpha, err = f.TypeInfo("alpha"); err != nil {
+		t.Fatal(err)
+	}
+	if alpha.Scope().Lookup("Alpha2") == nil {
+		t.Error("TypeInfo: alpha: cache not invalidated")
+	}
+
+	// Packages with type errors are returned with the error.
+	f.write(t, "typeerr/typeerr.go", "package typeerr\n\nvar X int = \"s\"\n\nfunc F() {}\n")
+	f.updateIndex()
+	p, err := f.TypeInfo("typeerr")
+	if err == nil {
+		t.Error("TypeInfo: typeerr: expected type error")
+	}
+	if p == nil || p.Scope().Lookup("F") == nil {
+		t.Error("TypeInfo: typeerr: expected partially checked package")
+	}
+	if _, err := f.TypeInfo("missing"); err == nil {
+		t.Error("TypeInfo: expected error for missing package")
+	}
+}
+
+func TestMethodSet(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.write(t, "embed/embed.go", `package embed
+
+import "alpha"
+
+type Base struct{}
+
+func (Base) Value() {}
+
+func (*Base) Pointer() {}
+
+type Iface interface {
+	IfaceMethod()
+}
+
+type T struct {
+	Base
+	alpha.AlphaType
+	Iface
+}
+
+func (T) Own() {}
+
+func (*T) own() {}
+`)
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(ids []Ident) []string {
+		var s []string
+		for _, id := range ids {
+			s = append(s, id.Path+":"+id.Name+":"+id.Info.Kind().String())
+		}
+		return s
+	}
+
+	// Without type information only the declared methods are returned.
+	exp := []string{"embed:T.Own:MethodDecl", "embed:T.own:MethodDecl"}
+	if got := names(f.MethodSet("embed", "T")); !reflect.DeepEqual(got, exp) {
+		t.Errorf("MethodSet:\nExp: %q\nGot: %q", exp, got)
+	}
+
+	f.TypeCheck = true
+	exp = []string{
+		"embed:Iface.IfaceMethod:InterfaceDecl",
+		"alpha:AlphaType.Method:MethodDecl",
+		"embed:T.Own:MethodDecl",
+		"embed:Base.Pointer:MethodDecl",
+		"embed:Base.Value:MethodDecl",
+		"embed:T.own:MethodDecl",
+	}
+	ids := f.MethodSet("embed", "T")
+	if got := names(ids); !reflect.DeepEqual(got, exp) {
+		t.Errorf("MethodSet:\nExp: %q\nGot: %q", exp, got)
+	}
+	for _, id := range ids {
+		if id.File == "" || id.Info.Line() == 0 {
+			t.Errorf("MethodSet: missing position: %+v", id)
+		}
+	}
+	if id, ok := f.Definition("embed", "T.Own"); ok {
+		for _, m := range ids {
+			if m.Name == "T.Own" && m.Position() != id.Position() {
+				t.Errorf("MethodSet: position: Exp: %s Got: %s", id.Position(), m.Position())
+			}
+		}
+	}
+
+	exp = []string{"embed:Iface.IfaceMethod:InterfaceDecl"}
+	if got := names(f.MethodSet("embed", "Iface")); !reflect.DeepEqual(got, exp) {
+		t.Errorf("MethodSet: Iface:\nExp: %q\nGot: %q", exp, got)
+	}
+	if ids := f.MethodSet("embed", "Missing"); len(ids) != 0 {
+		t.Errorf("MethodSet: Missing: expected no methods: %v", ids)
+	}
+}
@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+
+	"github.com/charlievieth/pkg/fs"
+)
+
+// UpdatePaths updates the packages in directories dirs, and their nodes in
+// the directory trees, without walking the rest of the source roots.  It is
+// intended for callers, such as file watchers or editors, that know which
+// directories changed, and is far cheaper than Update.
+//
+// Each directory is re-read and its package is created, updated or removed
+// accordingly, sub-directories are not visited.  Directories that do not
+// exist are removed from the index, along with any packages below them.
+// The directories may be given in any order and are deduplicated.
+//
+// Directories outside of the source roots, ignored directories and those
+// at or below MaxDepth are skipped.  All of the directories are updated even
+// if an error is encountered, the first error is returned.
+func (c *Corpus) UpdatePaths(dirs []string) error {
+	if c.packages == nil {
+		return errors.New("pkg: cannot update uninitialized Corpus")
+	}
+	if c.forked {
+		return errors.New("pkg: cannot update a forked Corpus")
+	}
+	paths := make([]string, 0, len(dirs))
+	seen := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dir = clean(dir)
+		if !seen[dir] {
+			seen[dir] = true
+			paths = append(paths, dir)
+		}
+	}
+	sort.Strings(paths)
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	prev := c.dirTrees()
+	trees := make(map[string]*Directory, len(prev))
+	for root, dir := range prev {
+		trees[root] = dir
+	}
+	t := newTreeBuilder(c, c.MaxDepth)
+	var first error
+	for _, path := range paths {
+		root, names, err := t.splitSrcPath(path)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		if names == nil {
+			continue // ignored
+		}
+		d, err := t.updatePath(trees[root], root, names, 0, false)
+		if err != nil && first == nil {
+			first = err
+		}
+		if d != nil {
+			trees[root] = d
+		} else {
+			delete(trees, root)
+		}
+	}
+	c.setDirTrees(trees)
+	return first
+}
+
+// splitSrcPath, returns the source root containing the directory at path and
+// the names of the directories from the root to path, see matchSrcDir.  Names
+// is nil if path is ignored or at or below MaxDepth, and empty if path is the
+// source root.
+func (t *treeBuilder) splitSrcPath(path string) (root string, names []string, err error) {
+	srcDir, dir, ok := t.c.packages.matchSrcDir(path)
+	root = srcDir.Path
+	if !ok || (len(dir) > len(root) && dir[len(root)] != '/') {
+		return "", nil, fmt.Errorf("pkg: directory %q is not in a source root", path)
+	}
+	names = []string{}
+	if rel := trimPathPrefix(dir, root); rel != "" {
+		names = strings.Split(rel, "/")
+	}
+	if len(names) >= t.maxDepth {
+		return root, nil, nil
+	}
+	dir = root
+	for _, name := range names {
+		dir = pathpkg.Join(dir, name)
+		if !validName(name) || t.ignored(dir, name) {
+			return root, nil, nil
+		}
+	}
+	return root, names, nil
+}
+
+// updatePath, returns a copy of Directory dir, at path and depth, with the
+// directory at the end of names re-indexed, see UpdatePaths.  Dir may be nil
+// if path is not in the directory tree.  Only the directories from dir to
+// the updated directory are copied, other sub-directories are shared.
+//
+// Nil is returned if the directory no longer contains a package or any
+// sub-directories.
+func (t *treeBuilder) updatePath(dir *Directory, path string, names []string, depth int, internal bool) (*Directory, error) {
+	if !internal && isInternal(path) {
+		internal = true
+	}
+	nd := &Directory{
+		Path:     path,
+		Name:     pathpkg.Base(path),
+		Internal: internal,
+		Depth:    depth,
+	}
+	if dir != nil {
+		// Keep the FileInfo of existing directories, so that the
+		// next Update still reads any directories that changed.
+		nd.PkgName = dir.PkgName
+		nd.HasPkg = dir.HasPkg
+		nd.Info = dir.Info
+		nd.ModuleRoot = dir.ModuleRoot
+		nd.ModulePath = dir.ModulePath
+		nd.modInfo = dir.modInfo
+	}
+	nd.Dirs = make(map[string]*Directory)
+	if dir != nil {
+		for name, d := range dir.Dirs {
+			nd.Dirs[name] = d
+		}
+	}
+
+	var err error
+	if len(names) != 0 {
+		name := names[0]
+		var d *Directory
+		if dir != nil {
+			d = dir.Dirs[name]
+		}
+		d, err = t.updatePath(d, pathpkg.Join(path, name), names[1:], depth+1, internal)
+		if d != nil {
+			nd.Dirs[name] = d
+		} else {
+			delete(nd.Dirs, name)
+		}
+	} else {
+		fi, serr := fs.Stat(path)
+		if serr != nil || !fi.IsDir() {
+			// Removed, delete all packages rooted at path.
+			if dir != nil {
+				t.removePackage(dir)
+			} else {
+				t.c.packages.removePath(path)
+			}
+			t.c.clearWalkErrors(path)
+			if serr != nil && !os.IsNotExist(serr) {
+				err = serr
+			}
+			return nil, err
+		}
+		pkg, perr := t.c.packages.ImportDir(path)
+		if pkg == nil && perr != nil && !IsNoGo(perr) && !IsNoBuildableGo(perr) {
+			t.errorEvent(perr, path)
+			err = perr
+		} else {
+			t.c.setWalkError(path, nil)
+		}
+		nd.setPackage(pkg, perr)
+		nd.setModule(statGoMod(path), dir)
+	}
+
+	if !nd.HasPkg && len(nd.Dirs) == 0 {
+		if dir != nil {
+			t.notify(DeleteEvent, path)
+		}
+		return nil, err
+	}
+	if len(names) == 0 {
+		if dir == nil {
+			t.notify(CreateEvent, path)
+		} else {
+			t.notify(UpdateEvent, path)
+		}
+	}
+	return nd, err
+}
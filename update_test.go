@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdatePaths(t *testing.T) {
+	if err := NewCorpus().UpdatePaths(nil); err == nil {
+		t.Error("UpdatePaths: expected error for uninitialized Corpus")
+	}
+
+	f := newFixture(t, true)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.write(t, "alpha/alpha2.go", "package alpha\n\nfunc Alpha2() {}\n")
+	f.write(t, "new/pkg/pkg.go", "package pkg\n\nfunc NewFunc() {}\n")
+	f.write(t, "nested/inner/internal/x/x.go", "package x\n")
+	f.write(t, "unlisted/unlisted.go", "package unlisted\n")
+	f.remove(t, "beta")
+
+	err := f.UpdatePaths([]string{
+		f.path("new/pkg"),
+		f.path("alpha"),
+		f.path("beta"),
+		f.path("alpha") + "/", // duplicate
+		f.path("nested/inner/internal/x"),
+		f.path("testdata/td"), // ignored
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := f.Definition("alpha", "Alpha2"); !ok {
+		t.Error("UpdatePaths: alpha: missing ident Alpha2")
+	}
+	if _, ok := f.Definition("new/pkg", "NewFunc"); !ok {
+		t.Error("UpdatePaths: new/pkg: missing ident NewFunc")
+	}
+	for _, rel := range []string{"beta", "beta/vendor/vendored", "unlisted", "testdata/td"} {
+		if _, ok := f.packages.lookupPath(f.path(rel)); ok {
+			t.Errorf("UpdatePaths: unexpected package: %s", rel)
+		}
+		if d := f.lookupDir(f.path(rel)); d != nil {
+			t.Errorf("UpdatePaths: unexpected directory: %s", rel)
+		}
+	}
+
+	for _, rel := range []string{"alpha", "new/pkg", "nested/inner/internal/x"} {
+		if _, ok := f.packages.lookupPath(f.path(rel)); !ok {
+			t.Errorf("UpdatePaths: missing package: %s", rel)
+		}
+		d := f.lookupDir(f.path(rel))
+		if d == nil || !d.HasPkg {
+			t.Errorf("UpdatePaths: missing package directory: %s: %+v", rel, d)
+		}
+	}
+	if d := f.lookupDir(f.path("new")); d == nil || d.HasPkg || d.Depth != 1 {
+		t.Errorf("UpdatePaths: new: %+v", d)
+	}
+	if d := f.lookupDir(f.path("nested/inner/internal/x")); d == nil || !d.Internal || d.Depth != 4 {
+		t.Errorf("UpdatePaths: nested/inner/internal/x: %+v", d)
+	}
+
+	// Removing the only package of a directory prunes empty parents.
+	f.remove(t, "new")
+	if err := f.UpdatePaths([]string{f.path("new/pkg")}); err != nil {
+		t.Fatal(err)
+	}
+	if d := f.lookupDir(f.path("new")); d != nil {
+		t.Errorf("UpdatePaths: directory not removed: new: %+v", d)
+	}
+
+	if err := f.UpdatePaths([]string{os.TempDir()}); err == nil {
+		t.Error("UpdatePaths: expected error for directory outside of source roots")
+	}
+	if err := f.UpdatePaths([]string{f.root + "x"}); err == nil {
+		t.Error("UpdatePaths: expected error for sibling of a source root")
+	}
+
+	// A full update agrees with the incremental updates.
+	pl, err := f.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl.Created) != 1 || pl.Created[0] != f.path("unlisted") || len(pl.Deleted) != 0 {
+		t.Errorf("UpdatePaths: unexpected plan: %+v", pl)
+	}
+}
+
+func TestUpdatePathsSymlink(t *testing.T) {
+	f := newFixture(t, true)
+	defer f.Close()
+	f.FollowSymlinks = true
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(f.dir, "link")
+	if err := os.Symlink(f.root, link); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+	root, err := filepath.EvalSymlinks(f.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths given through a symbolic link update the canonical directory.
+	f.write(t, "alpha/alpha2.go", "package alpha\n\nfunc Alpha2() {}\n")
+	if err := f.UpdatePaths([]string{filepath.Join(link, "alpha")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Definition("alpha", "Alpha2"); !ok {
+		t.Error("UpdatePaths: symlink: alpha: missing ident Alpha2")
+	}
+	dir := filepath.ToSlash(filepath.Join(root, "alpha"))
+	if d := f.lookupDir(dir); d == nil || !d.HasPkg {
+		t.Errorf("UpdatePaths: symlink: missing package directory: %s: %+v", dir, d)
+	}
+	if n := len(f.dirTrees()); n != 1 {
+		t.Errorf("UpdatePaths: symlink: expected 1 directory tree got: %d", n)
+	}
+}
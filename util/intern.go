@@ -66,3 +66,11 @@ func (x *StringInterner) Intern(s string) string {
 	}
 	return x.add(s)
 }
+
+// Len, returns the number of interned strings.
+func (x *StringInterner) Len() int {
+	x.RLock()
+	n := len(x.strings)
+	x.RUnlock()
+	return n
+}
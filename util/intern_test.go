@@ -27,3 +27,16 @@ func TestStringInterner(t *testing.T) {
 		t.Fatalf("TestStringInterner pointer: %p %p", s1, s2)
 	}
 }
+
+func TestStringInternerLen(t *testing.T) {
+	var i StringInterner
+	if n := i.Len(); n != 0 {
+		t.Fatalf("Len: exp: 0 got: %d", n)
+	}
+	i.Intern("a")
+	i.Intern("b")
+	i.Intern("a")
+	if n := i.Len(); n != 2 {
+		t.Fatalf("Len: exp: 2 got: %d", n)
+	}
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"math/rand"
+	pathpkg "path"
+	"sort"
+
+	"github.com/charlievieth/pkg/fs"
+)
+
+// A DiscrepancyKind, is the kind of disagreement between the index and the
+// file system found by Corpus.Verify.
+type DiscrepancyKind int
+
+const (
+	DirMissing     DiscrepancyKind = iota // package directory does not exist
+	DirChanged                            // package directory changed since indexed
+	FileMissing                           // indexed file does not exist
+	FileChanged                           // indexed file changed since indexed
+	FileNotIndexed                        // file of the package directory is not indexed
+)
+
+var discrepancyKindStr = [...]string{
+	"DirMissing",
+	"DirChanged",
+	"FileMissing",
+	"FileChanged",
+	"FileNotIndexed",
+}
+
+func (k DiscrepancyKind) String() string {
+	if 0 <= k && int(k) < len(discrepancyKindStr) {
+		return discrepancyKindStr[k]
+	}
+	return "Invalid"
+}
+
+// A Discrepancy, is an entry of the index that disagrees with the file
+// system, see Corpus.Verify.
+type Discrepancy struct {
+	Kind       DiscrepancyKind
+	Path       string // Path of the directory or file
+	ImportPath string // Import path of the package
+}
+
+func (d Discrepancy) String() string {
+	return d.Kind.String() + ": " + d.Path + " (" + d.ImportPath + ")"
+}
+
+// Verify, re-stats the directories and files of the indexed packages and
+// reports where the index disagrees with the file system: directories that
+// were removed or changed, indexed files that were removed or changed and
+// files that are not indexed.  Unlike an update, the index is not modified,
+// Verify is intended for monitoring the index of long-running processes.
+//
+// If VerifySample is greater than zero, only a random sample of that many
+// packages is verified, otherwise every package is.  Verify waits for any
+// update in progress and blocks updates until it returns.  Changes made since
+// the last update are reported until the next update, discrepancies that
+// persist across updates indicate an error in the index.
+//
+// Discrepancies are sorted by path.  Only the directories of packages are
+// verified, directories without Go files and binary-only packages, see
+// Corpus.AllowBinary, are not.
+func (c *Corpus) Verify() []Discrepancy {
+	if c.packages == nil {
+		return nil
+	}
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	// Packages are updated in place, so verify copies.
+	var pkgs []*Package
+	c.packages.each(func(p *Package) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+	if n := c.VerifySample; n > 0 && n < len(pkgs) {
+		rand.Shuffle(len(pkgs), func(i, j int) {
+			pkgs[i], pkgs[j] = pkgs[j], pkgs[i]
+		})
+		pkgs = pkgs[:n]
+	}
+	c.packages.mu.RLock()
+	for i, p := range pkgs {
+		pkgs[i] = p.clone()
+	}
+	c.packages.mu.RUnlock()
+
+	var list []Discrepancy
+	for _, p := range pkgs {
+		list = c.packages.verifyPkg(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Kind < list[j].Kind
+	})
+	return list
+}
+
+// verifyPkg, appends the discrepancies between Package p and the file
+// system to list, see Corpus.Verify.
+func (x *PackageIndex) verifyPkg(list []Discrepancy, p *Package) []Discrepancy {
+	add := func(kind DiscrepancyKind, path string) {
+		list = append(list, Discrepancy{
+			Kind:       kind,
+			Path:       path,
+			ImportPath: p.ImportPath,
+		})
+	}
+	if p.BinaryOnly {
+		return list // no directory
+	}
+	fi, err := fs.Stat(p.Dir)
+	if err != nil || !fi.IsDir() {
+		add(DirMissing, p.Dir)
+		return list
+	}
+	if !fs.SameFile(p.Info, fi) {
+		add(DirChanged, p.Dir)
+	}
+	// Files are not recorded in FindPackageName mode.
+	if p.mode == FindPackageName {
+		return list
+	}
+
+	indexed := make(map[string]bool, p.fileLen(-1)+len(p.other)+len(p.invalid))
+	check := func(f File) {
+		indexed[f.Name] = true
+		switch fi, err := fs.Stat(f.Path); {
+		case err != nil:
+			add(FileMissing, f.Path)
+		case !fs.SameFile(f.Info, fi):
+			add(FileChanged, f.Path)
+		}
+	}
+	for _, m := range p.files {
+		for _, f := range m {
+			check(f)
+		}
+	}
+	for _, f := range p.other {
+		check(f)
+	}
+	for _, f := range p.invalid {
+		check(f)
+	}
+
+	// Files that fail to parse or declare another package are not
+	// recorded by the package.
+	if p.indexError() != nil {
+		return list
+	}
+	names, err := fs.Readdirnames(p.Dir)
+	if err != nil {
+		return list
+	}
+	for _, name := range names {
+		if indexed[name] || !x.filterFiles(name) || !validName(name) {
+			continue
+		}
+		path := pathpkg.Join(p.Dir, name)
+		if fi, err := fs.Stat(path); err == nil && !fi.IsDir() {
+			add(FileNotIndexed, path)
+		}
+	}
+	return list
+}
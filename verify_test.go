@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	f := newFixture(t, false)
+	defer f.Close()
+	if err := f.initDirTree(); err != nil {
+		t.Fatal(err)
+	}
+	if list := f.Verify(); len(list) != 0 {
+		t.Fatalf("Verify: unexpected discrepancies: %v", list)
+	}
+
+	// Change a file in place, the directory is unchanged.
+	path := f.path("beta/beta.go")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, "alpha/alpha_new.go", "package alpha\n")
+	f.remove(t, "alpha/alpha_test.go")
+	f.remove(t, "nested/inner")
+
+	exp := []Discrepancy{
+		{DirChanged, f.path("alpha"), "alpha"},
+		{FileNotIndexed, f.path("alpha/alpha_new.go"), "alpha"},
+		{FileMissing, f.path("alpha/alpha_test.go"), "alpha"},
+		{FileChanged, f.path("beta/beta.go"), "beta"},
+		{DirMissing, f.path("nested/inner"), "nested/inner"},
+	}
+	if list := f.Verify(); !reflect.DeepEqual(list, exp) {
+		t.Errorf("Verify:\nExp: %v\nGot: %v", exp, list)
+	}
+
+	// Only verify a sample of the packages.
+	f.VerifySample = 1
+	if list := f.Verify(); len(list) > 3 {
+		t.Errorf("Verify: sample: too many discrepancies: %v", list)
+	}
+	f.VerifySample = 0
+
+	// The index agrees with the file system after an update.
+	f.Update()
+	if list := f.Verify(); len(list) != 0 {
+		t.Errorf("Verify: update: unexpected discrepancies: %v", list)
+	}
+}